@@ -26,15 +26,36 @@ type BaseRepoSpec struct {
 
 	//+optional
 	Commit string `json:"commit,omitempty"`
+
+	// SourceReconciler is the GitOps operator on the control plane cluster that fetches the base repo.
+	// If it's not set, the scheduler uses the one from its --source-reconciler flag.
+	//+optional
+	SourceReconciler SourceReconcilerType `json:"sourceReconciler,omitempty"`
+
+	// Flux overrides the settings of the Flux resources that fetch the base repo
+	//+optional
+	Flux *FluxSpec `json:"flux,omitempty"`
 }
 
 // BaseRepoStatus defines the observed state of BaseRepo
 type BaseRepoStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// LastAppliedRevision is the revision of the repo the source reconciler last applied the base repo from
+	//+optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+
+	// SourceReconciler is the GitOps operator that fetches the base repo, the resources of the other one are deleted when it changes
+	//+optional
+	SourceReconciler SourceReconcilerType `json:"sourceReconciler,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Revision",type="string",JSONPath=".status.lastAppliedRevision"
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // BaseRepo is the Schema for the baserepoes API
 type BaseRepo struct {
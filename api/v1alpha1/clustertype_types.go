@@ -17,6 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -41,6 +45,52 @@ type ClusterTypeSpec struct {
 
 	//+kubebuilder:validation:MinLength=0
 	ConfigType string `json:"configType"`
+
+	// Capacity is the amount of resources the deployment targets scheduled on the cluster type can request,
+	// e.g. cpu, memory or any custom resource. A cluster type without capacity is unlimited.
+	//+optional
+	Capacity corev1.ResourceList `json:"capacity,omitempty"`
+
+	// Cost is the relative cost of running a deployment target on the cluster type.
+	// The scheduling strategies prefer the cheaper cluster types.
+	//+optional
+	Cost *resource.Quantity `json:"cost,omitempty"`
+
+	// Taints repel the deployment targets that don't tolerate them
+	//+optional
+	Taints []Taint `json:"taints,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=NoSchedule;PreferNoSchedule;NoExecute
+type TaintEffect string
+
+const (
+	// NoScheduleTaintEffect doesn't schedule new deployment targets on the cluster type,
+	// the ones that are already assigned to it stay
+	NoScheduleTaintEffect TaintEffect = "NoSchedule"
+	// PreferNoScheduleTaintEffect schedules deployment targets on the cluster type only if there is no better choice
+	PreferNoScheduleTaintEffect TaintEffect = "PreferNoSchedule"
+	// NoExecuteTaintEffect doesn't schedule deployment targets on the cluster type and removes the assigned ones
+	NoExecuteTaintEffect TaintEffect = "NoExecute"
+)
+
+// Taint repels the deployment targets that don't tolerate it from the cluster type
+type Taint struct {
+	//+kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	//+optional
+	Value string `json:"value,omitempty"`
+
+	Effect TaintEffect `json:"effect"`
+}
+
+// String returns the taint in the key=value:effect form
+func (t *Taint) String() string {
+	if t.Value == "" {
+		return fmt.Sprintf("%s:%s", t.Key, t.Effect)
+	}
+	return fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)
 }
 
 // ClusterTypeStatus defines the observed state of ClusterType
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -37,6 +38,99 @@ type DeploymentTargetSpec struct {
 
 	// +optional
 	ConfigSchemas []string `json:"configSchemas"`
+
+	// Resources are the resources the deployment target requests from the capacity of a cluster type
+	// +optional
+	Resources corev1.ResourceList `json:"resources,omitempty"`
+
+	// Tolerations let the deployment target be scheduled on the cluster types with the matching taints
+	// +optional
+	Tolerations []Toleration `json:"tolerations,omitempty"`
+
+	// Affinity constrains the cluster types the deployment target is scheduled on
+	// +optional
+	Affinity *Affinity `json:"affinity,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Exists;Equal
+type TolerationOperator string
+
+const (
+	ExistsTolerationOperator TolerationOperator = "Exists"
+	EqualTolerationOperator  TolerationOperator = "Equal"
+)
+
+// Toleration tolerates the taints with the matching key, value and effect
+type Toleration struct {
+	// Key of the taints, an empty key with the Exists operator tolerates all taints
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// +kubebuilder:default=Equal
+	// +optional
+	Operator TolerationOperator `json:"operator,omitempty"`
+
+	// Value of the taints with the Equal operator
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Effect of the taints, an empty effect tolerates all effects
+	// +optional
+	Effect TaintEffect `json:"effect,omitempty"`
+}
+
+// ToleratesTaint checks if the toleration tolerates the taint
+func (t *Toleration) ToleratesTaint(taint *Taint) bool {
+	if t.Effect != "" && t.Effect != taint.Effect {
+		return false
+	}
+	if t.Key != "" && t.Key != taint.Key {
+		return false
+	}
+
+	switch t.Operator {
+	case ExistsTolerationOperator:
+		return true
+	case EqualTolerationOperator, "":
+		return t.Key != "" && t.Value == taint.Value
+	}
+	return false
+}
+
+// Affinity constrains the cluster types of a deployment target by their labels and by the other deployment targets
+// scheduled on them
+type Affinity struct {
+	// ClusterTypeAffinity selects the cluster types by their labels
+	// +optional
+	ClusterTypeAffinity *AffinityTerms `json:"clusterTypeAffinity,omitempty"`
+
+	// DeploymentTargetAffinity co-locates the deployment target with the matching deployment targets
+	// +optional
+	DeploymentTargetAffinity *AffinityTerms `json:"deploymentTargetAffinity,omitempty"`
+
+	// DeploymentTargetAntiAffinity keeps the deployment target away from the cluster types of the matching deployment targets
+	// +optional
+	DeploymentTargetAntiAffinity *AffinityTerms `json:"deploymentTargetAntiAffinity,omitempty"`
+}
+
+// AffinityTerms are the label selectors a deployment target must and should satisfy
+type AffinityTerms struct {
+	// Required label selectors must all be satisfied
+	// +optional
+	Required []metav1.LabelSelector `json:"required,omitempty"`
+
+	// Preferred label selectors add their weight to the cluster types that satisfy them
+	// +optional
+	Preferred []WeightedLabelSelector `json:"preferred,omitempty"`
+}
+
+// WeightedLabelSelector is a label selector with the weight of the preference
+type WeightedLabelSelector struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+
+	LabelSelector metav1.LabelSelector `json:"labelSelector"`
 }
 
 type ManifestsSpec struct {
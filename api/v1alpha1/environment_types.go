@@ -23,18 +23,105 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// +kubebuilder:validation:Enum=flux;argocd
+type SourceReconcilerType string
+
+const (
+	// Flux GitRepository and Kustomization
+	FluxSourceReconciler SourceReconcilerType = "flux"
+	// Argo CD Application
+	ArgoCDSourceReconciler SourceReconcilerType = "argocd"
+)
+
+// +kubebuilder:validation:Enum=Orphan;Delete
+type NamespaceDeletionPolicy string
+
+const (
+	// the environment namespace is kept when the environment is deleted
+	OrphanNamespace NamespaceDeletionPolicy = "Orphan"
+	// the environment namespace with its Kalypso objects is deleted along with the environment
+	DeleteNamespace NamespaceDeletionPolicy = "Delete"
+)
+
+// FluxSpec overrides the manager wide settings of the Flux GitRepository and Kustomization that fetch the manifests
+// to the control plane cluster. It's ignored by the other source reconcilers.
+type FluxSpec struct {
+	// Interval at which Flux fetches the repo and reapplies the manifests
+	//+optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Timeout of the git operations and of the manifests apply
+	//+optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// SecretName is the secret in the Flux namespace with the repo credentials
+	//+optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Suspend stops Flux fetching the repo and applying the manifests
+	//+optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// Decryption of the manifests encrypted with SOPS
+	//+optional
+	Decryption *DecryptionSpec `json:"decryption,omitempty"`
+
+	// DependsOn lists the Flux Kustomizations in the Flux namespace that must be ready before the manifests are applied
+	//+optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// DecryptionSpec defines how Flux decrypts the manifests
+type DecryptionSpec struct {
+	// Provider is the decryption engine
+	//+kubebuilder:validation:Enum=sops
+	//+kubebuilder:default=sops
+	Provider string `json:"provider"`
+
+	// SecretName is the secret in the Flux namespace with the decryption keys
+	//+optional
+	SecretName string `json:"secretName,omitempty"`
+}
+
 // EnvironmentSpec defines the desired state of Environment
 type EnvironmentSpec struct {
 	ControlPlane ManifestsSpec `json:"controlPlane"`
+
+	// SourceReconciler is the GitOps operator on the control plane cluster that fetches the environment abstractions.
+	// If it's not set, the scheduler uses the one from its --source-reconciler flag.
+	//+optional
+	SourceReconciler SourceReconcilerType `json:"sourceReconciler,omitempty"`
+
+	// Flux overrides the settings of the Flux resources that fetch the environment abstractions
+	//+optional
+	Flux *FluxSpec `json:"flux,omitempty"`
+
+	// NamespaceDeletionPolicy defines whether the environment namespace with its Kalypso objects is deleted
+	// along with the environment
+	//+kubebuilder:default=Orphan
+	//+optional
+	NamespaceDeletionPolicy NamespaceDeletionPolicy `json:"namespaceDeletionPolicy,omitempty"`
 }
 
 // EnvironmentStatus defines the observed state of Environment
 type EnvironmentStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// LastAppliedRevision is the revision of the repo the source reconciler last applied the environment abstractions from
+	//+optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+
+	// SourceReconciler is the GitOps operator that fetches the environment abstractions, the resources of the other one are deleted when it changes
+	//+optional
+	SourceReconciler SourceReconcilerType `json:"sourceReconciler,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Revision",type="string",JSONPath=".status.lastAppliedRevision"
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Environment is the Schema for the environments API
 type Environment struct {
@@ -24,13 +24,367 @@ const (
 	ReadyToPRConditionType = "ReadyToPR"
 	PRConditionType        = "PR"
 	ReadyConditionType     = "Ready"
+	// the last PR of the GitOps repo is merged
+	PRMergedConditionType = "PRMerged"
+	// the last PR of the GitOps repo is closed by someone without merging it
+	PRClosedWithoutMergeConditionType = "PRClosedWithoutMerge"
+	// every cluster type of the GitOps repo is selected by this repo only and every cluster type of the namespace has a repo
+	ClusterTypeOwnershipConditionType = "ClusterTypeOwnership"
+	// the base branch differs from the content delivered by the scheduler
+	DriftedConditionType = "Drifted"
+	// the delivery is held back by a change freeze
+	FrozenConditionType = "Frozen"
+	// the delivery is held back until the next delivery window opens
+	WaitingForWindowConditionType = "WaitingForWindow"
 )
 
+// +kubebuilder:validation:Enum=github;gitlab;azuredevops
+type GitProviderType string
+
+const (
+	GitHubGitProvider      GitProviderType = "github"
+	GitLabGitProvider      GitProviderType = "gitlab"
+	AzureDevOpsGitProvider GitProviderType = "azuredevops"
+)
+
+// +kubebuilder:validation:Enum=pull-request;direct-push;oci
+type DeliveryType string
+
+const (
+	// the manifests are delivered with a PR through the git provider API
+	PullRequestDelivery DeliveryType = "pull-request"
+	// the manifests are committed and pushed to the branch with plain git, without a hosting API
+	DirectPushDelivery DeliveryType = "direct-push"
+	// the manifests are published only as OCI artifacts, the git repo is not used
+	OCIDelivery DeliveryType = "oci"
+)
+
+// +kubebuilder:validation:Enum=never;always;unpromoted
+type AutoMergePolicy string
+
+const (
+	NeverAutoMerge AutoMergePolicy = "never"
+	// merge every PR
+	AlwaysAutoMerge AutoMergePolicy = "always"
+	// merge only the PRs that are not labelled as promoted
+	UnpromotedAutoMerge AutoMergePolicy = "unpromoted"
+)
+
+// +kubebuilder:validation:Enum=merge;squash;rebase
+type MergeMethod string
+
+const (
+	MergeMergeMethod  MergeMethod = "merge"
+	SquashMergeMethod MergeMethod = "squash"
+	RebaseMergeMethod MergeMethod = "rebase"
+)
+
+type AutoMergeSpec struct {
+	// Policy defines which PRs are merged automatically
+	//+kubebuilder:default=never
+	//+optional
+	Policy AutoMergePolicy `json:"policy,omitempty"`
+
+	// Method is the merge method used to merge the PR
+	//+kubebuilder:default=merge
+	//+optional
+	Method MergeMethod `json:"method,omitempty"`
+
+	// WaitForChecks makes the scheduler wait until the status checks of the PR succeed before merging it
+	//+optional
+	WaitForChecks bool `json:"waitForChecks,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=preserve;dismiss
+type ApprovalPolicy string
+
+const (
+	// the approvals of the open PR are kept when the scheduler pushes new content to it
+	PreserveApprovalPolicy ApprovalPolicy = "preserve"
+	// the approvals of the open PR are dismissed when its content changes, so it has to be reviewed again
+	DismissApprovalPolicy ApprovalPolicy = "dismiss"
+)
+
+// PullRequestSpec configures the PRs created by the scheduler
+type PullRequestSpec struct {
+	// ApprovalPolicy defines what happens to the approvals of the open PR when the scheduler updates its content.
+	// The branch protection rules of the git hosting service may dismiss the approvals regardless of the policy.
+	// Azure DevOps doesn't support dismissing the votes, use the "Reset code reviewer votes" branch policy instead.
+	//+kubebuilder:default=preserve
+	//+optional
+	ApprovalPolicy ApprovalPolicy `json:"approvalPolicy,omitempty"`
+}
+
+// BatchingSpec defines how long the changes are collected before they are delivered with a single PR
+type BatchingSpec struct {
+	// QuietPeriod is the time nothing has to change before the PR is created
+	//+kubebuilder:default="3s"
+	//+optional
+	QuietPeriod metav1.Duration `json:"quietPeriod,omitempty"`
+
+	// MaxDelay is the longest time the changes wait for the PR, even if they keep coming.
+	// Zero means there is no limit.
+	//+kubebuilder:default="5m"
+	//+optional
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+}
+
+// BatchStatus describes the changes waiting to be delivered
+type BatchStatus struct {
+	// PendingChanges is the number of content changes in the batch
+	PendingChanges int `json:"pendingChanges"`
+	// ContentHash is the hash of the last content in the batch
+	ContentHash string `json:"contentHash,omitempty"`
+	// FirstChangeAt is the time of the first change in the batch
+	FirstChangeAt metav1.Time `json:"firstChangeAt,omitempty"`
+	// LastChangeAt is the time of the last change in the batch
+	LastChangeAt metav1.Time `json:"lastChangeAt,omitempty"`
+	// NextPRAt is the time the PR is scheduled to be created
+	NextPRAt metav1.Time `json:"nextPRAt,omitempty"`
+}
+
+// DeliveryWindowsSpec restricts the time the manifests are delivered and the PRs are auto-merged
+type DeliveryWindowsSpec struct {
+	// TimeZone of the window schedules, e.g. Europe/Berlin
+	//+kubebuilder:default=UTC
+	//+optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Windows are the recurring periods the delivery is allowed in.
+	// If there are none, the delivery is allowed at any time outside the blackouts.
+	//+optional
+	Windows []DeliveryWindowSpec `json:"windows,omitempty"`
+
+	// Blackouts are the change freezes, the delivery is not allowed in them even inside a window
+	//+optional
+	Blackouts []BlackoutSpec `json:"blackouts,omitempty"`
+}
+
+// DeliveryWindowSpec is a recurring period the delivery is allowed in
+type DeliveryWindowSpec struct {
+	// Schedule is a cron expression of the window start: minute, hour, day of month, month and day of week,
+	// e.g. "0 9 * * 1-4" opens the window at 9:00 from Monday to Thursday
+	//+kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Duration is how long the window stays open
+	Duration metav1.Duration `json:"duration"`
+}
+
+// BlackoutSpec is a change freeze period
+type BlackoutSpec struct {
+	Start metav1.Time `json:"start"`
+	End   metav1.Time `json:"end"`
+
+	// Reason of the change freeze, it is shown in the Frozen condition
+	//+optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=report;correct
+type DriftPolicy string
+
+const (
+	// the drift is only reported in the Drifted condition
+	ReportDriftPolicy DriftPolicy = "report"
+	// the content is delivered again to restore the branch
+	CorrectDriftPolicy DriftPolicy = "correct"
+)
+
+type DriftDetectionSpec struct {
+	// Interval between the checks of the base branch
+	//+kubebuilder:default="10m"
+	//+optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+
+	// Policy defines what to do with a drifted branch.
+	// With the correct policy the scheduler opens a corrective PR, or pushes the content with the direct-push delivery.
+	//+kubebuilder:default=report
+	//+optional
+	Policy DriftPolicy `json:"policy,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Pending;Merged;Blocked
+type AutoMergeState string
+
+const (
+	AutoMergePending AutoMergeState = "Pending"
+	AutoMergeMerged  AutoMergeState = "Merged"
+	AutoMergeBlocked AutoMergeState = "Blocked"
+)
+
+type AutoMergeStatus struct {
+	// PullRequest is the number of the PR to merge
+	PullRequest string `json:"pullRequest,omitempty"`
+	// State of the auto-merge of the PR
+	State AutoMergeState `json:"state,omitempty"`
+	// MergedSHA is the commit the PR was merged with
+	MergedSHA string `json:"mergedSHA,omitempty"`
+	// Message explains why the merge is pending or blocked
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Open;Merged;Closed;Superseded
+type PullRequestState string
+
+const (
+	PullRequestOpen   PullRequestState = "Open"
+	PullRequestMerged PullRequestState = "Merged"
+	// the PR is closed without merging it
+	PullRequestClosed PullRequestState = "Closed"
+	// the PR is closed by the scheduler as its content is already in the branch
+	PullRequestSuperseded PullRequestState = "Superseded"
+)
+
+type PullRequestStatus struct {
+	// Number of the PR
+	Number string `json:"number"`
+	// URL of the PR in the git hosting service
+	URL string `json:"url,omitempty"`
+	// Branch is the head branch of the PR
+	Branch string `json:"branch,omitempty"`
+	// ContentHash is the hash of the content delivered with the PR
+	ContentHash string `json:"contentHash,omitempty"`
+	// BaseRepoCommit is the promoted base repo commit the content was generated from
+	BaseRepoCommit string `json:"baseRepoCommit,omitempty"`
+	// State of the PR
+	State PullRequestState `json:"state,omitempty"`
+	// MergedSHA is the commit the PR was merged with
+	MergedSHA string `json:"mergedSHA,omitempty"`
+	// CreatedAt is the time the PR was created by the scheduler
+	CreatedAt metav1.Time `json:"createdAt,omitempty"`
+	// UpdatedAt is the time the scheduler pushed the current content to the PR
+	UpdatedAt metav1.Time `json:"updatedAt,omitempty"`
+}
+
 // GitOpsRepoSpec defines the desired state of GitOpsRepo
 type GitOpsRepoSpec struct {
 	ManifestsSpec `json:",inline"`
-	//TODO
-	//AutoMerge
+
+	// Provider is the git hosting service of the repo. If empty, it is detected from the repo URL host.
+	//+optional
+	Provider GitProviderType `json:"provider,omitempty"`
+
+	// Delivery defines how the manifests get to the branch: with a PR or with a direct push.
+	// With the oci delivery the manifests are only published to the OCI repository.
+	//+kubebuilder:default=pull-request
+	//+optional
+	Delivery DeliveryType `json:"delivery,omitempty"`
+
+	// PullRequest configures the PRs the scheduler keeps up to date with the content.
+	// The scheduler owns the PRs from the deployment/<branch> branch, the other PRs to the branch are not touched.
+	//+optional
+	PullRequest *PullRequestSpec `json:"pullRequest,omitempty"`
+
+	// AutoMerge configures merging of the generated PRs without a human
+	//+optional
+	AutoMerge *AutoMergeSpec `json:"autoMerge,omitempty"`
+
+	// SecretRef is a secret in the namespace with the credentials to access the repo.
+	// It has the same keys as a Flux git repository secret: token, username and password, identity and known_hosts,
+	// or githubAppID, githubAppInstallationID and githubAppPrivateKey.
+	// If it's not set, the scheduler uses its default credentials.
+	//+optional
+	SecretRef *SecretReference `json:"secretRef,omitempty"`
+
+	// Commit configures the author and the signature of the commits created by the scheduler
+	//+optional
+	Commit *CommitSpec `json:"commit,omitempty"`
+
+	// Layout is the folder structure of the manifests in the repo.
+	// It defaults to <cluster type>/<deployment target> folders with a README in each cluster type folder.
+	//+optional
+	Layout *LayoutSpec `json:"layout,omitempty"`
+
+	// OCI publishes the manifests of every cluster type as an OCI artifact in addition to the git delivery
+	//+optional
+	OCI *OCIRepositorySpec `json:"oci,omitempty"`
+
+	// ClusterTypeSelector selects the cluster types of the namespace that are delivered to this repo,
+	// so the cluster types can be split across several GitOps repos of the environment.
+	// If it's not set, the repo receives all cluster types.
+	//+optional
+	ClusterTypeSelector *metav1.LabelSelector `json:"clusterTypeSelector,omitempty"`
+
+	// DriftDetection checks periodically that the base branch still contains the delivered content
+	//+optional
+	DriftDetection *DriftDetectionSpec `json:"driftDetection,omitempty"`
+
+	// DeliveryWindows hold back the delivery and the auto-merge outside the allowed windows and during the change freezes
+	//+optional
+	DeliveryWindows *DeliveryWindowsSpec `json:"deliveryWindows,omitempty"`
+
+	// Batching collects the changes that come in a burst into a single PR.
+	// By default the PR is created after 3 seconds without changes, but no later than 5 minutes after the first one.
+	//+optional
+	Batching *BatchingSpec `json:"batching,omitempty"`
+}
+
+// OCIRepositorySpec defines where the OCI artifacts with the manifests are pushed
+type OCIRepositorySpec struct {
+	// URL of the OCI repository, e.g. oci://ghcr.io/microsoft/kalypso-gitops.
+	// The artifact of a cluster type is pushed to <url>/<cluster type>.
+	//+kubebuilder:validation:Pattern="^oci://.+$"
+	URL string `json:"url"`
+
+	// SecretRef is a docker config secret (kubernetes.io/dockerconfigjson) in the namespace with the registry credentials.
+	// If it's not set, the scheduler uses its default docker config.
+	//+optional
+	SecretRef *SecretReference `json:"secretRef,omitempty"`
+
+	// Insecure allows pushing to a registry over plain HTTP
+	//+optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// LayoutSpec defines the paths of the manifests in the GitOps repo with Go templates
+type LayoutSpec struct {
+	// DeploymentTargetPath is a template of the folder with the manifests of a deployment target,
+	// e.g. clusters/{{.ClusterType}}/apps/{{.Workload}}/{{.DeploymentTarget}}.
+	// The variables are .ClusterType, .DeploymentTarget, .Workload, .Workspace and .Environment.
+	//+kubebuilder:validation:MinLength=1
+	DeploymentTargetPath string `json:"deploymentTargetPath"`
+
+	// ClusterTypeReadmePath is a template of the README file of a cluster type with the .ClusterType variable.
+	// The READMEs are not generated if it's empty.
+	//+optional
+	ClusterTypeReadmePath string `json:"clusterTypeReadmePath,omitempty"`
+}
+
+// SecretReference refers to a secret in the same namespace
+type SecretReference struct {
+	// Name of the secret
+	Name string `json:"name"`
+}
+
+// CommitSpec configures the commits created by the scheduler
+type CommitSpec struct {
+	// Author of the commits, it defaults to Kalypso Scheduler
+	//+optional
+	Author *CommitAuthor `json:"author,omitempty"`
+
+	// SigningKey is the key to sign the commits with
+	//+optional
+	SigningKey *SigningKey `json:"signingKey,omitempty"`
+}
+
+// CommitAuthor is the identity of the commit author
+type CommitAuthor struct {
+	// Name of the author
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Email of the author
+	//+kubebuilder:validation:MinLength=1
+	Email string `json:"email"`
+}
+
+// SigningKey refers to a secret with a private key to sign the commits.
+// A GPG key is stored in the git.asc key of the secret, an SSH key in the identity key.
+// The passphrase key holds the passphrase of an encrypted private key.
+type SigningKey struct {
+	// SecretRef is a secret in the namespace with the private key
+	SecretRef SecretReference `json:"secretRef"`
 }
 
 type RepoContentType struct {
@@ -40,12 +394,31 @@ type RepoContentType struct {
 
 type ClusterContentType struct {
 	DeploymentTargets map[string]AssignmentPackageSpec
+	// Metadata of the deployment targets places them in the layout of the repo
+	Metadata map[string]DeploymentTargetMetadata
+	// Sources are the objects that scheduled the deployment targets, they are not a part of the content hash
+	Sources map[string]ContentSource `hash:"ignore"`
+}
+
+// DeploymentTargetMetadata describes where a deployment target comes from
+type DeploymentTargetMetadata struct {
+	Workload    string
+	Workspace   string
+	Environment string
+}
+
+// ContentSource refers to the SchedulingPolicy and the Assignment that scheduled a deployment target on a cluster type
+type ContentSource struct {
+	SchedulingPolicy string
+	Assignment       string
 }
 
 // NewClusterContentType creates a new ClusterContentType
 func NewClusterContentType() *ClusterContentType {
 	return &ClusterContentType{
 		DeploymentTargets: make(map[string]AssignmentPackageSpec),
+		Metadata:          make(map[string]DeploymentTargetMetadata),
+		Sources:           make(map[string]ContentSource),
 	}
 }
 
@@ -58,8 +431,40 @@ func NewRepoContentType() *RepoContentType {
 
 // GitOpsRepoStatus defines the observed state of GitOpsRepo
 type GitOpsRepoStatus struct {
-	RepoContentHash string             `json:"repoContentHash,omitempty"`
-	Conditions      []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	RepoContentHash string `json:"repoContentHash,omitempty"`
+	// LiveContentHash is the hash of the content that is in the base branch
+	LiveContentHash string `json:"liveContentHash,omitempty"`
+	// LiveBaseRepoCommit is the base repo commit of the content that is in the base branch
+	LiveBaseRepoCommit string `json:"liveBaseRepoCommit,omitempty"`
+	// PullRequest is the last PR created by the scheduler
+	PullRequest *PullRequestStatus `json:"pullRequest,omitempty"`
+	AutoMerge   *AutoMergeStatus   `json:"autoMerge,omitempty"`
+	// OCIArtifacts are the last OCI artifacts published for the cluster types
+	OCIArtifacts []OCIArtifactStatus `json:"ociArtifacts,omitempty"`
+	// ClusterTypes are the cluster types delivered to this repo
+	ClusterTypes []string `json:"clusterTypes,omitempty"`
+	// OverlappingClusterTypes are the cluster types of this repo that are selected by other GitOps repos as well
+	OverlappingClusterTypes []string `json:"overlappingClusterTypes,omitempty"`
+	// UnownedClusterTypes are the cluster types of the namespace that are not selected by any GitOps repo
+	UnownedClusterTypes []string `json:"unownedClusterTypes,omitempty"`
+	// DriftedPaths are the files of the base branch that differ from the delivered content
+	DriftedPaths []string `json:"driftedPaths,omitempty"`
+	// LastDriftCheck is the time the base branch was last compared with the delivered content
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+	// Batch contains the changes waiting for the next PR
+	Batch      *BatchStatus       `json:"batch,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+// OCIArtifactStatus refers to a published OCI artifact with the manifests of a cluster type
+type OCIArtifactStatus struct {
+	ClusterType string `json:"clusterType"`
+	// URL of the artifact repository, e.g. oci://ghcr.io/microsoft/kalypso-gitops/drone
+	URL string `json:"url"`
+	// Tag is the version of the artifact, it's the content hash of the GitOps repo
+	Tag string `json:"tag"`
+	// Digest of the artifact manifest
+	Digest string `json:"digest"`
 }
 
 type GitIssueStatus struct {
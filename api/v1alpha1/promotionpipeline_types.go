@@ -0,0 +1,127 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// the base repo of an environment namespace that is promoted if the pipeline doesn't specify another one
+	DefaultPromotedBaseRepo = "main"
+)
+
+// PromotionPipelineSpec defines the desired state of PromotionPipeline
+type PromotionPipelineSpec struct {
+	// Stages are the environments in the promotion order.
+	// The commit of the base repo in the first stage is set by hand or by CI, the next stages get it from the previous ones.
+	//+kubebuilder:validation:MinItems=1
+	Stages []PromotionStage `json:"stages"`
+
+	// BaseRepo is the name of the base repo that is promoted in the environment namespaces
+	//+kubebuilder:default=main
+	//+optional
+	BaseRepo string `json:"baseRepo,omitempty"`
+}
+
+// PromotionStage is an environment of the promotion pipeline
+type PromotionStage struct {
+	// Environment is the name of the environment, its namespace contains the promoted base repo
+	//+kubebuilder:validation:MinLength=1
+	Environment string `json:"environment"`
+
+	// Gates must pass before the commit of the previous stage is promoted to this stage
+	//+optional
+	Gates PromotionGates `json:"gates,omitempty"`
+
+	// ApprovedCommit is the commit approved for this stage by a human, if the stage requires a manual approval
+	//+optional
+	ApprovedCommit string `json:"approvedCommit,omitempty"`
+}
+
+// PromotionGates are the conditions to promote a commit from the previous stage
+type PromotionGates struct {
+	// ManualApproval requires the commit to be set as the approved commit of the stage
+	//+optional
+	ManualApproval bool `json:"manualApproval,omitempty"`
+
+	// SoakTime is the minimum time the commit has to spend in the previous stage
+	//+optional
+	SoakTime *metav1.Duration `json:"soakTime,omitempty"`
+
+	// PreviousStageDeployed requires all Assignments of the previous stage to be Ready
+	// and the manifests of the commit to be merged to all GitOps repos of the previous stage
+	//+optional
+	PreviousStageDeployed bool `json:"previousStageDeployed,omitempty"`
+}
+
+// PromotionStageStatus is the observed state of a stage
+type PromotionStageStatus struct {
+	Environment string `json:"environment"`
+	// Commit is the commit of the base repo in the stage
+	Commit string `json:"commit,omitempty"`
+	// Since is the time the commit was promoted to the stage or noticed in it
+	Since metav1.Time `json:"since,omitempty"`
+	// DeployedSince is the time the commit was noticed deployed in the stage, the soak time of the next stage counts from it
+	DeployedSince *metav1.Time `json:"deployedSince,omitempty"`
+	// PendingCommit is the commit of the previous stage that waits for the gates of this stage
+	PendingCommit string `json:"pendingCommit,omitempty"`
+	// WaitingFor tells which gates hold the pending commit
+	WaitingFor string `json:"waitingFor,omitempty"`
+}
+
+// PromotionRecord is a promotion performed by the pipeline
+type PromotionRecord struct {
+	Environment string `json:"environment"`
+	Commit      string `json:"commit"`
+	// PreviousCommit is the commit the stage had before the promotion
+	PreviousCommit string      `json:"previousCommit,omitempty"`
+	PromotedAt     metav1.Time `json:"promotedAt"`
+}
+
+// PromotionPipelineStatus defines the observed state of PromotionPipeline
+type PromotionPipelineStatus struct {
+	Stages []PromotionStageStatus `json:"stages,omitempty"`
+	// History contains the last promotions, the latest first
+	History    []PromotionRecord  `json:"history,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// PromotionPipeline is the Schema for the promotionpipelines API
+type PromotionPipeline struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PromotionPipelineSpec   `json:"spec,omitempty"`
+	Status PromotionPipelineStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PromotionPipelineList contains a list of PromotionPipeline
+type PromotionPipelineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PromotionPipeline `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PromotionPipeline{}, &PromotionPipelineList{})
+}
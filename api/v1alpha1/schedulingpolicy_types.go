@@ -27,6 +27,52 @@ import (
 type SchedulingPolicySpec struct {
 	DeploymentTargetSelector DeploymentTargetSelectorSpec `json:"deploymentTargetSelector"`
 	ClusterTypeSelector      ClusterTypeSelectorSpec      `json:"clusterTypeSelector"`
+
+	// Strategy defines how the deployment targets are placed on the selected cluster types.
+	// All deployment targets are scheduled on all selected cluster types if it's not set.
+	//+optional
+	Strategy *SchedulingStrategySpec `json:"strategy,omitempty"`
+
+	// ScorePlugins override the weights of the score plugins that rank the cluster types for a deployment target.
+	// The plugins that are not listed keep their default weight, a weight of 0 turns a plugin off.
+	//+optional
+	ScorePlugins []ScorePluginWeight `json:"scorePlugins,omitempty"`
+}
+
+// ScorePluginWeight is the weight of a score plugin of the scheduler
+type ScorePluginWeight struct {
+	//+kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+}
+
+// +kubebuilder:validation:Enum=all;spread;binpack;least-loaded
+type SchedulingStrategyType string
+
+const (
+	// AllSchedulingStrategy schedules each deployment target on every selected cluster type with enough capacity
+	AllSchedulingStrategy SchedulingStrategyType = "all"
+	// SpreadSchedulingStrategy prefers the cluster types with the fewest assignments
+	SpreadSchedulingStrategy SchedulingStrategyType = "spread"
+	// BinPackSchedulingStrategy prefers the cluster types with the highest utilization of their capacity
+	BinPackSchedulingStrategy SchedulingStrategyType = "binpack"
+	// LeastLoadedSchedulingStrategy prefers the cluster types with the lowest utilization of their capacity
+	LeastLoadedSchedulingStrategy SchedulingStrategyType = "least-loaded"
+)
+
+type SchedulingStrategySpec struct {
+	//+kubebuilder:default=all
+	Type SchedulingStrategyType `json:"type,omitempty"`
+
+	// ClusterTypes is the number of cluster types each deployment target is scheduled on
+	// with the spread, binpack and least-loaded strategies
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:default=1
+	//+optional
+	ClusterTypes int32 `json:"clusterTypes,omitempty"`
 }
 
 type DeploymentTargetSelectorSpec struct {
@@ -37,12 +83,39 @@ type DeploymentTargetSelectorSpec struct {
 }
 
 type ClusterTypeSelectorSpec struct {
+	//+optional
 	LabelSelector metav1.LabelSelector `json:"labelSelector"`
+
+	// Placement refers to an Open Cluster Management Placement. The cluster types are selected by the names
+	// of the clusters in its decisions and narrowed down with the label selector.
+	//+optional
+	Placement *PlacementReference `json:"placement,omitempty"`
+}
+
+// PlacementReference refers to an Open Cluster Management Placement
+type PlacementReference struct {
+	Name string `json:"name"`
+
+	// Namespace of the Placement, it's the namespace of the scheduling policy if it's not set
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // SchedulingPolicyStatus defines the observed state of SchedulingPolicy
 type SchedulingPolicyStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// UnplacedDeploymentTargets are the selected deployment targets that are not scheduled on
+	// as many cluster types as the strategy requires
+	//+optional
+	UnplacedDeploymentTargets []UnplacedDeploymentTarget `json:"unplacedDeploymentTargets,omitempty"`
+}
+
+// UnplacedDeploymentTarget explains why a deployment target is not fully placed
+type UnplacedDeploymentTarget struct {
+	Name     string `json:"name"`
+	Workload string `json:"workload,omitempty"`
+	Message  string `json:"message"`
 }
 
 //+kubebuilder:object:root=true
@@ -24,15 +24,36 @@ import (
 type WorkloadRegistrationSpec struct {
 	Workload  ManifestsSpec `json:"workload"`
 	Workspace string        `json:"workspace,omitempty"`
+
+	// SourceReconciler is the GitOps operator on the control plane cluster that fetches the workload.
+	// If it's not set, the scheduler uses the one from its --source-reconciler flag.
+	//+optional
+	SourceReconciler SourceReconcilerType `json:"sourceReconciler,omitempty"`
+
+	// Flux overrides the settings of the Flux resources that fetch the workload
+	//+optional
+	Flux *FluxSpec `json:"flux,omitempty"`
 }
 
 // WorkloadRegistrationStatus defines the observed state of WorkloadRegistration
 type WorkloadRegistrationStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// LastAppliedRevision is the revision of the repo the source reconciler last applied the workload from
+	//+optional
+	LastAppliedRevision string `json:"lastAppliedRevision,omitempty"`
+
+	// SourceReconciler is the GitOps operator that fetches the workload, the resources of the other one are deleted when it changes
+	//+optional
+	SourceReconciler SourceReconcilerType `json:"sourceReconciler,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Revision",type="string",JSONPath=".status.lastAppliedRevision"
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].message"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // WorkloadRegistration is the Schema for the workloadregistrations API
 type WorkloadRegistration struct {
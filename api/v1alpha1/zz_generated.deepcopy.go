@@ -21,10 +21,70 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Affinity) DeepCopyInto(out *Affinity) {
+	*out = *in
+	if in.ClusterTypeAffinity != nil {
+		in, out := &in.ClusterTypeAffinity, &out.ClusterTypeAffinity
+		*out = new(AffinityTerms)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DeploymentTargetAffinity != nil {
+		in, out := &in.DeploymentTargetAffinity, &out.DeploymentTargetAffinity
+		*out = new(AffinityTerms)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DeploymentTargetAntiAffinity != nil {
+		in, out := &in.DeploymentTargetAntiAffinity, &out.DeploymentTargetAntiAffinity
+		*out = new(AffinityTerms)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Affinity.
+func (in *Affinity) DeepCopy() *Affinity {
+	if in == nil {
+		return nil
+	}
+	out := new(Affinity)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AffinityTerms) DeepCopyInto(out *AffinityTerms) {
+	*out = *in
+	if in.Required != nil {
+		in, out := &in.Required, &out.Required
+		*out = make([]v1.LabelSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Preferred != nil {
+		in, out := &in.Preferred, &out.Preferred
+		*out = make([]WeightedLabelSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AffinityTerms.
+func (in *AffinityTerms) DeepCopy() *AffinityTerms {
+	if in == nil {
+		return nil
+	}
+	out := new(AffinityTerms)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Assignment) DeepCopyInto(out *Assignment) {
 	*out = *in
@@ -233,12 +293,42 @@ func (in *AssignmentStatus) DeepCopy() *AssignmentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoMergeSpec) DeepCopyInto(out *AutoMergeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoMergeSpec.
+func (in *AutoMergeSpec) DeepCopy() *AutoMergeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoMergeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoMergeStatus) DeepCopyInto(out *AutoMergeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoMergeStatus.
+func (in *AutoMergeStatus) DeepCopy() *AutoMergeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoMergeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BaseRepo) DeepCopyInto(out *BaseRepo) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -296,6 +386,11 @@ func (in *BaseRepoList) DeepCopyObject() runtime.Object {
 func (in *BaseRepoSpec) DeepCopyInto(out *BaseRepoSpec) {
 	*out = *in
 	out.ManifestsSpec = in.ManifestsSpec
+	if in.Flux != nil {
+		in, out := &in.Flux, &out.Flux
+		*out = new(FluxSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BaseRepoSpec.
@@ -330,6 +425,58 @@ func (in *BaseRepoStatus) DeepCopy() *BaseRepoStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BatchStatus) DeepCopyInto(out *BatchStatus) {
+	*out = *in
+	in.FirstChangeAt.DeepCopyInto(&out.FirstChangeAt)
+	in.LastChangeAt.DeepCopyInto(&out.LastChangeAt)
+	in.NextPRAt.DeepCopyInto(&out.NextPRAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BatchStatus.
+func (in *BatchStatus) DeepCopy() *BatchStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BatchStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BatchingSpec) DeepCopyInto(out *BatchingSpec) {
+	*out = *in
+	out.QuietPeriod = in.QuietPeriod
+	out.MaxDelay = in.MaxDelay
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BatchingSpec.
+func (in *BatchingSpec) DeepCopy() *BatchingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BatchingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BlackoutSpec) DeepCopyInto(out *BlackoutSpec) {
+	*out = *in
+	in.Start.DeepCopyInto(&out.Start)
+	in.End.DeepCopyInto(&out.End)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BlackoutSpec.
+func (in *BlackoutSpec) DeepCopy() *BlackoutSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BlackoutSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterContentType) DeepCopyInto(out *ClusterContentType) {
 	*out = *in
@@ -340,6 +487,20 @@ func (in *ClusterContentType) DeepCopyInto(out *ClusterContentType) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.Metadata != nil {
+		in, out := &in.Metadata, &out.Metadata
+		*out = make(map[string]DeploymentTargetMetadata, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make(map[string]ContentSource, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterContentType.
@@ -357,7 +518,7 @@ func (in *ClusterType) DeepCopyInto(out *ClusterType) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 }
 
@@ -415,6 +576,11 @@ func (in *ClusterTypeList) DeepCopyObject() runtime.Object {
 func (in *ClusterTypeSelectorSpec) DeepCopyInto(out *ClusterTypeSelectorSpec) {
 	*out = *in
 	in.LabelSelector.DeepCopyInto(&out.LabelSelector)
+	if in.Placement != nil {
+		in, out := &in.Placement, &out.Placement
+		*out = new(PlacementReference)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterTypeSelectorSpec.
@@ -430,6 +596,23 @@ func (in *ClusterTypeSelectorSpec) DeepCopy() *ClusterTypeSelectorSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterTypeSpec) DeepCopyInto(out *ClusterTypeSpec) {
 	*out = *in
+	if in.Capacity != nil {
+		in, out := &in.Capacity, &out.Capacity
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Cost != nil {
+		in, out := &in.Cost, &out.Cost
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Taints != nil {
+		in, out := &in.Taints, &out.Taints
+		*out = make([]Taint, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterTypeSpec.
@@ -457,6 +640,46 @@ func (in *ClusterTypeStatus) DeepCopy() *ClusterTypeStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CommitAuthor) DeepCopyInto(out *CommitAuthor) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CommitAuthor.
+func (in *CommitAuthor) DeepCopy() *CommitAuthor {
+	if in == nil {
+		return nil
+	}
+	out := new(CommitAuthor)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CommitSpec) DeepCopyInto(out *CommitSpec) {
+	*out = *in
+	if in.Author != nil {
+		in, out := &in.Author, &out.Author
+		*out = new(CommitAuthor)
+		**out = **in
+	}
+	if in.SigningKey != nil {
+		in, out := &in.SigningKey, &out.SigningKey
+		*out = new(SigningKey)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CommitSpec.
+func (in *CommitSpec) DeepCopy() *CommitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CommitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigSchema) DeepCopyInto(out *ConfigSchema) {
 	*out = *in
@@ -546,6 +769,79 @@ func (in *ConfigSchemaStatus) DeepCopy() *ConfigSchemaStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContentSource) DeepCopyInto(out *ContentSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContentSource.
+func (in *ContentSource) DeepCopy() *ContentSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ContentSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DecryptionSpec) DeepCopyInto(out *DecryptionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DecryptionSpec.
+func (in *DecryptionSpec) DeepCopy() *DecryptionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DecryptionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeliveryWindowSpec) DeepCopyInto(out *DeliveryWindowSpec) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeliveryWindowSpec.
+func (in *DeliveryWindowSpec) DeepCopy() *DeliveryWindowSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DeliveryWindowSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeliveryWindowsSpec) DeepCopyInto(out *DeliveryWindowsSpec) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]DeliveryWindowSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.Blackouts != nil {
+		in, out := &in.Blackouts, &out.Blackouts
+		*out = make([]BlackoutSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeliveryWindowsSpec.
+func (in *DeliveryWindowsSpec) DeepCopy() *DeliveryWindowsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DeliveryWindowsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeploymentTarget) DeepCopyInto(out *DeploymentTarget) {
 	*out = *in
@@ -628,6 +924,21 @@ func (in *DeploymentTargetList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentTargetMetadata) DeepCopyInto(out *DeploymentTargetMetadata) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentTargetMetadata.
+func (in *DeploymentTargetMetadata) DeepCopy() *DeploymentTargetMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentTargetMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeploymentTargetSelectorSpec) DeepCopyInto(out *DeploymentTargetSelectorSpec) {
 	*out = *in
@@ -659,6 +970,23 @@ func (in *DeploymentTargetSpec) DeepCopyInto(out *DeploymentTargetSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]Toleration, len(*in))
+		copy(*out, *in)
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(Affinity)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentTargetSpec.
@@ -686,12 +1014,28 @@ func (in *DeploymentTargetStatus) DeepCopy() *DeploymentTargetStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriftDetectionSpec) DeepCopyInto(out *DriftDetectionSpec) {
+	*out = *in
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriftDetectionSpec.
+func (in *DriftDetectionSpec) DeepCopy() *DriftDetectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DriftDetectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Environment) DeepCopyInto(out *Environment) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -749,6 +1093,11 @@ func (in *EnvironmentList) DeepCopyObject() runtime.Object {
 func (in *EnvironmentSpec) DeepCopyInto(out *EnvironmentSpec) {
 	*out = *in
 	out.ControlPlane = in.ControlPlane
+	if in.Flux != nil {
+		in, out := &in.Flux, &out.Flux
+		*out = new(FluxSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvironmentSpec.
@@ -783,6 +1132,41 @@ func (in *EnvironmentStatus) DeepCopy() *EnvironmentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FluxSpec) DeepCopyInto(out *FluxSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Decryption != nil {
+		in, out := &in.Decryption, &out.Decryption
+		*out = new(DecryptionSpec)
+		**out = **in
+	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FluxSpec.
+func (in *FluxSpec) DeepCopy() *FluxSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FluxSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitIssueStatus) DeepCopyInto(out *GitIssueStatus) {
 	*out = *in
@@ -803,7 +1187,7 @@ func (in *GitOpsRepo) DeepCopyInto(out *GitOpsRepo) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -861,6 +1245,56 @@ func (in *GitOpsRepoList) DeepCopyObject() runtime.Object {
 func (in *GitOpsRepoSpec) DeepCopyInto(out *GitOpsRepoSpec) {
 	*out = *in
 	out.ManifestsSpec = in.ManifestsSpec
+	if in.PullRequest != nil {
+		in, out := &in.PullRequest, &out.PullRequest
+		*out = new(PullRequestSpec)
+		**out = **in
+	}
+	if in.AutoMerge != nil {
+		in, out := &in.AutoMerge, &out.AutoMerge
+		*out = new(AutoMergeSpec)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(SecretReference)
+		**out = **in
+	}
+	if in.Commit != nil {
+		in, out := &in.Commit, &out.Commit
+		*out = new(CommitSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Layout != nil {
+		in, out := &in.Layout, &out.Layout
+		*out = new(LayoutSpec)
+		**out = **in
+	}
+	if in.OCI != nil {
+		in, out := &in.OCI, &out.OCI
+		*out = new(OCIRepositorySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ClusterTypeSelector != nil {
+		in, out := &in.ClusterTypeSelector, &out.ClusterTypeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DriftDetection != nil {
+		in, out := &in.DriftDetection, &out.DriftDetection
+		*out = new(DriftDetectionSpec)
+		**out = **in
+	}
+	if in.DeliveryWindows != nil {
+		in, out := &in.DeliveryWindows, &out.DeliveryWindows
+		*out = new(DeliveryWindowsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Batching != nil {
+		in, out := &in.Batching, &out.Batching
+		*out = new(BatchingSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitOpsRepoSpec.
@@ -876,6 +1310,50 @@ func (in *GitOpsRepoSpec) DeepCopy() *GitOpsRepoSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitOpsRepoStatus) DeepCopyInto(out *GitOpsRepoStatus) {
 	*out = *in
+	if in.PullRequest != nil {
+		in, out := &in.PullRequest, &out.PullRequest
+		*out = new(PullRequestStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AutoMerge != nil {
+		in, out := &in.AutoMerge, &out.AutoMerge
+		*out = new(AutoMergeStatus)
+		**out = **in
+	}
+	if in.OCIArtifacts != nil {
+		in, out := &in.OCIArtifacts, &out.OCIArtifacts
+		*out = make([]OCIArtifactStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterTypes != nil {
+		in, out := &in.ClusterTypes, &out.ClusterTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OverlappingClusterTypes != nil {
+		in, out := &in.OverlappingClusterTypes, &out.OverlappingClusterTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UnownedClusterTypes != nil {
+		in, out := &in.UnownedClusterTypes, &out.UnownedClusterTypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DriftedPaths != nil {
+		in, out := &in.DriftedPaths, &out.DriftedPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastDriftCheck != nil {
+		in, out := &in.LastDriftCheck, &out.LastDriftCheck
+		*out = (*in).DeepCopy()
+	}
+	if in.Batch != nil {
+		in, out := &in.Batch, &out.Batch
+		*out = new(BatchStatus)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]v1.Condition, len(*in))
@@ -896,31 +1374,317 @@ func (in *GitOpsRepoStatus) DeepCopy() *GitOpsRepoStatus {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ManifestsSpec) DeepCopyInto(out *ManifestsSpec) {
+func (in *LayoutSpec) DeepCopyInto(out *LayoutSpec) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestsSpec.
-func (in *ManifestsSpec) DeepCopy() *ManifestsSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LayoutSpec.
+func (in *LayoutSpec) DeepCopy() *LayoutSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(ManifestsSpec)
+	out := new(LayoutSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RepoContentType) DeepCopyInto(out *RepoContentType) {
+func (in *ManifestsSpec) DeepCopyInto(out *ManifestsSpec) {
 	*out = *in
-	if in.ClusterTypes != nil {
-		in, out := &in.ClusterTypes, &out.ClusterTypes
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestsSpec.
+func (in *ManifestsSpec) DeepCopy() *ManifestsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OCIArtifactStatus) DeepCopyInto(out *OCIArtifactStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OCIArtifactStatus.
+func (in *OCIArtifactStatus) DeepCopy() *OCIArtifactStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OCIArtifactStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OCIRepositorySpec) DeepCopyInto(out *OCIRepositorySpec) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(SecretReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OCIRepositorySpec.
+func (in *OCIRepositorySpec) DeepCopy() *OCIRepositorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OCIRepositorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlacementReference) DeepCopyInto(out *PlacementReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlacementReference.
+func (in *PlacementReference) DeepCopy() *PlacementReference {
+	if in == nil {
+		return nil
+	}
+	out := new(PlacementReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionGates) DeepCopyInto(out *PromotionGates) {
+	*out = *in
+	if in.SoakTime != nil {
+		in, out := &in.SoakTime, &out.SoakTime
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionGates.
+func (in *PromotionGates) DeepCopy() *PromotionGates {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionGates)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionPipeline) DeepCopyInto(out *PromotionPipeline) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionPipeline.
+func (in *PromotionPipeline) DeepCopy() *PromotionPipeline {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionPipeline)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PromotionPipeline) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionPipelineList) DeepCopyInto(out *PromotionPipelineList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PromotionPipeline, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionPipelineList.
+func (in *PromotionPipelineList) DeepCopy() *PromotionPipelineList {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionPipelineList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PromotionPipelineList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionPipelineSpec) DeepCopyInto(out *PromotionPipelineSpec) {
+	*out = *in
+	if in.Stages != nil {
+		in, out := &in.Stages, &out.Stages
+		*out = make([]PromotionStage, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionPipelineSpec.
+func (in *PromotionPipelineSpec) DeepCopy() *PromotionPipelineSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionPipelineSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionPipelineStatus) DeepCopyInto(out *PromotionPipelineStatus) {
+	*out = *in
+	if in.Stages != nil {
+		in, out := &in.Stages, &out.Stages
+		*out = make([]PromotionStageStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]PromotionRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionPipelineStatus.
+func (in *PromotionPipelineStatus) DeepCopy() *PromotionPipelineStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionPipelineStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionRecord) DeepCopyInto(out *PromotionRecord) {
+	*out = *in
+	in.PromotedAt.DeepCopyInto(&out.PromotedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionRecord.
+func (in *PromotionRecord) DeepCopy() *PromotionRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionStage) DeepCopyInto(out *PromotionStage) {
+	*out = *in
+	in.Gates.DeepCopyInto(&out.Gates)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionStage.
+func (in *PromotionStage) DeepCopy() *PromotionStage {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionStage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PromotionStageStatus) DeepCopyInto(out *PromotionStageStatus) {
+	*out = *in
+	in.Since.DeepCopyInto(&out.Since)
+	if in.DeployedSince != nil {
+		in, out := &in.DeployedSince, &out.DeployedSince
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromotionStageStatus.
+func (in *PromotionStageStatus) DeepCopy() *PromotionStageStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PromotionStageStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullRequestSpec) DeepCopyInto(out *PullRequestSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullRequestSpec.
+func (in *PullRequestSpec) DeepCopy() *PullRequestSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PullRequestSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PullRequestStatus) DeepCopyInto(out *PullRequestStatus) {
+	*out = *in
+	in.CreatedAt.DeepCopyInto(&out.CreatedAt)
+	in.UpdatedAt.DeepCopyInto(&out.UpdatedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PullRequestStatus.
+func (in *PullRequestStatus) DeepCopy() *PullRequestStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PullRequestStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepoContentType) DeepCopyInto(out *RepoContentType) {
+	*out = *in
+	if in.ClusterTypes != nil {
+		in, out := &in.ClusterTypes, &out.ClusterTypes
 		*out = make(map[string]ClusterContentType, len(*in))
 		for key, val := range *in {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
-	out.BaseRepo = in.BaseRepo
+	in.BaseRepo.DeepCopyInto(&out.BaseRepo)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepoContentType.
@@ -997,6 +1761,16 @@ func (in *SchedulingPolicySpec) DeepCopyInto(out *SchedulingPolicySpec) {
 	*out = *in
 	in.DeploymentTargetSelector.DeepCopyInto(&out.DeploymentTargetSelector)
 	in.ClusterTypeSelector.DeepCopyInto(&out.ClusterTypeSelector)
+	if in.Strategy != nil {
+		in, out := &in.Strategy, &out.Strategy
+		*out = new(SchedulingStrategySpec)
+		**out = **in
+	}
+	if in.ScorePlugins != nil {
+		in, out := &in.ScorePlugins, &out.ScorePlugins
+		*out = make([]ScorePluginWeight, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulingPolicySpec.
@@ -1019,6 +1793,11 @@ func (in *SchedulingPolicyStatus) DeepCopyInto(out *SchedulingPolicyStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.UnplacedDeploymentTargets != nil {
+		in, out := &in.UnplacedDeploymentTargets, &out.UnplacedDeploymentTargets
+		*out = make([]UnplacedDeploymentTarget, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulingPolicyStatus.
@@ -1031,6 +1810,82 @@ func (in *SchedulingPolicyStatus) DeepCopy() *SchedulingPolicyStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SchedulingStrategySpec) DeepCopyInto(out *SchedulingStrategySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulingStrategySpec.
+func (in *SchedulingStrategySpec) DeepCopy() *SchedulingStrategySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SchedulingStrategySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScorePluginWeight) DeepCopyInto(out *ScorePluginWeight) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScorePluginWeight.
+func (in *ScorePluginWeight) DeepCopy() *ScorePluginWeight {
+	if in == nil {
+		return nil
+	}
+	out := new(ScorePluginWeight)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretReference) DeepCopyInto(out *SecretReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretReference.
+func (in *SecretReference) DeepCopy() *SecretReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SigningKey) DeepCopyInto(out *SigningKey) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SigningKey.
+func (in *SigningKey) DeepCopy() *SigningKey {
+	if in == nil {
+		return nil
+	}
+	out := new(SigningKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Taint) DeepCopyInto(out *Taint) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Taint.
+func (in *Taint) DeepCopy() *Taint {
+	if in == nil {
+		return nil
+	}
+	out := new(Taint)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Template) DeepCopyInto(out *Template) {
 	*out = *in
@@ -1125,6 +1980,52 @@ func (in *TemplateStatus) DeepCopy() *TemplateStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Toleration) DeepCopyInto(out *Toleration) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Toleration.
+func (in *Toleration) DeepCopy() *Toleration {
+	if in == nil {
+		return nil
+	}
+	out := new(Toleration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UnplacedDeploymentTarget) DeepCopyInto(out *UnplacedDeploymentTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UnplacedDeploymentTarget.
+func (in *UnplacedDeploymentTarget) DeepCopy() *UnplacedDeploymentTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(UnplacedDeploymentTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeightedLabelSelector) DeepCopyInto(out *WeightedLabelSelector) {
+	*out = *in
+	in.LabelSelector.DeepCopyInto(&out.LabelSelector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeightedLabelSelector.
+func (in *WeightedLabelSelector) DeepCopy() *WeightedLabelSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(WeightedLabelSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Workload) DeepCopyInto(out *Workload) {
 	*out = *in
@@ -1189,7 +2090,7 @@ func (in *WorkloadRegistration) DeepCopyInto(out *WorkloadRegistration) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -1247,6 +2148,11 @@ func (in *WorkloadRegistrationList) DeepCopyObject() runtime.Object {
 func (in *WorkloadRegistrationSpec) DeepCopyInto(out *WorkloadRegistrationSpec) {
 	*out = *in
 	out.Workload = in.Workload
+	if in.Flux != nil {
+		in, out := &in.Flux, &out.Flux
+		*out = new(FluxSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadRegistrationSpec.
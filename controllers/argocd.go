@@ -0,0 +1,221 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	DefaultArgoCDNamespace = "argocd"
+	DefaultArgoCDProject   = "default"
+	// the label and the annotation Argo CD tracks the resources of an Application with
+	ArgoCDInstanceLabel        = "app.kubernetes.io/instance"
+	ArgoCDTrackingIDAnnotation = "argocd.argoproj.io/tracking-id"
+	// deletes the resources of the Application along with it, as Flux prunes the resources of a deleted Kustomization
+	argoCDResourcesFinalizer = "resources-finalizer.argocd.argoproj.io"
+	// the control plane cluster itself
+	argoCDDestinationServer = "https://kubernetes.default.svc"
+)
+
+var argoCDApplicationGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Application"}
+
+// ArgoCDConfig is the configuration of the Argo CD resources, it's set with the --argocd-* flags
+type ArgoCDConfig struct {
+	// Namespace where the Argo CD Applications are created, argocd if it's not set
+	Namespace string
+	// Project of the Argo CD Applications, default if it's not set
+	Project string
+}
+
+// implements SourceReconciler interface with Argo CD Application.
+// The repo credentials are taken from the Argo CD repository secrets.
+type argoCD struct {
+	ctx       context.Context
+	client    client.Client
+	namespace string
+	project   string
+}
+
+// validate argoCD implements SourceReconciler interface
+var _ SourceReconciler = (*argoCD)(nil)
+
+// new argoCD function
+func NewArgoCD(ctx context.Context, client client.Client, config ArgoCDConfig) SourceReconciler {
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = DefaultArgoCDNamespace
+	}
+	project := config.Project
+	if project == "" {
+		project = DefaultArgoCDProject
+	}
+
+	return &argoCD{
+		ctx:       ctx,
+		client:    client,
+		namespace: namespace,
+		project:   project,
+	}
+}
+
+func (a *argoCD) Kind() string {
+	return "ArgoCD"
+}
+
+func (a *argoCD) Namespace() string {
+	return a.namespace
+}
+
+func newArgoCDApplication() *unstructured.Unstructured {
+	application := &unstructured.Unstructured{}
+	application.SetGroupVersionKind(argoCDApplicationGVK)
+	return application
+}
+
+func (a *argoCD) CreateReferenceResources(name, targetnamespace, url, branch, path, commit string) error {
+	application := newArgoCDApplication()
+	applicationExists := true
+	err := a.client.Get(a.ctx, client.ObjectKey{Name: name, Namespace: a.namespace}, application)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		application = newArgoCDApplication()
+		application.SetName(name)
+		application.SetNamespace(a.namespace)
+
+		applicationExists = false
+	}
+
+	targetRevision := branch
+	if commit != "" {
+		targetRevision = commit
+	}
+
+	controllerutil.AddFinalizer(application, argoCDResourcesFinalizer)
+	application.Object["spec"] = map[string]interface{}{
+		"project": a.project,
+		"source": map[string]interface{}{
+			"repoURL":        url,
+			"targetRevision": targetRevision,
+			"path":           path,
+		},
+		"destination": map[string]interface{}{
+			"server":    argoCDDestinationServer,
+			"namespace": targetnamespace,
+		},
+		"syncPolicy": map[string]interface{}{
+			"automated": map[string]interface{}{
+				"prune":    true,
+				"selfHeal": true,
+			},
+		},
+	}
+
+	if applicationExists {
+		return a.client.Update(a.ctx, application)
+	}
+	return a.client.Create(a.ctx, application)
+}
+
+func (a *argoCD) DeleteReferenceResources(name string) error {
+	application := newArgoCDApplication()
+	err := a.client.Get(a.ctx, client.ObjectKey{Name: name, Namespace: a.namespace}, application)
+	if err != nil {
+		// there is nothing to delete if Argo CD is not installed
+		if apimeta.IsNoMatchError(err) || errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	return client.IgnoreNotFound(a.client.Delete(a.ctx, application))
+}
+
+func (a *argoCD) GetReferenceResourcesStatus(name string) (*SourceStatus, error) {
+	application := newArgoCDApplication()
+	err := a.client.Get(a.ctx, client.ObjectKey{Name: name, Namespace: a.namespace}, application)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return progressingSourceStatus(a.Kind()), nil
+		}
+		return nil, err
+	}
+
+	// the application conditions report the errors, such as ComparisonError or SyncError
+	conditions, _, _ := unstructured.NestedSlice(application.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditionType, _ := condition["type"].(string)
+		if strings.HasSuffix(conditionType, "Error") {
+			message, _ := condition["message"].(string)
+			return &SourceStatus{
+				Status:  metav1.ConditionFalse,
+				Reason:  conditionType,
+				Message: fmt.Sprintf("Application %s/%s: %s", a.namespace, name, message),
+			}, nil
+		}
+	}
+
+	phase, _, _ := unstructured.NestedString(application.Object, "status", "operationState", "phase")
+	if phase == "Failed" || phase == "Error" {
+		message, _, _ := unstructured.NestedString(application.Object, "status", "operationState", "message")
+		return &SourceStatus{
+			Status:  metav1.ConditionFalse,
+			Reason:  "SyncFailed",
+			Message: fmt.Sprintf("Application %s/%s: %s", a.namespace, name, message),
+		}, nil
+	}
+
+	syncStatus, _, _ := unstructured.NestedString(application.Object, "status", "sync", "status")
+	revision, _, _ := unstructured.NestedString(application.Object, "status", "sync", "revision")
+	healthStatus, _, _ := unstructured.NestedString(application.Object, "status", "health", "status")
+	if healthStatus == "Degraded" {
+		message, _, _ := unstructured.NestedString(application.Object, "status", "health", "message")
+		return &SourceStatus{
+			Status:              metav1.ConditionFalse,
+			Reason:              "Degraded",
+			Message:             fmt.Sprintf("Application %s/%s: %s", a.namespace, name, message),
+			LastAppliedRevision: revision,
+		}, nil
+	}
+
+	if syncStatus == "Synced" && healthStatus == "Healthy" {
+		return &SourceStatus{
+			Status:              metav1.ConditionTrue,
+			Reason:              "Synced",
+			Message:             fmt.Sprintf("Synced revision: %s", revision),
+			LastAppliedRevision: revision,
+		}, nil
+	}
+
+	return progressingSourceStatus(a.Kind()), nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestArgoCDApplication(name, namespace string, status map[string]interface{}) *unstructured.Unstructured {
+	application := newArgoCDApplication()
+	application.SetName(name)
+	application.SetNamespace(namespace)
+	application.Object["status"] = status
+	return application
+}
+
+// Test the health and the sync state of the Application are mapped to the status of the source
+func TestArgoCDGetReferenceResourcesStatus(t *testing.T) {
+	synced := map[string]interface{}{"status": "Synced", "revision": "abc"}
+	healthy := map[string]interface{}{"status": "Healthy"}
+
+	tests := []struct {
+		name   string
+		status map[string]interface{}
+		want   *SourceStatus
+	}{
+		{
+			name: "missing application",
+			want: progressingSourceStatus("ArgoCD"),
+		},
+		{
+			name: "error condition",
+			status: map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "SharedResourceWarning", "message": "shared"},
+					map[string]interface{}{"type": "ComparisonError", "message": "repository not found"},
+				},
+				"sync":   synced,
+				"health": healthy,
+			},
+			want: &SourceStatus{Status: metav1.ConditionFalse, Reason: "ComparisonError", Message: "Application gitops/dev: repository not found"},
+		},
+		{
+			name: "failed sync",
+			status: map[string]interface{}{
+				"operationState": map[string]interface{}{"phase": "Failed", "message": "one or more objects failed to apply"},
+				"sync":           synced,
+				"health":         healthy,
+			},
+			want: &SourceStatus{Status: metav1.ConditionFalse, Reason: "SyncFailed", Message: "Application gitops/dev: one or more objects failed to apply"},
+		},
+		{
+			name: "degraded",
+			status: map[string]interface{}{
+				"sync":   synced,
+				"health": map[string]interface{}{"status": "Degraded", "message": "Deployment has no available replicas"},
+			},
+			want: &SourceStatus{Status: metav1.ConditionFalse, Reason: "Degraded", Message: "Application gitops/dev: Deployment has no available replicas", LastAppliedRevision: "abc"},
+		},
+		{
+			name: "out of sync",
+			status: map[string]interface{}{
+				"sync":   map[string]interface{}{"status": "OutOfSync", "revision": "abc"},
+				"health": healthy,
+			},
+			want: progressingSourceStatus("ArgoCD"),
+		},
+		{
+			name: "progressing",
+			status: map[string]interface{}{
+				"sync":   synced,
+				"health": map[string]interface{}{"status": "Progressing"},
+			},
+			want: progressingSourceStatus("ArgoCD"),
+		},
+		{
+			name: "synced and healthy, the warnings are ignored",
+			status: map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "SharedResourceWarning", "message": "shared"},
+				},
+				"operationState": map[string]interface{}{"phase": "Succeeded"},
+				"sync":           synced,
+				"health":         healthy,
+			},
+			want: &SourceStatus{Status: metav1.ConditionTrue, Reason: "Synced", Message: "Synced revision: abc", LastAppliedRevision: "abc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []client.Object
+			if tt.status != nil {
+				objects = append(objects, newTestArgoCDApplication("dev", "gitops", tt.status))
+			}
+			argoCD := NewArgoCD(context.Background(), newFakeClient(t, objects...), ArgoCDConfig{Namespace: "gitops"})
+
+			status, err := argoCD.GetReferenceResourcesStatus("dev")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, status)
+		})
+	}
+}
+
+// Test the Application is created in the configured namespace and project
+func TestArgoCDCreateReferenceResources(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t)
+
+	argoCD := NewArgoCD(ctx, c, ArgoCDConfig{})
+	assert.Equal(t, DefaultArgoCDNamespace, argoCD.Namespace())
+
+	argoCD = NewArgoCD(ctx, c, ArgoCDConfig{Namespace: "gitops", Project: "platform"})
+	assert.Equal(t, "gitops", argoCD.Namespace())
+	assert.NoError(t, argoCD.CreateReferenceResources("dev-main", "dev", "https://github.com/microsoft/kalypso-control-plane", "main", ".", ""))
+
+	application := newArgoCDApplication()
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: "dev-main", Namespace: "gitops"}, application))
+	assert.Equal(t, []string{argoCDResourcesFinalizer}, application.GetFinalizers())
+	project, _, _ := unstructured.NestedString(application.Object, "spec", "project")
+	assert.Equal(t, "platform", project)
+	targetRevision, _, _ := unstructured.NestedString(application.Object, "spec", "source", "targetRevision")
+	assert.Equal(t, "main", targetRevision)
+	destination, _, _ := unstructured.NestedString(application.Object, "spec", "destination", "namespace")
+	assert.Equal(t, "dev", destination)
+
+	// the commit is pinned over the branch
+	assert.NoError(t, argoCD.CreateReferenceResources("dev-main", "dev", "https://github.com/microsoft/kalypso-control-plane", "main", ".", "abc"))
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: "dev-main", Namespace: "gitops"}, application))
+	targetRevision, _, _ = unstructured.NestedString(application.Object, "spec", "source", "targetRevision")
+	assert.Equal(t, "abc", targetRevision)
+
+	assert.NoError(t, argoCD.DeleteReferenceResources("dev-main"))
+	assert.NoError(t, argoCD.DeleteReferenceResources("dev-main"))
+}
@@ -634,18 +634,25 @@ func (r *AssignmentReconciler) updateGitHubIssue(ctx context.Context, logger log
 	if messageHash != issueContentHash {
 
 		issueTitle := "Can't generate manifests for deplyment target " + assignment.Spec.DeploymentTarget + " in cluster type " + assignment.Spec.ClusterType + " in " + assignment.Namespace + " environment"
-		gitopsrepo, err := findGitOpsRepo(ctx, r.Client, assignment)
+		gitopsrepo, err := findGitOpsRepo(ctx, r.Client, assignment.Namespace, assignment.Spec.ClusterType)
 		if err != nil {
 			return nil, err
 		}
 
-		githubRepo, err := scheduler.NewGithubRepo(ctx, &gitopsrepo.Spec)
-		if err != nil {
-			return nil, err
-		}
-		issueNo, err = githubRepo.UpdateIssue(issueNo, issueTitle, message)
-		if err != nil {
-			return nil, err
+		// there is no git repo to report the issues to
+		if gitopsrepo.Spec.Delivery == schedulerv1alpha1.OCIDelivery {
+			if message != nil {
+				logger.Info("Issues are not supported with OCI delivery", "title", issueTitle, "message", *message)
+			}
+		} else {
+			gitRepo, err := newGitRepo(ctx, r.Client, gitopsrepo)
+			if err != nil {
+				return nil, err
+			}
+			issueNo, err = gitRepo.UpdateIssue(issueNo, issueTitle, message)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 	}
@@ -25,9 +25,13 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/go-logr/logr"
 	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
@@ -37,74 +41,132 @@ import (
 type BaseRepoReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// DefaultSourceReconciler is used if the resource doesn't specify its source reconciler
+	DefaultSourceReconciler schedulerv1alpha1.SourceReconcilerType
+	// FluxConfig is the manager wide configuration of the Flux resources
+	FluxConfig FluxConfig
+	// ArgoCDConfig is the manager wide configuration of the Argo CD resources
+	ArgoCDConfig ArgoCDConfig
 }
 
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=baserepoes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=baserepoes/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=baserepoes/finalizers,verbs=update
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=gitopsrepoes,verbs=get;list;watch
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=gitrepositories,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=argoproj.io,resources=applications,verbs=get;list;watch;create;update;patch;delete
 
 func (r *BaseRepoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	reqLogger := log.FromContext(ctx)
 	reqLogger.Info("=== Reconciling Base Repo ===")
 
+	name := fmt.Sprintf("%s-%s", req.Namespace, req.Name)
+
 	// Fetch the BaseRepo instance
 	baserepo := &schedulerv1alpha1.BaseRepo{}
-	deleted := false
 	err := r.Get(ctx, req.NamespacedName, baserepo)
 	if err != nil {
 		ignroredNotFound := client.IgnoreNotFound(err)
 		if ignroredNotFound != nil {
-			reqLogger.Error(err, "Failed to get Base Rep")
+			reqLogger.Error(err, "Failed to get Base Repo")
 			return ctrl.Result{}, ignroredNotFound
-		} else {
-			deleted = true
 		}
+		// the resource is gone without the finalizer, e.g. it was created before the finalizer was introduced
+		return ctrl.Result{}, deleteSourceResources(ctx, r.Client, r.FluxConfig, r.ArgoCDConfig, name)
 	}
 
 	// Check if the resource is being deleted
 	if !baserepo.ObjectMeta.DeletionTimestamp.IsZero() {
-		deleted = true
+		return r.finalize(ctx, reqLogger, baserepo, name)
 	}
 
-	flux := NewFlux(ctx, r.Client)
-	name := fmt.Sprintf("%s-%s", req.Namespace, req.Name)
-
-	if deleted {
-		err := flux.DeleteFluxReferenceResources(name, DefaulFluxNamespace)
-		if err != nil {
-			return r.manageFailure(ctx, reqLogger, baserepo, err, "Failed to delete flux resources")
-		}
-		reqLogger.Info(fmt.Sprintf("Flux resources %s in %s namespace deleted successfully", name, DefaulFluxNamespace))
-	} else {
-
-		if err := flux.CreateFluxReferenceResources(name, DefaulFluxNamespace, baserepo.Namespace,
-			baserepo.Spec.Repo,
-			baserepo.Spec.Branch,
-			baserepo.Spec.Path,
-			baserepo.Spec.Commit); err != nil {
-			return r.manageFailure(ctx, reqLogger, baserepo, err, "Failed to create flux resources")
+	if controllerutil.AddFinalizer(baserepo, SourceResourcesFinalizer) {
+		if err := r.Update(ctx, baserepo); err != nil {
+			return r.manageFailure(ctx, reqLogger, baserepo, err, "Failed to add finalizer")
 		}
+	}
 
-		reqLogger.Info(fmt.Sprintf("Flux resources %s in %s namespace created successfully", name, DefaulFluxNamespace))
+	sourceReconcilerType := getSourceReconcilerType(baserepo.Spec.SourceReconciler, r.DefaultSourceReconciler)
+	source, err := createSourceResources(ctx, r.Client,
+		sourceReconcilerType, baserepo.Status.SourceReconciler,
+		r.FluxConfig.WithOverrides(baserepo.Spec.Flux),
+		r.ArgoCDConfig,
+		name, baserepo.Namespace,
+		baserepo.Spec.Repo,
+		baserepo.Spec.Branch,
+		baserepo.Spec.Path,
+		baserepo.Spec.Commit)
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, baserepo, err, "Failed to create source reconciler resources")
+	}
 
-		condition := metav1.Condition{
-			Type:   "Ready",
-			Status: metav1.ConditionTrue,
-			Reason: "FluxResourcesCreated",
-		}
-		meta.SetStatusCondition(&baserepo.Status.Conditions, condition)
+	reqLogger.Info(fmt.Sprintf("%s resources %s in %s namespace created successfully", source.Kind(), name, source.Namespace()))
+
+	sourceStatus, err := source.GetReferenceResourcesStatus(name)
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, baserepo, err, "Failed to get source reconciler resources status")
+	}
+
+	baserepo.Status.SourceReconciler = sourceReconcilerType
+	meta.SetStatusCondition(&baserepo.Status.Conditions, sourceStatus.Condition())
+	if sourceStatus.LastAppliedRevision != "" {
+		baserepo.Status.LastAppliedRevision = sourceStatus.LastAppliedRevision
+	}
+
+	updateErr := r.Status().Update(ctx, baserepo)
+	if updateErr != nil {
+		reqLogger.Info("Error when updating status.")
+		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	}
+
+	return getSourceStatusResult(sourceReconcilerType, r.DefaultSourceReconciler), nil
+}
+
+// finalize deletes the source reconciler resources of the base repo. The deletion waits for the open PRs
+// of the GitOps repos whose content refers to the base repo.
+func (r *BaseRepoReconciler) finalize(ctx context.Context, logger logr.Logger, baserepo *schedulerv1alpha1.BaseRepo, name string) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(baserepo, SourceResourcesFinalizer) {
+		return ctrl.Result{}, nil
+	}
 
-		updateErr := r.Status().Update(ctx, baserepo)
-		if updateErr != nil {
-			reqLogger.Info("Error when updating status.")
-			return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	gitopsrepos, err := r.getDependentGitOpsRepos(ctx, baserepo)
+	if err != nil {
+		return r.manageFailure(ctx, logger, baserepo, err, "Failed to get dependent GitOps repos")
+	}
+	if condition := getDeletionBlockedCondition(gitopsrepos); condition != nil {
+		logger.Info(condition.Message)
+		meta.SetStatusCondition(&baserepo.Status.Conditions, *condition)
+		if err := r.Status().Update(ctx, baserepo); err != nil {
+			logger.Info("Error when updating status.")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, err
 		}
+		return ctrl.Result{RequeueAfter: DeletionBlockedRequeueInterval}, nil
+	}
 
+	err = deleteSourceResources(ctx, r.Client, r.FluxConfig, r.ArgoCDConfig, name)
+	if err != nil {
+		return r.manageFailure(ctx, logger, baserepo, err, "Failed to delete source reconciler resources")
 	}
+	logger.Info(fmt.Sprintf("Source reconciler resources %s deleted successfully", name))
 
-	return ctrl.Result{}, nil
+	controllerutil.RemoveFinalizer(baserepo, SourceResourcesFinalizer)
+	return ctrl.Result{}, r.Update(ctx, baserepo)
+}
+
+// getDependentGitOpsRepos returns the GitOps repos whose content refers to the base repo.
+// Only the promoted base repo of the namespace gets to the content of the GitOps repos.
+func (r *BaseRepoReconciler) getDependentGitOpsRepos(ctx context.Context, baserepo *schedulerv1alpha1.BaseRepo) ([]schedulerv1alpha1.GitOpsRepo, error) {
+	if baserepo.Name != schedulerv1alpha1.DefaultPromotedBaseRepo {
+		return nil, nil
+	}
+
+	gitopsrepos := &schedulerv1alpha1.GitOpsRepoList{}
+	err := r.List(ctx, gitopsrepos, client.InNamespace(baserepo.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	return gitopsrepos.Items, nil
 }
 
 // Gracefully handle errors
@@ -129,10 +191,17 @@ func (h *BaseRepoReconciler) manageFailure(ctx context.Context, logger logr.Logg
 	return ctrl.Result{}, err
 }
 
+// findBaseRepos returns the base repos whose source reconciler resources are the object
+func (r *BaseRepoReconciler) findBaseRepos(ctx context.Context, object client.Object) []reconcile.Request {
+	return findResourcesBySourceName(ctx, r.Client, &schedulerv1alpha1.BaseRepoList{}, object, func(object client.Object) string {
+		return fmt.Sprintf("%s-%s", object.GetNamespace(), object.GetName())
+	})
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *BaseRepoReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&schedulerv1alpha1.BaseRepo{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&schedulerv1alpha1.BaseRepo{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	return watchSourceResources(b, r.DefaultSourceReconciler, handler.EnqueueRequestsFromMapFunc(r.findBaseRepos)).
 		Complete(r)
 }
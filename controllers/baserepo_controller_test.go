@@ -0,0 +1,93 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Test only the promoted base repo waits for the PRs of the GitOps repos in its namespace, as the other base repos don't get to their content
+func TestBaseRepoReconcileFinalizer(t *testing.T) {
+	ctx := context.Background()
+	extraBaseRepo := newPromotedBaseRepo("dev", "abc")
+	extraBaseRepo.Name = "extra"
+	c := newFakeClient(t,
+		newPromotedBaseRepo("dev", "abc"),
+		extraBaseRepo,
+		newTestGitOpsRepo("dev", "dev", newOpenPR("1")),
+	)
+	r := &BaseRepoReconciler{Client: c, Scheme: c.Scheme()}
+
+	reconcile := func(name string) (ctrl.Result, *schedulerv1alpha1.BaseRepo, error) {
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: "dev"}}
+		result, err := r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		baseRepo := &schedulerv1alpha1.BaseRepo{}
+		return result, baseRepo, c.Get(ctx, req.NamespacedName, baseRepo)
+	}
+
+	for _, name := range []string{schedulerv1alpha1.DefaultPromotedBaseRepo, "extra"} {
+		_, baseRepo, err := reconcile(name)
+		assert.NoError(t, err)
+		assert.True(t, controllerutil.ContainsFinalizer(baseRepo, SourceResourcesFinalizer))
+		assertSourceResources(t, c, "dev-"+name, true)
+		assert.NoError(t, c.Delete(ctx, baseRepo))
+	}
+
+	// the other base repo is deleted right away
+	_, _, err := reconcile("extra")
+	assert.True(t, errors.IsNotFound(err))
+	assertSourceResources(t, c, "dev-extra", false)
+
+	// the promoted base repo waits for the PR
+	result, baseRepo, err := reconcile(schedulerv1alpha1.DefaultPromotedBaseRepo)
+	assert.NoError(t, err)
+	assert.Equal(t, DeletionBlockedRequeueInterval, result.RequeueAfter)
+	assert.Equal(t, "DeletionBlocked", meta.FindStatusCondition(baseRepo.Status.Conditions, "Ready").Reason)
+	assertSourceResources(t, c, "dev-main", true)
+
+	mergePR(t, c, "dev", "dev")
+	_, _, err = reconcile(schedulerv1alpha1.DefaultPromotedBaseRepo)
+	assert.True(t, errors.IsNotFound(err))
+	assertSourceResources(t, c, "dev-main", false)
+}
+
+// Test the Flux resources of a base repo that is gone without the finalizer are deleted
+func TestBaseRepoReconcileNotFound(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t,
+		&sourcev1.GitRepository{ObjectMeta: metav1.ObjectMeta{Name: "dev-main", Namespace: DefaulFluxNamespace}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "dev-main", Namespace: DefaulFluxNamespace}},
+	)
+	r := &BaseRepoReconciler{Client: c, Scheme: c.Scheme()}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "main", Namespace: "dev"}})
+	assert.NoError(t, err)
+	assertSourceResources(t, c, "dev-main", false)
+}
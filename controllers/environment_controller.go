@@ -26,9 +26,13 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/go-logr/logr"
 	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
@@ -38,13 +42,21 @@ import (
 type EnvironmentReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// DefaultSourceReconciler is used if the resource doesn't specify its source reconciler
+	DefaultSourceReconciler schedulerv1alpha1.SourceReconcilerType
+	// FluxConfig is the manager wide configuration of the Flux resources
+	FluxConfig FluxConfig
+	// ArgoCDConfig is the manager wide configuration of the Argo CD resources
+	ArgoCDConfig ArgoCDConfig
 }
 
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=environments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=environments/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=environments/finalizers,verbs=update
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=gitopsrepoes,verbs=get;list;watch
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=gitrepositories,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=argoproj.io,resources=applications,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;update;patch;delete
 
 func (r *EnvironmentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -53,92 +65,131 @@ func (r *EnvironmentReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	// Fetch the Environment instance
 	environment := &schedulerv1alpha1.Environment{}
-	deleted := false
 	err := r.Get(ctx, req.NamespacedName, environment)
 	if err != nil {
 		ignroredNotFound := client.IgnoreNotFound(err)
 		if ignroredNotFound != nil {
-			reqLogger.Error(err, "Failed to get Base Rep")
+			reqLogger.Error(err, "Failed to get Environment")
 			return ctrl.Result{}, ignroredNotFound
-		} else {
-			deleted = true
 		}
-
+		// the environment is gone without the finalizer, e.g. it was created before the finalizer was introduced
+		return ctrl.Result{}, deleteSourceResources(ctx, r.Client, r.FluxConfig, r.ArgoCDConfig, req.Name)
 	}
 
+	nameSpaceName := req.Name
+	sourceName := req.Name
+
 	// Check if the resource is being deleted
 	if !environment.ObjectMeta.DeletionTimestamp.IsZero() {
-		deleted = true
+		return r.finalize(ctx, reqLogger, environment, sourceName, nameSpaceName)
 	}
 
-	nameSpaceName := req.Name
-	fluxName := req.Name
-	flux := NewFlux(ctx, r.Client)
+	if controllerutil.AddFinalizer(environment, SourceResourcesFinalizer) {
+		if err := r.Update(ctx, environment); err != nil {
+			return r.manageFailure(ctx, reqLogger, environment, err, "Failed to add finalizer")
+		}
+	}
 
 	namespace := &v1.Namespace{}
-	if deleted {
-		// delete flux resources
-		err := flux.DeleteFluxReferenceResources(fluxName, DefaulFluxNamespace)
-		if err != nil {
-			return r.manageFailure(ctx, reqLogger, environment, err, "Failed to delete flux resources")
+	// get the namespace and if it does not exist create it
+	err = r.Get(ctx, client.ObjectKey{Name: nameSpaceName}, namespace)
+	if err != nil {
+		ignroredNotFound := client.IgnoreNotFound(err)
+		if ignroredNotFound != nil {
+			return r.manageFailure(ctx, reqLogger, environment, err, "Failed to get Namespace")
 		}
-		reqLogger.Info(fmt.Sprintf("Flux resources %s in %s namespace deleted successfully", fluxName, DefaulFluxNamespace))
-
-		// delete the namespace
-		err = r.Get(ctx, client.ObjectKey{Name: nameSpaceName}, namespace)
-		if err != nil {
-			ignroredNotFound := client.IgnoreNotFound(err)
-			if ignroredNotFound != nil {
-				return r.manageFailure(ctx, reqLogger, environment, err, "Failed to get Namespace")
+		if namespace.Name == "" {
+			namespace.Name = nameSpaceName
+			if err := r.Create(ctx, namespace); err != nil {
+				return r.manageFailure(ctx, reqLogger, environment, err, "Failed to create Namespace")
 			}
 		}
-		reqLogger.Info(fmt.Sprintf("Namespace %s deleted successfully", nameSpaceName))
 
-	} else {
-		// get the namespace and if it does not exist create it
-		err = r.Get(ctx, client.ObjectKey{Name: nameSpaceName}, namespace)
-		if err != nil {
-			ignroredNotFound := client.IgnoreNotFound(err)
-			if ignroredNotFound != nil {
-				return r.manageFailure(ctx, reqLogger, environment, err, "Failed to get Namespace")
-			}
-			if namespace.Name == "" {
-				namespace.Name = nameSpaceName
-				if err := r.Create(ctx, namespace); err != nil {
-					return r.manageFailure(ctx, reqLogger, environment, err, "Failed to create Namespace")
-				}
-			}
+	}
 
-		}
+	reqLogger.Info(fmt.Sprintf("Namespace %s created successfully", nameSpaceName))
+
+	sourceReconcilerType := getSourceReconcilerType(environment.Spec.SourceReconciler, r.DefaultSourceReconciler)
+	source, err := createSourceResources(ctx, r.Client,
+		sourceReconcilerType, environment.Status.SourceReconciler,
+		r.FluxConfig.WithOverrides(environment.Spec.Flux),
+		r.ArgoCDConfig,
+		sourceName, nameSpaceName,
+		environment.Spec.ControlPlane.Repo,
+		environment.Spec.ControlPlane.Branch,
+		environment.Spec.ControlPlane.Path,
+		"")
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, environment, err, "Failed to create source reconciler resources for the environment")
+	}
 
-		reqLogger.Info(fmt.Sprintf("Namespace %s created successfully", nameSpaceName))
+	reqLogger.Info(fmt.Sprintf("%s resources %s in %s namespace created successfully", source.Kind(), sourceName, source.Namespace()))
 
-		if err := flux.CreateFluxReferenceResources(fluxName, DefaulFluxNamespace, nameSpaceName,
-			environment.Spec.ControlPlane.Repo,
-			environment.Spec.ControlPlane.Branch,
-			environment.Spec.ControlPlane.Path,
-			""); err != nil {
-			return r.manageFailure(ctx, reqLogger, environment, err, "Failed to create flux resources for the environment")
-		}
+	sourceStatus, err := source.GetReferenceResourcesStatus(sourceName)
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, environment, err, "Failed to get source reconciler resources status")
+	}
 
-		reqLogger.Info(fmt.Sprintf("Flux resources %s in %s namespace created successfully", fluxName, DefaulFluxNamespace))
+	environment.Status.SourceReconciler = sourceReconcilerType
+	meta.SetStatusCondition(&environment.Status.Conditions, sourceStatus.Condition())
+	if sourceStatus.LastAppliedRevision != "" {
+		environment.Status.LastAppliedRevision = sourceStatus.LastAppliedRevision
+	}
 
-		condition := metav1.Condition{
-			Type:   "Ready",
-			Status: metav1.ConditionTrue,
-			Reason: "FluxResourcesCreated",
-		}
-		meta.SetStatusCondition(&environment.Status.Conditions, condition)
+	updateErr := r.Status().Update(ctx, environment)
+	if updateErr != nil {
+		reqLogger.Info("Error when updating status.")
+		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	}
 
-		updateErr := r.Status().Update(ctx, environment)
-		if updateErr != nil {
-			reqLogger.Info("Error when updating status.")
-			return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	return getSourceStatusResult(sourceReconcilerType, r.DefaultSourceReconciler), nil
+}
+
+// finalize deletes the source reconciler resources of the environment and, if the environment asks for it,
+// the environment namespace with its Kalypso objects. The deletion waits for the open PRs of the environment GitOps repos.
+func (r *EnvironmentReconciler) finalize(ctx context.Context, logger logr.Logger, environment *schedulerv1alpha1.Environment, sourceName, nameSpaceName string) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(environment, SourceResourcesFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// the content of the environment GitOps repos is generated from the Kalypso objects of the environment namespace
+	gitopsrepos := &schedulerv1alpha1.GitOpsRepoList{}
+	err := r.List(ctx, gitopsrepos, client.InNamespace(nameSpaceName))
+	if err != nil {
+		return r.manageFailure(ctx, logger, environment, err, "Failed to get dependent GitOps repos")
+	}
+	if condition := getDeletionBlockedCondition(gitopsrepos.Items); condition != nil {
+		logger.Info(condition.Message)
+		meta.SetStatusCondition(&environment.Status.Conditions, *condition)
+		if err := r.Status().Update(ctx, environment); err != nil {
+			logger.Info("Error when updating status.")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, err
 		}
+		return ctrl.Result{RequeueAfter: DeletionBlockedRequeueInterval}, nil
+	}
+
+	// delete source reconciler resources
+	err = deleteSourceResources(ctx, r.Client, r.FluxConfig, r.ArgoCDConfig, sourceName)
+	if err != nil {
+		return r.manageFailure(ctx, logger, environment, err, "Failed to delete source reconciler resources")
+	}
+	logger.Info(fmt.Sprintf("Source reconciler resources %s deleted successfully", sourceName))
 
+	if environment.Spec.NamespaceDeletionPolicy == schedulerv1alpha1.DeleteNamespace {
+		// the Kalypso objects of the environment are deleted along with the namespace
+		namespace := &v1.Namespace{}
+		namespace.Name = nameSpaceName
+		err = client.IgnoreNotFound(r.Delete(ctx, namespace))
+		if err != nil {
+			return r.manageFailure(ctx, logger, environment, err, "Failed to delete Namespace")
+		}
+		logger.Info(fmt.Sprintf("Namespace %s deleted successfully", nameSpaceName))
+	} else {
+		logger.Info(fmt.Sprintf("Namespace %s is kept", nameSpaceName))
 	}
 
-	return ctrl.Result{}, nil
+	controllerutil.RemoveFinalizer(environment, SourceResourcesFinalizer)
+	return ctrl.Result{}, r.Update(ctx, environment)
 }
 
 // Gracefully handle errors
@@ -163,10 +214,17 @@ func (h *EnvironmentReconciler) manageFailure(ctx context.Context, logger logr.L
 	return ctrl.Result{}, err
 }
 
+// findEnvironments returns the environments whose source reconciler resources are the object
+func (r *EnvironmentReconciler) findEnvironments(ctx context.Context, object client.Object) []reconcile.Request {
+	return findResourcesBySourceName(ctx, r.Client, &schedulerv1alpha1.EnvironmentList{}, object, func(object client.Object) string {
+		return object.GetName()
+	})
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *EnvironmentReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&schedulerv1alpha1.Environment{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&schedulerv1alpha1.Environment{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	return watchSourceResources(b, r.DefaultSourceReconciler, handler.EnqueueRequestsFromMapFunc(r.findEnvironments)).
 		Complete(r)
 }
@@ -0,0 +1,155 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func newTestGitOpsRepo(name, namespace string, pr *schedulerv1alpha1.PullRequestStatus) *schedulerv1alpha1.GitOpsRepo {
+	return &schedulerv1alpha1.GitOpsRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: schedulerv1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: schedulerv1alpha1.ManifestsSpec{Repo: "https://github.com/microsoft/kalypso-gitops", Branch: name, Path: "."},
+		},
+		Status: schedulerv1alpha1.GitOpsRepoStatus{PullRequest: pr},
+	}
+}
+
+func newOpenPR(number string) *schedulerv1alpha1.PullRequestStatus {
+	return &schedulerv1alpha1.PullRequestStatus{Number: number, State: schedulerv1alpha1.PullRequestOpen}
+}
+
+// mergePR merges the open PR of the GitOps repo
+func mergePR(t *testing.T, c client.Client, name, namespace string) {
+	gitopsRepo := &schedulerv1alpha1.GitOpsRepo{}
+	assert.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: name, Namespace: namespace}, gitopsRepo))
+	gitopsRepo.Status.PullRequest.State = schedulerv1alpha1.PullRequestMerged
+	assert.NoError(t, c.Status().Update(context.Background(), gitopsRepo))
+}
+
+// assertSourceResources checks if the Flux resources exist
+func assertSourceResources(t *testing.T, c client.Client, name string, exist bool) {
+	for _, object := range []client.Object{&sourcev1.GitRepository{}, &kustomizev1.Kustomization{}} {
+		err := c.Get(context.Background(), client.ObjectKey{Name: name, Namespace: DefaulFluxNamespace}, object)
+		if exist {
+			assert.NoError(t, err)
+		} else {
+			assert.True(t, errors.IsNotFound(err))
+		}
+	}
+}
+
+// Test the finalizer deletes the Flux resources of the environment once the PRs of the environment GitOps repos are closed,
+// and the namespace is deleted only with the Delete policy
+func TestEnvironmentReconcileFinalizer(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          schedulerv1alpha1.NamespaceDeletionPolicy
+		namespaceExists bool
+	}{
+		{
+			name:            "orphan namespace",
+			policy:          schedulerv1alpha1.OrphanNamespace,
+			namespaceExists: true,
+		},
+		{
+			name:            "delete namespace",
+			policy:          schedulerv1alpha1.DeleteNamespace,
+			namespaceExists: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			environment := &schedulerv1alpha1.Environment{
+				ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "kalypso"},
+				Spec: schedulerv1alpha1.EnvironmentSpec{
+					ControlPlane:            schedulerv1alpha1.ManifestsSpec{Repo: "https://github.com/microsoft/kalypso-control-plane", Branch: "dev", Path: "."},
+					NamespaceDeletionPolicy: tt.policy,
+				},
+			}
+			c := newFakeClient(t,
+				environment,
+				newTestGitOpsRepo("dev", "dev", newOpenPR("1")),
+				// the PRs of the other environments don't block the deletion
+				newTestGitOpsRepo("prod", "prod", newOpenPR("2")),
+			)
+			r := &EnvironmentReconciler{Client: c, Scheme: c.Scheme()}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "dev", Namespace: "kalypso"}}
+
+			_, err := r.Reconcile(ctx, req)
+			assert.NoError(t, err)
+			assert.NoError(t, c.Get(ctx, req.NamespacedName, environment))
+			assert.True(t, controllerutil.ContainsFinalizer(environment, SourceResourcesFinalizer))
+			assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: "dev"}, &corev1.Namespace{}))
+			assertSourceResources(t, c, "dev", true)
+
+			// the deletion waits for the PR of the environment GitOps repo
+			assert.NoError(t, c.Delete(ctx, environment))
+			result, err := r.Reconcile(ctx, req)
+			assert.NoError(t, err)
+			assert.Equal(t, DeletionBlockedRequeueInterval, result.RequeueAfter)
+			assert.NoError(t, c.Get(ctx, req.NamespacedName, environment))
+			condition := meta.FindStatusCondition(environment.Status.Conditions, "Ready")
+			assert.Equal(t, "DeletionBlocked", condition.Reason)
+			assert.Equal(t, "Deletion is waiting for the open PRs to be merged or closed: dev#1", condition.Message)
+			assertSourceResources(t, c, "dev", true)
+
+			mergePR(t, c, "dev", "dev")
+			_, err = r.Reconcile(ctx, req)
+			assert.NoError(t, err)
+			assert.True(t, errors.IsNotFound(c.Get(ctx, req.NamespacedName, environment)))
+			assertSourceResources(t, c, "dev", false)
+			err = c.Get(ctx, client.ObjectKey{Name: "dev"}, &corev1.Namespace{})
+			if tt.namespaceExists {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.IsNotFound(err))
+			}
+		})
+	}
+}
+
+// Test the Flux resources of an environment that is gone without the finalizer are deleted
+func TestEnvironmentReconcileNotFound(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t,
+		&sourcev1.GitRepository{ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: DefaulFluxNamespace}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: DefaulFluxNamespace}},
+	)
+	r := &EnvironmentReconciler{Client: c, Scheme: c.Scheme()}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "dev", Namespace: "kalypso"}})
+	assert.NoError(t, err)
+	assertSourceResources(t, c, "dev", false)
+}
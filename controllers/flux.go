@@ -18,12 +18,15 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
 	"github.com/fluxcd/pkg/apis/meta"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -34,30 +37,90 @@ const (
 	FluxOwnerLabel      = "kustomize.toolkit.fluxcd.io/name"
 	FluxNamespaceLabel  = "kustomize.toolkit.fluxcd.io/namespace"
 	RepoSecretName      = "gh-repo-secret"
+	// the only decryption engine of Flux Kustomizations
+	SopsDecryptionProvider = "sops"
 )
 
-type Flux interface {
-	CreateFluxReferenceResources(name, namespace, targetnamespace, url, branch, path, commit string) error
-	DeleteFluxReferenceResources(name, namespace string) error
+// FluxConfig is the configuration of the Flux resources. The manager wide defaults are set with the
+// --flux-* flags and a resource overrides them with its spec.flux
+type FluxConfig struct {
+	// Namespace where the Flux resources are created, flux-system if it's not set
+	Namespace string
+	// Settings of the Flux resources, the interval and the secret name default to FluxInterval and RepoSecretName
+	Settings schedulerv1alpha1.FluxSpec
 }
 
-// implements Flux interface
+// WithOverrides returns the config with the settings of the resource applied over the defaults
+func (c FluxConfig) WithOverrides(overrides *schedulerv1alpha1.FluxSpec) FluxConfig {
+	settings := *c.Settings.DeepCopy()
+	c.Settings = settings
+	if overrides == nil {
+		return c
+	}
+
+	if overrides.Interval != nil {
+		settings.Interval = overrides.Interval
+	}
+	if overrides.Timeout != nil {
+		settings.Timeout = overrides.Timeout
+	}
+	if overrides.SecretName != "" {
+		settings.SecretName = overrides.SecretName
+	}
+	if overrides.Suspend {
+		settings.Suspend = true
+	}
+	if overrides.Decryption != nil {
+		settings.Decryption = overrides.Decryption
+	}
+	settings.DependsOn = append(settings.DependsOn, overrides.DependsOn...)
+
+	c.Settings = settings
+	return c
+}
+
+// implements SourceReconciler interface with Flux GitRepository and Kustomization
 type flux struct {
-	ctx    context.Context
-	client client.Client
+	ctx       context.Context
+	client    client.Client
+	namespace string
+	settings  schedulerv1alpha1.FluxSpec
 }
 
-// validate flux implements Flux interface
-var _ Flux = (*flux)(nil)
+// validate flux implements SourceReconciler interface
+var _ SourceReconciler = (*flux)(nil)
 
 // new flux function
-func NewFlux(ctx context.Context, client client.Client) Flux {
+func NewFlux(ctx context.Context, client client.Client, config FluxConfig) SourceReconciler {
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = DefaulFluxNamespace
+	}
+
+	settings := config.Settings
+	if settings.Interval == nil {
+		settings.Interval = &metav1.Duration{Duration: FluxInterval}
+	}
+	if settings.SecretName == "" {
+		settings.SecretName = RepoSecretName
+	}
+
 	return &flux{
-		ctx:    ctx,
-		client: client,
+		ctx:       ctx,
+		client:    client,
+		namespace: namespace,
+		settings:  settings,
 	}
 }
 
+func (f *flux) Kind() string {
+	return "Flux"
+}
+
+func (f *flux) Namespace() string {
+	return f.namespace
+}
+
 func (f *flux) CreateFluxGitRepository(name, namespace, url, branch, commit string) (*sourcev1.GitRepository, error) {
 	gitRepo := &sourcev1.GitRepository{}
 	repoExists := true
@@ -77,12 +140,13 @@ func (f *flux) CreateFluxGitRepository(name, namespace, url, branch, commit stri
 		Commit: commit,
 	}
 
-	//TODO: remove hardcoding
 	gitRepo.Spec.SecretRef = &meta.LocalObjectReference{
-		Name: RepoSecretName,
+		Name: f.settings.SecretName,
 	}
 
-	gitRepo.Spec.Interval = metav1.Duration{Duration: FluxInterval}
+	gitRepo.Spec.Interval = *f.settings.Interval
+	gitRepo.Spec.Timeout = f.settings.Timeout
+	gitRepo.Spec.Suspend = f.settings.Suspend
 
 	if repoExists {
 		err = f.client.Update(f.ctx, gitRepo)
@@ -115,12 +179,39 @@ func (f *flux) CreateFluxKsutomization(name, namespace, targetnamespace, path st
 	}
 	kustomization.Spec.Path = path
 	kustomization.Spec.Prune = true
-	kustomization.Spec.Interval = metav1.Duration{Duration: FluxInterval}
+	kustomization.Spec.Interval = *f.settings.Interval
 	kustomization.Spec.SourceRef = kustomizev1.CrossNamespaceSourceReference{
 		Kind: sourcev1.GitRepositoryKind,
 		Name: name,
 	}
 	kustomization.Spec.TargetNamespace = targetnamespace
+	kustomization.Spec.Timeout = f.settings.Timeout
+	kustomization.Spec.Suspend = f.settings.Suspend
+
+	kustomization.Spec.Decryption = nil
+	if decryption := f.settings.Decryption; decryption != nil {
+		kustomization.Spec.Decryption = &kustomizev1.Decryption{
+			Provider: decryption.Provider,
+		}
+		if kustomization.Spec.Decryption.Provider == "" {
+			kustomization.Spec.Decryption.Provider = SopsDecryptionProvider
+		}
+		if decryption.SecretName != "" {
+			kustomization.Spec.Decryption.SecretRef = &meta.LocalObjectReference{Name: decryption.SecretName}
+		}
+	}
+
+	kustomization.Spec.DependsOn = nil
+	for _, dependency := range f.settings.DependsOn {
+		// a Kustomization can't depend on itself
+		if dependency == name {
+			continue
+		}
+		kustomization.Spec.DependsOn = append(kustomization.Spec.DependsOn, meta.NamespacedObjectReference{
+			Name:      dependency,
+			Namespace: namespace,
+		})
+	}
 
 	if kustomizationExists {
 		err = f.client.Update(f.ctx, kustomization)
@@ -137,16 +228,16 @@ func (f *flux) CreateFluxKsutomization(name, namespace, targetnamespace, path st
 	return kustomization, nil
 }
 
-func (f *flux) CreateFluxReferenceResources(name, namespace, targetnamespace, url, branch, path, commit string) error {
+func (f *flux) CreateReferenceResources(name, targetnamespace, url, branch, path, commit string) error {
 
 	//create Flux GitRepository
-	_, err := f.CreateFluxGitRepository(name, namespace, url, branch, commit)
+	_, err := f.CreateFluxGitRepository(name, f.namespace, url, branch, commit)
 	if err != nil {
 		return err
 	}
 
 	//create Flux Kustomization
-	_, err = f.CreateFluxKsutomization(name, namespace, targetnamespace, path)
+	_, err = f.CreateFluxKsutomization(name, f.namespace, targetnamespace, path)
 	if err != nil {
 		return err
 	}
@@ -154,11 +245,15 @@ func (f *flux) CreateFluxReferenceResources(name, namespace, targetnamespace, ur
 	return nil
 }
 
-func (f *flux) DeleteFluxReferenceResources(name, namespace string) error {
+func (f *flux) DeleteReferenceResources(name string) error {
 	//delete Flux Kustomization
 	kustomization := &kustomizev1.Kustomization{}
-	err := f.client.Get(f.ctx, client.ObjectKey{Name: name, Namespace: namespace}, kustomization)
+	err := f.client.Get(f.ctx, client.ObjectKey{Name: name, Namespace: f.namespace}, kustomization)
 	if err != nil {
+		// there is nothing to delete if Flux is not installed
+		if apimeta.IsNoMatchError(err) {
+			return nil
+		}
 		if !errors.IsNotFound(err) {
 			return err
 		}
@@ -171,7 +266,7 @@ func (f *flux) DeleteFluxReferenceResources(name, namespace string) error {
 
 	//delete Flux GitRepository
 	gitRepo := &sourcev1.GitRepository{}
-	err = f.client.Get(f.ctx, client.ObjectKey{Name: name, Namespace: namespace}, gitRepo)
+	err = f.client.Get(f.ctx, client.ObjectKey{Name: name, Namespace: f.namespace}, gitRepo)
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			return err
@@ -185,3 +280,51 @@ func (f *flux) DeleteFluxReferenceResources(name, namespace string) error {
 
 	return nil
 }
+
+func (f *flux) GetReferenceResourcesStatus(name string) (*SourceStatus, error) {
+	gitRepo := &sourcev1.GitRepository{}
+	err := f.client.Get(f.ctx, client.ObjectKey{Name: name, Namespace: f.namespace}, gitRepo)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return progressingSourceStatus(f.Kind()), nil
+		}
+		return nil, err
+	}
+
+	// the Kustomization keeps the last applied revision while the source is failing, so the source failure goes first
+	if ready := apimeta.FindStatusCondition(gitRepo.Status.Conditions, meta.ReadyCondition); ready != nil &&
+		ready.Status == metav1.ConditionFalse && gitRepo.Status.ObservedGeneration == gitRepo.Generation {
+		return &SourceStatus{
+			Status:  metav1.ConditionFalse,
+			Reason:  ready.Reason,
+			Message: fmt.Sprintf("GitRepository %s/%s: %s", f.namespace, name, ready.Message),
+		}, nil
+	}
+
+	kustomization := &kustomizev1.Kustomization{}
+	err = f.client.Get(f.ctx, client.ObjectKey{Name: name, Namespace: f.namespace}, kustomization)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return progressingSourceStatus(f.Kind()), nil
+		}
+		return nil, err
+	}
+
+	ready := apimeta.FindStatusCondition(kustomization.Status.Conditions, meta.ReadyCondition)
+	if ready == nil || ready.Status == metav1.ConditionUnknown || kustomization.Status.ObservedGeneration != kustomization.Generation {
+		status := progressingSourceStatus(f.Kind())
+		status.LastAppliedRevision = kustomization.Status.LastAppliedRevision
+		return status, nil
+	}
+
+	status := &SourceStatus{
+		Status:              ready.Status,
+		Reason:              ready.Reason,
+		Message:             ready.Message,
+		LastAppliedRevision: kustomization.Status.LastAppliedRevision,
+	}
+	if ready.Status == metav1.ConditionFalse {
+		status.Message = fmt.Sprintf("Kustomization %s/%s: %s", f.namespace, name, ready.Message)
+	}
+	return status, nil
+}
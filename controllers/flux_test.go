@@ -0,0 +1,242 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newTestFluxConfig() FluxConfig {
+	return FluxConfig{
+		Namespace: "flux",
+		Settings: schedulerv1alpha1.FluxSpec{
+			Interval:   &metav1.Duration{Duration: time.Minute},
+			Timeout:    &metav1.Duration{Duration: 2 * time.Minute},
+			SecretName: "repo-secret",
+			Decryption: &schedulerv1alpha1.DecryptionSpec{Provider: SopsDecryptionProvider, SecretName: "sops-keys"},
+			DependsOn:  []string{"infra"},
+		},
+	}
+}
+
+// Test the settings of a resource take precedence over the manager wide ones
+func TestFluxConfigWithOverrides(t *testing.T) {
+	hour := &metav1.Duration{Duration: time.Hour}
+	decryption := &schedulerv1alpha1.DecryptionSpec{Provider: SopsDecryptionProvider, SecretName: "team-keys"}
+
+	tests := []struct {
+		name      string
+		overrides *schedulerv1alpha1.FluxSpec
+		want      schedulerv1alpha1.FluxSpec
+	}{
+		{
+			name: "no overrides",
+			want: newTestFluxConfig().Settings,
+		},
+		{
+			name:      "empty overrides",
+			overrides: &schedulerv1alpha1.FluxSpec{},
+			want:      newTestFluxConfig().Settings,
+		},
+		{
+			name:      "interval only",
+			overrides: &schedulerv1alpha1.FluxSpec{Interval: hour},
+			want: schedulerv1alpha1.FluxSpec{
+				Interval:   hour,
+				Timeout:    &metav1.Duration{Duration: 2 * time.Minute},
+				SecretName: "repo-secret",
+				Decryption: &schedulerv1alpha1.DecryptionSpec{Provider: SopsDecryptionProvider, SecretName: "sops-keys"},
+				DependsOn:  []string{"infra"},
+			},
+		},
+		{
+			name: "all settings",
+			overrides: &schedulerv1alpha1.FluxSpec{
+				Interval:   hour,
+				Timeout:    hour,
+				SecretName: "team-secret",
+				Suspend:    true,
+				Decryption: decryption,
+				DependsOn:  []string{"dev", "dev-platform"},
+			},
+			want: schedulerv1alpha1.FluxSpec{
+				Interval:   hour,
+				Timeout:    hour,
+				SecretName: "team-secret",
+				Suspend:    true,
+				Decryption: decryption,
+				// the dependencies are added to the manager wide ones
+				DependsOn: []string{"infra", "dev", "dev-platform"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults := newTestFluxConfig()
+			config := defaults.WithOverrides(tt.overrides)
+			assert.Equal(t, "flux", config.Namespace)
+			assert.Equal(t, tt.want, config.Settings)
+
+			// the manager wide settings are not changed by the resource
+			config.Settings.DependsOn[0] = "changed"
+			config.Settings.Decryption.SecretName = "changed"
+			assert.Equal(t, newTestFluxConfig(), defaults)
+		})
+	}
+}
+
+// Test the Flux settings are applied to the GitRepository and the Kustomization
+func TestFluxCreateReferenceResources(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t)
+	getKustomization := func(name string) *kustomizev1.Kustomization {
+		kustomization := &kustomizev1.Kustomization{}
+		assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: name, Namespace: "flux"}, kustomization))
+		return kustomization
+	}
+
+	// the self dependency is dropped, a Kustomization can't depend on itself
+	config := newTestFluxConfig().WithOverrides(&schedulerv1alpha1.FluxSpec{DependsOn: []string{"dev", "dev-main"}})
+	flux := NewFlux(ctx, c, config)
+	assert.NoError(t, flux.CreateReferenceResources("dev-main", "dev", "https://github.com/microsoft/kalypso-control-plane", "main", ".", "abc"))
+
+	gitRepo := &sourcev1.GitRepository{}
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: "dev-main", Namespace: "flux"}, gitRepo))
+	assert.Equal(t, &sourcev1.GitRepositoryRef{Branch: "main", Commit: "abc"}, gitRepo.Spec.Reference)
+	assert.Equal(t, &meta.LocalObjectReference{Name: "repo-secret"}, gitRepo.Spec.SecretRef)
+	assert.Equal(t, time.Minute, gitRepo.Spec.Interval.Duration)
+
+	kustomization := getKustomization("dev-main")
+	assert.Equal(t, []meta.NamespacedObjectReference{{Name: "infra", Namespace: "flux"}, {Name: "dev", Namespace: "flux"}}, kustomization.Spec.DependsOn)
+	assert.Equal(t, &kustomizev1.Decryption{Provider: SopsDecryptionProvider, SecretRef: &meta.LocalObjectReference{Name: "sops-keys"}}, kustomization.Spec.Decryption)
+	assert.Equal(t, 2*time.Minute, kustomization.Spec.Timeout.Duration)
+
+	// the decryption provider defaults to sops, the settings removed from the config are removed from the Kustomization
+	flux = NewFlux(ctx, c, FluxConfig{
+		Namespace: "flux",
+		Settings:  schedulerv1alpha1.FluxSpec{Decryption: &schedulerv1alpha1.DecryptionSpec{}},
+	})
+	assert.NoError(t, flux.CreateReferenceResources("dev-main", "dev", "https://github.com/microsoft/kalypso-control-plane", "main", ".", ""))
+	kustomization = getKustomization("dev-main")
+	assert.Equal(t, &kustomizev1.Decryption{Provider: SopsDecryptionProvider}, kustomization.Spec.Decryption)
+	assert.Empty(t, kustomization.Spec.DependsOn)
+	assert.Nil(t, kustomization.Spec.Timeout)
+	assert.Equal(t, FluxInterval, kustomization.Spec.Interval.Duration)
+
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: "dev-main", Namespace: "flux"}, gitRepo))
+	assert.Equal(t, &meta.LocalObjectReference{Name: RepoSecretName}, gitRepo.Spec.SecretRef)
+
+	// no decryption without the settings
+	flux = NewFlux(ctx, c, FluxConfig{Namespace: "flux"})
+	assert.NoError(t, flux.CreateReferenceResources("dev-main", "dev", "https://github.com/microsoft/kalypso-control-plane", "main", ".", ""))
+	assert.Nil(t, getKustomization("dev-main").Spec.Decryption)
+}
+
+func newTestGitRepository(generation, observedGeneration int64, ready *metav1.Condition) *sourcev1.GitRepository {
+	gitRepo := &sourcev1.GitRepository{ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "flux", Generation: generation}}
+	gitRepo.Status.ObservedGeneration = observedGeneration
+	if ready != nil {
+		gitRepo.Status.Conditions = []metav1.Condition{*ready}
+	}
+	return gitRepo
+}
+
+func newTestKustomization(generation, observedGeneration int64, ready *metav1.Condition) *kustomizev1.Kustomization {
+	kustomization := &kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "flux", Generation: generation}}
+	kustomization.Status.ObservedGeneration = observedGeneration
+	kustomization.Status.LastAppliedRevision = "main@sha1:abc"
+	if ready != nil {
+		kustomization.Status.Conditions = []metav1.Condition{*ready}
+	}
+	return kustomization
+}
+
+// Test the Ready conditions of the GitRepository and the Kustomization are mapped to the status of the source
+func TestFluxGetReferenceResourcesStatus(t *testing.T) {
+	ready := &metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionTrue, Reason: "ReconciliationSucceeded", Message: "Applied revision: main@sha1:abc"}
+	authFailed := &metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionFalse, Reason: "AuthenticationFailed", Message: "failed to checkout and determine revision"}
+	buildFailed := &metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionFalse, Reason: "BuildFailed", Message: "kustomization.yaml not found"}
+	progressingWithRevision := progressingSourceStatus("Flux")
+	progressingWithRevision.LastAppliedRevision = "main@sha1:abc"
+
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    *SourceStatus
+	}{
+		{
+			name: "missing GitRepository",
+			want: progressingSourceStatus("Flux"),
+		},
+		{
+			name:    "missing Kustomization",
+			objects: []client.Object{newTestGitRepository(1, 1, ready)},
+			want:    progressingSourceStatus("Flux"),
+		},
+		{
+			name:    "failing GitRepository goes before the Kustomization",
+			objects: []client.Object{newTestGitRepository(2, 2, authFailed), newTestKustomization(1, 1, ready)},
+			want:    &SourceStatus{Status: metav1.ConditionFalse, Reason: "AuthenticationFailed", Message: "GitRepository flux/dev: failed to checkout and determine revision"},
+		},
+		{
+			name:    "GitRepository failure of the previous generation",
+			objects: []client.Object{newTestGitRepository(2, 1, authFailed), newTestKustomization(1, 1, ready)},
+			want:    &SourceStatus{Status: metav1.ConditionTrue, Reason: "ReconciliationSucceeded", Message: "Applied revision: main@sha1:abc", LastAppliedRevision: "main@sha1:abc"},
+		},
+		{
+			name:    "Kustomization of the previous generation",
+			objects: []client.Object{newTestGitRepository(1, 1, ready), newTestKustomization(2, 1, ready)},
+			want:    progressingWithRevision,
+		},
+		{
+			name:    "Kustomization without the Ready condition",
+			objects: []client.Object{newTestGitRepository(1, 1, ready), newTestKustomization(1, 1, nil)},
+			want:    progressingWithRevision,
+		},
+		{
+			name:    "failing Kustomization",
+			objects: []client.Object{newTestGitRepository(1, 1, ready), newTestKustomization(1, 1, buildFailed)},
+			want:    &SourceStatus{Status: metav1.ConditionFalse, Reason: "BuildFailed", Message: "Kustomization flux/dev: kustomization.yaml not found", LastAppliedRevision: "main@sha1:abc"},
+		},
+		{
+			name:    "ready",
+			objects: []client.Object{newTestGitRepository(1, 1, ready), newTestKustomization(1, 1, ready)},
+			want:    &SourceStatus{Status: metav1.ConditionTrue, Reason: "ReconciliationSucceeded", Message: "Applied revision: main@sha1:abc", LastAppliedRevision: "main@sha1:abc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flux := NewFlux(context.Background(), newFakeClient(t, tt.objects...), FluxConfig{Namespace: "flux"})
+
+			status, err := flux.GetReferenceResourcesStatus("dev")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, status)
+		})
+	}
+}
@@ -20,13 +20,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	meta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -44,9 +47,28 @@ import (
 type GitOpsRepoReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Clock evaluates the batching and the delivery windows, it's the real clock if nil
+	Clock clock.PassiveClock
+	// NewGitRepo connects to the GitOps repo, it's the git hosting service of the repo if nil
+	NewGitRepo func(ctx context.Context, c client.Client, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.GitRepo, error)
 }
 
-const prCreateTimeOut = 3 * time.Second
+const (
+	// how long nothing has to change before a PR is created, if the batching is not configured
+	prCreateTimeOut = 3 * time.Second
+	// the longest time the changes wait for a PR, if the batching is not configured
+	defaultMaxBatchDelay = 5 * time.Minute
+	// how often to check if the PR waiting for auto-merge can be merged
+	autoMergeCheckInterval = 30 * time.Second
+	// how often to check the state of the open PR
+	prStateCheckInterval = time.Minute
+	// how often to compare the base branch with the delivered content if the interval is not set
+	defaultDriftCheckInterval = 10 * time.Minute
+	// how many drifted files are listed in the condition message
+	maxDriftedPathsInMessage = 5
+	// how often to check the delivery windows if none of them opens anymore
+	deliveryWindowCheckInterval = time.Hour
+)
 
 //+kubebuilder:rbac:groups=scheduler.kalypso.io,resources=gitopsrepoes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=scheduler.kalypso.io,resources=gitopsrepoes/status,verbs=get;update;patch
@@ -55,6 +77,8 @@ const prCreateTimeOut = 3 * time.Second
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=schedulingpolicies,verbs=get;list;watch;
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=clustertypes,verbs=get;list;watch
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=assignments,verbs=get;list;watch;
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=deploymenttargets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 func (r *GitOpsRepoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	reqLogger := log.FromContext(ctx)
@@ -76,6 +100,31 @@ func (r *GitOpsRepoReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 
+	// the hosting service can't sign the commits of the PRs, the repo waits for its spec to change instead of failing every delivery
+	if err := scheduler.CheckCommitSigning(&gitopsrepo.Spec); err != nil {
+		reqLogger.Error(err, "Commit signing is not supported")
+		meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
+			Type:    schedulerv1alpha1.ReadyConditionType,
+			Status:  metav1.ConditionFalse,
+			Reason:  "CommitSigningNotSupported",
+			Message: err.Error(),
+		})
+		return ctrl.Result{}, r.Status().Update(ctx, gitopsrepo)
+	}
+
+	// find out the cluster types of the repo and report the ones shared with other repos or without a repo
+	ownership, err := getClusterTypeOwnership(ctx, r.Client, gitopsrepo.Namespace)
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to match the cluster types")
+	}
+	if r.setClusterTypeOwnership(gitopsrepo, ownership) {
+		updateErr := r.Status().Update(ctx, gitopsrepo)
+		if updateErr != nil {
+			reqLogger.Info("Error when updating status.")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+		}
+	}
+
 	// check Ready status condition of all Schedule Policies in the namespace
 	// if all are true, set ReadyToPR to true
 	schedulePolicies := &schedulerv1alpha1.SchedulingPolicyList{}
@@ -115,13 +164,13 @@ func (r *GitOpsRepoReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		//log all assignments and schedule policies are ready
 		reqLogger.Info("All Assignments and Schedule Policies are ready")
 
-		repoContent, err := r.getRepoContent(ctx, reqLogger, gitopsrepo)
+		repoContent, err := r.getRepoContent(ctx, reqLogger, gitopsrepo, ownership)
 		if err != nil {
 			return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to get repo content")
 		}
 
 		// convert the hash to a string
-		repoContentHashString, err := getHashString(repoContent)
+		repoContentHashString, err := getRepoContentHash(repoContent, gitopsrepo.Spec.Layout)
 		if err != nil {
 			return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to hash the repoContent")
 		}
@@ -133,69 +182,16 @@ func (r *GitOpsRepoReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// if the hash is different from the one in the status, create a PR
 		if gitopsrepo.Status.RepoContentHash != repoContentHashString {
 
-			//get "ReadyForPR" status condition
-			readyForPRCondition := meta.FindStatusCondition(gitopsrepo.Status.Conditions, schedulerv1alpha1.ReadyToPRConditionType)
-			//chheck it was set before 3 seconds ago
-			if readyForPRCondition != nil {
-				readyForPRConditionTime := readyForPRCondition.LastTransitionTime.Time
-				//wait until things calm down and then create a PR
-				//so we dont' create a PR for every change
-				if readyForPRConditionTime.Add(prCreateTimeOut).Before(time.Now()) {
-
-					meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
-						Type:   schedulerv1alpha1.ReadyConditionType,
-						Status: metav1.ConditionFalse,
-						Reason: "CreatingPR",
-					})
-
-					updateErr := r.Status().Update(ctx, gitopsrepo)
-
-					if updateErr != nil {
-						reqLogger.Info("Error when updating status.")
-						return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
-					}
-
-					// create a PR
-					reqLogger.Info("!!!!!!!!!!!!!!!!!!!Creating a PR!!!!!!!!!!!!!!!!!!!!!!!!")
-					githubRepo, err := scheduler.NewGithubRepo(ctx, &gitopsrepo.Spec)
-					if err != nil {
-						return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to create a GithubRepo")
-					}
-
-					_, err = githubRepo.CreatePR(r.getDeploymentBranchName(), repoContent)
-
-					if err != nil {
-						if r.ignorePrAlreadyExists(err) == nil {
-							reqLogger.Info("PR already exists")
-						} else {
-							return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to create a PR")
-						}
-					}
-
-					meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
-						Type:   schedulerv1alpha1.ReadyConditionType,
-						Status: metav1.ConditionTrue,
-						Reason: "PRCreated",
-					})
-					meta.RemoveStatusCondition(&gitopsrepo.Status.Conditions, schedulerv1alpha1.ReadyToPRConditionType)
-
-					gitopsrepo.Status.RepoContentHash = repoContentHashString
-
-					updateErr = r.Status().Update(ctx, gitopsrepo)
-
-					if updateErr != nil {
-						reqLogger.Info("Error when updating status.")
-						return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
-					}
-				}
-
-			} else {
-				reqLogger.Info("!!!!!!!Ready for PR!!!!!!!!")
+			// batch the changes, so a burst of them results in a single PR
+			nextPRAt := r.batchChange(gitopsrepo, repoContentHashString)
+			if wait := nextPRAt.Sub(r.getClock().Now()); wait > 0 {
+				reqLogger.Info("Waiting for more changes before creating a PR", "pendingChanges", gitopsrepo.Status.Batch.PendingChanges, "nextPRAt", nextPRAt)
 				// set the "ReadyForPR" status condition
 				meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
-					Type:   schedulerv1alpha1.ReadyToPRConditionType,
-					Status: metav1.ConditionTrue,
-					Reason: "ReadyForPR",
+					Type:    schedulerv1alpha1.ReadyToPRConditionType,
+					Status:  metav1.ConditionTrue,
+					Reason:  "ReadyForPR",
+					Message: fmt.Sprintf("%d pending changes, the PR is created at %s", gitopsrepo.Status.Batch.PendingChanges, nextPRAt.Format(time.RFC3339)),
 				})
 				updateErr := r.Status().Update(ctx, gitopsrepo)
 
@@ -204,14 +200,140 @@ func (r *GitOpsRepoReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
 				}
 
-				//create a pr in a timeout
-				return ctrl.Result{RequeueAfter: prCreateTimeOut}, nil
+				//create a pr when the batching window is over
+				return ctrl.Result{RequeueAfter: wait}, nil
+			}
+
+			// hold the delivery back outside the delivery windows
+			windowState, err := r.checkDeliveryWindow(gitopsrepo)
+			if err != nil {
+				return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to check the delivery windows")
+			}
+			if !windowState.Open {
+				reqLogger.Info("Delivery is held back", "reason", windowState.Message)
+				updateErr := r.Status().Update(ctx, gitopsrepo)
+
+				if updateErr != nil {
+					reqLogger.Info("Error when updating status.")
+					return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+				}
+
+				return ctrl.Result{RequeueAfter: r.untilOpening(windowState)}, nil
+			}
+
+			meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
+				Type:   schedulerv1alpha1.ReadyConditionType,
+				Status: metav1.ConditionFalse,
+				Reason: "CreatingPR",
+			})
+
+			updateErr := r.Status().Update(ctx, gitopsrepo)
 
+			if updateErr != nil {
+				reqLogger.Info("Error when updating status.")
+				return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
 			}
 
+			var pr *schedulerv1alpha1.PullRequestStatus
+			readyReason := "PRCreated"
+			switch gitopsrepo.Spec.Delivery {
+			case schedulerv1alpha1.DirectPushDelivery:
+				readyReason = "ManifestsPushed"
+			case schedulerv1alpha1.OCIDelivery:
+				readyReason = "ArtifactsPublished"
+			}
+
+			if gitopsrepo.Spec.Delivery != schedulerv1alpha1.OCIDelivery {
+				// create a PR
+				reqLogger.Info("!!!!!!!!!!!!!!!!!!!Creating a PR!!!!!!!!!!!!!!!!!!!!!!!!")
+				var gitRepo scheduler.GitRepo
+				gitRepo, err = r.getGitRepo(ctx, gitopsrepo)
+				if err != nil {
+					return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to create a GitRepo")
+				}
+
+				pr, err = gitRepo.CreatePR(scheduler.DeploymentBranchName(gitopsrepo.Spec.Branch), repoContent)
+			}
+
+			noChanges := errors.Is(err, scheduler.ErrNoChanges)
+			if err != nil {
+				if noChanges {
+					reqLogger.Info("No changes in the GitOps repo")
+					readyReason = "NoChanges"
+				} else if r.ignorePrAlreadyExists(err) == nil {
+					reqLogger.Info("PR already exists")
+				} else {
+					return r.manageFailure(ctx, reqLogger, gitopsrepo, err, "Failed to create a PR")
+				}
+			}
+
+			// the open PR of the scheduler gets the new content instead of being replaced
+			lastPR := gitopsrepo.Status.PullRequest
+			prUpdated := pr != nil && lastPR != nil && lastPR.Number == pr.Number
+			if prUpdated {
+				readyReason = "PRUpdated"
+			}
+
+			if gitopsrepo.Spec.OCI != nil || gitopsrepo.Spec.Delivery == schedulerv1alpha1.OCIDelivery {
+				artifacts, ociErr := r.publishArtifacts(ctx, gitopsrepo, repoContent, repoContentHashString)
+				if ociErr != nil {
+					return r.manageFailure(ctx, reqLogger, gitopsrepo, ociErr, "Failed to publish OCI artifacts")
+				}
+				gitopsrepo.Status.OCIArtifacts = artifacts
+			}
+
+			meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
+				Type:   schedulerv1alpha1.ReadyConditionType,
+				Status: metav1.ConditionTrue,
+				Reason: readyReason,
+			})
+			meta.RemoveStatusCondition(&gitopsrepo.Status.Conditions, schedulerv1alpha1.ReadyToPRConditionType)
+			gitopsrepo.Status.Batch = nil
+
+			gitopsrepo.Status.RepoContentHash = repoContentHashString
+
+			if pr != nil {
+				pr.ContentHash = repoContentHashString
+				pr.BaseRepoCommit = repoContent.BaseRepo.Commit
+				pr.UpdatedAt = metav1.Now()
+				pr.CreatedAt = pr.UpdatedAt
+				if prUpdated {
+					pr.CreatedAt = lastPR.CreatedAt
+				}
+				gitopsrepo.Status.PullRequest = pr
+			} else if noChanges || err == nil {
+				// the content is already in the branch or it has been pushed there directly
+				gitopsrepo.Status.LiveContentHash = repoContentHashString
+				gitopsrepo.Status.LiveBaseRepoCommit = repoContent.BaseRepo.Commit
+				if noChanges && lastPR != nil && lastPR.State == schedulerv1alpha1.PullRequestOpen {
+					// the scheduler has closed the PR as it is not needed anymore
+					lastPR.State = schedulerv1alpha1.PullRequestSuperseded
+				}
+			}
+			setPullRequestConditions(gitopsrepo)
+
+			// the new content restarts the merge of the PR
+			gitopsrepo.Status.AutoMerge = nil
+			if pr != nil && isAutoMergeOn(gitopsrepo) {
+				gitopsrepo.Status.AutoMerge = &schedulerv1alpha1.AutoMergeStatus{
+					PullRequest: pr.Number,
+					State:       schedulerv1alpha1.AutoMergePending,
+				}
+			}
+
+			updateErr = r.Status().Update(ctx, gitopsrepo)
+
+			if updateErr != nil {
+				reqLogger.Info("Error when updating status.")
+				return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+			}
+
+			return r.reconcilePullRequest(ctx, reqLogger, gitopsrepo)
 		} else {
-			if meta.IsStatusConditionTrue(gitopsrepo.Status.Conditions, schedulerv1alpha1.ReadyToPRConditionType) {
+			// the pending changes have been reverted
+			if meta.IsStatusConditionTrue(gitopsrepo.Status.Conditions, schedulerv1alpha1.ReadyToPRConditionType) || gitopsrepo.Status.Batch != nil {
 				meta.RemoveStatusCondition(&gitopsrepo.Status.Conditions, schedulerv1alpha1.ReadyToPRConditionType)
+				gitopsrepo.Status.Batch = nil
 				updateErr := r.Status().Update(ctx, gitopsrepo)
 
 				if updateErr != nil {
@@ -220,15 +342,343 @@ func (r *GitOpsRepoReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				}
 
 			}
+
+			// nothing new to PR, keep tracking the last PR
+			result, err := r.reconcilePullRequest(ctx, reqLogger, gitopsrepo)
+			if err != nil {
+				return result, err
+			}
+
+			return r.detectDrift(ctx, reqLogger, gitopsrepo, repoContent, result)
+		}
+
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// isAutoMergeOn returns true if the PRs to the repo may be merged automatically
+func isAutoMergeOn(gitopsrepo *schedulerv1alpha1.GitOpsRepo) bool {
+	autoMerge := gitopsrepo.Spec.AutoMerge
+	return autoMerge != nil && autoMerge.Policy != "" && autoMerge.Policy != schedulerv1alpha1.NeverAutoMerge
+}
+
+// publishArtifacts pushes the content of every cluster type to the OCI repository
+func (r *GitOpsRepoReconciler) publishArtifacts(ctx context.Context, gitopsrepo *schedulerv1alpha1.GitOpsRepo, repoContent *schedulerv1alpha1.RepoContentType, contentHash string) ([]schedulerv1alpha1.OCIArtifactStatus, error) {
+	ociRepo, err := newOCIRepo(ctx, r.Client, gitopsrepo)
+	if err != nil {
+		return nil, err
+	}
+	return ociRepo.Publish(repoContent, contentHash)
+}
+
+// autoMerge merges the PR waiting in the status and records the result.
+// It keeps requeuing while the PR is waiting for the status checks.
+func (r *GitOpsRepoReconciler) autoMerge(ctx context.Context, logger logr.Logger, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (ctrl.Result, error) {
+	status := gitopsrepo.Status.AutoMerge
+	if status == nil || status.State != schedulerv1alpha1.AutoMergePending {
+		return ctrl.Result{}, nil
+	}
+
+	// the PR is merged only inside the delivery windows
+	windowState, err := r.checkDeliveryWindow(gitopsrepo)
+	if err != nil {
+		return r.manageFailure(ctx, logger, gitopsrepo, err, "Failed to check the delivery windows")
+	}
+	if !windowState.Open {
+		logger.Info("Auto-merge is held back", "pr", status.PullRequest, "reason", windowState.Message)
+		status.Message = windowState.Message
+		updateErr := r.Status().Update(ctx, gitopsrepo)
+		if updateErr != nil {
+			logger.Info("Error when updating status.")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
 		}
+		return ctrl.Result{RequeueAfter: r.untilOpening(windowState)}, nil
+	}
 
+	gitRepo, err := r.getGitRepo(ctx, gitopsrepo)
+	if err != nil {
+		return r.manageFailure(ctx, logger, gitopsrepo, err, "Failed to create a GitRepo")
+	}
+
+	mergeStatus, err := gitRepo.MergePR(status.PullRequest)
+	if err != nil {
+		return r.manageFailure(ctx, logger, gitopsrepo, err, "Failed to merge the PR")
+	}
+	logger.Info("Auto-merge", "pr", mergeStatus.PullRequest, "state", mergeStatus.State, "message", mergeStatus.Message)
+
+	gitopsrepo.Status.AutoMerge = mergeStatus
+	updateErr := r.Status().Update(ctx, gitopsrepo)
+	if updateErr != nil {
+		logger.Info("Error when updating status.")
+		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
 	}
 
+	if mergeStatus.State == schedulerv1alpha1.AutoMergePending {
+		return ctrl.Result{RequeueAfter: autoMergeCheckInterval}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
-func (r *GitOpsRepoReconciler) getDeploymentBranchName() string {
-	return fmt.Sprintf("deployment/%s", time.Now().Format("2006-01-02-15-04-05"))
+// checkDeliveryWindow tells if the delivery is allowed now and reflects it in the Frozen and WaitingForWindow conditions
+func (r *GitOpsRepoReconciler) checkDeliveryWindow(gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.DeliveryWindowState, error) {
+	spec := gitopsrepo.Spec.DeliveryWindows
+	if spec == nil {
+		meta.RemoveStatusCondition(&gitopsrepo.Status.Conditions, schedulerv1alpha1.FrozenConditionType)
+		meta.RemoveStatusCondition(&gitopsrepo.Status.Conditions, schedulerv1alpha1.WaitingForWindowConditionType)
+		return scheduler.DeliveryWindowState{Open: true}, nil
+	}
+
+	window, err := scheduler.NewDeliveryWindow(spec, r.getClock())
+	if err != nil {
+		return scheduler.DeliveryWindowState{}, err
+	}
+	state := window.Check()
+
+	frozen := metav1.Condition{
+		Type:   schedulerv1alpha1.FrozenConditionType,
+		Status: metav1.ConditionFalse,
+		Reason: "NoChangeFreeze",
+	}
+	waiting := metav1.Condition{
+		Type:   schedulerv1alpha1.WaitingForWindowConditionType,
+		Status: metav1.ConditionFalse,
+		Reason: "WindowOpen",
+	}
+	if state.Frozen {
+		frozen.Status = metav1.ConditionTrue
+		frozen.Reason = "ChangeFreeze"
+		frozen.Message = state.Message
+	} else if !state.Open {
+		waiting.Status = metav1.ConditionTrue
+		waiting.Reason = "OutsideWindow"
+		waiting.Message = state.Message
+	}
+	meta.SetStatusCondition(&gitopsrepo.Status.Conditions, frozen)
+	meta.SetStatusCondition(&gitopsrepo.Status.Conditions, waiting)
+
+	return state, nil
+}
+
+// untilOpening returns the time to wait for the delivery to open
+func (r *GitOpsRepoReconciler) untilOpening(state scheduler.DeliveryWindowState) time.Duration {
+	if state.NextOpening.IsZero() {
+		return deliveryWindowCheckInterval
+	}
+	wait := state.NextOpening.Sub(r.getClock().Now())
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+func (r *GitOpsRepoReconciler) getClock() clock.PassiveClock {
+	if r.Clock == nil {
+		return clock.RealClock{}
+	}
+	return r.Clock
+}
+
+func (r *GitOpsRepoReconciler) getGitRepo(ctx context.Context, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.GitRepo, error) {
+	if r.NewGitRepo == nil {
+		return newGitRepo(ctx, r.Client, gitopsrepo)
+	}
+	return r.NewGitRepo(ctx, r.Client, gitopsrepo)
+}
+
+// reconcilePullRequest merges the last PR if auto-merge is on and refreshes its state in the status.
+// It keeps requeuing while the PR is open.
+func (r *GitOpsRepoReconciler) reconcilePullRequest(ctx context.Context, logger logr.Logger, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (ctrl.Result, error) {
+	result, err := r.autoMerge(ctx, logger, gitopsrepo)
+	if err != nil {
+		return result, err
+	}
+
+	pr := gitopsrepo.Status.PullRequest
+	if pr == nil || pr.State != schedulerv1alpha1.PullRequestOpen {
+		return result, nil
+	}
+
+	gitRepo, err := r.getGitRepo(ctx, gitopsrepo)
+	if err != nil {
+		return r.manageFailure(ctx, logger, gitopsrepo, err, "Failed to create a GitRepo")
+	}
+
+	current, err := gitRepo.GetPR(pr.Number)
+	if err != nil {
+		return r.manageFailure(ctx, logger, gitopsrepo, err, "Failed to get the PR")
+	}
+	logger.Info("PR state", "pr", pr.Number, "state", current.State)
+
+	if current.State != pr.State {
+		pr.State = current.State
+		pr.MergedSHA = current.MergedSHA
+		if pr.State == schedulerv1alpha1.PullRequestMerged {
+			gitopsrepo.Status.LiveContentHash = pr.ContentHash
+			gitopsrepo.Status.LiveBaseRepoCommit = pr.BaseRepoCommit
+		}
+		setPullRequestConditions(gitopsrepo)
+
+		updateErr := r.Status().Update(ctx, gitopsrepo)
+		if updateErr != nil {
+			logger.Info("Error when updating status.")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+		}
+	}
+
+	if pr.State == schedulerv1alpha1.PullRequestOpen && (result.RequeueAfter == 0 || result.RequeueAfter > prStateCheckInterval) {
+		result.RequeueAfter = prStateCheckInterval
+	}
+	return result, nil
+}
+
+// batchChange records the content in the pending batch of changes and returns the time to create the PR.
+// The PR is created when nothing has changed for the quiet period, but no later than the max delay after the first change.
+func (r *GitOpsRepoReconciler) batchChange(gitopsrepo *schedulerv1alpha1.GitOpsRepo, contentHash string) metav1.Time {
+	quietPeriod, maxDelay := prCreateTimeOut, defaultMaxBatchDelay
+	if batching := gitopsrepo.Spec.Batching; batching != nil {
+		quietPeriod, maxDelay = batching.QuietPeriod.Duration, batching.MaxDelay.Duration
+	}
+
+	now := metav1.NewTime(r.getClock().Now())
+	batch := gitopsrepo.Status.Batch
+	if batch == nil {
+		batch = &schedulerv1alpha1.BatchStatus{FirstChangeAt: now}
+		gitopsrepo.Status.Batch = batch
+	}
+	if batch.ContentHash != contentHash {
+		batch.ContentHash = contentHash
+		batch.PendingChanges++
+		batch.LastChangeAt = now
+	}
+
+	nextPRAt := batch.LastChangeAt.Add(quietPeriod)
+	if deadline := batch.FirstChangeAt.Add(maxDelay); maxDelay > 0 && deadline.Before(nextPRAt) {
+		nextPRAt = deadline
+	}
+	batch.NextPRAt = metav1.NewTime(nextPRAt)
+	return batch.NextPRAt
+}
+
+// detectDrift periodically compares the base branch with the delivered content and reports the drifted files.
+// With the correct policy the content is delivered again, so the drifted branch is restored.
+func (r *GitOpsRepoReconciler) detectDrift(ctx context.Context, logger logr.Logger, gitopsrepo *schedulerv1alpha1.GitOpsRepo, repoContent *schedulerv1alpha1.RepoContentType, result ctrl.Result) (ctrl.Result, error) {
+	spec := gitopsrepo.Spec.DriftDetection
+	if spec == nil || gitopsrepo.Spec.Delivery == schedulerv1alpha1.OCIDelivery {
+		return result, nil
+	}
+
+	// the base branch differs from the content until the PR is merged
+	if gitopsrepo.Status.LiveContentHash != gitopsrepo.Status.RepoContentHash {
+		return result, nil
+	}
+
+	// the drift is being corrected by the open PR, checking it again would only deliver the same content again
+	if pr := gitopsrepo.Status.PullRequest; pr != nil && pr.State == schedulerv1alpha1.PullRequestOpen && pr.ContentHash == gitopsrepo.Status.RepoContentHash {
+		return result, nil
+	}
+
+	interval := spec.Interval.Duration
+	if interval <= 0 {
+		interval = defaultDriftCheckInterval
+	}
+	if lastCheck := gitopsrepo.Status.LastDriftCheck; lastCheck != nil {
+		if wait := time.Until(lastCheck.Add(interval)); wait > 0 {
+			return requeueWithin(result, wait), nil
+		}
+	}
+
+	gitRepo, err := r.getGitRepo(ctx, gitopsrepo)
+	if err != nil {
+		return r.manageFailure(ctx, logger, gitopsrepo, err, "Failed to create a GitRepo")
+	}
+
+	drifted, err := gitRepo.GetDrift(repoContent)
+	if err != nil {
+		return r.manageFailure(ctx, logger, gitopsrepo, err, "Failed to check the drift")
+	}
+
+	now := metav1.Now()
+	gitopsrepo.Status.LastDriftCheck = &now
+	gitopsrepo.Status.DriftedPaths = drifted
+	condition := metav1.Condition{
+		Type:    schedulerv1alpha1.DriftedConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  "InSync",
+		Message: fmt.Sprintf("Branch %s contains the delivered content", gitopsrepo.Spec.Branch),
+	}
+	if len(drifted) > 0 {
+		logger.Info("The base branch has drifted", "paths", drifted)
+		listed := drifted
+		if len(listed) > maxDriftedPathsInMessage {
+			listed = append(listed[:maxDriftedPathsInMessage:maxDriftedPathsInMessage], "...")
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "BranchChanged"
+		condition.Message = fmt.Sprintf("%d files in branch %s differ from the delivered content: %s", len(drifted), gitopsrepo.Spec.Branch, strings.Join(listed, ", "))
+
+		if spec.Policy == schedulerv1alpha1.CorrectDriftPolicy {
+			logger.Info("Delivering the content again to correct the drift")
+			condition.Reason = "Correcting"
+			// the content is delivered as a new one and the branch is checked again once it's there
+			gitopsrepo.Status.RepoContentHash = ""
+			gitopsrepo.Status.LastDriftCheck = nil
+			result = requeueWithin(result, time.Second)
+		}
+	}
+	meta.SetStatusCondition(&gitopsrepo.Status.Conditions, condition)
+
+	updateErr := r.Status().Update(ctx, gitopsrepo)
+	if updateErr != nil {
+		logger.Info("Error when updating status.")
+		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	}
+
+	return requeueWithin(result, interval), nil
+}
+
+// requeueWithin makes sure the result is requeued no later than after the duration
+func requeueWithin(result ctrl.Result, after time.Duration) ctrl.Result {
+	if result.RequeueAfter == 0 || result.RequeueAfter > after {
+		result.RequeueAfter = after
+	}
+	return result
+}
+
+// setPullRequestConditions reflects the state of the last PR in the PRMerged and PRClosedWithoutMerge conditions
+func setPullRequestConditions(gitopsrepo *schedulerv1alpha1.GitOpsRepo) {
+	pr := gitopsrepo.Status.PullRequest
+	if pr == nil {
+		meta.RemoveStatusCondition(&gitopsrepo.Status.Conditions, schedulerv1alpha1.PRMergedConditionType)
+		meta.RemoveStatusCondition(&gitopsrepo.Status.Conditions, schedulerv1alpha1.PRClosedWithoutMergeConditionType)
+		return
+	}
+
+	message := "PR " + pr.Number
+	if pr.URL != "" {
+		message += " " + pr.URL
+	}
+	merged := metav1.ConditionFalse
+	if pr.State == schedulerv1alpha1.PullRequestMerged {
+		merged = metav1.ConditionTrue
+	}
+	closed := metav1.ConditionFalse
+	if pr.State == schedulerv1alpha1.PullRequestClosed {
+		closed = metav1.ConditionTrue
+	}
+
+	meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
+		Type:    schedulerv1alpha1.PRMergedConditionType,
+		Status:  merged,
+		Reason:  string(pr.State),
+		Message: message,
+	})
+	meta.SetStatusCondition(&gitopsrepo.Status.Conditions, metav1.Condition{
+		Type:    schedulerv1alpha1.PRClosedWithoutMergeConditionType,
+		Status:  closed,
+		Reason:  string(pr.State),
+		Message: message,
+	})
 }
 
 // ignorePrAlreadyExists returns nil if the error is a PR already exists error
@@ -236,44 +686,111 @@ func (r *GitOpsRepoReconciler) ignorePrAlreadyExists(err error) error {
 	if err == nil {
 		return nil
 	}
-	if strings.Contains(err.Error(), "A pull request already exists") {
+	if errors.Is(err, scheduler.ErrPullRequestExists) {
 		return nil
 	}
 	return err
 }
 
-func (r *GitOpsRepoReconciler) getRepoContent(ctx context.Context, logger logr.Logger, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (*schedulerv1alpha1.RepoContentType, error) {
+// setClusterTypeOwnership reports the cluster types of the repo in the status and tells if the status has changed
+func (r *GitOpsRepoReconciler) setClusterTypeOwnership(gitopsrepo *schedulerv1alpha1.GitOpsRepo, ownership *scheduler.ClusterTypeOwnership) bool {
+	status := gitopsrepo.Status.DeepCopy()
+	status.ClusterTypes = ownership.GetClusterTypes(gitopsrepo.Name)
+	status.OverlappingClusterTypes = ownership.GetOverlappingClusterTypes(gitopsrepo.Name)
+	status.UnownedClusterTypes = ownership.GetUnownedClusterTypes()
+
+	condition := metav1.Condition{
+		Type:    schedulerv1alpha1.ClusterTypeOwnershipConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Exclusive",
+		Message: "Every cluster type of the environment is delivered to one GitOps repo",
+	}
+	if len(status.OverlappingClusterTypes) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "OverlappingClusterTypes"
+		condition.Message = fmt.Sprintf("Cluster types %s are delivered to other GitOps repos as well", strings.Join(status.OverlappingClusterTypes, ", "))
+	} else if len(status.UnownedClusterTypes) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "UnownedClusterTypes"
+		condition.Message = fmt.Sprintf("Cluster types %s are not delivered to any GitOps repo", strings.Join(status.UnownedClusterTypes, ", "))
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if equality.Semantic.DeepEqual(status, &gitopsrepo.Status) {
+		return false
+	}
+	gitopsrepo.Status = *status
+	return true
+}
+
+func (r *GitOpsRepoReconciler) getRepoContent(ctx context.Context, logger logr.Logger, gitopsrepo *schedulerv1alpha1.GitOpsRepo, ownership *scheduler.ClusterTypeOwnership) (*schedulerv1alpha1.RepoContentType, error) {
 	// create repoContent map
 	repoContent := schedulerv1alpha1.NewRepoContentType()
 
-	//fetch all cluster types in the namespace
-	clusterTypes := &schedulerv1alpha1.ClusterTypeList{}
-	err := r.List(ctx, clusterTypes, client.InNamespace(gitopsrepo.Namespace))
+	//iterate over the cluster types delivered to the repo
+	for _, clusterType := range ownership.GetClusterTypes(gitopsrepo.Name) {
+		clusterTypeContent := schedulerv1alpha1.NewClusterContentType()
+		repoContent.ClusterTypes[clusterType] = *clusterTypeContent
+	}
+
+	//fetch all assignment packages in the namespace
+	assignmentPackages := &schedulerv1alpha1.AssignmentPackageList{}
+	err := r.List(ctx, assignmentPackages, client.InNamespace(gitopsrepo.Namespace))
 	if err != nil {
 		return nil, err
 	}
 
-	//iterate over all cluster types
-	for _, clusterType := range clusterTypes.Items {
-		clusterTypeContent := schedulerv1alpha1.NewClusterContentType()
-		repoContent.ClusterTypes[clusterType.Name] = *clusterTypeContent
+	//fetch all assignments in the namespace to find out the scheduling policies behind the packages
+	assignments := &schedulerv1alpha1.AssignmentList{}
+	err = r.List(ctx, assignments, client.InNamespace(gitopsrepo.Namespace))
+	if err != nil {
+		return nil, err
 	}
 
-	//fetch all assignment packages in the namespace
-	assignmentPackages := &schedulerv1alpha1.AssignmentPackageList{}
-	err = r.List(ctx, assignmentPackages, client.InNamespace(gitopsrepo.Namespace))
+	assignmentPolicies := make(map[string]string)
+	for _, assignment := range assignments.Items {
+		if owner := metav1.GetControllerOf(&assignment); owner != nil {
+			assignmentPolicies[assignment.Name] = owner.Name
+		}
+	}
+
+	//fetch all deployment targets of the environment to find out their workspaces
+	deploymentTargets := &schedulerv1alpha1.DeploymentTargetList{}
+	err = r.List(ctx, deploymentTargets, client.MatchingFields{EnvironmentField: gitopsrepo.Namespace})
 	if err != nil {
 		return nil, err
 	}
+	deploymentTargetWorkspaces := make(map[string]string)
+	for _, deploymentTarget := range deploymentTargets.Items {
+		deploymentTargetWorkspaces[deploymentTarget.Name] = deploymentTarget.GetWorkspace()
+	}
 
 	//iterate over all assignment packages
 	for _, assignmentPackage := range assignmentPackages.Items {
+		// the package goes to the repo of its cluster type
+		if !ownership.Owns(gitopsrepo.Name, assignmentPackage.Labels[schedulerv1alpha1.ClusterTypeLabel]) {
+			continue
+		}
 		clusterTypeContent, ok := repoContent.ClusterTypes[assignmentPackage.Labels[schedulerv1alpha1.ClusterTypeLabel]]
 		if !ok {
 			clusterTypeContent = *schedulerv1alpha1.NewClusterContentType()
 			repoContent.ClusterTypes[assignmentPackage.Labels[schedulerv1alpha1.ClusterTypeLabel]] = clusterTypeContent
 		}
-		clusterTypeContent.DeploymentTargets[assignmentPackage.Labels[schedulerv1alpha1.DeploymentTargetLabel]] = assignmentPackage.Spec
+		deploymentTarget := assignmentPackage.Labels[schedulerv1alpha1.DeploymentTargetLabel]
+		clusterTypeContent.DeploymentTargets[deploymentTarget] = assignmentPackage.Spec
+		clusterTypeContent.Metadata[deploymentTarget] = schedulerv1alpha1.DeploymentTargetMetadata{
+			Workload:    assignmentPackage.Labels[schedulerv1alpha1.WorkloadLabel],
+			Workspace:   deploymentTargetWorkspaces[deploymentTarget],
+			Environment: gitopsrepo.Namespace,
+		}
+
+		// the package is owned by the assignment, which is owned by the scheduling policy
+		if owner := metav1.GetControllerOf(&assignmentPackage); owner != nil {
+			clusterTypeContent.Sources[deploymentTarget] = schedulerv1alpha1.ContentSource{
+				Assignment:       owner.Name,
+				SchedulingPolicy: assignmentPolicies[owner.Name],
+			}
+		}
 	}
 
 	// list all BaseRepos in the namespace with name "main"
@@ -301,11 +818,19 @@ func (r *GitOpsRepoReconciler) getRepoContent(ctx context.Context, logger logr.L
 func (h *GitOpsRepoReconciler) manageFailure(ctx context.Context, logger logr.Logger, gitopsrepo *schedulerv1alpha1.GitOpsRepo, err error, message string) (ctrl.Result, error) {
 	logger.Error(err, message)
 
+	reason := "UpdateFailed"
+	// the git provider throttles the requests, so there is no point to retry before it allows them again
+	var rateLimitErr *scheduler.RateLimitError
+	rateLimited := errors.As(err, &rateLimitErr)
+	if rateLimited {
+		reason = "RateLimited"
+	}
+
 	//crerate a condition
 	condition := metav1.Condition{
 		Type:    "Ready",
 		Status:  metav1.ConditionFalse,
-		Reason:  "UpdateFailed",
+		Reason:  reason,
 		Message: err.Error(),
 	}
 
@@ -316,6 +841,9 @@ func (h *GitOpsRepoReconciler) manageFailure(ctx context.Context, logger logr.Lo
 		logger.Info("Error when updating status. Requeued")
 		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
 	}
+	if rateLimited {
+		return ctrl.Result{RequeueAfter: max(rateLimitErr.RetryAfter, time.Second)}, nil
+	}
 	return ctrl.Result{}, err
 }
 
@@ -352,6 +880,16 @@ func (r *GitOpsRepoReconciler) normalPredicate() predicate.Predicate {
 				return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration()
 			}
 
+			// a new base repo commit is promoted to the environment
+			if _, ok := e.ObjectNew.(*schedulerv1alpha1.BaseRepo); ok {
+				return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration()
+			}
+
+			// the cluster type may move to another repo
+			if _, ok := e.ObjectNew.(*schedulerv1alpha1.ClusterType); ok {
+				return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+			}
+
 			if schedulingPolicy, ok := e.ObjectNew.(*schedulerv1alpha1.SchedulingPolicy); ok {
 				return meta.IsStatusConditionTrue(schedulingPolicy.Status.Conditions, schedulerv1alpha1.ReadyConditionType)
 			}
@@ -372,6 +910,10 @@ func (r *GitOpsRepoReconciler) normalPredicate() predicate.Predicate {
 func (r *GitOpsRepoReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&schedulerv1alpha1.GitOpsRepo{}).
+		// the cluster type selector of a repo changes the cluster types of the other repos in the namespace
+		Watches(
+			&schedulerv1alpha1.GitOpsRepo{},
+			handler.EnqueueRequestsFromMapFunc(r.findGitOpsRepo)).
 		Watches(
 			&schedulerv1alpha1.SchedulingPolicy{},
 			handler.EnqueueRequestsFromMapFunc(r.findGitOpsRepo)).
@@ -384,6 +926,9 @@ func (r *GitOpsRepoReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Watches(
 			&schedulerv1alpha1.ClusterType{},
 			handler.EnqueueRequestsFromMapFunc(r.findGitOpsRepo)).
+		Watches(
+			&schedulerv1alpha1.BaseRepo{},
+			handler.EnqueueRequestsFromMapFunc(r.findGitOpsRepo)).
 		WithEventFilter(r.normalPredicate()).
 		Complete(r)
 }
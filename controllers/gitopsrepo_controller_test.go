@@ -0,0 +1,403 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/microsoft/kalypso-scheduler/scheduler"
+	"github.com/stretchr/testify/assert"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeGitRepo keeps the PRs in memory and reports the drift it is told to
+type fakeGitRepo struct {
+	prs     map[string]*schedulerv1alpha1.PullRequestStatus
+	drifted []string
+	// how many times the content has been delivered
+	deliveries int
+	// createErr fails the delivery of the content
+	createErr error
+}
+
+// validate fakeGitRepo implements GitRepo interface
+var _ scheduler.GitRepo = (*fakeGitRepo)(nil)
+
+func newFakeGitRepo() *fakeGitRepo {
+	return &fakeGitRepo{prs: map[string]*schedulerv1alpha1.PullRequestStatus{}}
+}
+
+func (g *fakeGitRepo) CreatePR(prBranchName string, content *schedulerv1alpha1.RepoContentType) (*schedulerv1alpha1.PullRequestStatus, error) {
+	if g.createErr != nil {
+		return nil, g.createErr
+	}
+	g.deliveries++
+	pr := &schedulerv1alpha1.PullRequestStatus{
+		Number: fmt.Sprint(len(g.prs) + 1),
+		Branch: prBranchName,
+		State:  schedulerv1alpha1.PullRequestOpen,
+	}
+	g.prs[pr.Number] = pr
+	return pr.DeepCopy(), nil
+}
+
+func (g *fakeGitRepo) GetPR(prNumber string) (*schedulerv1alpha1.PullRequestStatus, error) {
+	pr, ok := g.prs[prNumber]
+	if !ok {
+		return nil, fmt.Errorf("PR %s not found", prNumber)
+	}
+	return pr.DeepCopy(), nil
+}
+
+func (g *fakeGitRepo) UpdateIssue(gitIssueNumber *int, title string, message *string) (*int, error) {
+	return gitIssueNumber, nil
+}
+
+func (g *fakeGitRepo) MergePR(prNumber string) (*schedulerv1alpha1.AutoMergeStatus, error) {
+	return nil, fmt.Errorf("auto-merge is off")
+}
+
+func (g *fakeGitRepo) GetDrift(content *schedulerv1alpha1.RepoContentType) ([]string, error) {
+	return g.drifted, nil
+}
+
+// merge merges the PR and the base branch gets its content back
+func (g *fakeGitRepo) merge(prNumber string) {
+	g.prs[prNumber].State = schedulerv1alpha1.PullRequestMerged
+	g.drifted = nil
+}
+
+// Test the drift of the base branch is detected, corrected once with a PR and checked again after the PR is merged
+func TestGitOpsRepoReconcileDriftCorrection(t *testing.T) {
+	ctx := context.Background()
+	gitopsRepo := &schedulerv1alpha1.GitOpsRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "dev"},
+		Spec: schedulerv1alpha1.GitOpsRepoSpec{
+			ManifestsSpec:  schedulerv1alpha1.ManifestsSpec{Repo: "https://github.com/microsoft/kalypso-gitops", Branch: "dev", Path: "."},
+			Batching:       &schedulerv1alpha1.BatchingSpec{},
+			DriftDetection: &schedulerv1alpha1.DriftDetectionSpec{Policy: schedulerv1alpha1.CorrectDriftPolicy},
+		},
+	}
+	c := newFakeClient(t, gitopsRepo)
+	gitRepo := newFakeGitRepo()
+	r := &GitOpsRepoReconciler{
+		Client: c,
+		Scheme: c.Scheme(),
+		NewGitRepo: func(ctx context.Context, c client.Client, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.GitRepo, error) {
+			return gitRepo, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "dev", Namespace: "dev"}}
+
+	reconcile := func() (ctrl.Result, *schedulerv1alpha1.GitOpsRepo) {
+		result, err := r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		gitopsRepo := &schedulerv1alpha1.GitOpsRepo{}
+		assert.NoError(t, c.Get(ctx, req.NamespacedName, gitopsRepo))
+		return result, gitopsRepo
+	}
+	drifted := func(gitopsRepo *schedulerv1alpha1.GitOpsRepo) string {
+		condition := meta.FindStatusCondition(gitopsRepo.Status.Conditions, schedulerv1alpha1.DriftedConditionType)
+		if condition == nil {
+			return ""
+		}
+		return condition.Reason
+	}
+
+	// the content is delivered and merged, the branch is in sync
+	_, gitopsRepo = reconcile()
+	assert.Equal(t, 1, gitRepo.deliveries)
+	assert.Equal(t, "1", gitopsRepo.Status.PullRequest.Number)
+	gitRepo.merge("1")
+	_, gitopsRepo = reconcile()
+	assert.Equal(t, gitopsRepo.Status.RepoContentHash, gitopsRepo.Status.LiveContentHash)
+	assert.Equal(t, "InSync", drifted(gitopsRepo))
+
+	// someone edits the branch by hand
+	gitRepo.drifted = []string{"drone/target/reconciler.yaml"}
+	gitopsRepo.Status.LastDriftCheck = nil
+	assert.NoError(t, c.Status().Update(ctx, gitopsRepo))
+	_, gitopsRepo = reconcile()
+	assert.Equal(t, "Correcting", drifted(gitopsRepo))
+	assert.Equal(t, []string{"drone/target/reconciler.yaml"}, gitopsRepo.Status.DriftedPaths)
+
+	// the content is delivered again with a corrective PR
+	_, gitopsRepo = reconcile()
+	assert.Equal(t, 2, gitRepo.deliveries)
+	assert.Equal(t, "2", gitopsRepo.Status.PullRequest.Number)
+	assert.Equal(t, schedulerv1alpha1.PullRequestOpen, gitopsRepo.Status.PullRequest.State)
+
+	// the open corrective PR is only tracked, the drift is not corrected again
+	for i := 0; i < 3; i++ {
+		var result ctrl.Result
+		result, gitopsRepo = reconcile()
+		assert.Equal(t, prStateCheckInterval, result.RequeueAfter)
+	}
+	assert.Equal(t, 2, gitRepo.deliveries)
+	assert.Equal(t, "2", gitopsRepo.Status.PullRequest.Number)
+	assert.Equal(t, "Correcting", drifted(gitopsRepo))
+
+	// the corrective PR is merged and the branch is checked again
+	gitRepo.merge("2")
+	_, gitopsRepo = reconcile()
+	assert.Equal(t, schedulerv1alpha1.PullRequestMerged, gitopsRepo.Status.PullRequest.State)
+	assert.Equal(t, gitopsRepo.Status.RepoContentHash, gitopsRepo.Status.LiveContentHash)
+	assert.Equal(t, "InSync", drifted(gitopsRepo))
+	assert.Empty(t, gitopsRepo.Status.DriftedPaths)
+	assert.Equal(t, 2, gitRepo.deliveries)
+}
+
+// Test a failed delivery is retried and a delivery without changes supersedes the open PR
+func TestGitOpsRepoReconcileDeliveryErrors(t *testing.T) {
+	ctx := context.Background()
+	gitopsRepo := &schedulerv1alpha1.GitOpsRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "dev"},
+		Spec: schedulerv1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: schedulerv1alpha1.ManifestsSpec{Repo: "https://github.com/microsoft/kalypso-gitops", Branch: "dev", Path: "."},
+			Batching:      &schedulerv1alpha1.BatchingSpec{},
+		},
+	}
+	c := newFakeClient(t, gitopsRepo)
+	gitRepo := newFakeGitRepo()
+	r := &GitOpsRepoReconciler{
+		Client: c,
+		Scheme: c.Scheme(),
+		NewGitRepo: func(ctx context.Context, c client.Client, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.GitRepo, error) {
+			return gitRepo, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "dev", Namespace: "dev"}}
+	get := func() *schedulerv1alpha1.GitOpsRepo {
+		gitopsRepo := &schedulerv1alpha1.GitOpsRepo{}
+		assert.NoError(t, c.Get(ctx, req.NamespacedName, gitopsRepo))
+		return gitopsRepo
+	}
+	ready := func(gitopsRepo *schedulerv1alpha1.GitOpsRepo) *metav1.Condition {
+		return meta.FindStatusCondition(gitopsRepo.Status.Conditions, schedulerv1alpha1.ReadyConditionType)
+	}
+
+	// the failed delivery is not recorded, so it's retried
+	gitRepo.createErr = errors.New("401 Bad credentials")
+	_, err := r.Reconcile(ctx, req)
+	assert.EqualError(t, err, "401 Bad credentials")
+	gitopsRepo = get()
+	assert.Empty(t, gitopsRepo.Status.RepoContentHash)
+	assert.Empty(t, gitopsRepo.Status.LiveContentHash)
+	assert.Nil(t, gitopsRepo.Status.PullRequest)
+	assert.Equal(t, metav1.ConditionFalse, ready(gitopsRepo).Status)
+	assert.Equal(t, "401 Bad credentials", ready(gitopsRepo).Message)
+
+	gitRepo.createErr = nil
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	gitopsRepo = get()
+	assert.Equal(t, 1, gitRepo.deliveries)
+	assert.Equal(t, "PRCreated", ready(gitopsRepo).Reason)
+	assert.Equal(t, "1", gitopsRepo.Status.PullRequest.Number)
+	assert.Empty(t, gitopsRepo.Status.LiveContentHash)
+
+	// the content changes back to the one of the branch, the open PR is not needed anymore
+	gitRepo.createErr = scheduler.ErrNoChanges
+	gitopsRepo.Status.RepoContentHash = "previous"
+	assert.NoError(t, c.Status().Update(ctx, gitopsRepo))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	gitopsRepo = get()
+	assert.Equal(t, "NoChanges", ready(gitopsRepo).Reason)
+	assert.Equal(t, schedulerv1alpha1.PullRequestSuperseded, gitopsRepo.Status.PullRequest.State)
+	assert.Equal(t, gitopsRepo.Status.RepoContentHash, gitopsRepo.Status.LiveContentHash)
+}
+
+// Test a GitLab repo that signs the commits of the PRs is not ready and doesn't deliver
+func TestGitOpsRepoReconcileCommitSigningNotSupported(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t, &schedulerv1alpha1.GitOpsRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "dev"},
+		Spec: schedulerv1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: schedulerv1alpha1.ManifestsSpec{Repo: "https://gitlab.com/microsoft/kalypso-gitops", Branch: "dev", Path: "."},
+			Commit:        &schedulerv1alpha1.CommitSpec{SigningKey: &schedulerv1alpha1.SigningKey{SecretRef: schedulerv1alpha1.SecretReference{Name: "signing-key"}}},
+		},
+	})
+	gitRepo := newFakeGitRepo()
+	r := &GitOpsRepoReconciler{
+		Client: c,
+		Scheme: c.Scheme(),
+		NewGitRepo: func(ctx context.Context, c client.Client, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.GitRepo, error) {
+			return gitRepo, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "dev", Namespace: "dev"}}
+
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+	assert.Equal(t, 0, gitRepo.deliveries)
+	gitopsRepo := &schedulerv1alpha1.GitOpsRepo{}
+	assert.NoError(t, c.Get(ctx, req.NamespacedName, gitopsRepo))
+	condition := meta.FindStatusCondition(gitopsRepo.Status.Conditions, schedulerv1alpha1.ReadyConditionType)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "CommitSigningNotSupported", condition.Reason)
+	assert.Contains(t, condition.Message, "signed commits in gitlab")
+}
+
+// Test a burst of changes keeps pushing the PR back until the max delay
+func TestGitOpsRepoBatchChange(t *testing.T) {
+	start := time.Date(2023, 5, 1, 8, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	tests := []struct {
+		name     string
+		batching *schedulerv1alpha1.BatchingSpec
+		// the seconds from the start the content changes at
+		changes    []int
+		wantNextPR time.Time
+	}{
+		{
+			name:       "single change",
+			batching:   &schedulerv1alpha1.BatchingSpec{QuietPeriod: metav1.Duration{Duration: 10 * time.Second}, MaxDelay: metav1.Duration{Duration: time.Minute}},
+			changes:    []int{0},
+			wantNextPR: at(10),
+		},
+		{
+			name:       "every change pushes the PR back",
+			batching:   &schedulerv1alpha1.BatchingSpec{QuietPeriod: metav1.Duration{Duration: 10 * time.Second}, MaxDelay: metav1.Duration{Duration: time.Minute}},
+			changes:    []int{0, 5, 12, 20},
+			wantNextPR: at(30),
+		},
+		{
+			name:       "the max delay caps the batch",
+			batching:   &schedulerv1alpha1.BatchingSpec{QuietPeriod: metav1.Duration{Duration: 10 * time.Second}, MaxDelay: metav1.Duration{Duration: time.Minute}},
+			changes:    []int{0, 8, 16, 24, 32, 40, 48, 56},
+			wantNextPR: at(60),
+		},
+		{
+			name:       "no max delay",
+			batching:   &schedulerv1alpha1.BatchingSpec{QuietPeriod: metav1.Duration{Duration: 10 * time.Second}},
+			changes:    []int{0, 8, 16, 24, 32, 40, 48, 56},
+			wantNextPR: at(66),
+		},
+		{
+			name:       "default batching",
+			changes:    []int{0, 2, 4},
+			wantNextPR: at(4).Add(prCreateTimeOut),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := clocktesting.NewFakeClock(start)
+			r := &GitOpsRepoReconciler{Clock: clock}
+			gitopsRepo := &schedulerv1alpha1.GitOpsRepo{Spec: schedulerv1alpha1.GitOpsRepoSpec{Batching: tt.batching}}
+
+			var nextPRAt metav1.Time
+			for i, change := range tt.changes {
+				clock.SetTime(at(change))
+				nextPRAt = r.batchChange(gitopsRepo, fmt.Sprint(i))
+			}
+			assert.Equal(t, tt.wantNextPR, nextPRAt.Time)
+			assert.Equal(t, metav1.NewTime(tt.wantNextPR), gitopsRepo.Status.Batch.NextPRAt)
+			assert.Equal(t, len(tt.changes), gitopsRepo.Status.Batch.PendingChanges)
+			assert.Equal(t, start, gitopsRepo.Status.Batch.FirstChangeAt.Time)
+
+			// the same content is not counted again
+			clock.Step(time.Second)
+			assert.Equal(t, nextPRAt, r.batchChange(gitopsRepo, fmt.Sprint(len(tt.changes)-1)))
+			assert.Equal(t, len(tt.changes), gitopsRepo.Status.Batch.PendingChanges)
+		})
+	}
+}
+
+// Test the PR waits for the batching window and a reverted change clears the batch
+func TestGitOpsRepoReconcileBatching(t *testing.T) {
+	ctx := context.Background()
+	gitopsRepo := &schedulerv1alpha1.GitOpsRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "dev"},
+		Spec: schedulerv1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: schedulerv1alpha1.ManifestsSpec{Repo: "https://github.com/microsoft/kalypso-gitops", Branch: "dev", Path: "."},
+			Batching:      &schedulerv1alpha1.BatchingSpec{QuietPeriod: metav1.Duration{Duration: time.Minute}, MaxDelay: metav1.Duration{Duration: 5 * time.Minute}},
+		},
+	}
+	clusterType := &schedulerv1alpha1.ClusterType{ObjectMeta: metav1.ObjectMeta{Name: "drone", Namespace: "dev"}}
+	c := newFakeClient(t, gitopsRepo)
+	clock := clocktesting.NewFakeClock(time.Date(2023, 5, 1, 8, 0, 0, 0, time.UTC))
+	gitRepo := newFakeGitRepo()
+	r := &GitOpsRepoReconciler{
+		Client: c,
+		Scheme: c.Scheme(),
+		Clock:  clock,
+		NewGitRepo: func(ctx context.Context, c client.Client, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.GitRepo, error) {
+			return gitRepo, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "dev", Namespace: "dev"}}
+	reconcile := func() (ctrl.Result, *schedulerv1alpha1.GitOpsRepo) {
+		result, err := r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		gitopsRepo := &schedulerv1alpha1.GitOpsRepo{}
+		assert.NoError(t, c.Get(ctx, req.NamespacedName, gitopsRepo))
+		return result, gitopsRepo
+	}
+	readyToPR := func(gitopsRepo *schedulerv1alpha1.GitOpsRepo) bool {
+		return meta.IsStatusConditionTrue(gitopsRepo.Status.Conditions, schedulerv1alpha1.ReadyToPRConditionType)
+	}
+
+	// the first content waits for the quiet period of the fake clock
+	result, gitopsRepo := reconcile()
+	assert.Equal(t, time.Minute, result.RequeueAfter)
+	assert.Equal(t, 1, gitopsRepo.Status.Batch.PendingChanges)
+	assert.True(t, clock.Now().Add(time.Minute).Equal(gitopsRepo.Status.Batch.NextPRAt.Time))
+	assert.True(t, readyToPR(gitopsRepo))
+	assert.Zero(t, gitRepo.deliveries)
+
+	clock.Step(time.Minute)
+	_, gitopsRepo = reconcile()
+	assert.Equal(t, 1, gitRepo.deliveries)
+	assert.Nil(t, gitopsRepo.Status.Batch)
+	assert.False(t, readyToPR(gitopsRepo))
+	gitRepo.merge("1")
+	_, gitopsRepo = reconcile()
+	deliveredHash := gitopsRepo.Status.RepoContentHash
+
+	// a new cluster type changes the content
+	assert.NoError(t, c.Create(ctx, clusterType))
+	clock.Step(time.Minute)
+	result, gitopsRepo = reconcile()
+	assert.Equal(t, time.Minute, result.RequeueAfter)
+	assert.Equal(t, 1, gitopsRepo.Status.Batch.PendingChanges)
+	assert.True(t, readyToPR(gitopsRepo))
+
+	// the change is reverted before the PR
+	assert.NoError(t, c.Delete(ctx, clusterType))
+	clock.Step(30 * time.Second)
+	_, gitopsRepo = reconcile()
+	assert.Nil(t, gitopsRepo.Status.Batch)
+	assert.False(t, readyToPR(gitopsRepo))
+	assert.Equal(t, deliveredHash, gitopsRepo.Status.RepoContentHash)
+	assert.Equal(t, 1, gitRepo.deliveries)
+}
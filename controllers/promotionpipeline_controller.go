@@ -0,0 +1,304 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/go-logr/logr"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/microsoft/kalypso-scheduler/scheduler"
+)
+
+// PromotionPipelineReconciler reconciles a PromotionPipeline object
+type PromotionPipelineReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+const (
+	// how many promotions are kept in the status
+	maxPromotionHistory = 20
+)
+
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=promotionpipelines,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=promotionpipelines/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=promotionpipelines/finalizers,verbs=update
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=environments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=baserepoes,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=assignments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=gitopsrepoes,verbs=get;list;watch
+
+func (r *PromotionPipelineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.FromContext(ctx)
+	reqLogger.Info("=== Reconciling Promotion Pipeline ===")
+
+	// Fetch the PromotionPipeline instance
+	pipeline := &schedulerv1alpha1.PromotionPipeline{}
+	err := r.Get(ctx, req.NamespacedName, pipeline)
+	if err != nil {
+		ignroredNotFound := client.IgnoreNotFound(err)
+		if ignroredNotFound != nil {
+			reqLogger.Error(err, "Failed to get PromotionPipeline")
+		}
+		return ctrl.Result{}, ignroredNotFound
+	}
+
+	// Check if the resource is being deleted
+	if !pipeline.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	baseRepoName := pipeline.Spec.BaseRepo
+	if baseRepoName == "" {
+		baseRepoName = schedulerv1alpha1.DefaultPromotedBaseRepo
+	}
+
+	previousStages := make(map[string]schedulerv1alpha1.PromotionStageStatus)
+	for _, stage := range pipeline.Status.Stages {
+		previousStages[stage.Environment] = stage
+	}
+
+	// observe the commits of the base repos in the stages and whether they are deployed
+	now := metav1.Now()
+	stages := make([]schedulerv1alpha1.PromotionStageStatus, len(pipeline.Spec.Stages))
+	baseRepos := make([]*schedulerv1alpha1.BaseRepo, len(pipeline.Spec.Stages))
+	notDeployed := make([]string, len(pipeline.Spec.Stages))
+	for i, stage := range pipeline.Spec.Stages {
+		environment := &schedulerv1alpha1.Environment{}
+		err := r.Get(ctx, client.ObjectKey{Name: stage.Environment, Namespace: pipeline.Namespace}, environment)
+		if err != nil {
+			return r.manageFailure(ctx, reqLogger, pipeline, err, "Failed to get Environment")
+		}
+
+		baseRepo := &schedulerv1alpha1.BaseRepo{}
+		err = r.Get(ctx, client.ObjectKey{Name: baseRepoName, Namespace: stage.Environment}, baseRepo)
+		if err != nil {
+			return r.manageFailure(ctx, reqLogger, pipeline, err, "Failed to get BaseRepo")
+		}
+		baseRepos[i] = baseRepo
+
+		stages[i] = schedulerv1alpha1.PromotionStageStatus{
+			Environment: stage.Environment,
+			Commit:      baseRepo.Spec.Commit,
+			Since:       now,
+		}
+		previous, ok := previousStages[stage.Environment]
+		if ok && previous.Commit == baseRepo.Spec.Commit {
+			stages[i].Since = previous.Since
+		}
+		if baseRepo.Spec.Commit == "" {
+			continue
+		}
+
+		// the soak time counts from the moment the commit is deployed, not from when it got to the base repo
+		notDeployed[i], err = r.checkStageDeployed(ctx, stage.Environment, baseRepo.Spec.Commit)
+		if err != nil {
+			return r.manageFailure(ctx, reqLogger, pipeline, err, "Failed to check the stage")
+		}
+		if notDeployed[i] == "" {
+			stages[i].DeployedSince = now.DeepCopy()
+			if ok && previous.Commit == baseRepo.Spec.Commit && previous.DeployedSince != nil {
+				stages[i].DeployedSince = previous.DeployedSince
+			}
+		}
+	}
+
+	// promote the commits down the chain
+	var promoted, waiting []string
+	var requeueAfter time.Duration
+	for i := 1; i < len(pipeline.Spec.Stages); i++ {
+		commit := stages[i-1].Commit
+		if commit == "" || commit == stages[i].Commit {
+			continue
+		}
+
+		candidate := scheduler.PromotionCandidate{
+			Commit:      commit,
+			NotDeployed: notDeployed[i-1],
+		}
+		if stages[i-1].DeployedSince != nil {
+			candidate.DeployedSince = stages[i-1].DeployedSince.Time
+		}
+		waitingFor, soakRemaining := scheduler.CheckPromotionGates(pipeline.Spec.Stages[i], candidate, now.Time)
+		if waitingFor != "" {
+			reqLogger.Info("Promotion is waiting", "environment", stages[i].Environment, "commit", commit, "waitingFor", waitingFor)
+			stages[i].PendingCommit = commit
+			stages[i].WaitingFor = waitingFor
+			waiting = append(waiting, stages[i].Environment)
+			if soakRemaining > 0 && (requeueAfter == 0 || soakRemaining < requeueAfter) {
+				requeueAfter = soakRemaining
+			}
+			continue
+		}
+
+		reqLogger.Info("Promoting commit", "environment", stages[i].Environment, "commit", commit)
+		baseRepo := baseRepos[i]
+		previousCommit := baseRepo.Spec.Commit
+		baseRepo.Spec.Commit = commit
+		if err := r.Update(ctx, baseRepo); err != nil {
+			return r.manageFailure(ctx, reqLogger, pipeline, err, "Failed to promote the commit")
+		}
+
+		stages[i].Commit = commit
+		stages[i].Since = now
+		stages[i].DeployedSince = nil
+		notDeployed[i] = fmt.Sprintf("commit %s to be deployed in environment %s", commit, stages[i].Environment)
+		pipeline.Status.History = append([]schedulerv1alpha1.PromotionRecord{{
+			Environment:    stages[i].Environment,
+			Commit:         commit,
+			PreviousCommit: previousCommit,
+			PromotedAt:     now,
+		}}, pipeline.Status.History...)
+		if len(pipeline.Status.History) > maxPromotionHistory {
+			pipeline.Status.History = pipeline.Status.History[:maxPromotionHistory]
+		}
+		promoted = append(promoted, stages[i].Environment)
+	}
+
+	pipeline.Status.Stages = stages
+
+	condition := metav1.Condition{
+		Type:   schedulerv1alpha1.ReadyConditionType,
+		Status: metav1.ConditionTrue,
+		Reason: "UpToDate",
+	}
+	if len(promoted) > 0 {
+		condition.Reason = "Promoted"
+		condition.Message = fmt.Sprintf("Promoted to %s", strings.Join(promoted, ", "))
+	} else if len(waiting) > 0 {
+		condition.Reason = "WaitingForGates"
+		condition.Message = fmt.Sprintf("Waiting for the gates of %s", strings.Join(waiting, ", "))
+	}
+	meta.SetStatusCondition(&pipeline.Status.Conditions, condition)
+
+	updateErr := r.Status().Update(ctx, pipeline)
+	if updateErr != nil {
+		reqLogger.Info("Error when updating status.")
+		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// checkStageDeployed tells why the commit is not deployed in the environment yet or returns an empty string if it is.
+// The commit is deployed when all Assignments are ready and its manifests are in the base branch of all GitOps repos.
+func (r *PromotionPipelineReconciler) checkStageDeployed(ctx context.Context, environment string, commit string) (string, error) {
+	assignments := &schedulerv1alpha1.AssignmentList{}
+	err := r.List(ctx, assignments, client.InNamespace(environment))
+	if err != nil {
+		return "", err
+	}
+	for _, assignment := range assignments.Items {
+		if !meta.IsStatusConditionTrue(assignment.Status.Conditions, schedulerv1alpha1.ReadyConditionType) {
+			return fmt.Sprintf("assignment %s in environment %s to be ready", assignment.Name, environment), nil
+		}
+	}
+
+	gitopsrepos := &schedulerv1alpha1.GitOpsRepoList{}
+	err = r.List(ctx, gitopsrepos, client.InNamespace(environment))
+	if err != nil {
+		return "", err
+	}
+	for _, gitopsrepo := range gitopsrepos.Items {
+		if gitopsrepo.Status.LiveBaseRepoCommit != commit {
+			return fmt.Sprintf("the manifests of commit %s to be merged to GitOps repo %s in environment %s", commit, gitopsrepo.Name, environment), nil
+		}
+	}
+
+	return "", nil
+}
+
+// Gracefully handle errors
+func (h *PromotionPipelineReconciler) manageFailure(ctx context.Context, logger logr.Logger, pipeline *schedulerv1alpha1.PromotionPipeline, err error, message string) (ctrl.Result, error) {
+	logger.Error(err, message)
+
+	//crerate a condition
+	condition := metav1.Condition{
+		Type:    "Ready",
+		Status:  metav1.ConditionFalse,
+		Reason:  "UpdateFailed",
+		Message: err.Error(),
+	}
+
+	meta.SetStatusCondition(&pipeline.Status.Conditions, condition)
+
+	updateErr := h.Status().Update(ctx, pipeline)
+	if updateErr != nil {
+		logger.Info("Error when updating status. Requeued")
+		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	}
+	return ctrl.Result{}, err
+}
+
+// findPromotionPipelines returns the pipelines with a stage in the namespace of the object
+func (r *PromotionPipelineReconciler) findPromotionPipelines(ctx context.Context, object client.Object) []reconcile.Request {
+	pipelines := &schedulerv1alpha1.PromotionPipelineList{}
+	err := r.List(ctx, pipelines)
+	if err != nil {
+		return []reconcile.Request{}
+	}
+
+	var requests []reconcile.Request
+	for _, pipeline := range pipelines.Items {
+		for _, stage := range pipeline.Spec.Stages {
+			if stage.Environment == object.GetNamespace() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{
+						Name:      pipeline.Name,
+						Namespace: pipeline.Namespace,
+					},
+				})
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PromotionPipelineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&schedulerv1alpha1.PromotionPipeline{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&schedulerv1alpha1.BaseRepo{},
+			handler.EnqueueRequestsFromMapFunc(r.findPromotionPipelines),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&schedulerv1alpha1.Assignment{},
+			handler.EnqueueRequestsFromMapFunc(r.findPromotionPipelines)).
+		Watches(
+			&schedulerv1alpha1.GitOpsRepo{},
+			handler.EnqueueRequestsFromMapFunc(r.findPromotionPipelines)).
+		Complete(r)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestScheme returns a scheme with the built-in, the scheduler and the Flux types
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, schedulerv1alpha1.AddToScheme(scheme))
+	assert.NoError(t, kustomizev1.AddToScheme(scheme))
+	assert.NoError(t, sourcev1.AddToScheme(scheme))
+	return scheme
+}
+
+// newFakeClient returns a fake client with the objects, it has the status subresources and the field indexes of the cluster
+func newFakeClient(t *testing.T, objects ...client.Object) client.WithWatch {
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(objects...).
+		WithStatusSubresource(
+			&schedulerv1alpha1.PromotionPipeline{},
+			&schedulerv1alpha1.GitOpsRepo{},
+			&schedulerv1alpha1.Assignment{},
+			&schedulerv1alpha1.BaseRepo{},
+			&schedulerv1alpha1.Environment{},
+			&schedulerv1alpha1.WorkloadRegistration{},
+		).
+		WithIndex(&schedulerv1alpha1.DeploymentTarget{}, EnvironmentField, func(object client.Object) []string {
+			return []string{object.(*schedulerv1alpha1.DeploymentTarget).Spec.Environment}
+		}).
+		Build()
+}
+
+func newPromotedBaseRepo(environment string, commit string) *schedulerv1alpha1.BaseRepo {
+	return &schedulerv1alpha1.BaseRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: schedulerv1alpha1.DefaultPromotedBaseRepo, Namespace: environment},
+		Spec: schedulerv1alpha1.BaseRepoSpec{
+			ManifestsSpec: schedulerv1alpha1.ManifestsSpec{
+				Repo:   "https://github.com/microsoft/kalypso-control-plane",
+				Branch: "main",
+				Path:   ".",
+			},
+			Commit: commit,
+		},
+	}
+}
+
+// Test a promotion from dev to prod, it waits until the commit has soaked in dev since it was deployed there
+func TestPromotionPipelineReconcile(t *testing.T) {
+	ctx := context.Background()
+	pipeline := &schedulerv1alpha1.PromotionPipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "main", Namespace: "dev"},
+		Spec: schedulerv1alpha1.PromotionPipelineSpec{
+			Stages: []schedulerv1alpha1.PromotionStage{
+				{Environment: "dev"},
+				{Environment: "prod", Gates: schedulerv1alpha1.PromotionGates{
+					SoakTime:              &metav1.Duration{Duration: time.Hour},
+					PreviousStageDeployed: true,
+				}},
+			},
+		},
+	}
+	gitopsRepo := &schedulerv1alpha1.GitOpsRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "dev"},
+		Spec: schedulerv1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: schedulerv1alpha1.ManifestsSpec{Repo: "https://github.com/microsoft/kalypso-gitops", Branch: "dev", Path: "."},
+		},
+	}
+	c := newFakeClient(t,
+		pipeline,
+		gitopsRepo,
+		&schedulerv1alpha1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "dev"}},
+		&schedulerv1alpha1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "prod", Namespace: "dev"}},
+		newPromotedBaseRepo("dev", "new"),
+		newPromotedBaseRepo("prod", "old"),
+	)
+	r := &PromotionPipelineReconciler{Client: c, Scheme: c.Scheme()}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "main", Namespace: "dev"}}
+
+	reconcile := func() *schedulerv1alpha1.PromotionPipeline {
+		_, err := r.Reconcile(ctx, req)
+		assert.NoError(t, err)
+		pipeline := &schedulerv1alpha1.PromotionPipeline{}
+		assert.NoError(t, c.Get(ctx, req.NamespacedName, pipeline))
+		return pipeline
+	}
+
+	// the commit is in the base repo of dev, but it's not deployed yet, so it doesn't soak
+	pipeline = reconcile()
+	assert.Nil(t, pipeline.Status.Stages[0].DeployedSince)
+	assert.Equal(t, "new", pipeline.Status.Stages[1].PendingCommit)
+	assert.Contains(t, pipeline.Status.Stages[1].WaitingFor, "the commit is not deployed there yet")
+	assert.Equal(t, "WaitingForGates", meta.FindStatusCondition(pipeline.Status.Conditions, schedulerv1alpha1.ReadyConditionType).Reason)
+
+	// the time in the base repo doesn't count
+	pipeline.Status.Stages[0].Since = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	assert.NoError(t, c.Status().Update(ctx, pipeline))
+	pipeline = reconcile()
+	assert.Empty(t, pipeline.Status.History)
+	assert.Contains(t, pipeline.Status.Stages[1].WaitingFor, "the commit is not deployed there yet")
+
+	// the manifests of the commit are merged to the GitOps repo of dev, the soak time starts
+	gitopsRepo.Status.LiveBaseRepoCommit = "new"
+	assert.NoError(t, c.Status().Update(ctx, gitopsRepo))
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Hour, result.RequeueAfter, float64(time.Minute))
+	assert.NoError(t, c.Get(ctx, req.NamespacedName, pipeline))
+	deployedSince := pipeline.Status.Stages[0].DeployedSince
+	assert.NotNil(t, deployedSince)
+	assert.Contains(t, pipeline.Status.Stages[1].WaitingFor, "soak time in the previous stage")
+	assert.Empty(t, pipeline.Status.History)
+
+	// the deployment time is kept while the commit stays in the stage
+	pipeline = reconcile()
+	assert.Equal(t, deployedSince.Unix(), pipeline.Status.Stages[0].DeployedSince.Unix())
+
+	// the commit has been deployed in dev for longer than the soak time
+	soaked := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	pipeline.Status.Stages[0].DeployedSince = &soaked
+	assert.NoError(t, c.Status().Update(ctx, pipeline))
+	pipeline = reconcile()
+
+	assert.Len(t, pipeline.Status.History, 1)
+	assert.Equal(t, "prod", pipeline.Status.History[0].Environment)
+	assert.Equal(t, "new", pipeline.Status.History[0].Commit)
+	assert.Equal(t, "old", pipeline.Status.History[0].PreviousCommit)
+	assert.Equal(t, "new", pipeline.Status.Stages[1].Commit)
+	assert.Empty(t, pipeline.Status.Stages[1].WaitingFor)
+	assert.Nil(t, pipeline.Status.Stages[1].DeployedSince)
+	condition := meta.FindStatusCondition(pipeline.Status.Conditions, schedulerv1alpha1.ReadyConditionType)
+	assert.Equal(t, "Promoted", condition.Reason)
+	assert.Equal(t, "Promoted to prod", condition.Message)
+
+	baseRepo := &schedulerv1alpha1.BaseRepo{}
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: schedulerv1alpha1.DefaultPromotedBaseRepo, Namespace: "prod"}, baseRepo))
+	assert.Equal(t, "new", baseRepo.Spec.Commit)
+
+	// the pipeline is up to date and the promotion is not repeated
+	pipeline = reconcile()
+	assert.Len(t, pipeline.Status.History, 1)
+	assert.Equal(t, "UpToDate", meta.FindStatusCondition(pipeline.Status.Conditions, schedulerv1alpha1.ReadyConditionType).Reason)
+}
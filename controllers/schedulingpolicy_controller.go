@@ -18,15 +18,18 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	meta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -41,6 +44,9 @@ import (
 type SchedulingPolicyReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Registry holds the plugins of the scheduler, the built-in plugins are used if it's nil
+	Registry *scheduler.Registry
 }
 
 const (
@@ -59,6 +65,7 @@ const (
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=assignments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=gitrepositories,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.open-cluster-management.io,resources=placements;placementdecisions,verbs=get;list;watch
 
 func (r *SchedulingPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	reqLogger := log.FromContext(ctx)
@@ -108,13 +115,20 @@ func (r *SchedulingPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return r.manageFailure(ctx, reqLogger, schedulingPolicy, err, "Failed to list DeploymentTargets")
 	}
 
+	// fetch the list of all assignments in the namespace, they tell how loaded the cluster types are
+	allAssignments := &schedulerv1alpha1.AssignmentList{}
+	err = r.List(ctx, allAssignments, client.InNamespace(req.Namespace))
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, schedulingPolicy, err, "Failed to list Assignments")
+	}
+
 	// schedule the deployment targets
-	scheduler, err := scheduler.NewScheduler(schedulingPolicy)
+	scheduler, err := scheduler.NewFramework(ctx, schedulingPolicy, r.Registry, r.Client)
 	if err != nil {
 		return r.manageFailure(ctx, reqLogger, schedulingPolicy, err, "Failed to create scheduler")
 	}
 
-	assignments, err := scheduler.Schedule(ctx, clusterTypes.Items, deploymentTargets.Items)
+	assignments, unplaced, err := scheduler.Schedule(ctx, clusterTypes.Items, deploymentTargets.Items, allAssignments.Items)
 	if err != nil {
 		return r.manageFailure(ctx, reqLogger, schedulingPolicy, err, "Failed to schedule")
 	}
@@ -124,12 +138,16 @@ func (r *SchedulingPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	for _, assignment := range assignments {
 		reqLogger.Info("Assignment", "name", assignment.Name, "clusterType", assignment.Spec.ClusterType, "deploymentTarget", assignment.Spec.DeploymentTarget)
 	}
+	for _, deploymentTarget := range unplaced {
+		reqLogger.Info("Unplaced DeploymentTarget", "name", deploymentTarget.Name, "message", deploymentTarget.Message)
+	}
 
-	// fetch the list of assignments in the namespace by label that are owned by the scheduling policy
+	// the assignments in the namespace that are owned by the scheduling policy
 	assignmentsList := &schedulerv1alpha1.AssignmentList{}
-	err = r.List(ctx, assignmentsList, client.InNamespace(req.Namespace), client.MatchingLabels{schedulerv1alpha1.AssignmentSchedulingPolicyLabel: schedulingPolicy.Name})
-	if err != nil {
-		return r.manageFailure(ctx, reqLogger, schedulingPolicy, err, "Failed to list Assignments")
+	for _, assignment := range allAssignments.Items {
+		if assignment.Labels[schedulerv1alpha1.AssignmentSchedulingPolicyLabel] == schedulingPolicy.Name {
+			assignmentsList.Items = append(assignmentsList.Items, assignment)
+		}
 	}
 
 	// iterate over the existing assignments and delete the ones that are not in the new assignments
@@ -181,7 +199,11 @@ func (r *SchedulingPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		Status: metav1.ConditionTrue,
 		Reason: "AssignmentsCreated",
 	}
+	if len(unplaced) > 0 {
+		condition.Message = fmt.Sprintf("%d deployment targets are not fully placed", len(unplaced))
+	}
 	meta.SetStatusCondition(&schedulingPolicy.Status.Conditions, condition)
+	schedulingPolicy.Status.UnplacedDeploymentTargets = unplaced
 
 	updateErr = r.Status().Update(ctx, schedulingPolicy)
 	if updateErr != nil {
@@ -238,6 +260,48 @@ func (r *SchedulingPolicyReconciler) findPolicies(ctx context.Context, object cl
 	return requests
 }
 
+// findPlacementPolicies returns the scheduling policies that refer to the placement of the placement decision
+func (r *SchedulingPolicyReconciler) findPlacementPolicies(ctx context.Context, object client.Object) []reconcile.Request {
+	placement := object.GetLabels()[scheduler.PlacementLabel]
+	if placement == "" {
+		return []reconcile.Request{}
+	}
+
+	// the policies may refer to a placement in another namespace
+	schedulingPolicies := &schedulerv1alpha1.SchedulingPolicyList{}
+	err := r.List(ctx, schedulingPolicies)
+	if err != nil {
+		return []reconcile.Request{}
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range schedulingPolicies.Items {
+		placementRef := item.Spec.ClusterTypeSelector.Placement
+		if placementRef == nil || placementRef.Name != placement || scheduler.GetPlacementNamespace(&item) != object.GetNamespace() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      item.GetName(),
+				Namespace: item.GetNamespace(),
+			},
+		})
+	}
+	return requests
+}
+
+// assignmentCreatedOrDeleted passes the events of the assignments that change the load of the cluster types
+func assignmentCreatedOrDeleted() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *SchedulingPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	// Add the field index for the reconciler field in the cluster type
@@ -275,7 +339,7 @@ func (r *SchedulingPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&schedulerv1alpha1.SchedulingPolicy{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Owns(&schedulerv1alpha1.Assignment{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Watches(
@@ -284,5 +348,19 @@ func (r *SchedulingPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Watches(
 			&schedulerv1alpha1.DeploymentTarget{},
 			handler.EnqueueRequestsFromMapFunc(r.findPolicies)).
-		Complete(r)
+		// the assignments of the other policies consume and free the capacity of the cluster types
+		Watches(
+			&schedulerv1alpha1.Assignment{},
+			handler.EnqueueRequestsFromMapFunc(r.findPolicies),
+			builder.WithPredicates(assignmentCreatedOrDeleted()))
+
+	// watch the placement decisions only if Open Cluster Management is installed on the control plane cluster
+	gvk := scheduler.PlacementDecisionGVK
+	if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+		placementDecision := &unstructured.Unstructured{}
+		placementDecision.SetGroupVersionKind(gvk)
+		b = b.Watches(placementDecision, handler.EnqueueRequestsFromMapFunc(r.findPlacementPolicies))
+	}
+
+	return b.Complete(r)
 }
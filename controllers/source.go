@@ -0,0 +1,235 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// SourceStatusPollInterval is how often the status of the source reconciler resources is checked if they're not watched
+	SourceStatusPollInterval = time.Minute
+	// SourceResourcesFinalizer makes the controllers delete the source reconciler resources before the resource is gone
+	SourceResourcesFinalizer = "scheduler.kalypso.io/source-resources"
+	// DeletionBlockedRequeueInterval is how often the deletion blocked by the open PRs is retried
+	DeletionBlockedRequeueInterval = time.Minute
+)
+
+// SourceReconciler creates the resources that make the GitOps operator of the control plane cluster
+// fetch the manifests from a repo to a namespace
+type SourceReconciler interface {
+	// Kind is the name of the GitOps operator used in the logs and the conditions
+	Kind() string
+	// Namespace is where the resources of the GitOps operator are created
+	Namespace() string
+	CreateReferenceResources(name, targetnamespace, url, branch, path, commit string) error
+	DeleteReferenceResources(name string) error
+	// GetReferenceResourcesStatus returns whether the GitOps operator has fetched and applied the manifests
+	GetReferenceResourcesStatus(name string) (*SourceStatus, error)
+}
+
+// SourceStatus is the observed state of the resources of a source reconciler
+type SourceStatus struct {
+	// Status is True if the manifests are applied, False if fetching or applying them failed
+	// and Unknown while the GitOps operator is working on them
+	Status  metav1.ConditionStatus
+	Reason  string
+	Message string
+	// LastAppliedRevision is the revision of the repo the manifests were last applied from
+	LastAppliedRevision string
+}
+
+// Condition returns the Ready condition mirroring the status of the source reconciler resources
+func (s *SourceStatus) Condition() metav1.Condition {
+	return metav1.Condition{
+		Type:    "Ready",
+		Status:  s.Status,
+		Reason:  s.Reason,
+		Message: s.Message,
+	}
+}
+
+// progressingSourceStatus is the status of the resources that the GitOps operator hasn't reported on yet
+func progressingSourceStatus(kind string) *SourceStatus {
+	return &SourceStatus{
+		Status:  metav1.ConditionUnknown,
+		Reason:  "Progressing",
+		Message: fmt.Sprintf("Waiting for %s to apply the manifests", kind),
+	}
+}
+
+// NewSourceReconciler creates the SourceReconciler of the type, Flux is the default one
+func NewSourceReconciler(ctx context.Context, client client.Client, reconcilerType schedulerv1alpha1.SourceReconcilerType, fluxConfig FluxConfig, argoCDConfig ArgoCDConfig) (SourceReconciler, error) {
+	switch reconcilerType {
+	case "", schedulerv1alpha1.FluxSourceReconciler:
+		return NewFlux(ctx, client, fluxConfig), nil
+	case schedulerv1alpha1.ArgoCDSourceReconciler:
+		return NewArgoCD(ctx, client, argoCDConfig), nil
+	}
+	return nil, fmt.Errorf("unsupported source reconciler %s", reconcilerType)
+}
+
+// getSourceReconcilerType returns the source reconciler of the resource or the default one
+func getSourceReconcilerType(resourceType, defaultType schedulerv1alpha1.SourceReconcilerType) schedulerv1alpha1.SourceReconcilerType {
+	if resourceType != "" {
+		return resourceType
+	}
+	return defaultType
+}
+
+// createSourceResources creates the resources of the source reconciler. If the source reconciler of the resource has been
+// switched from the previous one, it deletes the resources of the other reconcilers, so the manifests are not fetched twice.
+// An empty previous reconciler is unknown, e.g. the resource has just been created, so the other resources are deleted too.
+func createSourceResources(ctx context.Context, c client.Client, reconcilerType, previousType schedulerv1alpha1.SourceReconcilerType,
+	fluxConfig FluxConfig, argoCDConfig ArgoCDConfig, name, targetnamespace, url, branch, path, commit string) (SourceReconciler, error) {
+	source, err := NewSourceReconciler(ctx, c, reconcilerType, fluxConfig, argoCDConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if previousType != reconcilerType {
+		for _, other := range []SourceReconciler{NewFlux(ctx, c, fluxConfig), NewArgoCD(ctx, c, argoCDConfig)} {
+			if other.Kind() == source.Kind() {
+				continue
+			}
+			if err := other.DeleteReferenceResources(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return source, source.CreateReferenceResources(name, targetnamespace, url, branch, path, commit)
+}
+
+// deleteSourceResources deletes the resources of all source reconcilers, as the deleted resource may be gone with its spec
+func deleteSourceResources(ctx context.Context, c client.Client, fluxConfig FluxConfig, argoCDConfig ArgoCDConfig, name string) error {
+	for _, source := range []SourceReconciler{NewFlux(ctx, c, fluxConfig), NewArgoCD(ctx, c, argoCDConfig)} {
+		if err := source.DeleteReferenceResources(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSourceName returns the name of the Flux Kustomization or the Argo CD Application that has delivered the object
+func getSourceName(object metav1.Object) string {
+	if name := object.GetLabels()[FluxOwnerLabel]; name != "" {
+		return name
+	}
+	// the annotation tracking id is <application>:<group>/<kind>:<namespace>/<name>
+	if trackingID := object.GetAnnotations()[ArgoCDTrackingIDAnnotation]; trackingID != "" {
+		return strings.SplitN(trackingID, ":", 2)[0]
+	}
+	return object.GetLabels()[ArgoCDInstanceLabel]
+}
+
+// watchSourceResources makes the controller reconcile the resource when the readiness of the resources of its default
+// source reconciler changes. The resources of the other source reconcilers are polled, as their CRDs may be missing.
+func watchSourceResources(b *builder.Builder, reconcilerType schedulerv1alpha1.SourceReconcilerType, eventHandler handler.EventHandler) *builder.Builder {
+	statusChanged := builder.WithPredicates(predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return getSourceStatusSummary(e.ObjectOld) != getSourceStatusSummary(e.ObjectNew)
+		},
+	})
+
+	if reconcilerType == schedulerv1alpha1.ArgoCDSourceReconciler {
+		return b.Watches(newArgoCDApplication(), eventHandler, statusChanged)
+	}
+	return b.
+		Watches(&sourcev1.GitRepository{}, eventHandler, statusChanged).
+		Watches(&kustomizev1.Kustomization{}, eventHandler, statusChanged)
+}
+
+// getSourceStatusSummary returns the part of the status of a source reconciler resource that its readiness is computed from,
+// so the periodic reconciliations of the GitOps operator don't trigger the controllers
+func getSourceStatusSummary(object client.Object) string {
+	readySummary := func(conditions []metav1.Condition) string {
+		if ready := apimeta.FindStatusCondition(conditions, meta.ReadyCondition); ready != nil {
+			return fmt.Sprintf("%s/%s/%s", ready.Status, ready.Reason, ready.Message)
+		}
+		return ""
+	}
+
+	switch o := object.(type) {
+	case *sourcev1.GitRepository:
+		return fmt.Sprintf("%d/%s", o.Status.ObservedGeneration, readySummary(o.Status.Conditions))
+	case *kustomizev1.Kustomization:
+		return fmt.Sprintf("%d/%s/%s", o.Status.ObservedGeneration, readySummary(o.Status.Conditions), o.Status.LastAppliedRevision)
+	case *unstructured.Unstructured:
+		sync, _, _ := unstructured.NestedFieldNoCopy(o.Object, "status", "sync")
+		health, _, _ := unstructured.NestedFieldNoCopy(o.Object, "status", "health")
+		conditions, _, _ := unstructured.NestedFieldNoCopy(o.Object, "status", "conditions")
+		phase, _, _ := unstructured.NestedString(o.Object, "status", "operationState", "phase")
+		return fmt.Sprintf("%v/%v/%v/%s", sync, health, conditions, phase)
+	}
+	return ""
+}
+
+// findResourcesBySourceName returns the requests for the resources of the list whose source reconciler resources
+// are named as the object
+func findResourcesBySourceName(ctx context.Context, c client.Client, list client.ObjectList, object client.Object,
+	getSourceName func(client.Object) string) []reconcile.Request {
+	if err := c.List(ctx, list); err != nil {
+		return []reconcile.Request{}
+	}
+
+	var requests []reconcile.Request
+	_ = apimeta.EachListItem(list, func(o runtime.Object) error {
+		item := o.(client.Object)
+		if getSourceName(item) == object.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      item.GetName(),
+					Namespace: item.GetNamespace(),
+				},
+			})
+		}
+		return nil
+	})
+	return requests
+}
+
+// getSourceStatusResult returns the result that polls the status of the source reconciler resources if they're not watched
+func getSourceStatusResult(reconcilerType, defaultType schedulerv1alpha1.SourceReconcilerType) ctrl.Result {
+	isArgoCD := func(t schedulerv1alpha1.SourceReconcilerType) bool {
+		return t == schedulerv1alpha1.ArgoCDSourceReconciler
+	}
+	if isArgoCD(reconcilerType) != isArgoCD(defaultType) {
+		return ctrl.Result{RequeueAfter: SourceStatusPollInterval}
+	}
+	return ctrl.Result{}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// Test the source reconciler resources of an object are found by the Flux label, the Argo CD tracking id or the Argo CD instance label
+func TestGetSourceName(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			name:   "flux label",
+			labels: map[string]string{FluxOwnerLabel: "dev-hello-world"},
+			want:   "dev-hello-world",
+		},
+		{
+			name:        "argocd tracking id",
+			annotations: map[string]string{ArgoCDTrackingIDAnnotation: "dev-hello-world:scheduler.kalypso.io/Workload:dev/hello-world"},
+			want:        "dev-hello-world",
+		},
+		{
+			name:        "argocd tracking id without the resource",
+			annotations: map[string]string{ArgoCDTrackingIDAnnotation: "dev-hello-world"},
+			want:        "dev-hello-world",
+		},
+		{
+			name:   "argocd instance label",
+			labels: map[string]string{ArgoCDInstanceLabel: "dev-hello-world"},
+			want:   "dev-hello-world",
+		},
+		{
+			name:        "the tracking id goes before the instance label",
+			labels:      map[string]string{ArgoCDInstanceLabel: "other"},
+			annotations: map[string]string{ArgoCDTrackingIDAnnotation: "dev-hello-world:scheduler.kalypso.io/Workload:dev/hello-world"},
+			want:        "dev-hello-world",
+		},
+		{
+			name:        "the flux label goes first",
+			labels:      map[string]string{FluxOwnerLabel: "dev-hello-world", ArgoCDInstanceLabel: "other"},
+			annotations: map[string]string{ArgoCDTrackingIDAnnotation: "other:scheduler.kalypso.io/Workload:dev/hello-world"},
+			want:        "dev-hello-world",
+		},
+		{
+			name: "created by hand",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object := &metav1.ObjectMeta{Labels: tt.labels, Annotations: tt.annotations}
+			assert.Equal(t, tt.want, getSourceName(object))
+		})
+	}
+}
+
+// Test the resources of the other source reconciler are deleted only when the source reconciler changes
+func TestCreateSourceResources(t *testing.T) {
+	ctx := context.Background()
+	fluxConfig := FluxConfig{}
+	argoCDConfig := ArgoCDConfig{Namespace: "gitops"}
+	c := newFakeClient(t,
+		&sourcev1.GitRepository{ObjectMeta: metav1.ObjectMeta{Name: "dev-main", Namespace: DefaulFluxNamespace}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "dev-main", Namespace: DefaulFluxNamespace}},
+	)
+
+	// count the reads of the Flux resources
+	fluxReads := 0
+	c = interceptor.NewClient(c, interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			switch obj.(type) {
+			case *sourcev1.GitRepository, *kustomizev1.Kustomization:
+				fluxReads++
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+	create := func(reconcilerType, previousType schedulerv1alpha1.SourceReconcilerType) {
+		source, err := createSourceResources(ctx, c, reconcilerType, previousType, fluxConfig, argoCDConfig,
+			"dev-main", "dev", "https://github.com/microsoft/kalypso-control-plane", "main", ".", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "ArgoCD", source.Kind())
+	}
+
+	// the resource switches from Flux to Argo CD
+	create(schedulerv1alpha1.ArgoCDSourceReconciler, schedulerv1alpha1.FluxSourceReconciler)
+	assert.True(t, errors.IsNotFound(c.Get(ctx, client.ObjectKey{Name: "dev-main", Namespace: DefaulFluxNamespace}, &sourcev1.GitRepository{})))
+	assert.True(t, errors.IsNotFound(c.Get(ctx, client.ObjectKey{Name: "dev-main", Namespace: DefaulFluxNamespace}, &kustomizev1.Kustomization{})))
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: "dev-main", Namespace: "gitops"}, newArgoCDApplication()))
+
+	// the Flux resources are not touched while the source reconciler stays the same
+	fluxReads = 0
+	create(schedulerv1alpha1.ArgoCDSourceReconciler, schedulerv1alpha1.ArgoCDSourceReconciler)
+	assert.Zero(t, fluxReads)
+
+	// the previous source reconciler is unknown, so the Flux resources are cleaned up just in case
+	create(schedulerv1alpha1.ArgoCDSourceReconciler, "")
+	assert.Equal(t, 2, fluxReads)
+}
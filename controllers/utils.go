@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/microsoft/kalypso-scheduler/scheduler"
 	"github.com/mitchellh/hashstructure"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -19,23 +23,135 @@ func getHashString(object interface{}) (string, error) {
 	return strconv.FormatUint(hash, 10), nil
 }
 
-// find GitOps repo
-func findGitOpsRepo(ctx context.Context, r client.Client, object client.Object) (*schedulerv1alpha1.GitOpsRepo, error) {
-	//list gitops repos in the object namespace
+// getRepoContentHash hashes the content of a GitOps repo along with its layout, so a new layout is delivered as well
+func getRepoContentHash(repoContent *schedulerv1alpha1.RepoContentType, layout *schedulerv1alpha1.LayoutSpec) (string, error) {
+	if layout == nil {
+		return getHashString(repoContent)
+	}
+	return getHashString(struct {
+		Content *schedulerv1alpha1.RepoContentType
+		Layout  *schedulerv1alpha1.LayoutSpec
+	}{repoContent, layout})
+}
+
+// create a GitRepo with the credentials from the secret of the GitOps repo
+func newGitRepo(ctx context.Context, r client.Client, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.GitRepo, error) {
+	var credentials *scheduler.Credentials
+	if gitopsrepo.Spec.SecretRef != nil {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Name: gitopsrepo.Spec.SecretRef.Name, Namespace: gitopsrepo.Namespace}, secret)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials, err = scheduler.NewCredentialsFromSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var signer scheduler.Signer
+	if gitopsrepo.Spec.Commit != nil && gitopsrepo.Spec.Commit.SigningKey != nil {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Name: gitopsrepo.Spec.Commit.SigningKey.SecretRef.Name, Namespace: gitopsrepo.Namespace}, secret)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err = scheduler.NewSignerFromSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return scheduler.NewGitRepo(ctx, &gitopsrepo.Spec, credentials, signer)
+}
+
+// create an OCIRepo with the registry credentials from the docker config secret of the GitOps repo
+func newOCIRepo(ctx context.Context, r client.Client, gitopsrepo *schedulerv1alpha1.GitOpsRepo) (scheduler.OCIRepo, error) {
+	spec := gitopsrepo.Spec.OCI
+	if spec == nil {
+		return nil, fmt.Errorf("GitOps repo %s has %s delivery without an OCI repository", gitopsrepo.Name, schedulerv1alpha1.OCIDelivery)
+	}
+
+	var credentials *scheduler.RegistryCredentials
+	if spec.SecretRef != nil {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Name: spec.SecretRef.Name, Namespace: gitopsrepo.Namespace}, secret)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials, err = scheduler.NewRegistryCredentialsFromSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return scheduler.NewOCIRepo(ctx, spec, credentials)
+}
+
+// getClusterTypeOwnership matches the GitOps repos of the namespace with its cluster types
+func getClusterTypeOwnership(ctx context.Context, r client.Client, namespace string) (*scheduler.ClusterTypeOwnership, error) {
 	gitopsrepos := &schedulerv1alpha1.GitOpsRepoList{}
-	listOpts := []client.ListOption{
-		client.InNamespace(object.GetNamespace()),
+	err := r.List(ctx, gitopsrepos, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
 	}
 
-	err := r.List(ctx, gitopsrepos, listOpts...)
+	clusterTypes := &schedulerv1alpha1.ClusterTypeList{}
+	err = r.List(ctx, clusterTypes, client.InNamespace(namespace))
 	if err != nil {
 		return nil, err
 	}
 
-	if len(gitopsrepos.Items) > 0 {
-		return &gitopsrepos.Items[0], nil
-	} else {
-		return nil, fmt.Errorf("no GitOps repo found in namespace %s", object.GetNamespace())
+	return scheduler.NewClusterTypeOwnership(gitopsrepos.Items, clusterTypes.Items)
+}
+
+// find the GitOps repo that owns the cluster type
+func findGitOpsRepo(ctx context.Context, r client.Client, namespace string, clusterType string) (*schedulerv1alpha1.GitOpsRepo, error) {
+	ownership, err := getClusterTypeOwnership(ctx, r, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, ok := ownership.GetOwner(clusterType)
+	if !ok {
+		return nil, fmt.Errorf("no GitOps repo found for cluster type %s in namespace %s", clusterType, namespace)
 	}
 
+	gitopsrepo := &schedulerv1alpha1.GitOpsRepo{}
+	err = r.Get(ctx, client.ObjectKey{Name: owner, Namespace: namespace}, gitopsrepo)
+	if err != nil {
+		return nil, err
+	}
+	return gitopsrepo, nil
+}
+
+// getDeletionBlockedCondition returns the condition that blocks the deletion of a resource while the GitOps repos
+// depending on it have open PRs, so the PRs are not left behind with the content of the deleted resource.
+// It returns nil if there is no open PR.
+func getDeletionBlockedCondition(gitopsrepos []schedulerv1alpha1.GitOpsRepo) *metav1.Condition {
+	var pullRequests []string
+	for _, gitopsrepo := range gitopsrepos {
+		pr := gitopsrepo.Status.PullRequest
+		if pr == nil || pr.State != schedulerv1alpha1.PullRequestOpen {
+			continue
+		}
+		if pr.URL != "" {
+			pullRequests = append(pullRequests, pr.URL)
+		} else {
+			pullRequests = append(pullRequests, fmt.Sprintf("%s#%s", gitopsrepo.Name, pr.Number))
+		}
+	}
+	if len(pullRequests) == 0 {
+		return nil
+	}
+
+	return &metav1.Condition{
+		Type:    "Ready",
+		Status:  metav1.ConditionFalse,
+		Reason:  "DeletionBlocked",
+		Message: fmt.Sprintf("Deletion is waiting for the open PRs to be merged or closed: %s", strings.Join(pullRequests, ", ")),
+	}
 }
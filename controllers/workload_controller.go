@@ -194,19 +194,17 @@ func (r *WorkloadReconciler) buildDeploymentTargetName(workload *schedulerv1alph
 
 func (r *WorkloadReconciler) getWorkspaceLabel(ctx context.Context, workload *schedulerv1alpha1.Workload) (*string, error) {
 	workspaceLabel := ""
-	if workload.Labels != nil {
-		fluxKustomizationName := workload.Labels[FluxOwnerLabel]
-		// extract the workspace label from the flux kustomization name by removing the namespace- prefix
-		if fluxKustomizationName != "" {
-			workloadRegistrationName := strings.SplitN(fluxKustomizationName, "-", 2)[1]
-			// get the workload registration
-			workloadRegistration := &schedulerv1alpha1.WorkloadRegistration{}
-			err := r.Get(ctx, types.NamespacedName{Name: workloadRegistrationName, Namespace: workload.Namespace}, workloadRegistration)
-			if err != nil {
-				return nil, err
-			}
-			workspaceLabel = workloadRegistration.Spec.Workspace
+	// the Flux Kustomization or the Argo CD Application that has delivered the workload
+	sourceName := getSourceName(workload)
+	// extract the workload registration name from the source name by removing the namespace- prefix
+	if workloadRegistrationName, found := strings.CutPrefix(sourceName, workload.Namespace+"-"); found {
+		// get the workload registration
+		workloadRegistration := &schedulerv1alpha1.WorkloadRegistration{}
+		err := r.Get(ctx, types.NamespacedName{Name: workloadRegistrationName, Namespace: workload.Namespace}, workloadRegistration)
+		if err != nil {
+			return nil, err
 		}
+		workspaceLabel = workloadRegistration.Spec.Workspace
 	}
 	return &workspaceLabel, nil
 }
@@ -25,86 +25,224 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/go-logr/logr"
 	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/microsoft/kalypso-scheduler/scheduler"
 )
 
 // WorkloadRegistrationReconciler reconciles a WorkloadRegistration object
 type WorkloadRegistrationReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// DefaultSourceReconciler is used if the resource doesn't specify its source reconciler
+	DefaultSourceReconciler schedulerv1alpha1.SourceReconcilerType
+	// FluxConfig is the manager wide configuration of the Flux resources
+	FluxConfig FluxConfig
+	// ArgoCDConfig is the manager wide configuration of the Argo CD resources
+	ArgoCDConfig ArgoCDConfig
 }
 
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=workloadregistrations,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=workloadregistrations/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=workloadregistrations/finalizers,verbs=update
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=gitopsrepoes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=environments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=workloads,verbs=get;list;watch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=assignmentpackages,verbs=get;list;watch
+// +kubebuilder:rbac:groups=scheduler.kalypso.io,resources=clustertypes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=gitrepositories,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=argoproj.io,resources=applications,verbs=get;list;watch;create;update;patch;delete
 
 func (r *WorkloadRegistrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	reqLogger := log.FromContext(ctx)
 	reqLogger.Info("=== Reconciling Workload Registration ===")
 
+	name := fmt.Sprintf("%s-%s", req.Namespace, req.Name)
+
 	// Fetch the WorkloadRegistration instance
 	workloadRegistration := &schedulerv1alpha1.WorkloadRegistration{}
-	deleted := false
 	err := r.Get(ctx, req.NamespacedName, workloadRegistration)
 	if err != nil {
 		ignroredNotFound := client.IgnoreNotFound(err)
 		if ignroredNotFound != nil {
 			reqLogger.Error(err, "Failed to get Workload Registration")
 			return ctrl.Result{}, ignroredNotFound
-		} else {
-			deleted = true
 		}
-
+		// the resource is gone without the finalizer, e.g. it was created before the finalizer was introduced
+		return ctrl.Result{}, deleteSourceResources(ctx, r.Client, r.FluxConfig, r.ArgoCDConfig, name)
 	}
 
 	// Check if the resource is being deleted
 	if !workloadRegistration.ObjectMeta.DeletionTimestamp.IsZero() {
-		deleted = true
+		return r.finalize(ctx, reqLogger, workloadRegistration, name)
 	}
 
-	flux := NewFlux(ctx, r.Client)
-	name := fmt.Sprintf("%s-%s", req.Namespace, req.Name)
-	if deleted {
-		err := flux.DeleteFluxReferenceResources(name, DefaulFluxNamespace)
-		if err != nil {
-			return r.manageFailure(ctx, reqLogger, workloadRegistration, err, "Failed to delete flux resources")
+	if controllerutil.AddFinalizer(workloadRegistration, SourceResourcesFinalizer) {
+		if err := r.Update(ctx, workloadRegistration); err != nil {
+			return r.manageFailure(ctx, reqLogger, workloadRegistration, err, "Failed to add finalizer")
 		}
-		reqLogger.Info(fmt.Sprintf("Flux resources %s in %s namespace deleted successfully", name, DefaulFluxNamespace))
-	} else {
+	}
+
+	fluxConfig, err := r.getFluxConfig(ctx, workloadRegistration)
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, workloadRegistration, err, "Failed to get environment")
+	}
 
-		// Create flux resources
+	// Create source reconciler resources
 
-		err = flux.CreateFluxReferenceResources(name, DefaulFluxNamespace,
-			workloadRegistration.Namespace,
-			workloadRegistration.Spec.Workload.Repo,
-			workloadRegistration.Spec.Workload.Branch,
-			workloadRegistration.Spec.Workload.Path,
-			"")
+	sourceReconcilerType := getSourceReconcilerType(workloadRegistration.Spec.SourceReconciler, r.DefaultSourceReconciler)
+	source, err := createSourceResources(ctx, r.Client,
+		sourceReconcilerType, workloadRegistration.Status.SourceReconciler,
+		fluxConfig,
+		r.ArgoCDConfig,
+		name,
+		workloadRegistration.Namespace,
+		workloadRegistration.Spec.Workload.Repo,
+		workloadRegistration.Spec.Workload.Branch,
+		workloadRegistration.Spec.Workload.Path,
+		"")
 
-		if err != nil {
-			return r.manageFailure(ctx, reqLogger, workloadRegistration, err, "Failed to create flux resources")
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, workloadRegistration, err, "Failed to create source reconciler resources")
+	}
+
+	sourceStatus, err := source.GetReferenceResourcesStatus(name)
+	if err != nil {
+		return r.manageFailure(ctx, reqLogger, workloadRegistration, err, "Failed to get source reconciler resources status")
+	}
+
+	workloadRegistration.Status.SourceReconciler = sourceReconcilerType
+	meta.SetStatusCondition(&workloadRegistration.Status.Conditions, sourceStatus.Condition())
+	if sourceStatus.LastAppliedRevision != "" {
+		workloadRegistration.Status.LastAppliedRevision = sourceStatus.LastAppliedRevision
+	}
+
+	updateErr := r.Status().Update(ctx, workloadRegistration)
+	if updateErr != nil {
+		reqLogger.Info("Error when updating status.")
+		return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	}
+
+	return getSourceStatusResult(sourceReconcilerType, r.DefaultSourceReconciler), nil
+}
+
+// finalize deletes the source reconciler resources of the workload registration. The deletion waits for the open PRs
+// of the GitOps repos the workloads of the registration are delivered to.
+func (r *WorkloadRegistrationReconciler) finalize(ctx context.Context, logger logr.Logger, workloadRegistration *schedulerv1alpha1.WorkloadRegistration, name string) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(workloadRegistration, SourceResourcesFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	gitopsrepos, err := r.getDependentGitOpsRepos(ctx, workloadRegistration, name)
+	if err != nil {
+		return r.manageFailure(ctx, logger, workloadRegistration, err, "Failed to get dependent GitOps repos")
+	}
+	if condition := getDeletionBlockedCondition(gitopsrepos); condition != nil {
+		logger.Info(condition.Message)
+		meta.SetStatusCondition(&workloadRegistration.Status.Conditions, *condition)
+		if err := r.Status().Update(ctx, workloadRegistration); err != nil {
+			logger.Info("Error when updating status.")
+			return ctrl.Result{RequeueAfter: time.Second * 3}, err
 		}
+		return ctrl.Result{RequeueAfter: DeletionBlockedRequeueInterval}, nil
+	}
 
-		condition := metav1.Condition{
-			Type:   "Ready",
-			Status: metav1.ConditionTrue,
-			Reason: "FluxResourcesCreated",
+	err = deleteSourceResources(ctx, r.Client, r.FluxConfig, r.ArgoCDConfig, name)
+	if err != nil {
+		return r.manageFailure(ctx, logger, workloadRegistration, err, "Failed to delete source reconciler resources")
+	}
+	logger.Info(fmt.Sprintf("Source reconciler resources %s deleted successfully", name))
+
+	controllerutil.RemoveFinalizer(workloadRegistration, SourceResourcesFinalizer)
+	return ctrl.Result{}, r.Update(ctx, workloadRegistration)
+}
+
+// getDependentGitOpsRepos returns the GitOps repos the workloads fetched by the source reconciler resources of the
+// registration are delivered to, i.e. the repos of the cluster types of their assignment packages
+func (r *WorkloadRegistrationReconciler) getDependentGitOpsRepos(ctx context.Context, workloadRegistration *schedulerv1alpha1.WorkloadRegistration, name string) ([]schedulerv1alpha1.GitOpsRepo, error) {
+	namespace := workloadRegistration.Namespace
+
+	workloads := &schedulerv1alpha1.WorkloadList{}
+	err := r.List(ctx, workloads, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	registeredWorkloads := make(map[string]bool)
+	for _, workload := range workloads.Items {
+		if getSourceName(&workload) == name {
+			registeredWorkloads[workload.Name] = true
+		}
+	}
+
+	assignmentPackages := &schedulerv1alpha1.AssignmentPackageList{}
+	err = r.List(ctx, assignmentPackages, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	var clusterTypes []string
+	for _, assignmentPackage := range assignmentPackages.Items {
+		if registeredWorkloads[assignmentPackage.Labels[schedulerv1alpha1.WorkloadLabel]] {
+			clusterTypes = append(clusterTypes, assignmentPackage.Labels[schedulerv1alpha1.ClusterTypeLabel])
+		}
+	}
+	if len(clusterTypes) == 0 {
+		return nil, nil
+	}
+
+	gitopsrepos := &schedulerv1alpha1.GitOpsRepoList{}
+	err = r.List(ctx, gitopsrepos, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	clusterTypeList := &schedulerv1alpha1.ClusterTypeList{}
+	err = r.List(ctx, clusterTypeList, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	ownership, err := scheduler.NewClusterTypeOwnership(gitopsrepos.Items, clusterTypeList.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var dependentRepos []schedulerv1alpha1.GitOpsRepo
+	for _, gitopsrepo := range gitopsrepos.Items {
+		for _, clusterType := range clusterTypes {
+			if ownership.Owns(gitopsrepo.Name, clusterType) {
+				dependentRepos = append(dependentRepos, gitopsrepo)
+				break
+			}
 		}
-		meta.SetStatusCondition(&workloadRegistration.Status.Conditions, condition)
+	}
+	return dependentRepos, nil
+}
+
+// getFluxConfig returns the Flux config of the workload registration. The registration is delivered to the namespace
+// of its environment, so its Kustomization depends on the environment Kustomization if the environment is fetched by Flux
+func (r *WorkloadRegistrationReconciler) getFluxConfig(ctx context.Context, workloadRegistration *schedulerv1alpha1.WorkloadRegistration) (FluxConfig, error) {
+	fluxConfig := r.FluxConfig.WithOverrides(workloadRegistration.Spec.Flux)
 
-		updateErr := r.Status().Update(ctx, workloadRegistration)
-		if updateErr != nil {
-			reqLogger.Info("Error when updating status.")
-			return ctrl.Result{RequeueAfter: time.Second * 3}, updateErr
+	environments := &schedulerv1alpha1.EnvironmentList{}
+	if err := r.List(ctx, environments); err != nil {
+		return fluxConfig, err
+	}
+	for _, environment := range environments.Items {
+		if environment.Name == workloadRegistration.Namespace &&
+			getSourceReconcilerType(environment.Spec.SourceReconciler, r.DefaultSourceReconciler) == schedulerv1alpha1.FluxSourceReconciler {
+			fluxConfig.Settings.DependsOn = append(fluxConfig.Settings.DependsOn, environment.Name)
+			break
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return fluxConfig, nil
 }
 
 // Gracefully handle errors
@@ -129,10 +267,17 @@ func (h *WorkloadRegistrationReconciler) manageFailure(ctx context.Context, logg
 	return ctrl.Result{}, err
 }
 
+// findWorkloadRegistrations returns the workload registrations whose source reconciler resources are the object
+func (r *WorkloadRegistrationReconciler) findWorkloadRegistrations(ctx context.Context, object client.Object) []reconcile.Request {
+	return findResourcesBySourceName(ctx, r.Client, &schedulerv1alpha1.WorkloadRegistrationList{}, object, func(object client.Object) string {
+		return fmt.Sprintf("%s-%s", object.GetNamespace(), object.GetName())
+	})
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *WorkloadRegistrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&schedulerv1alpha1.WorkloadRegistration{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&schedulerv1alpha1.WorkloadRegistration{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	return watchSourceResources(b, r.DefaultSourceReconciler, handler.EnqueueRequestsFromMapFunc(r.findWorkloadRegistrations)).
 		Complete(r)
 }
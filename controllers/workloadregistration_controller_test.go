@@ -0,0 +1,118 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Test the workload registration waits only for the PRs of the GitOps repos its workloads are delivered to
+func TestWorkloadRegistrationReconcileFinalizer(t *testing.T) {
+	ctx := context.Background()
+	newWorkload := func(name, sourceName string) *schedulerv1alpha1.Workload {
+		return &schedulerv1alpha1.Workload{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "dev", Labels: map[string]string{FluxOwnerLabel: sourceName},
+		}}
+	}
+	newAssignmentPackage := func(name, workload, clusterType string) *schedulerv1alpha1.AssignmentPackage {
+		return &schedulerv1alpha1.AssignmentPackage{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "dev", Labels: map[string]string{
+				schedulerv1alpha1.WorkloadLabel:    workload,
+				schedulerv1alpha1.ClusterTypeLabel: clusterType,
+			},
+		}}
+	}
+	newClusterType := func(name string) *schedulerv1alpha1.ClusterType {
+		return &schedulerv1alpha1.ClusterType{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "dev", Labels: map[string]string{"size": name},
+		}}
+	}
+	newSizeGitOpsRepo := func(size, prNumber string) *schedulerv1alpha1.GitOpsRepo {
+		gitopsRepo := newTestGitOpsRepo(size, "dev", newOpenPR(prNumber))
+		gitopsRepo.Spec.ClusterTypeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"size": size}}
+		return gitopsRepo
+	}
+
+	workloadRegistration := &schedulerv1alpha1.WorkloadRegistration{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello-world", Namespace: "dev"},
+		Spec: schedulerv1alpha1.WorkloadRegistrationSpec{
+			Workload:  schedulerv1alpha1.ManifestsSpec{Repo: "https://github.com/microsoft/kalypso-app", Branch: "main", Path: "workload"},
+			Workspace: "kalypso",
+		},
+	}
+	c := newFakeClient(t,
+		workloadRegistration,
+		newWorkload("hello-world", "dev-hello-world"),
+		newWorkload("other", "dev-other"),
+		newAssignmentPackage("hello-world-small", "hello-world", "small"),
+		newAssignmentPackage("other-large", "other", "large"),
+		newClusterType("small"),
+		newClusterType("large"),
+		newSizeGitOpsRepo("small", "1"),
+		newSizeGitOpsRepo("large", "2"),
+	)
+	r := &WorkloadRegistrationReconciler{Client: c, Scheme: c.Scheme()}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "hello-world", Namespace: "dev"}}
+
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Get(ctx, req.NamespacedName, workloadRegistration))
+	assert.True(t, controllerutil.ContainsFinalizer(workloadRegistration, SourceResourcesFinalizer))
+	assertSourceResources(t, c, "dev-hello-world", true)
+
+	// the repo of the other workload doesn't block the deletion
+	assert.NoError(t, c.Delete(ctx, workloadRegistration))
+	result, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, DeletionBlockedRequeueInterval, result.RequeueAfter)
+	assert.NoError(t, c.Get(ctx, req.NamespacedName, workloadRegistration))
+	condition := meta.FindStatusCondition(workloadRegistration.Status.Conditions, "Ready")
+	assert.Equal(t, "DeletionBlocked", condition.Reason)
+	assert.Equal(t, "Deletion is waiting for the open PRs to be merged or closed: small#1", condition.Message)
+
+	mergePR(t, c, "small", "dev")
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.True(t, errors.IsNotFound(c.Get(ctx, req.NamespacedName, workloadRegistration)))
+	assertSourceResources(t, c, "dev-hello-world", false)
+}
+
+// Test the Flux resources of a workload registration that is gone without the finalizer are deleted
+func TestWorkloadRegistrationReconcileNotFound(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t,
+		&sourcev1.GitRepository{ObjectMeta: metav1.ObjectMeta{Name: "dev-hello-world", Namespace: DefaulFluxNamespace}},
+		&kustomizev1.Kustomization{ObjectMeta: metav1.ObjectMeta{Name: "dev-hello-world", Namespace: DefaulFluxNamespace}},
+	)
+	r := &WorkloadRegistrationReconciler{Client: c, Scheme: c.Scheme()}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "hello-world", Namespace: "dev"}})
+	assert.NoError(t, err)
+	assertSourceResources(t, c, "dev-hello-world", false)
+}
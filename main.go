@@ -18,12 +18,15 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -38,6 +41,7 @@ import (
 
 	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
 	"github.com/microsoft/kalypso-scheduler/controllers"
+	"github.com/microsoft/kalypso-scheduler/scheduler"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -60,11 +64,36 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var sourceReconciler string
+	var fluxNamespace string
+	var fluxInterval time.Duration
+	var fluxTimeout time.Duration
+	var fluxSecretName string
+	var fluxSopsSecretName string
+	var argoCDNamespace string
+	var argoCDProject string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&sourceReconciler, "source-reconciler", string(schedulerv1alpha1.FluxSourceReconciler),
+		"The GitOps operator that fetches the manifests of environments, base repos and workload registrations "+
+			"to the control plane cluster, unless a resource specifies its own one. One of flux, argocd.")
+	flag.StringVar(&fluxNamespace, "flux-namespace", controllers.DefaulFluxNamespace,
+		"The namespace where the Flux GitRepositories and Kustomizations are created.")
+	flag.DurationVar(&fluxInterval, "flux-interval", controllers.FluxInterval,
+		"The interval at which Flux fetches the repos and reapplies the manifests, unless a resource overrides it.")
+	flag.DurationVar(&fluxTimeout, "flux-timeout", 0,
+		"The timeout of the Flux git operations and manifests apply, unless a resource overrides it. The Flux default is used if it's not set.")
+	flag.StringVar(&fluxSecretName, "flux-secret-name", controllers.RepoSecretName,
+		"The secret in the Flux namespace with the repo credentials, unless a resource overrides it.")
+	flag.StringVar(&fluxSopsSecretName, "flux-sops-secret-name", "",
+		"The secret in the Flux namespace with the SOPS decryption keys. If it's set, Flux decrypts the manifests of all resources.")
+	flag.StringVar(&argoCDNamespace, "argocd-namespace", controllers.DefaultArgoCDNamespace,
+		"The namespace where the Argo CD Applications are created.")
+	flag.StringVar(&argoCDProject, "argocd-project", controllers.DefaultArgoCDProject,
+		"The Argo CD project of the Applications.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -73,6 +102,34 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	defaultSourceReconciler := schedulerv1alpha1.SourceReconcilerType(sourceReconciler)
+	if defaultSourceReconciler != schedulerv1alpha1.FluxSourceReconciler && defaultSourceReconciler != schedulerv1alpha1.ArgoCDSourceReconciler {
+		setupLog.Error(fmt.Errorf("unsupported source reconciler %s", sourceReconciler), "invalid --source-reconciler flag")
+		os.Exit(1)
+	}
+
+	fluxConfig := controllers.FluxConfig{
+		Namespace: fluxNamespace,
+		Settings: schedulerv1alpha1.FluxSpec{
+			Interval:   &metav1.Duration{Duration: fluxInterval},
+			SecretName: fluxSecretName,
+		},
+	}
+	if fluxTimeout > 0 {
+		fluxConfig.Settings.Timeout = &metav1.Duration{Duration: fluxTimeout}
+	}
+	if fluxSopsSecretName != "" {
+		fluxConfig.Settings.Decryption = &schedulerv1alpha1.DecryptionSpec{
+			Provider:   controllers.SopsDecryptionProvider,
+			SecretName: fluxSopsSecretName,
+		}
+	}
+
+	argoCDConfig := controllers.ArgoCDConfig{
+		Namespace: argoCDNamespace,
+		Project:   argoCDProject,
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: metricsserver.Options{
@@ -101,8 +158,9 @@ func main() {
 	}
 
 	if err = (&controllers.SchedulingPolicyReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Registry: scheduler.NewRegistry(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "SchedulingPolicy")
 		os.Exit(1)
@@ -129,24 +187,40 @@ func main() {
 		os.Exit(1)
 	}
 	if err = (&controllers.BaseRepoReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		DefaultSourceReconciler: defaultSourceReconciler,
+		FluxConfig:              fluxConfig,
+		ArgoCDConfig:            argoCDConfig,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "BaseRepo")
 		os.Exit(1)
 	}
 	if err = (&controllers.WorkloadRegistrationReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		DefaultSourceReconciler: defaultSourceReconciler,
+		FluxConfig:              fluxConfig,
+		ArgoCDConfig:            argoCDConfig,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "WorkloadRegistration")
 		os.Exit(1)
 	}
 	if err = (&controllers.EnvironmentReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		DefaultSourceReconciler: defaultSourceReconciler,
+		FluxConfig:              fluxConfig,
+		ArgoCDConfig:            argoCDConfig,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Environment")
+		os.Exit(1)
+	}
+	if err = (&controllers.PromotionPipelineReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Environment")
+		setupLog.Error(err, "unable to create controller", "controller", "PromotionPipeline")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
@@ -0,0 +1,223 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	TaintsPluginName   = "Taints"
+	AffinityPluginName = "Affinity"
+)
+
+// taintsPlugin keeps the deployment targets away from the cluster types with taints they don't tolerate.
+// The NoSchedule and NoExecute taints filter the cluster types out, the PreferNoSchedule ones lower their score.
+type taintsPlugin struct{}
+
+// validate taintsPlugin implements FilterPlugin, ScorePlugin and ScoreNormalizer interfaces
+var _ FilterPlugin = (*taintsPlugin)(nil)
+var _ ScorePlugin = (*taintsPlugin)(nil)
+var _ ScoreNormalizer = (*taintsPlugin)(nil)
+
+func newTaintsPlugin(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	return &taintsPlugin{}, nil
+}
+
+func (p *taintsPlugin) Name() string {
+	return TaintsPluginName
+}
+
+// Filter drops the cluster types with untolerated taints, the NoSchedule taints don't remove the deployment target
+// from the cluster types it is currently assigned to
+func (p *taintsPlugin) Filter(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) *Status {
+	isCurrent := state.IsAssigned(deploymentTarget.Name, clusterType.Name)
+	for i := range clusterType.Spec.Taints {
+		taint := &clusterType.Spec.Taints[i]
+		if taint.Effect == kalypsov1alpha1.PreferNoScheduleTaintEffect || (taint.Effect == kalypsov1alpha1.NoScheduleTaintEffect && isCurrent) {
+			continue
+		}
+		if !toleratesTaint(deploymentTarget, taint) {
+			return NewStatus(Ineligible, fmt.Sprintf("have untolerated taint %s", taint))
+		}
+	}
+	return nil
+}
+
+// Score returns the number of untolerated PreferNoSchedule taints of the cluster type, the fewer the better
+func (p *taintsPlugin) Score(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) int64 {
+	var count int64
+	for i := range clusterType.Spec.Taints {
+		taint := &clusterType.Spec.Taints[i]
+		if taint.Effect == kalypsov1alpha1.PreferNoScheduleTaintEffect && !toleratesTaint(deploymentTarget, taint) {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *taintsPlugin) NormalizeScores(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, scores []int64) {
+	NormalizeScores(scores, true)
+}
+
+// toleratesTaint checks if any toleration of the deployment target tolerates the taint
+func toleratesTaint(deploymentTarget *kalypsov1alpha1.DeploymentTarget, taint *kalypsov1alpha1.Taint) bool {
+	for i := range deploymentTarget.Spec.Tolerations {
+		if deploymentTarget.Spec.Tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// affinityPlugin places the deployment targets according to their affinity to the cluster types and
+// their affinity and anti-affinity to the other deployment targets
+type affinityPlugin struct{}
+
+// validate affinityPlugin implements FilterPlugin, ScorePlugin and ScoreNormalizer interfaces
+var _ FilterPlugin = (*affinityPlugin)(nil)
+var _ ScorePlugin = (*affinityPlugin)(nil)
+var _ ScoreNormalizer = (*affinityPlugin)(nil)
+
+func newAffinityPlugin(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	return &affinityPlugin{}, nil
+}
+
+func (p *affinityPlugin) Name() string {
+	return AffinityPluginName
+}
+
+// Filter drops the cluster types that don't match the required cluster type affinity and the ones that violate
+// the required deployment target affinity or anti-affinity. The required anti-affinity of the deployment targets
+// that are already scheduled on the cluster type is honoured as well, so it doesn't matter which one is placed first.
+func (p *affinityPlugin) Filter(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) *Status {
+	affinity := deploymentTarget.Spec.Affinity
+	if affinity == nil {
+		affinity = &kalypsov1alpha1.Affinity{}
+	}
+
+	for _, selector := range getRequiredTerms(affinity.ClusterTypeAffinity) {
+		if !matchesLabelSelector(selector, clusterType.GetLabels()) {
+			return NewStatus(Ineligible, "don't match the cluster type affinity")
+		}
+	}
+
+	hosted := state.GetDeploymentTargets(clusterType.Name)
+	for _, selector := range getRequiredTerms(affinity.DeploymentTargetAffinity) {
+		if !hostsMatchingDeploymentTarget(hosted, deploymentTarget, selector) {
+			return NewStatus(Unschedulable, "don't host the deployment targets of the affinity")
+		}
+	}
+
+	for _, selector := range getRequiredTerms(affinity.DeploymentTargetAntiAffinity) {
+		if hostsMatchingDeploymentTarget(hosted, deploymentTarget, selector) {
+			return NewStatus(Unschedulable, "host the deployment targets of the anti-affinity")
+		}
+	}
+
+	for _, other := range hosted {
+		if other.Name == deploymentTarget.Name || other.Spec.Affinity == nil {
+			continue
+		}
+		for _, selector := range getRequiredTerms(other.Spec.Affinity.DeploymentTargetAntiAffinity) {
+			if matchesLabelSelector(selector, deploymentTarget.GetLabels()) {
+				return NewStatus(Unschedulable, "host the deployment targets of the anti-affinity")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Score returns how much the deployment target prefers the cluster type: the sum of the weights of the
+// preferred affinity it satisfies minus the weights of the preferred anti-affinity
+func (p *affinityPlugin) Score(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) int64 {
+	affinity := deploymentTarget.Spec.Affinity
+	if affinity == nil {
+		return 0
+	}
+
+	var preference int64
+	for _, term := range getPreferredTerms(affinity.ClusterTypeAffinity) {
+		if matchesLabelSelector(term.LabelSelector, clusterType.GetLabels()) {
+			preference += int64(term.Weight)
+		}
+	}
+
+	hosted := state.GetDeploymentTargets(clusterType.Name)
+	for _, term := range getPreferredTerms(affinity.DeploymentTargetAffinity) {
+		if hostsMatchingDeploymentTarget(hosted, deploymentTarget, term.LabelSelector) {
+			preference += int64(term.Weight)
+		}
+	}
+	for _, term := range getPreferredTerms(affinity.DeploymentTargetAntiAffinity) {
+		if hostsMatchingDeploymentTarget(hosted, deploymentTarget, term.LabelSelector) {
+			preference -= int64(term.Weight)
+		}
+	}
+
+	return preference
+}
+
+func (p *affinityPlugin) NormalizeScores(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, scores []int64) {
+	NormalizeScores(scores, false)
+}
+
+// hasRequiredDeploymentTargetAffinity checks if the deployment target must be co-located with other deployment targets,
+// such deployment targets are placed after the others
+func hasRequiredDeploymentTargetAffinity(deploymentTarget *kalypsov1alpha1.DeploymentTarget) bool {
+	return deploymentTarget.Spec.Affinity != nil && len(getRequiredTerms(deploymentTarget.Spec.Affinity.DeploymentTargetAffinity)) > 0
+}
+
+// hostsMatchingDeploymentTarget checks if any of the hosted deployment targets but the deployment target itself matches the selector
+func hostsMatchingDeploymentTarget(hosted []*kalypsov1alpha1.DeploymentTarget, deploymentTarget *kalypsov1alpha1.DeploymentTarget, selector metav1.LabelSelector) bool {
+	for _, other := range hosted {
+		if other.Name != deploymentTarget.Name && matchesLabelSelector(selector, other.GetLabels()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesLabelSelector checks if the labels match the selector, an invalid selector matches nothing
+func matchesLabelSelector(selector metav1.LabelSelector, objectLabels map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(&selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(objectLabels))
+}
+
+func getRequiredTerms(terms *kalypsov1alpha1.AffinityTerms) []metav1.LabelSelector {
+	if terms == nil {
+		return nil
+	}
+	return terms.Required
+}
+
+func getPreferredTerms(terms *kalypsov1alpha1.AffinityTerms) []kalypsov1alpha1.WeightedLabelSelector {
+	if terms == nil {
+		return nil
+	}
+	return terms.Preferred
+}
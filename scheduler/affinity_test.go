@@ -0,0 +1,238 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestToleratesTaint(t *testing.T) {
+	taint := &kalypsov1alpha1.Taint{Key: "gpu", Value: "true", Effect: kalypsov1alpha1.NoScheduleTaintEffect}
+
+	tests := []struct {
+		toleration kalypsov1alpha1.Toleration
+		expected   bool
+	}{
+		{kalypsov1alpha1.Toleration{Key: "gpu", Value: "true"}, true},
+		{kalypsov1alpha1.Toleration{Key: "gpu", Value: "true", Operator: kalypsov1alpha1.EqualTolerationOperator, Effect: kalypsov1alpha1.NoScheduleTaintEffect}, true},
+		{kalypsov1alpha1.Toleration{Key: "gpu", Value: "false"}, false},
+		{kalypsov1alpha1.Toleration{Key: "gpu", Operator: kalypsov1alpha1.ExistsTolerationOperator}, true},
+		{kalypsov1alpha1.Toleration{Key: "gpu", Value: "true", Effect: kalypsov1alpha1.NoExecuteTaintEffect}, false},
+		{kalypsov1alpha1.Toleration{Key: "fpga", Operator: kalypsov1alpha1.ExistsTolerationOperator}, false},
+		{kalypsov1alpha1.Toleration{Operator: kalypsov1alpha1.ExistsTolerationOperator}, true},
+		{kalypsov1alpha1.Toleration{Value: "true"}, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.toleration.ToleratesTaint(taint), "%+v", test.toleration)
+	}
+}
+
+func TestScheduleAffinity(t *testing.T) {
+	clusterTypes := []kalypsov1alpha1.ClusterType{
+		{ObjectMeta: metav1.ObjectMeta{Name: "core", Labels: map[string]string{"zone": "core"}}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "drain", Labels: map[string]string{"zone": "edge"}},
+			Spec: kalypsov1alpha1.ClusterTypeSpec{Taints: []kalypsov1alpha1.Taint{
+				{Key: "maintenance", Effect: kalypsov1alpha1.NoExecuteTaintEffect},
+			}},
+		},
+		{ObjectMeta: metav1.ObjectMeta{Name: "edge", Labels: map[string]string{"zone": "edge"}}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "gpu", Labels: map[string]string{"zone": "core", "accelerator": "gpu"}},
+			Spec: kalypsov1alpha1.ClusterTypeSpec{Taints: []kalypsov1alpha1.Taint{
+				{Key: "gpu", Value: "true", Effect: kalypsov1alpha1.NoScheduleTaintEffect},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "legacy", Labels: map[string]string{"zone": "core"}},
+			Spec: kalypsov1alpha1.ClusterTypeSpec{Taints: []kalypsov1alpha1.Taint{
+				{Key: "deprecated", Effect: kalypsov1alpha1.PreferNoScheduleTaintEffect},
+			}},
+		},
+	}
+
+	target := func(name, workload, tier string, spec kalypsov1alpha1.DeploymentTargetSpec) kalypsov1alpha1.DeploymentTarget {
+		return kalypsov1alpha1.DeploymentTarget{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{kalypsov1alpha1.WorkloadLabel: workload, "tier": tier}},
+			Spec:       spec,
+		}
+	}
+	selector := func(key, value string) metav1.LabelSelector {
+		return metav1.LabelSelector{MatchLabels: map[string]string{key: value}}
+	}
+	anyTaint := []kalypsov1alpha1.Toleration{{Operator: kalypsov1alpha1.ExistsTolerationOperator}}
+
+	tests := []struct {
+		name              string
+		strategy          kalypsov1alpha1.SchedulingStrategyType
+		deploymentTargets []kalypsov1alpha1.DeploymentTarget
+		existing          []kalypsov1alpha1.Assignment
+		expected          map[string][]string
+		unplaced          map[string]string
+	}{
+		{
+			name:              "taints repel the deployment targets without tolerations",
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{target("a", "app", "web", kalypsov1alpha1.DeploymentTargetSpec{})},
+			expected:          map[string][]string{"a": {"core", "edge", "legacy"}},
+		},
+		{
+			name: "tolerations let the deployment targets on the tainted cluster types",
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("a", "app", "web", kalypsov1alpha1.DeploymentTargetSpec{Tolerations: []kalypsov1alpha1.Toleration{
+					{Key: "gpu", Value: "true"},
+					{Key: "maintenance", Operator: kalypsov1alpha1.ExistsTolerationOperator},
+				}}),
+				target("b", "app", "web", kalypsov1alpha1.DeploymentTargetSpec{Tolerations: anyTaint}),
+			},
+			expected: map[string][]string{
+				"a": {"core", "drain", "edge", "gpu", "legacy"},
+				"b": {"core", "drain", "edge", "gpu", "legacy"},
+			},
+		},
+		{
+			name:              "NoSchedule taints keep the current assignments, NoExecute taints remove them",
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{target("a", "app", "web", kalypsov1alpha1.DeploymentTargetSpec{})},
+			existing: []kalypsov1alpha1.Assignment{
+				assign("a", "app", "gpu", "policy"),
+				assign("a", "app", "drain", "policy"),
+			},
+			expected: map[string][]string{"a": {"gpu", "core", "edge", "legacy"}},
+		},
+		{
+			name: "required cluster type affinity",
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("a", "app", "web", kalypsov1alpha1.DeploymentTargetSpec{Affinity: &kalypsov1alpha1.Affinity{
+					ClusterTypeAffinity: &kalypsov1alpha1.AffinityTerms{Required: []metav1.LabelSelector{selector("zone", "edge")}},
+				}}),
+			},
+			expected: map[string][]string{"a": {"edge"}},
+		},
+		{
+			name:     "preferred cluster type affinity",
+			strategy: kalypsov1alpha1.SpreadSchedulingStrategy,
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("a", "app", "web", kalypsov1alpha1.DeploymentTargetSpec{Affinity: &kalypsov1alpha1.Affinity{
+					ClusterTypeAffinity: &kalypsov1alpha1.AffinityTerms{Preferred: []kalypsov1alpha1.WeightedLabelSelector{
+						{Weight: 50, LabelSelector: selector("zone", "edge")},
+					}},
+				}}),
+			},
+			expected: map[string][]string{"a": {"edge"}},
+		},
+		{
+			name:     "PreferNoSchedule taints outweigh the strategy",
+			strategy: kalypsov1alpha1.SpreadSchedulingStrategy,
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("a", "app", "web", kalypsov1alpha1.DeploymentTargetSpec{}),
+				target("x", "other", "web", kalypsov1alpha1.DeploymentTargetSpec{}),
+			},
+			existing: []kalypsov1alpha1.Assignment{
+				assign("x", "other", "core", "other-policy"),
+				assign("x", "other", "edge", "other-policy"),
+			},
+			expected: map[string][]string{"a": {"core"}},
+		},
+		{
+			name:     "required anti-affinity keeps the deployment targets apart in both directions",
+			strategy: kalypsov1alpha1.SpreadSchedulingStrategy,
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("payment", "app", "payment", kalypsov1alpha1.DeploymentTargetSpec{Affinity: &kalypsov1alpha1.Affinity{
+					DeploymentTargetAntiAffinity: &kalypsov1alpha1.AffinityTerms{Required: []metav1.LabelSelector{selector("tier", "public")}},
+				}}),
+				target("public", "app", "public", kalypsov1alpha1.DeploymentTargetSpec{}),
+				target("z-payment", "app", "payment", kalypsov1alpha1.DeploymentTargetSpec{}),
+			},
+			expected: map[string][]string{"payment": {"core"}, "public": {"edge"}, "z-payment": {"core"}},
+		},
+		{
+			name: "required anti-affinity with the all strategy",
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("payment", "app", "payment", kalypsov1alpha1.DeploymentTargetSpec{Affinity: &kalypsov1alpha1.Affinity{
+					DeploymentTargetAntiAffinity: &kalypsov1alpha1.AffinityTerms{Required: []metav1.LabelSelector{selector("tier", "public")}},
+				}}),
+				target("public", "app", "public", kalypsov1alpha1.DeploymentTargetSpec{}),
+			},
+			expected: map[string][]string{"payment": {"core", "edge", "legacy"}},
+			unplaced: map[string]string{
+				"public": "scheduled on 0 of 3 cluster types: 1 cluster types have untolerated taint gpu=true:NoSchedule, " +
+					"1 cluster types have untolerated taint maintenance:NoExecute, 3 cluster types host the deployment targets of the anti-affinity",
+			},
+		},
+		{
+			name:     "required affinity co-locates the deployment targets",
+			strategy: kalypsov1alpha1.SpreadSchedulingStrategy,
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("backend", "app", "backend", kalypsov1alpha1.DeploymentTargetSpec{Affinity: &kalypsov1alpha1.Affinity{
+					DeploymentTargetAffinity: &kalypsov1alpha1.AffinityTerms{Required: []metav1.LabelSelector{selector("tier", "frontend")}},
+				}}),
+				target("cache", "app", "cache", kalypsov1alpha1.DeploymentTargetSpec{Affinity: &kalypsov1alpha1.Affinity{
+					DeploymentTargetAffinity: &kalypsov1alpha1.AffinityTerms{Required: []metav1.LabelSelector{selector("tier", "db")}},
+				}}),
+				target("frontend", "app", "frontend", kalypsov1alpha1.DeploymentTargetSpec{}),
+			},
+			expected: map[string][]string{"backend": {"core"}, "frontend": {"core"}},
+			unplaced: map[string]string{
+				"cache": "scheduled on 0 of 1 cluster types: 3 cluster types don't host the deployment targets of the affinity, " +
+					"1 cluster types have untolerated taint gpu=true:NoSchedule, 1 cluster types have untolerated taint maintenance:NoExecute",
+			},
+		},
+		{
+			name:     "preferred affinity and anti-affinity",
+			strategy: kalypsov1alpha1.SpreadSchedulingStrategy,
+			deploymentTargets: []kalypsov1alpha1.DeploymentTarget{
+				target("a", "app", "public", kalypsov1alpha1.DeploymentTargetSpec{}),
+				target("b", "app", "public", kalypsov1alpha1.DeploymentTargetSpec{Affinity: &kalypsov1alpha1.Affinity{
+					DeploymentTargetAntiAffinity: &kalypsov1alpha1.AffinityTerms{Preferred: []kalypsov1alpha1.WeightedLabelSelector{
+						{Weight: 10, LabelSelector: selector("tier", "public")},
+					}},
+				}}),
+				target("c", "app", "private", kalypsov1alpha1.DeploymentTargetSpec{Tolerations: anyTaint, Affinity: &kalypsov1alpha1.Affinity{
+					DeploymentTargetAffinity: &kalypsov1alpha1.AffinityTerms{Preferred: []kalypsov1alpha1.WeightedLabelSelector{
+						{Weight: 10, LabelSelector: selector("tier", "db")},
+					}},
+				}}),
+				target("x", "other", "db", kalypsov1alpha1.DeploymentTargetSpec{}),
+			},
+			existing: []kalypsov1alpha1.Assignment{assign("x", "other", "legacy", "other-policy")},
+			expected: map[string][]string{"a": {"core"}, "b": {"edge"}, "c": {"legacy"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := newStrategyPolicy(test.strategy, 1)
+			policy.Spec.DeploymentTargetSelector.LabelSelector = selector(kalypsov1alpha1.WorkloadLabel, "app")
+
+			assignments, unplaced := scheduleWithStrategy(t, policy, clusterTypes, test.deploymentTargets, test.existing)
+			assert.Equal(t, test.expected, getPlacement(assignments))
+
+			messages := map[string]string{}
+			for _, deploymentTarget := range unplaced {
+				messages[deploymentTarget.Name] = deploymentTarget.Message
+			}
+			if test.unplaced == nil {
+				test.unplaced = map[string]string{}
+			}
+			assert.Equal(t, test.unplaced, messages)
+		})
+	}
+}
@@ -0,0 +1,561 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	azureDevOpsAPIVersion = "7.1"
+	azureDevOpsZeroSHA    = "0000000000000000000000000000000000000000"
+	// work item type and its closed state in the Basic process
+	azureDevOpsWorkItemType    = "Issue"
+	azureDevOpsWorkItemDone    = "Done"
+	azureDevOpsJsonPatchType   = "application/json-patch+json"
+	azureDevOpsRefsHeadsPrefix = "refs/heads/"
+	azureDevOpsPageSize        = 100
+	// the maximum length of a pull request description
+	azureDevOpsMaxBodySize = 4000
+)
+
+// implements GitProvider interface on top of the Azure DevOps Git and Work Item Tracking REST APIs
+type azureDevOpsProvider struct {
+	repoUrl    string
+	repository string
+	client     *restClient
+	ctx        context.Context
+	logger     logr.Logger
+}
+
+// validate azureDevOpsProvider implements GitProvider interface
+var _ GitProvider = (*azureDevOpsProvider)(nil)
+
+type azureDevOpsRef struct {
+	Name        string `json:"name"`
+	ObjectID    string `json:"objectId,omitempty"`
+	OldObjectID string `json:"oldObjectId,omitempty"`
+	NewObjectID string `json:"newObjectId,omitempty"`
+	Success     bool   `json:"success,omitempty"`
+}
+
+type azureDevOpsItem struct {
+	ObjectID      string `json:"objectId"`
+	GitObjectType string `json:"gitObjectType"`
+	Path          string `json:"path"`
+}
+
+type azureDevOpsChange struct {
+	ChangeType string `json:"changeType"`
+	Item       struct {
+		Path string `json:"path"`
+	} `json:"item"`
+	NewContent *azureDevOpsContent `json:"newContent,omitempty"`
+}
+
+type azureDevOpsContent struct {
+	Content     string `json:"content"`
+	ContentType string `json:"contentType"`
+}
+
+type azureDevOpsAuthor struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type azureDevOpsCommit struct {
+	CommitID string              `json:"commitId,omitempty"`
+	Comment  string              `json:"comment,omitempty"`
+	Author   *azureDevOpsAuthor  `json:"author,omitempty"`
+	Changes  []azureDevOpsChange `json:"changes,omitempty"`
+	Parents  []string            `json:"parents,omitempty"`
+}
+
+type azureDevOpsPush struct {
+	RefUpdates []azureDevOpsRef    `json:"refUpdates"`
+	Commits    []azureDevOpsCommit `json:"commits"`
+}
+
+type azureDevOpsLabel struct {
+	Name string `json:"name"`
+}
+
+type azureDevOpsCommitRef struct {
+	CommitID string `json:"commitId"`
+}
+
+type azureDevOpsCompletionOptions struct {
+	MergeStrategy      string `json:"mergeStrategy"`
+	DeleteSourceBranch bool   `json:"deleteSourceBranch"`
+}
+
+type azureDevOpsPullRequest struct {
+	PullRequestID         int                           `json:"pullRequestId,omitempty"`
+	Title                 string                        `json:"title,omitempty"`
+	Description           string                        `json:"description,omitempty"`
+	SourceRefName         string                        `json:"sourceRefName,omitempty"`
+	TargetRefName         string                        `json:"targetRefName,omitempty"`
+	Status                string                        `json:"status,omitempty"`
+	Labels                []azureDevOpsLabel            `json:"labels,omitempty"`
+	LastMergeSourceCommit *azureDevOpsCommitRef         `json:"lastMergeSourceCommit,omitempty"`
+	LastMergeCommit       *azureDevOpsCommitRef         `json:"lastMergeCommit,omitempty"`
+	CompletionOptions     *azureDevOpsCompletionOptions `json:"completionOptions,omitempty"`
+}
+
+type azureDevOpsStatus struct {
+	State string `json:"state"`
+}
+
+type azureDevOpsWorkItem struct {
+	ID     int                    `json:"id"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+type azureDevOpsPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+type azureDevOpsList[T any] struct {
+	Value []T `json:"value"`
+}
+
+func getAzureDevOpsHTTPClient(token string) *http.Client {
+	return &http.Client{Transport: &basicAuthTransport{token: token, base: http.DefaultTransport}}
+}
+
+// new azureDevOpsProvider function
+func newAzureDevOpsProvider(ctx context.Context, repoUrl string, httpClient *http.Client) (*azureDevOpsProvider, error) {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// https://dev.azure.com/{organization}/{project}/_git/{repository}
+	// https://{organization}.visualstudio.com/{project}/_git/{repository}
+	urlParts := strings.Split(strings.Trim(u.Path, "/"), "/_git/")
+	if len(urlParts) != 2 || urlParts[0] == "" || urlParts[1] == "" {
+		return nil, errors.New("invalid repo url")
+	}
+	repository := strings.TrimSuffix(strings.Split(urlParts[1], "/")[0], ".git")
+
+	return &azureDevOpsProvider{
+		repoUrl:    fmt.Sprintf("%s://%s/%s/_git/%s", u.Scheme, u.Host, urlParts[0], repository),
+		repository: repository,
+		client: &restClient{
+			baseURL:    fmt.Sprintf("%s://%s/%s/_apis", u.Scheme, u.Host, urlParts[0]),
+			httpClient: httpClient,
+			ctx:        ctx,
+		},
+		ctx:    ctx,
+		logger: log.FromContext(ctx),
+	}, nil
+}
+
+// wrapError converts Azure DevOps API errors into the GitProvider errors
+func (a *azureDevOpsProvider) wrapError(err error) error {
+	var restErr *restError
+	if errors.As(err, &restErr) && restErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, err.Error())
+	}
+	return err
+}
+
+func (a *azureDevOpsProvider) query(values url.Values) url.Values {
+	if values == nil {
+		values = url.Values{}
+	}
+	values.Set("api-version", azureDevOpsAPIVersion)
+	return values
+}
+
+func (a *azureDevOpsProvider) gitPath(path string) string {
+	return "/git/repositories/" + url.PathEscape(a.repository) + path
+}
+
+func (a *azureDevOpsProvider) GetBranch(name string) (*Branch, error) {
+	refs := &azureDevOpsList[azureDevOpsRef]{}
+	if _, err := a.client.do(http.MethodGet, a.gitPath("/refs"), a.query(url.Values{"filter": {"heads/" + name}}), nil, refs); err != nil {
+		return nil, a.wrapError(err)
+	}
+
+	// filter is a prefix match
+	for _, ref := range refs.Value {
+		if ref.Name == azureDevOpsRefsHeadsPrefix+name {
+			return &Branch{Name: name, SHA: ref.ObjectID}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: branch %s", ErrNotFound, name)
+}
+
+func (a *azureDevOpsProvider) updateRef(name, oldSHA, newSHA string) error {
+	refUpdates := []azureDevOpsRef{{
+		Name:        azureDevOpsRefsHeadsPrefix + name,
+		OldObjectID: oldSHA,
+		NewObjectID: newSHA,
+	}}
+	result := &azureDevOpsList[azureDevOpsRef]{}
+	if _, err := a.client.do(http.MethodPost, a.gitPath("/refs"), a.query(nil), refUpdates, result); err != nil {
+		return a.wrapError(err)
+	}
+	for _, ref := range result.Value {
+		if !ref.Success {
+			return fmt.Errorf("failed to update ref %s", ref.Name)
+		}
+	}
+	return nil
+}
+
+func (a *azureDevOpsProvider) CreateBranch(name string, sha string) (*Branch, error) {
+	if err := a.updateRef(name, azureDevOpsZeroSHA, sha); err != nil {
+		return nil, err
+	}
+	return &Branch{Name: name, SHA: sha}, nil
+}
+
+func (a *azureDevOpsProvider) DeleteBranch(name string) error {
+	branch, err := a.GetBranch(name)
+	if err != nil {
+		return err
+	}
+	return a.updateRef(name, branch.SHA, azureDevOpsZeroSHA)
+}
+
+func (a *azureDevOpsProvider) GetTree(sha string) ([]TreeEntry, error) {
+	query := a.query(url.Values{
+		"recursionLevel":                {"Full"},
+		"versionDescriptor.version":     {sha},
+		"versionDescriptor.versionType": {"commit"},
+	})
+	items := &azureDevOpsList[azureDevOpsItem]{}
+	if _, err := a.client.do(http.MethodGet, a.gitPath("/items"), query, nil, items); err != nil {
+		return nil, a.wrapError(err)
+	}
+
+	var entries []TreeEntry
+	for _, item := range items.Value {
+		if item.GitObjectType == "blob" {
+			entries = append(entries, TreeEntry{Path: strings.TrimPrefix(item.Path, "/"), SHA: item.ObjectID})
+		}
+	}
+	return entries, nil
+}
+
+func (a *azureDevOpsProvider) GetBlob(sha string) ([]byte, error) {
+	var blob []byte
+	query := a.query(url.Values{"$format": {"octetstream"}})
+	if _, err := a.client.do(http.MethodGet, a.gitPath("/blobs/"+sha), query, nil, &blob); err != nil {
+		return nil, a.wrapError(err)
+	}
+	return blob, nil
+}
+
+// CommitFiles pushes a commit with the changes on top of the branch head
+func (a *azureDevOpsProvider) CommitFiles(branch *Branch, commit *Commit, changes []FileChange) (*Branch, error) {
+	if commit.Signer != nil {
+		return nil, fmt.Errorf("%w: signed commits in Azure DevOps", ErrNotSupported)
+	}
+
+	newCommit := azureDevOpsCommit{
+		Comment: commit.Message,
+		Author:  &azureDevOpsAuthor{Name: commit.AuthorName, Email: commit.AuthorEmail},
+	}
+
+	for _, change := range changes {
+		adoChange := azureDevOpsChange{}
+		adoChange.Item.Path = "/" + change.Path
+		switch change.Action {
+		case FileCreate:
+			adoChange.ChangeType = "add"
+		case FileUpdate:
+			adoChange.ChangeType = "edit"
+		case FileDelete:
+			adoChange.ChangeType = "delete"
+		}
+		if change.Action != FileDelete {
+			adoChange.NewContent = &azureDevOpsContent{Content: change.Content, ContentType: "rawtext"}
+		}
+		newCommit.Changes = append(newCommit.Changes, adoChange)
+	}
+
+	oldObjectID := branch.SHA
+	if commit.Force {
+		// the push replaces the current head of the branch with a commit on top of the branch SHA
+		current, err := a.GetBranch(branch.Name)
+		if err != nil {
+			return nil, err
+		}
+		oldObjectID = current.SHA
+		newCommit.Parents = []string{branch.SHA}
+	}
+
+	push := &azureDevOpsPush{
+		RefUpdates: []azureDevOpsRef{{Name: azureDevOpsRefsHeadsPrefix + branch.Name, OldObjectID: oldObjectID}},
+		Commits:    []azureDevOpsCommit{newCommit},
+	}
+
+	result := &azureDevOpsPush{}
+	if _, err := a.client.do(http.MethodPost, a.gitPath("/pushes"), a.query(nil), push, result); err != nil {
+		return nil, a.wrapError(err)
+	}
+	if len(result.Commits) == 0 {
+		return nil, errors.New("push didn't create a commit")
+	}
+
+	return &Branch{Name: branch.Name, SHA: result.Commits[0].CommitID}, nil
+}
+
+func (a *azureDevOpsProvider) toPullRequest(pr *azureDevOpsPullRequest) *PullRequest {
+	var labels []string
+	for _, label := range pr.Labels {
+		labels = append(labels, label.Name)
+	}
+	// active, abandoned or completed
+	state := PullRequestOpen
+	var mergeSHA string
+	switch pr.Status {
+	case "abandoned":
+		state = PullRequestClosed
+	case "completed":
+		state = PullRequestMerged
+		if pr.LastMergeCommit != nil {
+			mergeSHA = pr.LastMergeCommit.CommitID
+		}
+	}
+	var headSHA string
+	if pr.LastMergeSourceCommit != nil {
+		headSHA = pr.LastMergeSourceCommit.CommitID
+	}
+	return &PullRequest{
+		Number:   pr.PullRequestID,
+		Title:    pr.Title,
+		Body:     pr.Description,
+		Head:     strings.TrimPrefix(pr.SourceRefName, azureDevOpsRefsHeadsPrefix),
+		Base:     strings.TrimPrefix(pr.TargetRefName, azureDevOpsRefsHeadsPrefix),
+		URL:      fmt.Sprintf("%s/pullrequest/%d", a.repoUrl, pr.PullRequestID),
+		Labels:   labels,
+		State:    state,
+		HeadSHA:  headSHA,
+		MergeSHA: mergeSHA,
+	}
+}
+
+func (a *azureDevOpsProvider) GetPullRequest(number int) (*PullRequest, error) {
+	pr := &azureDevOpsPullRequest{}
+	if _, err := a.client.do(http.MethodGet, a.gitPath("/pullrequests/"+strconv.Itoa(number)), a.query(nil), nil, pr); err != nil {
+		return nil, a.wrapError(err)
+	}
+	return a.toPullRequest(pr), nil
+}
+
+func (a *azureDevOpsProvider) ListPullRequests(baseBranch string) ([]PullRequest, error) {
+	var pullRequests []PullRequest
+	// the pages are full until the last one
+	for skip := 0; ; skip += azureDevOpsPageSize {
+		query := a.query(url.Values{
+			"searchCriteria.status":        {"active"},
+			"searchCriteria.targetRefName": {azureDevOpsRefsHeadsPrefix + baseBranch},
+			"$top":                         {strconv.Itoa(azureDevOpsPageSize)},
+			"$skip":                        {strconv.Itoa(skip)},
+		})
+		prs := &azureDevOpsList[azureDevOpsPullRequest]{}
+		if _, err := a.client.do(http.MethodGet, a.gitPath("/pullrequests"), query, nil, prs); err != nil {
+			return nil, a.wrapError(err)
+		}
+		for i := range prs.Value {
+			pullRequests = append(pullRequests, *a.toPullRequest(&prs.Value[i]))
+		}
+		if len(prs.Value) < azureDevOpsPageSize {
+			return pullRequests, nil
+		}
+	}
+}
+
+func (a *azureDevOpsProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	newPR := &azureDevOpsPullRequest{
+		Title:         pr.Title,
+		Description:   pr.Body,
+		SourceRefName: azureDevOpsRefsHeadsPrefix + pr.Head,
+		TargetRefName: azureDevOpsRefsHeadsPrefix + pr.Base,
+	}
+	for _, label := range pr.Labels {
+		newPR.Labels = append(newPR.Labels, azureDevOpsLabel{Name: label})
+	}
+
+	created := &azureDevOpsPullRequest{}
+	if _, err := a.client.do(http.MethodPost, a.gitPath("/pullrequests"), a.query(nil), newPR, created); err != nil {
+		var restErr *restError
+		if errors.As(err, &restErr) && restErr.StatusCode == http.StatusConflict {
+			return nil, fmt.Errorf("%w: %s", ErrPullRequestExists, err.Error())
+		}
+		return nil, a.wrapError(err)
+	}
+	return a.toPullRequest(created), nil
+}
+
+func (a *azureDevOpsProvider) UpdatePullRequest(number int, title, body string) error {
+	update := &azureDevOpsPullRequest{Title: title, Description: body}
+	_, err := a.client.do(http.MethodPatch, a.gitPath("/pullrequests/"+strconv.Itoa(number)), a.query(nil), update, nil)
+	return a.wrapError(err)
+}
+
+func (a *azureDevOpsProvider) ClosePullRequest(number int) error {
+	body := map[string]string{"status": "abandoned"}
+	_, err := a.client.do(http.MethodPatch, a.gitPath("/pullrequests/"+strconv.Itoa(number)), a.query(nil), body, nil)
+	return a.wrapError(err)
+}
+
+// DismissApprovals is not available in Azure DevOps, only the reviewers can reset their votes.
+// The votes are reset on new pushes by the "Reset code reviewer votes" branch policy.
+func (a *azureDevOpsProvider) DismissApprovals(number int, message string) error {
+	return fmt.Errorf("%w: dismissing approvals in Azure DevOps", ErrNotSupported)
+}
+
+// MergePullRequest completes the PR and returns the merge commit
+func (a *azureDevOpsProvider) MergePullRequest(number int, method schedulerv1alpha1.MergeMethod) (string, error) {
+	pr, err := a.GetPullRequest(number)
+	if err != nil {
+		return "", err
+	}
+
+	mergeStrategy := map[schedulerv1alpha1.MergeMethod]string{
+		schedulerv1alpha1.MergeMergeMethod:  "noFastForward",
+		schedulerv1alpha1.SquashMergeMethod: "squash",
+		schedulerv1alpha1.RebaseMergeMethod: "rebase",
+	}[method]
+
+	update := &azureDevOpsPullRequest{
+		Status:                "completed",
+		LastMergeSourceCommit: &azureDevOpsCommitRef{CommitID: pr.HeadSHA},
+		CompletionOptions:     &azureDevOpsCompletionOptions{MergeStrategy: mergeStrategy},
+	}
+	completed := &azureDevOpsPullRequest{}
+	if _, err := a.client.do(http.MethodPatch, a.gitPath("/pullrequests/"+strconv.Itoa(number)), a.query(nil), update, completed); err != nil {
+		var restErr *restError
+		// policies are not met or the source branch has moved
+		if errors.As(err, &restErr) && (restErr.StatusCode == http.StatusBadRequest || restErr.StatusCode == http.StatusConflict) {
+			return "", fmt.Errorf("%w: %s", ErrMergeBlocked, err.Error())
+		}
+		return "", a.wrapError(err)
+	}
+
+	if completed.LastMergeCommit == nil {
+		return "", nil
+	}
+	return completed.LastMergeCommit.CommitID, nil
+}
+
+// GetCheckState combines the statuses posted to the commit
+func (a *azureDevOpsProvider) GetCheckState(sha string) (CheckState, error) {
+	statuses := &azureDevOpsList[azureDevOpsStatus]{}
+	if _, err := a.client.do(http.MethodGet, a.gitPath("/commits/"+sha+"/statuses"), a.query(url.Values{"latestOnly": {"true"}}), nil, statuses); err != nil {
+		return "", a.wrapError(err)
+	}
+
+	state := CheckSuccess
+	for _, status := range statuses.Value {
+		switch status.State {
+		case "succeeded", "notApplicable":
+		case "failed", "error":
+			return CheckFailure, nil
+		default:
+			state = CheckPending
+		}
+	}
+	return state, nil
+}
+
+func (a *azureDevOpsProvider) AddLabels(number int, labels []string) error {
+	for _, label := range labels {
+		_, err := a.client.do(http.MethodPost, a.gitPath("/pullrequests/"+strconv.Itoa(number)+"/labels"), a.query(nil), azureDevOpsLabel{Name: label}, nil)
+		if err != nil {
+			return a.wrapError(err)
+		}
+	}
+	return nil
+}
+
+func (a *azureDevOpsProvider) RemoveLabel(number int, label string) error {
+	_, err := a.client.do(http.MethodDelete, a.gitPath("/pullrequests/"+strconv.Itoa(number)+"/labels/"+url.PathEscape(label)), a.query(nil), nil, nil)
+	return a.wrapError(err)
+}
+
+func (a *azureDevOpsProvider) toIssue(workItem *azureDevOpsWorkItem) *Issue {
+	field := func(name string) string {
+		value, _ := workItem.Fields[name].(string)
+		return value
+	}
+	return &Issue{
+		Number: workItem.ID,
+		Title:  field("System.Title"),
+		Body:   field("System.Description"),
+		State:  field("System.State"),
+	}
+}
+
+func (a *azureDevOpsProvider) patchWorkItem(method, path string, operations []azureDevOpsPatchOperation) (*Issue, error) {
+	workItem := &azureDevOpsWorkItem{}
+	if _, err := a.client.doWithContentType(method, path, a.query(nil), azureDevOpsJsonPatchType, operations, workItem); err != nil {
+		return nil, a.wrapError(err)
+	}
+	return a.toIssue(workItem), nil
+}
+
+func (a *azureDevOpsProvider) GetIssue(number int) (*Issue, error) {
+	workItem := &azureDevOpsWorkItem{}
+	if _, err := a.client.do(http.MethodGet, "/wit/workitems/"+strconv.Itoa(number), a.query(nil), nil, workItem); err != nil {
+		return nil, a.wrapError(err)
+	}
+	return a.toIssue(workItem), nil
+}
+
+func (a *azureDevOpsProvider) CreateIssue(title, body string) (*Issue, error) {
+	return a.patchWorkItem(http.MethodPost, "/wit/workitems/$"+azureDevOpsWorkItemType, []azureDevOpsPatchOperation{
+		{Op: "add", Path: "/fields/System.Title", Value: title},
+		{Op: "add", Path: "/fields/System.Description", Value: body},
+	})
+}
+
+func (a *azureDevOpsProvider) UpdateIssue(number int, title, body string) error {
+	_, err := a.patchWorkItem(http.MethodPatch, "/wit/workitems/"+strconv.Itoa(number), []azureDevOpsPatchOperation{
+		{Op: "add", Path: "/fields/System.Title", Value: title},
+		{Op: "add", Path: "/fields/System.Description", Value: body},
+	})
+	return err
+}
+
+func (a *azureDevOpsProvider) CloseIssue(number int) error {
+	_, err := a.patchWorkItem(http.MethodPatch, "/wit/workitems/"+strconv.Itoa(number), []azureDevOpsPatchOperation{
+		{Op: "add", Path: "/fields/System.State", Value: azureDevOpsWorkItemDone},
+	})
+	return err
+}
+
+func (a *azureDevOpsProvider) MaxPullRequestBodySize() int {
+	return azureDevOpsMaxBodySize
+}
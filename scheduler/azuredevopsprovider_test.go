@@ -0,0 +1,175 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test azureDevOpsProvider against a fake Azure DevOps API
+func TestAzureDevOpsProvider(t *testing.T) {
+	var pushRequest azureDevOpsPush
+	var workItemRequest []azureDevOpsPatchOperation
+	var contentType string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/microsoft/kalypso/_apis/git/repositories/kalypso-gitops/refs", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, azureDevOpsAPIVersion, r.URL.Query().Get("api-version"))
+		// the filter is a prefix match
+		fmt.Fprint(w, `{"value":[{"name":"refs/heads/dev-old","objectId":"old"},{"name":"refs/heads/dev","objectId":"base"}]}`)
+	})
+	mux.HandleFunc("/microsoft/kalypso/_apis/git/repositories/kalypso-gitops/items", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"value":[{"objectId":"1","gitObjectType":"tree","path":"/drone"},{"objectId":"2","gitObjectType":"blob","path":"/drone/README.md"}]}`)
+	})
+	mux.HandleFunc("/microsoft/kalypso/_apis/git/repositories/kalypso-gitops/pushes", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &pushRequest)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"commits":[{"commitId":"new"}]}`)
+	})
+	mux.HandleFunc("/microsoft/kalypso/_apis/git/repositories/kalypso-gitops/pullrequests", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// a full page of the open PRs and the last one on the next page
+			first, last := 1, azureDevOpsPageSize
+			if r.URL.Query().Get("$skip") != "0" {
+				first, last = azureDevOpsPageSize+1, azureDevOpsPageSize+1
+			}
+			var prs []string
+			for i := first; i <= last; i++ {
+				prs = append(prs, fmt.Sprintf(`{"pullRequestId":%d,"status":"active","sourceRefName":"refs/heads/deployment/%d"}`, i, i))
+			}
+			fmt.Fprintf(w, `{"count":%d,"value":[%s]}`, len(prs), strings.Join(prs, ","))
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"message":"TF401179: An active pull request for the source and target branch already exists."}`)
+	})
+	mux.HandleFunc("/microsoft/kalypso/_apis/wit/workitems/$Issue", func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &workItemRequest)
+		fmt.Fprint(w, `{"id":7,"fields":{"System.Title":"title","System.State":"To Do"}}`)
+	})
+	mux.HandleFunc("/microsoft/kalypso/_apis/wit/workitems/8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	var completeRequest azureDevOpsPullRequest
+	mux.HandleFunc("/microsoft/kalypso/_apis/git/repositories/kalypso-gitops/pullrequests/5", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &completeRequest)
+			fmt.Fprint(w, `{"pullRequestId":5,"status":"completed","lastMergeCommit":{"commitId":"merged"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"pullRequestId":5,"status":"active","lastMergeSourceCommit":{"commitId":"head"}}`)
+	})
+
+	var removedLabel string
+	mux.HandleFunc("/microsoft/kalypso/_apis/git/repositories/kalypso-gitops/pullrequests/5/labels/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			removedLabel = strings.TrimPrefix(r.URL.Path, "/microsoft/kalypso/_apis/git/repositories/kalypso-gitops/pullrequests/5/labels/")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := newAzureDevOpsProvider(ctx, server.URL+"/microsoft/kalypso/_git/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+
+	branch, err := provider.GetBranch("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, &Branch{Name: "dev", SHA: "base"}, branch)
+
+	tree, err := provider.GetTree("base")
+	assert.NoError(t, err)
+	assert.Equal(t, []TreeEntry{{Path: "drone/README.md", SHA: "2"}}, tree)
+
+	branch, err = provider.CommitFiles(branch, &Commit{Message: "commit"}, []FileChange{
+		{Path: "drone/README.md", Content: "a", Action: FileUpdate},
+		{Path: "b.yaml", Action: FileDelete},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "new", branch.SHA)
+	assert.Equal(t, "base", pushRequest.RefUpdates[0].OldObjectID)
+	assert.Equal(t, "edit", pushRequest.Commits[0].Changes[0].ChangeType)
+	assert.Equal(t, "/drone/README.md", pushRequest.Commits[0].Changes[0].Item.Path)
+	assert.Equal(t, "delete", pushRequest.Commits[0].Changes[1].ChangeType)
+	assert.Nil(t, pushRequest.Commits[0].Changes[1].NewContent)
+	assert.Empty(t, pushRequest.Commits[0].Parents)
+
+	// the forced push replaces the current head with a commit on top of the given one
+	_, err = provider.CommitFiles(&Branch{Name: "dev", SHA: "parent"}, &Commit{Message: "commit", Force: true}, []FileChange{
+		{Path: "drone/README.md", Content: "a", Action: FileUpdate},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "base", pushRequest.RefUpdates[0].OldObjectID)
+	assert.Equal(t, []string{"parent"}, pushRequest.Commits[0].Parents)
+
+	_, err = provider.CreatePullRequest(&PullRequest{Title: "title", Head: "deployment/new", Base: "dev"})
+	assert.True(t, errors.Is(err, ErrPullRequestExists))
+
+	prs, err := provider.ListPullRequests("dev")
+	assert.NoError(t, err)
+	assert.Len(t, prs, azureDevOpsPageSize+1)
+	assert.Equal(t, "deployment/101", prs[azureDevOpsPageSize].Head)
+
+	issue, err := provider.CreateIssue("title", "body")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, issue.Number)
+	assert.Equal(t, azureDevOpsJsonPatchType, contentType)
+	assert.Equal(t, "/fields/System.Title", workItemRequest[0].Path)
+
+	_, err = provider.GetIssue(8)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	sha, err := provider.MergePullRequest(5, kalypsov1alpha1.RebaseMergeMethod)
+	assert.NoError(t, err)
+	assert.Equal(t, "merged", sha)
+	assert.Equal(t, "completed", completeRequest.Status)
+	assert.Equal(t, "head", completeRequest.LastMergeSourceCommit.CommitID)
+	assert.Equal(t, "rebase", completeRequest.CompletionOptions.MergeStrategy)
+
+	completeRequest = azureDevOpsPullRequest{}
+	err = provider.UpdatePullRequest(5, "new title", "new body")
+	assert.NoError(t, err)
+	assert.Equal(t, "new title", completeRequest.Title)
+	assert.Equal(t, "new body", completeRequest.Description)
+	assert.Empty(t, completeRequest.Status)
+
+	err = provider.DismissApprovals(5, "updated")
+	assert.True(t, errors.Is(err, ErrNotSupported))
+
+	err = provider.RemoveLabel(5, "promoted")
+	assert.NoError(t, err)
+	assert.Equal(t, "promoted", removedLabel)
+
+	_, err = newAzureDevOpsProvider(ctx, "https://dev.azure.com/microsoft/kalypso", server.Client())
+	assert.Error(t, err)
+}
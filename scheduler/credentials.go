@@ -0,0 +1,168 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// the keys of the credentials secret, they are the same as in the Flux git repository secrets
+const (
+	tokenSecretKey                   = "token"
+	usernameSecretKey                = "username"
+	passwordSecretKey                = "password"
+	identitySecretKey                = "identity"
+	knownHostsSecretKey              = "known_hosts"
+	githubAppIDSecretKey             = "githubAppID"
+	githubAppInstallationIDSecretKey = "githubAppInstallationID"
+	githubAppPrivateKeySecretKey     = "githubAppPrivateKey"
+)
+
+// Credentials to access a GitOps repo
+type Credentials struct {
+	// Token is a personal access token of the git hosting service
+	Token string
+	// Username and Password are used for the HTTPS git remotes
+	Username string
+	Password string
+	// SSHIdentity is the private key and SSHKnownHosts are the host keys for the SSH git remotes
+	SSHIdentity   []byte
+	SSHKnownHosts []byte
+	// GitHubApp authenticates as a GitHub App installation instead of a token
+	GitHubApp *GitHubAppCredentials
+}
+
+// GitHubAppCredentials identify a GitHub App installation
+type GitHubAppCredentials struct {
+	AppID          int64
+	InstallationID int64
+	PrivateKey     []byte
+}
+
+// key identifies the credentials for the host without revealing them
+func (c *Credentials) key(host string) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00", c.Token, c.Username, c.Password)
+	if c.GitHubApp != nil {
+		fmt.Fprintf(hash, "%d\x00%d\x00", c.GitHubApp.AppID, c.GitHubApp.InstallationID)
+	}
+	return fmt.Sprintf("%s/%x", host, hash.Sum(nil))
+}
+
+// NewCredentialsFromSecret reads the credentials from a secret with the Flux git repository secret keys
+func NewCredentialsFromSecret(secret *corev1.Secret) (*Credentials, error) {
+	credentials := &Credentials{
+		Token:         string(secret.Data[tokenSecretKey]),
+		Username:      string(secret.Data[usernameSecretKey]),
+		Password:      string(secret.Data[passwordSecretKey]),
+		SSHIdentity:   secret.Data[identitySecretKey],
+		SSHKnownHosts: secret.Data[knownHostsSecretKey],
+	}
+
+	appID, hasAppID := secret.Data[githubAppIDSecretKey]
+	installationID, hasInstallationID := secret.Data[githubAppInstallationIDSecretKey]
+	privateKey, hasPrivateKey := secret.Data[githubAppPrivateKeySecretKey]
+	if !hasAppID && !hasInstallationID && !hasPrivateKey {
+		return credentials, nil
+	}
+	if !hasAppID || !hasInstallationID || !hasPrivateKey {
+		return nil, fmt.Errorf("secret %s must contain %s, %s and %s", secret.Name,
+			githubAppIDSecretKey, githubAppInstallationIDSecretKey, githubAppPrivateKeySecretKey)
+	}
+
+	githubApp := &GitHubAppCredentials{PrivateKey: privateKey}
+	var err error
+	githubApp.AppID, err = strconv.ParseInt(string(appID), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in secret %s: %w", githubAppIDSecretKey, secret.Name, err)
+	}
+	githubApp.InstallationID, err = strconv.ParseInt(string(installationID), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in secret %s: %w", githubAppInstallationIDSecretKey, secret.Name, err)
+	}
+	credentials.GitHubApp = githubApp
+
+	return credentials, nil
+}
+
+// getEnvCredentials returns the default credentials of the scheduler from the environment variables
+func getEnvCredentials(providerType schedulerv1alpha1.GitProviderType) *Credentials {
+	credentials := &Credentials{
+		Username:      os.Getenv("GIT_USERNAME"),
+		Password:      os.Getenv("GIT_PASSWORD"),
+		SSHIdentity:   []byte(os.Getenv("GIT_SSH_IDENTITY")),
+		SSHKnownHosts: []byte(os.Getenv("GIT_SSH_KNOWN_HOSTS")),
+	}
+
+	switch providerType {
+	case schedulerv1alpha1.GitHubGitProvider:
+		credentials.Token = os.Getenv("GITHUB_AUTH_TOKEN")
+	case schedulerv1alpha1.GitLabGitProvider:
+		credentials.Token = os.Getenv("GITLAB_AUTH_TOKEN")
+	case schedulerv1alpha1.AzureDevOpsGitProvider:
+		credentials.Token = os.Getenv("AZURE_DEVOPS_AUTH_TOKEN")
+	}
+
+	return credentials
+}
+
+// the installation transport with the hash of the private key it signs the token requests with
+type githubAppTransport struct {
+	transport     *ghinstallation.Transport
+	privateKeySHA [sha256.Size]byte
+}
+
+var (
+	// the installation transports are shared between the reconciles, so the tokens are minted only when they expire
+	githubAppTransports     = map[string]*githubAppTransport{}
+	githubAppTransportsLock sync.Mutex
+)
+
+// getGitHubAppTransport returns a transport authenticating as the GitHub App installation.
+// It mints the installation tokens and refreshes them before they expire.
+// A rotated private key replaces the transport of the installation.
+func getGitHubAppTransport(githubApp *GitHubAppCredentials, apiURL string) (*ghinstallation.Transport, error) {
+	key := fmt.Sprintf("%s/%d/%d", apiURL, githubApp.AppID, githubApp.InstallationID)
+	privateKeySHA := sha256.Sum256(githubApp.PrivateKey)
+
+	githubAppTransportsLock.Lock()
+	defer githubAppTransportsLock.Unlock()
+
+	if cached, ok := githubAppTransports[key]; ok && cached.privateKeySHA == privateKeySHA {
+		return cached.transport, nil
+	}
+
+	transport, err := ghinstallation.New(http.DefaultTransport, githubApp.AppID, githubApp.InstallationID, githubApp.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if apiURL != "" {
+		transport.BaseURL = apiURL
+	}
+
+	githubAppTransports[key] = &githubAppTransport{transport: transport, privateKeySHA: privateKeySHA}
+	return transport, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestPrivateKey(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// Test NewCredentialsFromSecret
+func TestNewCredentialsFromSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "dev-repo-secret"},
+		Data: map[string][]byte{
+			"token":       []byte("pat"),
+			"identity":    []byte("private key"),
+			"known_hosts": []byte("github.com ssh-ed25519 key"),
+		},
+	}
+
+	credentials, err := NewCredentialsFromSecret(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "pat", credentials.Token)
+	assert.Equal(t, []byte("private key"), credentials.SSHIdentity)
+	assert.Equal(t, []byte("github.com ssh-ed25519 key"), credentials.SSHKnownHosts)
+	assert.Nil(t, credentials.GitHubApp)
+
+	secret.Data = map[string][]byte{
+		"githubAppID":             []byte("1"),
+		"githubAppInstallationID": []byte("2"),
+		"githubAppPrivateKey":     []byte("app key"),
+	}
+	credentials, err = NewCredentialsFromSecret(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, &GitHubAppCredentials{AppID: 1, InstallationID: 2, PrivateKey: []byte("app key")}, credentials.GitHubApp)
+
+	secret.Data["githubAppInstallationID"] = []byte("two")
+	_, err = NewCredentialsFromSecret(secret)
+	assert.Error(t, err)
+
+	delete(secret.Data, "githubAppInstallationID")
+	_, err = NewCredentialsFromSecret(secret)
+	assert.Error(t, err)
+}
+
+// Test the GitHub App installation tokens against a fake GitHub Enterprise API
+func TestGitHubAppCredentials(t *testing.T) {
+	tokenRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/app/installations/2/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Contains(t, r.Header.Get("Authorization"), "Bearer ")
+		fmt.Fprintf(w, `{"token":"installation-token","expires_at":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/ref/heads/dev", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token installation-token", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"ref":"refs/heads/dev","object":{"sha":"base"}}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	repoUrl := server.URL + "/microsoft/kalypso-gitops"
+	credentials := &Credentials{
+		GitHubApp: &GitHubAppCredentials{AppID: 1, InstallationID: 2, PrivateKey: newTestPrivateKey(t)},
+	}
+
+	// the token is minted once and reused by the next providers
+	for i := 0; i < 2; i++ {
+		httpClient, err := getGitHubHTTPClient(ctx, repoUrl, credentials)
+		assert.NoError(t, err)
+		provider, err := newGithubProvider(ctx, repoUrl, httpClient)
+		assert.NoError(t, err)
+		branch, err := provider.GetBranch("dev")
+		assert.NoError(t, err)
+		assert.Equal(t, "base", branch.SHA)
+	}
+	assert.Equal(t, 1, tokenRequests)
+
+	// plain git pushes with the installation token
+	auth, err := getGoGitAuth(ctx, repoUrl, credentials)
+	assert.NoError(t, err)
+	assert.Equal(t, &githttp.BasicAuth{Username: gitHubAppUsername, Password: "installation-token"}, auth)
+	assert.Equal(t, 1, tokenRequests)
+
+	// the rotated key replaces the transport of the installation, the new one mints its own token
+	apiURL := server.URL + "/api/v3"
+	transport, err := getGitHubAppTransport(credentials.GitHubApp, apiURL)
+	assert.NoError(t, err)
+	credentials.GitHubApp.PrivateKey = newTestPrivateKey(t)
+	rotated, err := getGitHubAppTransport(credentials.GitHubApp, apiURL)
+	assert.NoError(t, err)
+	assert.NotSame(t, transport, rotated)
+	_, err = getGoGitAuth(ctx, repoUrl, credentials)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, tokenRequests)
+	// the transport of the old key is not kept
+	assert.Same(t, rotated, githubAppTransports[apiURL+"/1/2"].transport)
+}
+
+// Test getGoGitAuth
+func TestGetGoGitAuth(t *testing.T) {
+	auth, err := getGoGitAuth(ctx, "https://git.contoso.com/kalypso-gitops", &Credentials{Token: "pat"})
+	assert.NoError(t, err)
+	assert.Equal(t, &githttp.BasicAuth{Username: defaultSSHUser, Password: "pat"}, auth)
+
+	auth, err = getGoGitAuth(ctx, "https://git.contoso.com/kalypso-gitops", &Credentials{Token: "pat", Username: "user", Password: "password"})
+	assert.NoError(t, err)
+	assert.Equal(t, &githttp.BasicAuth{Username: "user", Password: "password"}, auth)
+
+	auth, err = getGoGitAuth(ctx, "https://git.contoso.com/kalypso-gitops", &Credentials{})
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	_, err = getGoGitAuth(ctx, "ssh://git@git.contoso.com/kalypso-gitops", &Credentials{SSHIdentity: []byte("not a key")})
+	assert.Error(t, err)
+}
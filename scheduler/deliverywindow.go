@@ -0,0 +1,317 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// how far ahead the next window start is searched
+	maxScheduleSearchYears = 5
+	// how many blackouts and windows are skipped while searching for the next opening
+	maxOpeningSearchSteps = 1000
+)
+
+// DeliveryWindowState tells if the manifests can be delivered at the moment
+type DeliveryWindowState struct {
+	// Open is true if the delivery is allowed
+	Open bool
+	// Frozen is true during a blackout
+	Frozen bool
+	// Message explains why the delivery is held back
+	Message string
+	// NextOpening is the time the delivery is allowed again, it's zero if the delivery is open or it never opens
+	NextOpening time.Time
+}
+
+type DeliveryWindow interface {
+	// Check evaluates the windows and the blackouts at the current time of the clock
+	Check() DeliveryWindowState
+}
+
+// implements DeliveryWindow interface
+type deliveryWindow struct {
+	location  *time.Location
+	windows   []scheduledWindow
+	blackouts []schedulerv1alpha1.BlackoutSpec
+	clock     clock.PassiveClock
+}
+
+// validate deliveryWindow implements DeliveryWindow interface
+var _ DeliveryWindow = (*deliveryWindow)(nil)
+
+type scheduledWindow struct {
+	schedule *cronSchedule
+	duration time.Duration
+}
+
+// NewDeliveryWindow parses the delivery windows spec. A nil spec allows the delivery at any time.
+func NewDeliveryWindow(spec *schedulerv1alpha1.DeliveryWindowsSpec, clock clock.PassiveClock) (DeliveryWindow, error) {
+	d := &deliveryWindow{location: time.UTC, clock: clock}
+	if spec == nil {
+		return d, nil
+	}
+
+	if spec.TimeZone != "" {
+		location, err := time.LoadLocation(spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %s: %w", spec.TimeZone, err)
+		}
+		d.location = location
+	}
+
+	for _, window := range spec.Windows {
+		schedule, err := parseCronSchedule(window.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		if window.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("the window %s must have a positive duration", window.Schedule)
+		}
+		d.windows = append(d.windows, scheduledWindow{schedule: schedule, duration: window.Duration.Duration})
+	}
+
+	for _, blackout := range spec.Blackouts {
+		if !blackout.End.After(blackout.Start.Time) {
+			return nil, fmt.Errorf("the blackout starting at %s must end after it starts", blackout.Start.Format(time.RFC3339))
+		}
+	}
+	d.blackouts = spec.Blackouts
+
+	return d, nil
+}
+
+// implement Check function
+func (d *deliveryWindow) Check() DeliveryWindowState {
+	now := d.clock.Now().In(d.location)
+
+	blackout := d.findBlackout(now)
+	if blackout == nil && d.inWindow(now) {
+		return DeliveryWindowState{Open: true}
+	}
+
+	state := DeliveryWindowState{Frozen: blackout != nil, NextOpening: d.nextOpening(now)}
+	if blackout != nil {
+		state.Message = fmt.Sprintf("change freeze until %s", blackout.End.In(d.location).Format(time.RFC3339))
+		if blackout.Reason != "" {
+			state.Message += ": " + blackout.Reason
+		}
+	} else {
+		state.Message = "outside of the delivery windows"
+	}
+
+	if state.NextOpening.IsZero() {
+		state.Message += ", no delivery window opens anymore"
+	} else {
+		state.Message += fmt.Sprintf(", the delivery opens at %s", state.NextOpening.Format(time.RFC3339))
+	}
+	return state
+}
+
+// findBlackout returns the blackout the time is in
+func (d *deliveryWindow) findBlackout(t time.Time) *schedulerv1alpha1.BlackoutSpec {
+	for i, blackout := range d.blackouts {
+		if !t.Before(blackout.Start.Time) && t.Before(blackout.End.Time) {
+			return &d.blackouts[i]
+		}
+	}
+	return nil
+}
+
+// inWindow tells if a window is open at the time, without windows the delivery is always allowed
+func (d *deliveryWindow) inWindow(t time.Time) bool {
+	if len(d.windows) == 0 {
+		return true
+	}
+
+	for _, window := range d.windows {
+		// the window is open if it has started within its duration before the time
+		start, ok := window.schedule.next(t.Add(-window.duration))
+		if ok && !start.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOpening returns the first time after the blackouts that is inside a window
+func (d *deliveryWindow) nextOpening(t time.Time) time.Time {
+	for i := 0; i < maxOpeningSearchSteps; i++ {
+		if blackout := d.findBlackout(t); blackout != nil {
+			t = blackout.End.In(d.location)
+			continue
+		}
+		if d.inWindow(t) {
+			return t
+		}
+
+		var next time.Time
+		for _, window := range d.windows {
+			if start, ok := window.schedule.next(t); ok && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if next.IsZero() {
+			return time.Time{}
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// cronSchedule is a parsed cron expression with the minute, hour, day of month, month and day of week fields
+type cronSchedule struct {
+	minutes     cronField
+	hours       cronField
+	daysOfMonth cronField
+	months      cronField
+	daysOfWeek  cronField
+	// the restricted day fields are combined with OR, as in cron
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// cronField is a bit set of the allowed values
+type cronField uint64
+
+func (f cronField) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", expression, len(fields))
+	}
+
+	bounds := []struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := make([]cronField, len(fields))
+	for i, field := range fields {
+		value, err := parseCronField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expression, err)
+		}
+		parsed[i] = value
+	}
+
+	// both 0 and 7 stand for Sunday
+	if parsed[4].has(7) {
+		parsed[4] |= 1
+	}
+
+	return &cronSchedule{
+		minutes:       parsed[0],
+		hours:         parsed[1],
+		daysOfMonth:   parsed[2],
+		months:        parsed[3],
+		daysOfWeek:    parsed[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField parses a comma separated list of *, values and ranges with optional steps
+func parseCronField(field string, min, max int) (cronField, error) {
+	var result cronField
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			var err error
+			if start, err = strconv.Atoi(rangePart); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			// a single value with a step runs to the end of the range
+			end = start
+			if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			result |= 1 << uint(value)
+		}
+	}
+	return result, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.daysOfMonth.has(t.Day())
+	dayOfWeek := s.daysOfWeek.has(int(t.Weekday()))
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// next returns the first time after t that matches the schedule, in the location of t
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	location := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, location)
+	limit := t.AddDate(maxScheduleSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if !s.months.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !s.hours.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+			continue
+		}
+		if !s.minutes.has(t.Minute()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, location)
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
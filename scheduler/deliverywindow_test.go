@@ -0,0 +1,163 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// Test parseCronSchedule and the next start of a schedule
+func TestCronSchedule(t *testing.T) {
+	// Monday, May 1st 2023
+	monday := time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		schedule string
+		from     time.Time
+		next     time.Time
+	}{
+		{"* * * * *", monday, monday.Add(time.Minute)},
+		{"0 9 * * 1-4", monday, time.Date(2023, 5, 2, 9, 0, 0, 0, time.UTC)},
+		{"*/15 10 * * *", monday, time.Date(2023, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 22 * * 5,7", monday, time.Date(2023, 5, 5, 22, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", monday, time.Date(2023, 5, 7, 0, 0, 0, 0, time.UTC)},
+		{"30 6 1 */3 *", monday, time.Date(2023, 7, 1, 6, 30, 0, 0, time.UTC)},
+		// the restricted days of month and of week are combined with OR
+		{"0 12 15 * 3", monday, time.Date(2023, 5, 3, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", monday, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.schedule, func(t *testing.T) {
+			schedule, err := parseCronSchedule(test.schedule)
+			assert.NoError(t, err)
+			next, ok := schedule.next(test.from)
+			assert.True(t, ok)
+			assert.Equal(t, test.next, next)
+		})
+	}
+
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := parseCronSchedule(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	// the 31st of February never comes
+	schedule, err := parseCronSchedule("0 0 31 2 *")
+	assert.NoError(t, err)
+	_, ok := schedule.next(monday)
+	assert.False(t, ok)
+}
+
+// Test the delivery windows with a fake clock
+func TestDeliveryWindow(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+
+	// Monday, May 1st 2023, 8:00 in Berlin
+	clock := clocktesting.NewFakePassiveClock(time.Date(2023, 5, 1, 8, 0, 0, 0, berlin))
+	spec := &kalypsov1alpha1.DeliveryWindowsSpec{
+		TimeZone: "Europe/Berlin",
+		Windows: []kalypsov1alpha1.DeliveryWindowSpec{
+			{Schedule: "0 9 * * 1-4", Duration: metav1.Duration{Duration: 8 * time.Hour}},
+		},
+		Blackouts: []kalypsov1alpha1.BlackoutSpec{{
+			Start:  metav1.NewTime(time.Date(2023, 5, 2, 0, 0, 0, 0, berlin)),
+			End:    metav1.NewTime(time.Date(2023, 5, 4, 0, 0, 0, 0, berlin)),
+			Reason: "release",
+		}},
+	}
+
+	window, err := NewDeliveryWindow(spec, clock)
+	assert.NoError(t, err)
+
+	// before the window opens
+	state := window.Check()
+	assert.False(t, state.Open)
+	assert.False(t, state.Frozen)
+	assert.True(t, time.Date(2023, 5, 1, 9, 0, 0, 0, berlin).Equal(state.NextOpening))
+	assert.Equal(t, "outside of the delivery windows, the delivery opens at 2023-05-01T09:00:00+02:00", state.Message)
+
+	// inside the window
+	clock.SetTime(time.Date(2023, 5, 1, 16, 59, 0, 0, berlin))
+	state = window.Check()
+	assert.True(t, state.Open)
+	assert.Empty(t, state.Message)
+
+	// the window has closed, the next two days are frozen
+	clock.SetTime(time.Date(2023, 5, 1, 17, 0, 0, 0, berlin))
+	state = window.Check()
+	assert.False(t, state.Open)
+	assert.False(t, state.Frozen)
+	assert.True(t, time.Date(2023, 5, 4, 9, 0, 0, 0, berlin).Equal(state.NextOpening))
+
+	// during the change freeze, the delivery opens with the first window after it
+	clock.SetTime(time.Date(2023, 5, 2, 10, 0, 0, 0, berlin))
+	state = window.Check()
+	assert.False(t, state.Open)
+	assert.True(t, state.Frozen)
+	assert.True(t, time.Date(2023, 5, 4, 9, 0, 0, 0, berlin).Equal(state.NextOpening))
+	assert.Equal(t, "change freeze until 2023-05-04T00:00:00+02:00: release, the delivery opens at 2023-05-04T09:00:00+02:00", state.Message)
+
+	// the windows are evaluated in the time zone
+	clock.SetTime(time.Date(2023, 5, 4, 7, 0, 0, 0, time.UTC))
+	assert.True(t, window.Check().Open)
+}
+
+// Test the delivery windows without windows or without a spec
+func TestDeliveryWindowDefaults(t *testing.T) {
+	now := time.Date(2023, 12, 24, 12, 0, 0, 0, time.UTC)
+	clock := clocktesting.NewFakePassiveClock(now)
+
+	window, err := NewDeliveryWindow(nil, clock)
+	assert.NoError(t, err)
+	assert.True(t, window.Check().Open)
+
+	// only the blackouts hold the delivery back
+	spec := &kalypsov1alpha1.DeliveryWindowsSpec{
+		Blackouts: []kalypsov1alpha1.BlackoutSpec{{
+			Start: metav1.NewTime(time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC)),
+			End:   metav1.NewTime(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)),
+		}},
+	}
+	window, err = NewDeliveryWindow(spec, clock)
+	assert.NoError(t, err)
+	state := window.Check()
+	assert.True(t, state.Frozen)
+	assert.True(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC).Equal(state.NextOpening))
+
+	clock.SetTime(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	assert.True(t, window.Check().Open)
+
+	// invalid specs
+	_, err = NewDeliveryWindow(&kalypsov1alpha1.DeliveryWindowsSpec{TimeZone: "Mars/Olympus"}, clock)
+	assert.Error(t, err)
+	_, err = NewDeliveryWindow(&kalypsov1alpha1.DeliveryWindowsSpec{
+		Windows: []kalypsov1alpha1.DeliveryWindowSpec{{Schedule: "0 9 * * *"}},
+	}, clock)
+	assert.Error(t, err)
+	_, err = NewDeliveryWindow(&kalypsov1alpha1.DeliveryWindowsSpec{
+		Blackouts: []kalypsov1alpha1.BlackoutSpec{{Start: metav1.NewTime(now), End: metav1.NewTime(now)}},
+	}, clock)
+	assert.Error(t, err)
+}
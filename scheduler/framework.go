@@ -0,0 +1,567 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// MaxScore is the highest score a score plugin gives a cluster type
+	MaxScore int64 = 100
+
+	// DefaultScoreWeight is the weight of a score plugin without a default weight in the registry
+	DefaultScoreWeight int32 = 1
+)
+
+// Plugin is a plugin of the scheduling framework. Its name identifies it in the registry and in the scheduling policies.
+type Plugin interface {
+	Name() string
+}
+
+// SelectPlugin narrows down the deployment targets and the cluster types the scheduling policy works with.
+// The deployment targets and the cluster types have to be selected by all select plugins.
+type SelectPlugin interface {
+	Plugin
+	SelectDeploymentTarget(ctx context.Context, deploymentTarget *kalypsov1alpha1.DeploymentTarget) bool
+	SelectClusterType(ctx context.Context, clusterType *kalypsov1alpha1.ClusterType) bool
+}
+
+// FilterPlugin drops the selected cluster types a deployment target can't be scheduled on
+type FilterPlugin interface {
+	Plugin
+	// Filter returns nil or a successful status if the deployment target can be scheduled on the cluster type
+	Filter(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) *Status
+}
+
+// ScorePlugin ranks the cluster types that are left after the filters for a deployment target.
+// The scores of all score plugins are multiplied by their weights and added up, the higher the total the better.
+type ScorePlugin interface {
+	Plugin
+	// Score returns the score of the cluster type between 0 and MaxScore, unless the plugin normalizes its scores
+	Score(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) int64
+}
+
+// ScoreNormalizer is implemented by the score plugins that scale the scores they give the cluster types of
+// a deployment target to between 0 and MaxScore
+type ScoreNormalizer interface {
+	NormalizeScores(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, scores []int64)
+}
+
+// BindPlugin emits the assignment of a deployment target to a cluster type it is placed on
+type BindPlugin interface {
+	Plugin
+	Bind(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) (kalypsov1alpha1.Assignment, error)
+}
+
+// Code is the result code of a filter plugin
+type Code int
+
+const (
+	// Success means the deployment target can be scheduled on the cluster type
+	Success Code = iota
+	// Unschedulable means the deployment target can't be scheduled on the cluster type because of what is
+	// already scheduled on it
+	Unschedulable
+	// Ineligible means the deployment target excludes the cluster type by itself, so it isn't expected
+	// on it even with the all strategy
+	Ineligible
+)
+
+// Status is the result of a filter plugin. The reason completes a message like "3 cluster types <reason>",
+// e.g. "have insufficient cpu".
+type Status struct {
+	Code   Code
+	Reason string
+}
+
+// NewStatus creates a status with the code and the reason
+func NewStatus(code Code, reason string) *Status {
+	return &Status{Code: code, Reason: reason}
+}
+
+// IsSuccess checks if the status lets the deployment target be scheduled on the cluster type
+func (s *Status) IsSuccess() bool {
+	return s == nil || s.Code == Success
+}
+
+// clusterTypeLoad is what is already scheduled on a cluster type
+type clusterTypeLoad struct {
+	assignments       int
+	allocated         corev1.ResourceList
+	deploymentTargets []*kalypsov1alpha1.DeploymentTarget
+}
+
+// State is the state of a scheduling round shared by the plugins. It keeps track of what is scheduled on
+// the cluster types, so the deployment targets are placed one by one.
+type State struct {
+	SchedulingPolicy *kalypsov1alpha1.SchedulingPolicy
+
+	loads map[string]*clusterTypeLoad
+	// cluster types the deployment targets are currently assigned to by the scheduling policy
+	current map[string]map[string]bool
+}
+
+// newState creates the state of a scheduling round. The existing assignments of the other scheduling policies
+// load the cluster types with the resources of their deployment targets, the existing assignments of the policy
+// are remembered, so they are kept if they still pass the filters.
+func newState(schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, deploymentTargets []kalypsov1alpha1.DeploymentTarget, assignments []kalypsov1alpha1.Assignment) *State {
+	s := &State{
+		SchedulingPolicy: schedulingPolicy,
+		loads:            map[string]*clusterTypeLoad{},
+		current:          map[string]map[string]bool{},
+	}
+
+	targets := map[string]*kalypsov1alpha1.DeploymentTarget{}
+	for i := range deploymentTargets {
+		targets[deploymentTargets[i].Name] = &deploymentTargets[i]
+	}
+
+	for _, assignment := range assignments {
+		if assignment.Labels[kalypsov1alpha1.AssignmentSchedulingPolicyLabel] == schedulingPolicy.Name {
+			if s.current[assignment.Spec.DeploymentTarget] == nil {
+				s.current[assignment.Spec.DeploymentTarget] = map[string]bool{}
+			}
+			s.current[assignment.Spec.DeploymentTarget][assignment.Spec.ClusterType] = true
+			continue
+		}
+		s.allocate(assignment.Spec.ClusterType, targets[assignment.Spec.DeploymentTarget])
+	}
+
+	return s
+}
+
+// getLoad returns the load of the cluster type
+func (s *State) getLoad(clusterType string) *clusterTypeLoad {
+	load, ok := s.loads[clusterType]
+	if !ok {
+		load = &clusterTypeLoad{allocated: corev1.ResourceList{}}
+		s.loads[clusterType] = load
+	}
+	return load
+}
+
+// allocate adds the deployment target and its requested resources to the load of the cluster type
+func (s *State) allocate(clusterType string, deploymentTarget *kalypsov1alpha1.DeploymentTarget) {
+	load := s.getLoad(clusterType)
+	load.assignments++
+	if deploymentTarget == nil {
+		return
+	}
+	load.deploymentTargets = append(load.deploymentTargets, deploymentTarget)
+	for name, request := range deploymentTarget.Spec.Resources {
+		allocated := load.allocated[name]
+		allocated.Add(request)
+		load.allocated[name] = allocated
+	}
+}
+
+// GetAssignments returns the number of deployment targets scheduled on the cluster type
+func (s *State) GetAssignments(clusterType string) int {
+	return s.getLoad(clusterType).assignments
+}
+
+// GetAllocated returns the resources requested by the deployment targets scheduled on the cluster type
+func (s *State) GetAllocated(clusterType string) corev1.ResourceList {
+	return s.getLoad(clusterType).allocated
+}
+
+// GetDeploymentTargets returns the known deployment targets scheduled on the cluster type
+func (s *State) GetDeploymentTargets(clusterType string) []*kalypsov1alpha1.DeploymentTarget {
+	return s.getLoad(clusterType).deploymentTargets
+}
+
+// IsAssigned checks if the deployment target is currently assigned to the cluster type by the scheduling policy
+func (s *State) IsAssigned(deploymentTarget, clusterType string) bool {
+	return s.current[deploymentTarget][clusterType]
+}
+
+// PluginFactory creates a plugin for the scheduling policy, it returns nil if the plugin doesn't apply to the policy.
+// The reader reads the objects the plugin depends on, it is nil if the scheduler has no access to the cluster.
+type PluginFactory func(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error)
+
+type registration struct {
+	name    string
+	factory PluginFactory
+	weight  int32
+}
+
+// Registry holds the factories of the plugins by name. The plugins run in the order they are registered in.
+type Registry struct {
+	registrations []registration
+}
+
+// NewRegistry creates a registry with the built-in plugins
+func NewRegistry() *Registry {
+	r := &Registry{}
+	for _, p := range []struct {
+		name    string
+		factory PluginFactory
+		weight  int32
+	}{
+		{WorkspacePluginName, newWorkspacePlugin, 0},
+		{LabelsPluginName, newLabelsPlugin, 0},
+		{PlacementPluginName, newPlacementPlugin, 0},
+		{TaintsPluginName, newTaintsPlugin, 3},
+		{AffinityPluginName, newAffinityPlugin, 2},
+		{CapacityPluginName, newCapacityPlugin, 1},
+		{SpreadPluginName, newSpreadPlugin, 1},
+		{DefaultBinderName, newDefaultBinder, 0},
+	} {
+		r.registrations = append(r.registrations, registration{name: p.name, factory: p.factory, weight: p.weight})
+	}
+	return r
+}
+
+// Register adds a plugin to the registry, it runs after the plugins that are already registered
+func (r *Registry) Register(name string, factory PluginFactory) error {
+	if r.find(name) >= 0 {
+		return fmt.Errorf("plugin %s is already registered", name)
+	}
+	r.registrations = append(r.registrations, registration{name: name, factory: factory, weight: DefaultScoreWeight})
+	return nil
+}
+
+// Unregister removes a plugin from the registry, e.g. to replace the default binder
+func (r *Registry) Unregister(name string) error {
+	i := r.find(name)
+	if i < 0 {
+		return fmt.Errorf("plugin %s is not registered", name)
+	}
+	r.registrations = append(r.registrations[:i], r.registrations[i+1:]...)
+	return nil
+}
+
+// SetDefaultWeight sets the weight of a score plugin for the scheduling policies that don't override it
+func (r *Registry) SetDefaultWeight(name string, weight int32) error {
+	i := r.find(name)
+	if i < 0 {
+		return fmt.Errorf("plugin %s is not registered", name)
+	}
+	r.registrations[i].weight = weight
+	return nil
+}
+
+func (r *Registry) find(name string) int {
+	for i := range r.registrations {
+		if r.registrations[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+type weightedScorePlugin struct {
+	ScorePlugin
+	weight int64
+}
+
+// implements Scheduler interface with the plugins of a registry
+type framework struct {
+	schedulingPolicy *kalypsov1alpha1.SchedulingPolicy
+	selectPlugins    []SelectPlugin
+	filterPlugins    []FilterPlugin
+	scorePlugins     []weightedScorePlugin
+	bindPlugin       BindPlugin
+}
+
+// validate framework implements Scheduler interface
+var _ Scheduler = (*framework)(nil)
+
+// NewFramework creates the scheduler of the scheduling policy with the plugins of the registry.
+// The weights of the score plugins in the scheduling policy override the defaults of the registry.
+func NewFramework(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, registry *Registry, reader client.Reader) (Scheduler, error) {
+	if registry == nil {
+		registry = NewRegistry()
+	}
+
+	weights := map[string]int32{}
+	for _, r := range registry.registrations {
+		weights[r.name] = r.weight
+	}
+	overridden := map[string]bool{}
+	for _, scorePlugin := range schedulingPolicy.Spec.ScorePlugins {
+		if _, ok := weights[scorePlugin.Name]; !ok {
+			return nil, fmt.Errorf("unknown score plugin %s", scorePlugin.Name)
+		}
+		weights[scorePlugin.Name] = scorePlugin.Weight
+		overridden[scorePlugin.Name] = true
+	}
+
+	f := &framework{schedulingPolicy: schedulingPolicy}
+	for _, r := range registry.registrations {
+		plugin, err := r.factory(ctx, schedulingPolicy, reader)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", r.name, err)
+		}
+		if plugin == nil {
+			continue
+		}
+		if plugin.Name() != r.name {
+			return nil, fmt.Errorf("plugin %s is registered as %s", plugin.Name(), r.name)
+		}
+
+		if p, ok := plugin.(SelectPlugin); ok {
+			f.selectPlugins = append(f.selectPlugins, p)
+		}
+		if p, ok := plugin.(FilterPlugin); ok {
+			f.filterPlugins = append(f.filterPlugins, p)
+		}
+		if p, ok := plugin.(ScorePlugin); ok {
+			if weights[r.name] > 0 {
+				f.scorePlugins = append(f.scorePlugins, weightedScorePlugin{ScorePlugin: p, weight: int64(weights[r.name])})
+			}
+		} else if overridden[r.name] {
+			return nil, fmt.Errorf("plugin %s doesn't score cluster types", r.name)
+		}
+		if p, ok := plugin.(BindPlugin); ok {
+			if f.bindPlugin != nil {
+				return nil, fmt.Errorf("plugins %s and %s both bind, unregister one of them", f.bindPlugin.Name(), r.name)
+			}
+			f.bindPlugin = p
+		}
+	}
+
+	if f.bindPlugin == nil {
+		return nil, fmt.Errorf("no bind plugin is registered")
+	}
+
+	return f, nil
+}
+
+// Schedule schedules the deployment targets on cluster types. The existing assignments in the namespace
+// tell how loaded the cluster types are. It returns the new assignments of the scheduling policy and
+// the deployment targets that are not placed on as many cluster types as the strategy requires.
+func (f *framework) Schedule(ctx context.Context, clusterTypes []kalypsov1alpha1.ClusterType, deploymentTargets []kalypsov1alpha1.DeploymentTarget, existingAssignments []kalypsov1alpha1.Assignment) ([]kalypsov1alpha1.Assignment, []kalypsov1alpha1.UnplacedDeploymentTarget, error) {
+	var assignments []kalypsov1alpha1.Assignment
+	var unplaced []kalypsov1alpha1.UnplacedDeploymentTarget
+
+	selectedDeploymentTargets := f.selectDeploymentTargets(ctx, deploymentTargets)
+	selectedClusterTypes := f.selectClusterTypes(ctx, clusterTypes)
+
+	// place the deployment targets one by one in a stable order, so the result doesn't depend on the listing order.
+	// The ones that must be co-located with other deployment targets go last, so those are already placed.
+	sort.SliceStable(selectedDeploymentTargets, func(i, j int) bool {
+		affinityI := hasRequiredDeploymentTargetAffinity(&selectedDeploymentTargets[i])
+		affinityJ := hasRequiredDeploymentTargetAffinity(&selectedDeploymentTargets[j])
+		if affinityI != affinityJ {
+			return affinityJ
+		}
+		return selectedDeploymentTargets[i].Name < selectedDeploymentTargets[j].Name
+	})
+
+	state := newState(f.schedulingPolicy, deploymentTargets, existingAssignments)
+	for i := range selectedDeploymentTargets {
+		deploymentTarget := &selectedDeploymentTargets[i]
+		placed, message := f.place(ctx, state, selectedClusterTypes, deploymentTarget)
+		for j := range placed {
+			assignment, err := f.bindPlugin.Bind(ctx, state, deploymentTarget, &placed[j])
+			if err != nil {
+				return nil, nil, err
+			}
+			assignments = append(assignments, assignment)
+		}
+		if message != "" {
+			unplaced = append(unplaced, kalypsov1alpha1.UnplacedDeploymentTarget{
+				Name:     deploymentTarget.GetName(),
+				Workload: deploymentTarget.GetWorkload(),
+				Message:  message,
+			})
+		}
+	}
+
+	return assignments, unplaced, nil
+}
+
+// selectDeploymentTargets returns the deployment targets selected by all select plugins
+func (f *framework) selectDeploymentTargets(ctx context.Context, deploymentTargets []kalypsov1alpha1.DeploymentTarget) []kalypsov1alpha1.DeploymentTarget {
+	var selected []kalypsov1alpha1.DeploymentTarget
+	for i := range deploymentTargets {
+		if f.isDeploymentTargetSelected(ctx, &deploymentTargets[i]) {
+			selected = append(selected, deploymentTargets[i])
+		}
+	}
+	return selected
+}
+
+func (f *framework) isDeploymentTargetSelected(ctx context.Context, deploymentTarget *kalypsov1alpha1.DeploymentTarget) bool {
+	for _, p := range f.selectPlugins {
+		if !p.SelectDeploymentTarget(ctx, deploymentTarget) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectClusterTypes returns the cluster types selected by all select plugins sorted by name
+func (f *framework) selectClusterTypes(ctx context.Context, clusterTypes []kalypsov1alpha1.ClusterType) []kalypsov1alpha1.ClusterType {
+	var selected []kalypsov1alpha1.ClusterType
+	for i := range clusterTypes {
+		if f.isClusterTypeSelected(ctx, &clusterTypes[i]) {
+			selected = append(selected, clusterTypes[i])
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Name < selected[j].Name
+	})
+	return selected
+}
+
+func (f *framework) isClusterTypeSelected(ctx context.Context, clusterType *kalypsov1alpha1.ClusterType) bool {
+	for _, p := range f.selectPlugins {
+		if !p.SelectClusterType(ctx, clusterType) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter runs the filter plugins until one of them fails
+func (f *framework) filter(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) *Status {
+	for _, p := range f.filterPlugins {
+		if status := p.Filter(ctx, state, deploymentTarget, clusterType); !status.IsSuccess() {
+			return status
+		}
+	}
+	return nil
+}
+
+// sortByScore sorts the cluster types by the weighted sum of the scores of the score plugins,
+// the cheaper cluster types are preferred when the scores don't tell them apart
+func (f *framework) sortByScore(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterTypes []kalypsov1alpha1.ClusterType) {
+	totals := map[string]int64{}
+	for _, p := range f.scorePlugins {
+		scores := make([]int64, len(clusterTypes))
+		for i := range clusterTypes {
+			scores[i] = p.Score(ctx, state, deploymentTarget, &clusterTypes[i])
+		}
+		if normalizer, ok := p.ScorePlugin.(ScoreNormalizer); ok {
+			normalizer.NormalizeScores(ctx, state, deploymentTarget, scores)
+		}
+		for i := range clusterTypes {
+			totals[clusterTypes[i].Name] += p.weight * scores[i]
+		}
+	}
+
+	sort.SliceStable(clusterTypes, func(i, j int) bool {
+		totalI, totalJ := totals[clusterTypes[i].Name], totals[clusterTypes[j].Name]
+		if totalI != totalJ {
+			return totalI > totalJ
+		}
+		costI, costJ := getCost(clusterTypes[i]), getCost(clusterTypes[j])
+		return costI.Cmp(costJ) < 0
+	})
+}
+
+// place chooses the cluster types for the deployment target and allocates its resources on them.
+// It returns a message explaining why the deployment target is not placed on as many cluster types as required.
+func (f *framework) place(ctx context.Context, state *State, clusterTypes []kalypsov1alpha1.ClusterType, deploymentTarget *kalypsov1alpha1.DeploymentTarget) ([]kalypsov1alpha1.ClusterType, string) {
+	if len(clusterTypes) == 0 {
+		return nil, "no cluster types match the scheduling policy"
+	}
+
+	// the cluster types the deployment target is currently assigned to go first, so it doesn't move around
+	var current, other []kalypsov1alpha1.ClusterType
+	unschedulable := map[string]int{}
+	ineligible := 0
+	for i := range clusterTypes {
+		clusterType := &clusterTypes[i]
+		if status := f.filter(ctx, state, deploymentTarget, clusterType); !status.IsSuccess() {
+			if status.Code == Ineligible {
+				ineligible++
+			}
+			unschedulable[status.Reason]++
+			continue
+		}
+		if state.IsAssigned(deploymentTarget.Name, clusterType.Name) {
+			current = append(current, *clusterType)
+		} else {
+			other = append(other, *clusterType)
+		}
+	}
+	f.sortByScore(ctx, state, deploymentTarget, current)
+	f.sortByScore(ctx, state, deploymentTarget, other)
+
+	// with the all strategy the deployment target is expected on all cluster types it doesn't exclude by itself
+	strategy, required := GetSchedulingStrategy(f.schedulingPolicy)
+	if strategy == kalypsov1alpha1.AllSchedulingStrategy {
+		required = len(clusterTypes) - ineligible
+	}
+
+	placed := append(current, other...)
+	if len(placed) > required {
+		placed = placed[:required]
+	}
+	for i := range placed {
+		state.allocate(placed[i].Name, deploymentTarget)
+	}
+
+	if len(placed) == required && required > 0 {
+		return placed, ""
+	}
+
+	var reasons []string
+	for reason := range unschedulable {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for i, reason := range reasons {
+		reasons[i] = fmt.Sprintf("%d cluster types %s", unschedulable[reason], reason)
+	}
+	if len(clusterTypes) < required {
+		reasons = append(reasons, fmt.Sprintf("only %d cluster types match the scheduling policy", len(clusterTypes)))
+	}
+	if required == 0 {
+		return placed, fmt.Sprintf("not scheduled on any cluster types: %s", strings.Join(reasons, ", "))
+	}
+	return placed, fmt.Sprintf("scheduled on %d of %d cluster types: %s", len(placed), required, strings.Join(reasons, ", "))
+}
+
+// NormalizeScores scales the scores to between 0 and MaxScore, the highest score gets MaxScore and the lowest 0.
+// With reverse the lowest score gets MaxScore. The scores are all 0 if they are equal.
+func NormalizeScores(scores []int64, reverse bool) {
+	if len(scores) == 0 {
+		return
+	}
+	lowest, highest := scores[0], scores[0]
+	for _, score := range scores {
+		if score < lowest {
+			lowest = score
+		}
+		if score > highest {
+			highest = score
+		}
+	}
+	for i, score := range scores {
+		if highest == lowest {
+			scores[i] = 0
+			continue
+		}
+		if reverse {
+			scores[i] = (highest - score) * MaxScore / (highest - lowest)
+		} else {
+			scores[i] = (score - lowest) * MaxScore / (highest - lowest)
+		}
+	}
+}
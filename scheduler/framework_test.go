@@ -0,0 +1,195 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// regionPlugin keeps the deployment targets in their region and prefers the cluster types of a zone
+type regionPlugin struct {
+	zone string
+}
+
+func (p *regionPlugin) Name() string {
+	return "Region"
+}
+
+func (p *regionPlugin) Filter(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) *Status {
+	if region := deploymentTarget.Labels["region"]; region != "" && region != clusterType.Labels["region"] {
+		return NewStatus(Ineligible, "are out of the region")
+	}
+	return nil
+}
+
+func (p *regionPlugin) Score(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) int64 {
+	if clusterType.Labels["zone"] == p.zone {
+		return MaxScore
+	}
+	return 0
+}
+
+// labelBinder emits the assignments with an extra label
+type labelBinder struct{}
+
+func (p *labelBinder) Name() string {
+	return "LabelBinder"
+}
+
+func (p *labelBinder) Bind(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) (kalypsov1alpha1.Assignment, error) {
+	assignment := assign(deploymentTarget.Name, deploymentTarget.GetWorkload(), clusterType.Name, state.SchedulingPolicy.Name)
+	assignment.Labels["team"] = "platform"
+	return assignment, nil
+}
+
+func newRegionClusterType(name, region, zone string) kalypsov1alpha1.ClusterType {
+	return kalypsov1alpha1.ClusterType{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"region": region, "zone": zone}},
+	}
+}
+
+func newRegionRegistry(t *testing.T, zone string) *Registry {
+	registry := NewRegistry()
+	assert.NoError(t, registry.Register("Region", func(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+		return &regionPlugin{zone: zone}, nil
+	}))
+	return registry
+}
+
+func TestFrameworkCustomPlugins(t *testing.T) {
+	ctx := context.Background()
+	clusterTypes := []kalypsov1alpha1.ClusterType{
+		newRegionClusterType("east-a", "east", "a"),
+		newRegionClusterType("west-a", "west", "a"),
+		newRegionClusterType("west-b", "west", "b"),
+	}
+	deploymentTarget := newRequestingDeploymentTarget("web", "")
+	deploymentTarget.Labels["region"] = "west"
+	deploymentTargets := []kalypsov1alpha1.DeploymentTarget{deploymentTarget}
+
+	// the filter keeps the target in its region, the score prefers the zone
+	s, err := NewFramework(ctx, newStrategyPolicy(kalypsov1alpha1.SpreadSchedulingStrategy, 1), newRegionRegistry(t, "b"), nil)
+	assert.NoError(t, err)
+	assignments, _, err := s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"web": {"west-b"}}, getPlacement(assignments))
+
+	// the ineligible cluster types are not expected with the all strategy
+	s, err = NewFramework(ctx, newStrategyPolicy("", 0), newRegionRegistry(t, "b"), nil)
+	assert.NoError(t, err)
+	assignments, unplaced, err := s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"web": {"west-b", "west-a"}}, getPlacement(assignments))
+	assert.Empty(t, unplaced)
+
+	// the weights of the scheduling policy outweigh the preferred zone with the spread of the targets
+	policy := newStrategyPolicy(kalypsov1alpha1.SpreadSchedulingStrategy, 1)
+	policy.Spec.ScorePlugins = []kalypsov1alpha1.ScorePluginWeight{{Name: "Region", Weight: 1}, {Name: SpreadPluginName, Weight: 2}}
+	existing := []kalypsov1alpha1.Assignment{assign("x", "other", "west-b", "other-policy")}
+	s, err = NewFramework(ctx, policy, newRegionRegistry(t, "b"), nil)
+	assert.NoError(t, err)
+	assignments, _, err = s.Schedule(ctx, clusterTypes, deploymentTargets, existing)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"web": {"west-a"}}, getPlacement(assignments))
+
+	// a weight of 0 turns the score plugin off
+	policy.Spec.ScorePlugins = []kalypsov1alpha1.ScorePluginWeight{{Name: "Region", Weight: 0}}
+	s, err = NewFramework(ctx, policy, newRegionRegistry(t, "b"), nil)
+	assert.NoError(t, err)
+	assignments, _, err = s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"web": {"west-a"}}, getPlacement(assignments))
+}
+
+func TestFrameworkBinder(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry()
+	binderFactory := func(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+		return &labelBinder{}, nil
+	}
+	assert.NoError(t, registry.Register("LabelBinder", binderFactory))
+
+	// only one plugin binds
+	_, err := NewFramework(ctx, newStrategyPolicy("", 0), registry, nil)
+	assert.EqualError(t, err, "plugins DefaultBinder and LabelBinder both bind, unregister one of them")
+
+	assert.NoError(t, registry.Unregister(DefaultBinderName))
+	s, err := NewFramework(ctx, newStrategyPolicy("", 0), registry, nil)
+	assert.NoError(t, err)
+	assignments, _, err := s.Schedule(ctx, []kalypsov1alpha1.ClusterType{newRegionClusterType("east-a", "east", "a")},
+		[]kalypsov1alpha1.DeploymentTarget{newRequestingDeploymentTarget("web", "")}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, assignments, 1)
+	assert.Equal(t, "platform", assignments[0].Labels["team"])
+	assert.Equal(t, "policy", assignments[0].Labels[kalypsov1alpha1.AssignmentSchedulingPolicyLabel])
+
+	// a registry without a binder
+	assert.NoError(t, registry.Unregister("LabelBinder"))
+	_, err = NewFramework(ctx, newStrategyPolicy("", 0), registry, nil)
+	assert.EqualError(t, err, "no bind plugin is registered")
+}
+
+func TestFrameworkErrors(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry()
+	assert.EqualError(t, registry.Register(TaintsPluginName, newTaintsPlugin), "plugin Taints is already registered")
+	assert.EqualError(t, registry.Unregister("Region"), "plugin Region is not registered")
+	assert.EqualError(t, registry.SetDefaultWeight("Region", 1), "plugin Region is not registered")
+
+	// a plugin has to be registered under its name
+	assert.NoError(t, registry.Register("Zone", func(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+		return &regionPlugin{}, nil
+	}))
+	_, err := NewFramework(ctx, newStrategyPolicy("", 0), registry, nil)
+	assert.EqualError(t, err, "plugin Region is registered as Zone")
+
+	// the scheduling policy weighs score plugins only
+	policy := newStrategyPolicy("", 0)
+	policy.Spec.ScorePlugins = []kalypsov1alpha1.ScorePluginWeight{{Name: "Unknown", Weight: 1}}
+	_, err = NewFramework(ctx, policy, nil, nil)
+	assert.EqualError(t, err, "unknown score plugin Unknown")
+
+	policy.Spec.ScorePlugins = []kalypsov1alpha1.ScorePluginWeight{{Name: LabelsPluginName, Weight: 1}}
+	_, err = NewFramework(ctx, policy, nil, nil)
+	assert.EqualError(t, err, "plugin Labels doesn't score cluster types")
+
+	// the placement is read with a client
+	policy = newStrategyPolicy("", 0)
+	policy.Spec.ClusterTypeSelector.Placement = &kalypsov1alpha1.PlacementReference{Name: "edge"}
+	_, err = NewScheduler(policy)
+	assert.EqualError(t, err, "plugin Placement: placement edge can't be read without a client")
+}
+
+func TestNormalizeScores(t *testing.T) {
+	scores := []int64{-10, 0, 10}
+	NormalizeScores(scores, false)
+	assert.Equal(t, []int64{0, 50, 100}, scores)
+
+	scores = []int64{0, 1, 4}
+	NormalizeScores(scores, true)
+	assert.Equal(t, []int64{100, 75, 0}, scores)
+
+	scores = []int64{3, 3}
+	NormalizeScores(scores, false)
+	assert.Equal(t, []int64{0, 0}, scores)
+}
@@ -0,0 +1,493 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v49/github"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"golang.org/x/oauth2"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// the maximum length of a PR body on GitHub
+const githubMaxBodySize = 65536
+
+// implements GitProvider interface
+type githubProvider struct {
+	sourceOwner string
+	sourceRepo  string
+	client      *github.Client
+	ctx         context.Context
+	logger      logr.Logger
+}
+
+// validate githubProvider implements GitProvider interface
+var _ GitProvider = (*githubProvider)(nil)
+
+// getGitHubHTTPClient authenticates as the GitHub App installation if it is configured, otherwise with the token
+func getGitHubHTTPClient(ctx context.Context, repoUrl string, credentials *Credentials) (*http.Client, error) {
+	if credentials.GitHubApp != nil {
+		apiURL, err := getGitHubAPIURL(repoUrl)
+		if err != nil {
+			return nil, err
+		}
+		transport, err := getGitHubAppTransport(credentials.GitHubApp, apiURL)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: transport}, nil
+	}
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: credentials.Token})
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+// getGitHubAPIURL returns the REST API URL of GitHub Enterprise Server hosting the repo or an empty string for github.com
+func getGitHubAPIURL(repoUrl string) (string, error) {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.EqualFold(u.Hostname(), "github.com") {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%s://%s/api/v3", u.Scheme, u.Host), nil
+}
+
+// getGitHubClient creates a client for github.com or for GitHub Enterprise Server hosting the repo
+func getGitHubClient(repoUrl string, httpClient *http.Client) (*github.Client, error) {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(u.Hostname(), "github.com") {
+		return github.NewClient(httpClient), nil
+	}
+
+	baseURL := fmt.Sprintf("%s://%s/", u.Scheme, u.Host)
+	return github.NewEnterpriseClient(baseURL, baseURL, httpClient)
+}
+
+// new githubProvider function
+func newGithubProvider(ctx context.Context, repoUrl string, httpClient *http.Client) (*githubProvider, error) {
+	//parse url into owner and repo
+	sourceOwner, sourceRepo, err := parseRepoURL(repoUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := getGitHubClient(repoUrl, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &githubProvider{
+		sourceOwner: *sourceOwner,
+		sourceRepo:  *sourceRepo,
+		client:      client,
+		ctx:         ctx,
+		logger:      log.FromContext(ctx),
+	}, nil
+}
+
+// implement parse function
+func parseRepoURL(repoUrl string) (owner, repo *string, err error) {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	urlPart := strings.Split(u.Path, "/")
+	if len(urlPart) < 3 {
+		return nil, nil, errors.New("invalid repo url")
+
+	}
+
+	owner = &urlPart[1]
+	repoName := strings.TrimSuffix(urlPart[2], ".git")
+	repo = &repoName
+
+	return owner, repo, nil
+}
+
+// wrapError converts GitHub API errors into the GitProvider errors
+func (g *githubProvider) wrapError(resp *github.Response, err error) error {
+	if err == nil {
+		return nil
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, err.Error())
+	}
+	if strings.Contains(err.Error(), "A pull request already exists") {
+		return fmt.Errorf("%w: %s", ErrPullRequestExists, err.Error())
+	}
+
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return &RateLimitError{RetryAfter: time.Until(rateLimitErr.Rate.Reset.Time), Message: rateLimitErr.Message}
+	}
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseRateLimitErr) {
+		retryAfter := rateLimitMaxWait
+		if abuseRateLimitErr.RetryAfter != nil {
+			retryAfter = *abuseRateLimitErr.RetryAfter
+		}
+		return &RateLimitError{RetryAfter: retryAfter, Message: abuseRateLimitErr.Message}
+	}
+	return err
+}
+
+func (g *githubProvider) GetBranch(name string) (*Branch, error) {
+	ref, resp, err := g.client.Git.GetRef(g.ctx, g.sourceOwner, g.sourceRepo, "refs/heads/"+name)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+
+	return &Branch{Name: name, SHA: ref.GetObject().GetSHA()}, nil
+}
+
+func (g *githubProvider) CreateBranch(name string, sha string) (*Branch, error) {
+	newRef := &github.Reference{Ref: github.String("refs/heads/" + name), Object: &github.GitObject{SHA: github.String(sha)}}
+	ref, resp, err := g.client.Git.CreateRef(g.ctx, g.sourceOwner, g.sourceRepo, newRef)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+
+	return &Branch{Name: name, SHA: ref.GetObject().GetSHA()}, nil
+}
+
+func (g *githubProvider) DeleteBranch(name string) error {
+	resp, err := g.client.Git.DeleteRef(g.ctx, g.sourceOwner, g.sourceRepo, "heads/"+name)
+	return g.wrapError(resp, err)
+}
+
+func (g *githubProvider) GetTree(sha string) ([]TreeEntry, error) {
+	tree, resp, err := g.client.Git.GetTree(g.ctx, g.sourceOwner, g.sourceRepo, sha, true)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+
+	var entries []TreeEntry
+	for _, entry := range tree.Entries {
+		if entry.GetType() == "blob" {
+			entries = append(entries, TreeEntry{Path: entry.GetPath(), SHA: entry.GetSHA()})
+		}
+	}
+	return entries, nil
+}
+
+func (g *githubProvider) GetBlob(sha string) ([]byte, error) {
+	blob, resp, err := g.client.Git.GetBlobRaw(g.ctx, g.sourceOwner, g.sourceRepo, sha)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+	return blob, nil
+}
+
+// CommitFiles creates a tree on top of the branch head, commits it and moves the branch to the new commit
+func (g *githubProvider) CommitFiles(branch *Branch, commit *Commit, changes []FileChange) (*Branch, error) {
+	entries := []*github.TreeEntry{}
+	for _, change := range changes {
+		entry := &github.TreeEntry{
+			Path: github.String(change.Path),
+			Mode: github.String("100644"),
+		}
+		if change.Action != FileDelete {
+			entry.Type = github.String("blob")
+			entry.Content = github.String(change.Content)
+		}
+		entries = append(entries, entry)
+	}
+
+	tree, resp, err := g.client.Git.CreateTree(g.ctx, g.sourceOwner, g.sourceRepo, branch.SHA, entries)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+
+	author := &github.CommitAuthor{Name: github.String(commit.AuthorName), Email: github.String(commit.AuthorEmail)}
+	githubCommit := &github.Commit{
+		Author:  author,
+		Message: github.String(commit.Message),
+		Tree:    tree,
+		Parents: []*github.Commit{{SHA: github.String(branch.SHA)}},
+	}
+	if commit.Signer != nil {
+		// GitHub verifies the signature against the commit object it creates,
+		// so the author and the committer with their dates must be exactly the signed ones
+		now := time.Now().UTC().Truncate(time.Second)
+		author.Date = &now
+		githubCommit.Committer = author
+
+		signature, err := signCommit(commit.Signer, &object.Commit{
+			Author:       object.Signature{Name: commit.AuthorName, Email: commit.AuthorEmail, When: now},
+			Committer:    object.Signature{Name: commit.AuthorName, Email: commit.AuthorEmail, When: now},
+			Message:      commit.Message,
+			TreeHash:     plumbing.NewHash(tree.GetSHA()),
+			ParentHashes: []plumbing.Hash{plumbing.NewHash(branch.SHA)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		githubCommit.Verification = &github.SignatureVerification{Signature: github.String(signature)}
+	}
+
+	newCommit, resp, err := g.client.Git.CreateCommit(g.ctx, g.sourceOwner, g.sourceRepo, githubCommit)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+
+	// Attach the commit to the branch.
+	ref := &github.Reference{Ref: github.String("refs/heads/" + branch.Name), Object: &github.GitObject{SHA: newCommit.SHA}}
+	_, resp, err = g.client.Git.UpdateRef(g.ctx, g.sourceOwner, g.sourceRepo, ref, commit.Force)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+
+	return &Branch{Name: branch.Name, SHA: newCommit.GetSHA()}, nil
+}
+
+func (g *githubProvider) ListPullRequests(baseBranch string) ([]PullRequest, error) {
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		Base:        baseBranch,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	var pullRequests []PullRequest
+	for {
+		prs, resp, err := g.client.PullRequests.List(g.ctx, g.sourceOwner, g.sourceRepo, opts)
+		if err != nil {
+			return nil, g.wrapError(resp, err)
+		}
+		for _, pr := range prs {
+			pullRequests = append(pullRequests, *g.toPullRequest(pr))
+		}
+		if resp.NextPage == 0 {
+			return pullRequests, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (g *githubProvider) toPullRequest(pr *github.PullRequest) *PullRequest {
+	var labels []string
+	for _, label := range pr.Labels {
+		labels = append(labels, label.GetName())
+	}
+	state := PullRequestState(pr.GetState())
+	var mergeSHA string
+	if pr.GetMerged() {
+		state = PullRequestMerged
+		mergeSHA = pr.GetMergeCommitSHA()
+	}
+	return &PullRequest{
+		Number:   pr.GetNumber(),
+		Title:    pr.GetTitle(),
+		Body:     pr.GetBody(),
+		Head:     pr.GetHead().GetRef(),
+		Base:     pr.GetBase().GetRef(),
+		URL:      pr.GetHTMLURL(),
+		Labels:   labels,
+		State:    state,
+		HeadSHA:  pr.GetHead().GetSHA(),
+		MergeSHA: mergeSHA,
+	}
+}
+
+func (g *githubProvider) GetPullRequest(number int) (*PullRequest, error) {
+	pr, resp, err := g.client.PullRequests.Get(g.ctx, g.sourceOwner, g.sourceRepo, number)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+	return g.toPullRequest(pr), nil
+}
+
+func (g *githubProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	newPR := &github.NewPullRequest{
+		Title:               github.String(pr.Title),
+		Head:                github.String(pr.Head),
+		Base:                github.String(pr.Base),
+		Body:                github.String(pr.Body),
+		MaintainerCanModify: github.Bool(true),
+	}
+
+	created, resp, err := g.client.PullRequests.Create(g.ctx, g.sourceOwner, g.sourceRepo, newPR)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+
+	// GitHub doesn't accept labels on PR creation
+	if len(pr.Labels) > 0 {
+		if err := g.AddLabels(created.GetNumber(), pr.Labels); err != nil {
+			return nil, err
+		}
+	}
+
+	result := g.toPullRequest(created)
+	result.Labels = pr.Labels
+	return result, nil
+}
+
+func (g *githubProvider) UpdatePullRequest(number int, title, body string) error {
+	_, resp, err := g.client.PullRequests.Edit(g.ctx, g.sourceOwner, g.sourceRepo, number, &github.PullRequest{
+		Title: github.String(title),
+		Body:  github.String(body),
+	})
+	return g.wrapError(resp, err)
+}
+
+func (g *githubProvider) ClosePullRequest(number int) error {
+	_, resp, err := g.client.PullRequests.Edit(g.ctx, g.sourceOwner, g.sourceRepo, number, &github.PullRequest{
+		State: github.String("closed"),
+	})
+	return g.wrapError(resp, err)
+}
+
+// DismissApprovals dismisses the approving reviews of the PR
+func (g *githubProvider) DismissApprovals(number int, message string) error {
+	reviews, resp, err := g.client.PullRequests.ListReviews(g.ctx, g.sourceOwner, g.sourceRepo, number, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return g.wrapError(resp, err)
+	}
+
+	for _, review := range reviews {
+		if review.GetState() != "APPROVED" {
+			continue
+		}
+		_, resp, err := g.client.PullRequests.DismissReview(g.ctx, g.sourceOwner, g.sourceRepo, number, review.GetID(), &github.PullRequestReviewDismissalRequest{
+			Message: github.String(message),
+		})
+		if err != nil {
+			return g.wrapError(resp, err)
+		}
+	}
+	return nil
+}
+
+// MergePullRequest merges the PR and returns the merge commit
+func (g *githubProvider) MergePullRequest(number int, method schedulerv1alpha1.MergeMethod) (string, error) {
+	result, resp, err := g.client.PullRequests.Merge(g.ctx, g.sourceOwner, g.sourceRepo, number, "", &github.PullRequestOptions{
+		MergeMethod: string(method),
+	})
+	if err != nil {
+		// 405 - not mergeable, 409 - head branch was modified
+		if resp != nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusConflict) {
+			return "", fmt.Errorf("%w: %s", ErrMergeBlocked, err.Error())
+		}
+		return "", g.wrapError(resp, err)
+	}
+	return result.GetSHA(), nil
+}
+
+// GetCheckState combines the commit statuses and the check runs of the commit
+func (g *githubProvider) GetCheckState(sha string) (CheckState, error) {
+	status, resp, err := g.client.Repositories.GetCombinedStatus(g.ctx, g.sourceOwner, g.sourceRepo, sha, nil)
+	if err != nil {
+		return "", g.wrapError(resp, err)
+	}
+
+	state := CheckSuccess
+	// the combined state is pending when there are no statuses at all
+	if status.GetTotalCount() > 0 {
+		switch status.GetState() {
+		case "pending":
+			state = CheckPending
+		case "failure", "error":
+			return CheckFailure, nil
+		}
+	}
+
+	checkRuns, resp, err := g.client.Checks.ListCheckRunsForRef(g.ctx, g.sourceOwner, g.sourceRepo, sha, nil)
+	if err != nil {
+		return "", g.wrapError(resp, err)
+	}
+
+	for _, checkRun := range checkRuns.CheckRuns {
+		if checkRun.GetStatus() != "completed" {
+			state = CheckPending
+			continue
+		}
+		switch checkRun.GetConclusion() {
+		case "success", "neutral", "skipped":
+		default:
+			return CheckFailure, nil
+		}
+	}
+
+	return state, nil
+}
+
+func (g *githubProvider) AddLabels(number int, labels []string) error {
+	_, resp, err := g.client.Issues.AddLabelsToIssue(g.ctx, g.sourceOwner, g.sourceRepo, number, labels)
+	return g.wrapError(resp, err)
+}
+
+func (g *githubProvider) RemoveLabel(number int, label string) error {
+	resp, err := g.client.Issues.RemoveLabelForIssue(g.ctx, g.sourceOwner, g.sourceRepo, number, label)
+	return g.wrapError(resp, err)
+}
+
+func (g *githubProvider) GetIssue(number int) (*Issue, error) {
+	issue, resp, err := g.client.Issues.Get(g.ctx, g.sourceOwner, g.sourceRepo, number)
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+	return &Issue{Number: issue.GetNumber(), Title: issue.GetTitle(), Body: issue.GetBody(), State: issue.GetState()}, nil
+}
+
+func (g *githubProvider) CreateIssue(title, body string) (*Issue, error) {
+	issue, resp, err := g.client.Issues.Create(g.ctx, g.sourceOwner, g.sourceRepo, &github.IssueRequest{
+		Title: github.String(title),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return nil, g.wrapError(resp, err)
+	}
+	return &Issue{Number: issue.GetNumber(), Title: issue.GetTitle(), Body: issue.GetBody(), State: issue.GetState()}, nil
+}
+
+func (g *githubProvider) UpdateIssue(number int, title, body string) error {
+	_, resp, err := g.client.Issues.Edit(g.ctx, g.sourceOwner, g.sourceRepo, number, &github.IssueRequest{
+		Title: github.String(title),
+		Body:  github.String(body),
+	})
+	return g.wrapError(resp, err)
+}
+
+func (g *githubProvider) CloseIssue(number int) error {
+	_, resp, err := g.client.Issues.Edit(g.ctx, g.sourceOwner, g.sourceRepo, number, &github.IssueRequest{
+		State: github.String("closed"),
+	})
+	return g.wrapError(resp, err)
+}
+
+func (g *githubProvider) MaxPullRequestBodySize() int {
+	return githubMaxBodySize
+}
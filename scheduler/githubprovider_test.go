@@ -0,0 +1,214 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test parseRepoURL
+func TestParseRepoURL(t *testing.T) {
+	owner, repo, err := parseRepoURL("https://github.com/microsoft/kalypso-gitops.git")
+	assert.NoError(t, err)
+	assert.Equal(t, "microsoft", *owner)
+	assert.Equal(t, "kalypso-gitops", *repo)
+
+	_, _, err = parseRepoURL("https://github.com/microsoft")
+	assert.Error(t, err)
+}
+
+// Test githubProvider against a fake GitHub Enterprise API
+func TestGithubProvider(t *testing.T) {
+	var commitRequest, refRequest map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/ref/heads/dev", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ref":"refs/heads/dev","object":{"sha":"base"}}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/ref/heads/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"Not Found"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/trees", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sha":"tree"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/commits", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &commitRequest)
+		fmt.Fprint(w, `{"sha":"new"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/refs/heads/dev", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &refRequest)
+		fmt.Fprint(w, `{"ref":"refs/heads/dev","object":{"sha":"new"}}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/pulls", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// the open PRs take two pages
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s?page=2>; rel="next"`, r.URL.Path))
+				fmt.Fprint(w, `[{"number":1,"state":"open","head":{"ref":"deployment/1"}}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"number":2,"state":"open","head":{"ref":"deployment/2"}}]`)
+			return
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprint(w, `{"message":"Validation Failed","errors":[{"message":"A pull request already exists for microsoft:dev."}]}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := newGithubProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+
+	branch, err := provider.GetBranch("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, &Branch{Name: "dev", SHA: "base"}, branch)
+
+	_, err = provider.GetBranch("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	branch, err = provider.CommitFiles(branch, &Commit{Message: "commit", AuthorName: "name", AuthorEmail: "email"},
+		[]FileChange{{Path: "a.yaml", Content: "a", Action: FileCreate}})
+	assert.NoError(t, err)
+	assert.Equal(t, "new", branch.SHA)
+	assert.Equal(t, "commit", commitRequest["message"])
+	assert.Equal(t, []interface{}{"base"}, commitRequest["parents"])
+	assert.Equal(t, "new", refRequest["sha"])
+	assert.Equal(t, false, refRequest["force"])
+
+	_, err = provider.CommitFiles(branch, &Commit{Message: "commit", Force: true}, []FileChange{{Path: "a.yaml", Content: "a", Action: FileCreate}})
+	assert.NoError(t, err)
+	assert.Equal(t, true, refRequest["force"])
+
+	_, err = provider.CreatePullRequest(&PullRequest{Title: "title", Head: "deployment/new", Base: "dev"})
+	assert.True(t, errors.Is(err, ErrPullRequestExists))
+
+	prs, err := provider.ListPullRequests("dev")
+	assert.NoError(t, err)
+	assert.Len(t, prs, 2)
+	assert.Equal(t, "deployment/2", prs[1].Head)
+}
+
+// Test githubProvider updates the open PR and dismisses its approvals
+func TestGithubProviderUpdatePullRequest(t *testing.T) {
+	var editRequest map[string]interface{}
+	var dismissed []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &editRequest)
+		fmt.Fprint(w, `{"number":1,"state":"open"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/pulls/1/reviews", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id":10,"state":"APPROVED"},{"id":11,"state":"COMMENTED"},{"id":12,"state":"APPROVED"}]`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/pulls/1/reviews/", func(w http.ResponseWriter, r *http.Request) {
+		dismissed = append(dismissed, r.URL.Path)
+		fmt.Fprint(w, `{"state":"DISMISSED"}`)
+	})
+	var removedLabel bool
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/issues/1/labels/promoted", func(w http.ResponseWriter, r *http.Request) {
+		removedLabel = r.Method == http.MethodDelete
+		fmt.Fprint(w, `[]`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := newGithubProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+
+	err = provider.UpdatePullRequest(1, "title", "body")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"title": "title", "body": "body"}, editRequest)
+
+	// only the approvals are dismissed
+	err = provider.DismissApprovals(1, "updated")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/api/v3/repos/microsoft/kalypso-gitops/pulls/1/reviews/10/dismissals",
+		"/api/v3/repos/microsoft/kalypso-gitops/pulls/1/reviews/12/dismissals",
+	}, dismissed)
+
+	err = provider.RemoveLabel(1, "promoted")
+	assert.NoError(t, err)
+	assert.True(t, removedLabel)
+}
+
+// Test githubProvider auto-merge operations
+func TestGithubProviderMerge(t *testing.T) {
+	var mergeRequest map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"number":1,"state":"closed","merged":true,"merge_commit_sha":"merged","head":{"ref":"deployment/new","sha":"head"},"labels":[{"name":"promoted"}]}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/pulls/2/merge", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &mergeRequest)
+		fmt.Fprint(w, `{"sha":"squashed","merged":true}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/pulls/3/merge", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"message":"Pull Request is not mergeable"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/commits/head/status", func(w http.ResponseWriter, r *http.Request) {
+		// no commit statuses at all
+		fmt.Fprint(w, `{"state":"pending","total_count":0}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/commits/head/check-runs", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"total_count":2,"check_runs":[{"status":"completed","conclusion":"success"},{"status":"in_progress"}]}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := newGithubProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+
+	pr, err := provider.GetPullRequest(1)
+	assert.NoError(t, err)
+	assert.Equal(t, PullRequestMerged, pr.State)
+	assert.Equal(t, "merged", pr.MergeSHA)
+	assert.Equal(t, "head", pr.HeadSHA)
+	assert.Equal(t, []string{"promoted"}, pr.Labels)
+
+	sha, err := provider.MergePullRequest(2, kalypsov1alpha1.SquashMergeMethod)
+	assert.NoError(t, err)
+	assert.Equal(t, "squashed", sha)
+	assert.Equal(t, "squash", mergeRequest["merge_method"])
+
+	_, err = provider.MergePullRequest(3, kalypsov1alpha1.MergeMergeMethod)
+	assert.True(t, errors.Is(err, ErrMergeBlocked))
+
+	state, err := provider.GetCheckState("head")
+	assert.NoError(t, err)
+	assert.Equal(t, CheckPending, state)
+}
@@ -17,113 +17,90 @@ limitations under the License.
 package scheduler
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
-	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
-)
-
-var (
-	ctx        = context.Background()
-	gitOpsRepo = &kalypsov1alpha1.GitOpsRepoSpec{
-		ManifestsSpec: kalypsov1alpha1.ManifestsSpec{
-			Repo:   "https://github.com/microsoft/kalypso-gitops",
-			Path:   ".",
-			Branch: "dev",
-		},
-	}
-	reconcilerManifestsFile = "./testdata/reconciler-manifests.yaml"
-	namespaceManifestsFile  = "./testdata/namespace-manifests.yaml"
+	"github.com/stretchr/testify/assert"
 )
 
 // Test NewGithubRepo
 func TestNewGithubRepo(t *testing.T) {
 
-	githubRepo, err := NewGithubRepo(ctx,
-		gitOpsRepo)
+	githubRepo, err := NewGitRepo(ctx,
+		gitOpsRepo, nil, nil)
 	if err != nil {
 		t.Errorf("error creating github repo: %v", err)
 	}
 	if githubRepo == nil {
 		t.Errorf("github repo is nil")
+		return
 	}
 
+	// a github.com repo is delivered through the GitHub API
+	assert.IsType(t, &githubProvider{}, githubRepo.(*gitRepo).provider)
 }
 
-// Test CreatePR
-func TestCreatePR(t *testing.T) {
-	githubRepo, err := NewGithubRepo(ctx,
-		gitOpsRepo)
-	if err != nil {
-		t.Errorf("error creating github repo: %v", err)
-	}
-	if githubRepo == nil {
-		t.Errorf("github repo is nil")
-	}
-
-	fmt.Println("reconcilerManifests")
-	reconcilerManifests := getManifestsYamlString(t, reconcilerManifestsFile)
-	fmt.Println(reconcilerManifests)
-
-	fmt.Println("namespaceManifests")
-	namespaceManifests := getManifestsYamlString(t, namespaceManifestsFile)
-	fmt.Println(namespaceManifests)
-
-	//Initialize the package
-	assignmentPackageSpec := &kalypsov1alpha1.AssignmentPackageSpec{
-		ReconcilerManifests: reconcilerManifests,
-		NamespaceManifests:  namespaceManifests,
-	}
-
-	repoContentType := kalypsov1alpha1.NewRepoContentType()
-	repoContentType.ClusterTypes["drone"] = *kalypsov1alpha1.NewClusterContentType()
-	repoContentType.ClusterTypes["drone"].DeploymentTargets["hello-world-app-functional-test"] = *assignmentPackageSpec
-
-	// _, err = githubRepo.CreatePR("unit-test", repoContentType)
-	// if err != nil {
-	// 	t.Errorf("can't create PR: %v", err)
-	// }
-
-}
-
-func getManifestsYamlString(t *testing.T, filename string) []string {
-	// Read the file
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		t.Errorf("could not read the file: %v", err)
-	}
-
-	return []string{string(data)}
-}
-
-// test update issue
-func TestUpdateIssue(t *testing.T) {
-	githubRepo, err := NewGithubRepo(ctx,
-		gitOpsRepo)
-	if err != nil {
-		t.Errorf("error creating github repo: %v", err)
-	}
-	if githubRepo == nil {
-		t.Errorf("github repo is nil")
-	}
+// test update issue against a fake GitHub API
+func TestGithubRepoUpdateIssue(t *testing.T) {
+	issue := map[string]interface{}{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/issues", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &issue)
+		issue["number"] = 7
+		issue["state"] = "open"
+		_ = json.NewEncoder(w).Encode(issue)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/issues/7", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &issue)
+		}
+		_ = json.NewEncoder(w).Encode(issue)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/issues/8", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"Not Found"}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := newGithubProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+	githubRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
 
 	message := "test update issue"
 
 	issueNo, err := githubRepo.UpdateIssue(nil, "unit-test", &message)
 	if err != nil {
 		t.Errorf("can't update issue: %v", err)
+		return
 	}
+	assert.Equal(t, 7, *issueNo)
+	assert.Equal(t, "unit-test", issue["title"])
 
 	issueNo, err = githubRepo.UpdateIssue(issueNo, "unit-test2", &message)
 	if err != nil {
 		t.Errorf("can't update issue: %v", err)
 	}
+	assert.Equal(t, "unit-test2", issue["title"])
+	assert.Equal(t, message, issue["body"])
 
 	_, err = githubRepo.UpdateIssue(issueNo, "unit-test2", nil)
 	if err != nil {
 		t.Errorf("can't update issue: %v", err)
 	}
+	assert.Equal(t, "closed", issue["state"])
 
+	// closing an issue that is gone is not an error
+	missing := 8
+	_, err = githubRepo.UpdateIssue(&missing, "unit-test2", nil)
+	assert.NoError(t, err)
 }
@@ -0,0 +1,421 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"golang.org/x/oauth2"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	gitlabPageSize = 100
+	// the maximum length of a merge request description
+	gitlabMaxBodySize = 1048576
+)
+
+// implements GitProvider interface on top of the GitLab REST API v4
+type gitlabProvider struct {
+	projectPath string
+	client      *restClient
+	ctx         context.Context
+	logger      logr.Logger
+}
+
+// validate gitlabProvider implements GitProvider interface
+var _ GitProvider = (*gitlabProvider)(nil)
+
+type gitlabBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+type gitlabTreeEntry struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type gitlabCommitAction struct {
+	Action   string `json:"action"`
+	FilePath string `json:"file_path"`
+	Content  string `json:"content,omitempty"`
+}
+
+type gitlabCommit struct {
+	ID string `json:"id"`
+}
+
+type gitlabMergeRequest struct {
+	IID             int      `json:"iid"`
+	Title           string   `json:"title"`
+	Description     string   `json:"description"`
+	SourceBranch    string   `json:"source_branch"`
+	TargetBranch    string   `json:"target_branch"`
+	WebURL          string   `json:"web_url"`
+	Labels          []string `json:"labels"`
+	State           string   `json:"state"`
+	SHA             string   `json:"sha"`
+	MergeCommitSHA  string   `json:"merge_commit_sha"`
+	SquashCommitSHA string   `json:"squash_commit_sha"`
+}
+
+type gitlabCommitStatus struct {
+	Status string `json:"status"`
+}
+
+type gitlabIssue struct {
+	IID         int    `json:"iid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+}
+
+func getGitLabHTTPClient(ctx context.Context, token string) *http.Client {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	return oauth2.NewClient(ctx, ts)
+}
+
+// new gitlabProvider function
+func newGitlabProvider(ctx context.Context, repoUrl string, httpClient *http.Client) (*gitlabProvider, error) {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// GitLab projects may be nested in subgroups, so the whole path is the project id
+	projectPath := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if !strings.Contains(projectPath, "/") {
+		return nil, errors.New("invalid repo url")
+	}
+
+	return &gitlabProvider{
+		projectPath: projectPath,
+		client: &restClient{
+			baseURL:    fmt.Sprintf("%s://%s/api/v4/projects/%s", u.Scheme, u.Host, url.PathEscape(projectPath)),
+			httpClient: httpClient,
+			ctx:        ctx,
+		},
+		ctx:    ctx,
+		logger: log.FromContext(ctx),
+	}, nil
+}
+
+// wrapError converts GitLab API errors into the GitProvider errors
+func (g *gitlabProvider) wrapError(err error) error {
+	var restErr *restError
+	if errors.As(err, &restErr) && restErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, err.Error())
+	}
+	return err
+}
+
+func (g *gitlabProvider) GetBranch(name string) (*Branch, error) {
+	branch := &gitlabBranch{}
+	if _, err := g.client.do(http.MethodGet, "/repository/branches/"+url.PathEscape(name), nil, nil, branch); err != nil {
+		return nil, g.wrapError(err)
+	}
+	return &Branch{Name: branch.Name, SHA: branch.Commit.ID}, nil
+}
+
+func (g *gitlabProvider) CreateBranch(name string, sha string) (*Branch, error) {
+	branch := &gitlabBranch{}
+	body := map[string]string{"branch": name, "ref": sha}
+	if _, err := g.client.do(http.MethodPost, "/repository/branches", nil, body, branch); err != nil {
+		return nil, g.wrapError(err)
+	}
+	return &Branch{Name: branch.Name, SHA: branch.Commit.ID}, nil
+}
+
+func (g *gitlabProvider) DeleteBranch(name string) error {
+	_, err := g.client.do(http.MethodDelete, "/repository/branches/"+url.PathEscape(name), nil, nil, nil)
+	return g.wrapError(err)
+}
+
+func (g *gitlabProvider) GetTree(sha string) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	page := "1"
+	for page != "" {
+		query := url.Values{
+			"ref":       {sha},
+			"recursive": {"true"},
+			"per_page":  {strconv.Itoa(gitlabPageSize)},
+			"page":      {page},
+		}
+		var gitlabEntries []gitlabTreeEntry
+		resp, err := g.client.do(http.MethodGet, "/repository/tree", query, nil, &gitlabEntries)
+		if err != nil {
+			return nil, g.wrapError(err)
+		}
+		for _, entry := range gitlabEntries {
+			if entry.Type == "blob" {
+				entries = append(entries, TreeEntry{Path: entry.Path, SHA: entry.ID})
+			}
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return entries, nil
+}
+
+func (g *gitlabProvider) GetBlob(sha string) ([]byte, error) {
+	var blob []byte
+	if _, err := g.client.do(http.MethodGet, "/repository/blobs/"+sha+"/raw", nil, nil, &blob); err != nil {
+		return nil, g.wrapError(err)
+	}
+	return blob, nil
+}
+
+// CommitFiles commits the changes to the branch with the GitLab commits API
+func (g *gitlabProvider) CommitFiles(branch *Branch, commit *Commit, changes []FileChange) (*Branch, error) {
+	// the commits API signs the commits with the GitLab key, if at all
+	if commit.Signer != nil {
+		return nil, fmt.Errorf("%w: signed commits in GitLab", ErrNotSupported)
+	}
+
+	actions := []gitlabCommitAction{}
+	for _, change := range changes {
+		action := gitlabCommitAction{
+			Action:   string(change.Action),
+			FilePath: change.Path,
+		}
+		if change.Action != FileDelete {
+			action.Content = change.Content
+		}
+		actions = append(actions, action)
+	}
+
+	body := map[string]interface{}{
+		"branch":         branch.Name,
+		"commit_message": commit.Message,
+		"author_name":    commit.AuthorName,
+		"author_email":   commit.AuthorEmail,
+		"actions":        actions,
+	}
+	if commit.Force {
+		// the commit is created on top of start_sha and the branch is overwritten with it
+		body["start_sha"] = branch.SHA
+		body["force"] = true
+	}
+	newCommit := &gitlabCommit{}
+	if _, err := g.client.do(http.MethodPost, "/repository/commits", nil, body, newCommit); err != nil {
+		return nil, g.wrapError(err)
+	}
+
+	return &Branch{Name: branch.Name, SHA: newCommit.ID}, nil
+}
+
+func (g *gitlabProvider) toPullRequest(mr *gitlabMergeRequest) *PullRequest {
+	// opened, closed, locked or merged
+	state := PullRequestOpen
+	switch mr.State {
+	case "closed":
+		state = PullRequestClosed
+	case "merged":
+		state = PullRequestMerged
+	}
+
+	mergeSHA := mr.MergeCommitSHA
+	if mergeSHA == "" {
+		mergeSHA = mr.SquashCommitSHA
+	}
+
+	return &PullRequest{
+		Number:   mr.IID,
+		Title:    mr.Title,
+		Body:     mr.Description,
+		Head:     mr.SourceBranch,
+		Base:     mr.TargetBranch,
+		URL:      mr.WebURL,
+		Labels:   mr.Labels,
+		State:    state,
+		HeadSHA:  mr.SHA,
+		MergeSHA: mergeSHA,
+	}
+}
+
+func (g *gitlabProvider) GetPullRequest(number int) (*PullRequest, error) {
+	mr := &gitlabMergeRequest{}
+	if _, err := g.client.do(http.MethodGet, "/merge_requests/"+strconv.Itoa(number), nil, nil, mr); err != nil {
+		return nil, g.wrapError(err)
+	}
+	return g.toPullRequest(mr), nil
+}
+
+func (g *gitlabProvider) ListPullRequests(baseBranch string) ([]PullRequest, error) {
+	var pullRequests []PullRequest
+	page := "1"
+	for page != "" {
+		query := url.Values{
+			"state":         {"opened"},
+			"target_branch": {baseBranch},
+			"per_page":      {strconv.Itoa(gitlabPageSize)},
+			"page":          {page},
+		}
+		var mrs []gitlabMergeRequest
+		resp, err := g.client.do(http.MethodGet, "/merge_requests", query, nil, &mrs)
+		if err != nil {
+			return nil, g.wrapError(err)
+		}
+		for i := range mrs {
+			pullRequests = append(pullRequests, *g.toPullRequest(&mrs[i]))
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return pullRequests, nil
+}
+
+func (g *gitlabProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	body := map[string]string{
+		"source_branch": pr.Head,
+		"target_branch": pr.Base,
+		"title":         pr.Title,
+		"description":   pr.Body,
+	}
+	if len(pr.Labels) > 0 {
+		body["labels"] = strings.Join(pr.Labels, ",")
+	}
+
+	mr := &gitlabMergeRequest{}
+	if _, err := g.client.do(http.MethodPost, "/merge_requests", nil, body, mr); err != nil {
+		var restErr *restError
+		if errors.As(err, &restErr) && restErr.StatusCode == http.StatusConflict {
+			return nil, fmt.Errorf("%w: %s", ErrPullRequestExists, err.Error())
+		}
+		return nil, g.wrapError(err)
+	}
+	return g.toPullRequest(mr), nil
+}
+
+func (g *gitlabProvider) UpdatePullRequest(number int, title, body string) error {
+	request := map[string]string{"title": title, "description": body}
+	_, err := g.client.do(http.MethodPut, "/merge_requests/"+strconv.Itoa(number), nil, request, nil)
+	return g.wrapError(err)
+}
+
+func (g *gitlabProvider) ClosePullRequest(number int) error {
+	body := map[string]string{"state_event": "close"}
+	_, err := g.client.do(http.MethodPut, "/merge_requests/"+strconv.Itoa(number), nil, body, nil)
+	return g.wrapError(err)
+}
+
+// DismissApprovals resets the approvals of the merge request, it requires the bot or the owner role in the project
+func (g *gitlabProvider) DismissApprovals(number int, message string) error {
+	_, err := g.client.do(http.MethodPut, "/merge_requests/"+strconv.Itoa(number)+"/reset_approvals", nil, nil, nil)
+	return g.wrapError(err)
+}
+
+// MergePullRequest accepts the merge request and returns the merge or squash commit
+func (g *gitlabProvider) MergePullRequest(number int, method schedulerv1alpha1.MergeMethod) (string, error) {
+	// rebasing is asynchronous in GitLab, it can't be done in one call with the merge
+	if method == schedulerv1alpha1.RebaseMergeMethod {
+		return "", fmt.Errorf("%w: %s merge method in GitLab", ErrNotSupported, method)
+	}
+
+	body := map[string]interface{}{"squash": method == schedulerv1alpha1.SquashMergeMethod}
+	mr := &gitlabMergeRequest{}
+	if _, err := g.client.do(http.MethodPut, "/merge_requests/"+strconv.Itoa(number)+"/merge", nil, body, mr); err != nil {
+		var restErr *restError
+		// 405 - can't be merged, 406 - has conflicts, 409 - sha doesn't match, 422 - branch can't be merged
+		if errors.As(err, &restErr) && (restErr.StatusCode == http.StatusMethodNotAllowed || restErr.StatusCode == http.StatusNotAcceptable ||
+			restErr.StatusCode == http.StatusConflict || restErr.StatusCode == http.StatusUnprocessableEntity) {
+			return "", fmt.Errorf("%w: %s", ErrMergeBlocked, err.Error())
+		}
+		return "", g.wrapError(err)
+	}
+	return g.toPullRequest(mr).MergeSHA, nil
+}
+
+// GetCheckState combines the statuses of the commit pipelines and external checks
+func (g *gitlabProvider) GetCheckState(sha string) (CheckState, error) {
+	var statuses []gitlabCommitStatus
+	query := url.Values{"per_page": {strconv.Itoa(gitlabPageSize)}}
+	if _, err := g.client.do(http.MethodGet, "/repository/commits/"+sha+"/statuses", query, nil, &statuses); err != nil {
+		return "", g.wrapError(err)
+	}
+
+	state := CheckSuccess
+	for _, status := range statuses {
+		switch status.Status {
+		case "success", "skipped", "manual":
+		case "failed", "canceled":
+			return CheckFailure, nil
+		default:
+			state = CheckPending
+		}
+	}
+	return state, nil
+}
+
+func (g *gitlabProvider) AddLabels(number int, labels []string) error {
+	body := map[string]string{"add_labels": strings.Join(labels, ",")}
+	_, err := g.client.do(http.MethodPut, "/merge_requests/"+strconv.Itoa(number), nil, body, nil)
+	return g.wrapError(err)
+}
+
+func (g *gitlabProvider) RemoveLabel(number int, label string) error {
+	body := map[string]string{"remove_labels": label}
+	_, err := g.client.do(http.MethodPut, "/merge_requests/"+strconv.Itoa(number), nil, body, nil)
+	return g.wrapError(err)
+}
+
+func (g *gitlabProvider) toIssue(issue *gitlabIssue) *Issue {
+	return &Issue{Number: issue.IID, Title: issue.Title, Body: issue.Description, State: issue.State}
+}
+
+func (g *gitlabProvider) GetIssue(number int) (*Issue, error) {
+	issue := &gitlabIssue{}
+	if _, err := g.client.do(http.MethodGet, "/issues/"+strconv.Itoa(number), nil, nil, issue); err != nil {
+		return nil, g.wrapError(err)
+	}
+	return g.toIssue(issue), nil
+}
+
+func (g *gitlabProvider) CreateIssue(title, body string) (*Issue, error) {
+	issue := &gitlabIssue{}
+	request := map[string]string{"title": title, "description": body}
+	if _, err := g.client.do(http.MethodPost, "/issues", nil, request, issue); err != nil {
+		return nil, g.wrapError(err)
+	}
+	return g.toIssue(issue), nil
+}
+
+func (g *gitlabProvider) UpdateIssue(number int, title, body string) error {
+	request := map[string]string{"title": title, "description": body}
+	_, err := g.client.do(http.MethodPut, "/issues/"+strconv.Itoa(number), nil, request, nil)
+	return g.wrapError(err)
+}
+
+func (g *gitlabProvider) CloseIssue(number int) error {
+	request := map[string]string{"state_event": "close"}
+	_, err := g.client.do(http.MethodPut, "/issues/"+strconv.Itoa(number), nil, request, nil)
+	return g.wrapError(err)
+}
+
+func (g *gitlabProvider) MaxPullRequestBodySize() int {
+	return gitlabMaxBodySize
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test gitlabProvider against a fake GitLab API
+func TestGitlabProvider(t *testing.T) {
+	var commitRequest, updateRequest map[string]interface{}
+	var approvalsReset bool
+
+	mux := http.NewServeMux()
+	// the project path with subgroups is url encoded
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/repository/branches/dev", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name":"dev","commit":{"id":"base"}}`)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/repository/branches/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/repository/tree", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprint(w, `[{"id":"1","type":"tree","path":"drone"},{"id":"2","type":"blob","path":"drone/README.md"}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"id":"3","type":"blob","path":"README.md"}]`)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/repository/commits", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &commitRequest)
+		fmt.Fprint(w, `{"id":"new"}`)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/merge_requests", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			if r.URL.Query().Get("page") == "1" {
+				w.Header().Set("X-Next-Page", "2")
+				fmt.Fprint(w, `[{"iid":1,"state":"opened","source_branch":"deployment/1"}]`)
+				return
+			}
+			fmt.Fprint(w, `[{"iid":2,"state":"opened","source_branch":"deployment/2"}]`)
+			return
+		}
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"message":["Another open merge request already exists for this source branch"]}`)
+	})
+
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/merge_requests/1", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &updateRequest)
+		fmt.Fprint(w, `{"iid":1,"state":"opened"}`)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/merge_requests/1/reset_approvals", func(w http.ResponseWriter, r *http.Request) {
+		approvalsReset = r.Method == http.MethodPut
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/merge_requests/1/merge", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"iid":1,"state":"merged","sha":"head","squash_commit_sha":"squashed"}`)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fplatform%2Fkalypso-gitops/repository/commits/head/statuses", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"status":"success"},{"status":"failed"}]`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := newGitlabProvider(ctx, server.URL+"/microsoft/platform/kalypso-gitops.git", server.Client())
+	assert.NoError(t, err)
+
+	branch, err := provider.GetBranch("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, &Branch{Name: "dev", SHA: "base"}, branch)
+
+	_, err = provider.GetBranch("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	tree, err := provider.GetTree("base")
+	assert.NoError(t, err)
+	assert.Equal(t, []TreeEntry{{Path: "drone/README.md", SHA: "2"}, {Path: "README.md", SHA: "3"}}, tree)
+
+	branch, err = provider.CommitFiles(branch, &Commit{Message: "commit"}, []FileChange{
+		{Path: "a.yaml", Content: "a", Action: FileCreate},
+		{Path: "b.yaml", Action: FileDelete},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "new", branch.SHA)
+	assert.Equal(t, "dev", commitRequest["branch"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"action": "create", "file_path": "a.yaml", "content": "a"},
+		map[string]interface{}{"action": "delete", "file_path": "b.yaml"},
+	}, commitRequest["actions"])
+	assert.NotContains(t, commitRequest, "force")
+
+	// the forced commit overwrites the branch with a commit on top of the given one
+	_, err = provider.CommitFiles(&Branch{Name: "deployment/dev", SHA: "base"}, &Commit{Message: "commit", Force: true}, []FileChange{
+		{Path: "a.yaml", Content: "a", Action: FileCreate},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "deployment/dev", commitRequest["branch"])
+	assert.Equal(t, "base", commitRequest["start_sha"])
+	assert.Equal(t, true, commitRequest["force"])
+
+	_, err = provider.CreatePullRequest(&PullRequest{Title: "title", Head: "deployment/new", Base: "dev"})
+	assert.True(t, errors.Is(err, ErrPullRequestExists))
+
+	prs, err := provider.ListPullRequests("dev")
+	assert.NoError(t, err)
+	assert.Len(t, prs, 2)
+	assert.Equal(t, "deployment/2", prs[1].Head)
+
+	err = provider.UpdatePullRequest(1, "new title", "new body")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"title": "new title", "description": "new body"}, updateRequest)
+
+	updateRequest = nil
+	err = provider.RemoveLabel(1, "promoted")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"remove_labels": "promoted"}, updateRequest)
+
+	err = provider.DismissApprovals(1, "updated")
+	assert.NoError(t, err)
+	assert.True(t, approvalsReset)
+
+	sha, err := provider.MergePullRequest(1, kalypsov1alpha1.SquashMergeMethod)
+	assert.NoError(t, err)
+	assert.Equal(t, "squashed", sha)
+
+	_, err = provider.MergePullRequest(1, kalypsov1alpha1.RebaseMergeMethod)
+	assert.True(t, errors.Is(err, ErrNotSupported))
+
+	state, err := provider.GetCheckState("head")
+	assert.NoError(t, err)
+	assert.Equal(t, CheckFailure, state)
+
+	_, err = newGitlabProvider(ctx, "https://gitlab.com/microsoft", server.Client())
+	assert.Error(t, err)
+}
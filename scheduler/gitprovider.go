@@ -0,0 +1,244 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+)
+
+var (
+	// ErrNotFound is returned by a GitProvider when the requested object doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrPullRequestExists is returned by a GitProvider when there is already an open PR for the branch
+	ErrPullRequestExists = errors.New("a pull request already exists")
+	// ErrNotSupported is returned by a GitProvider when the operation is not available, e.g. PRs on a plain git server
+	ErrNotSupported = errors.New("not supported by the git provider")
+	// ErrNoChanges is returned when the content is already in the branch, so there is nothing to commit
+	ErrNoChanges = errors.New("no changes")
+	// ErrMergeBlocked is returned by a GitProvider when the hosting service refuses to merge a PR
+	ErrMergeBlocked = errors.New("the pull request can't be merged")
+	// ErrRateLimited is wrapped by RateLimitError when the hosting service throttles the requests
+	ErrRateLimited = errors.New("rate limited by the git provider")
+)
+
+// GitProvider is a set of primitive operations on a git hosting service (GitHub, GitLab, Azure DevOps)
+// the scheduler needs to deliver the manifests to a GitOps repository
+type GitProvider interface {
+	GetBranch(name string) (*Branch, error)
+	CreateBranch(name string, sha string) (*Branch, error)
+	DeleteBranch(name string) error
+	GetTree(sha string) ([]TreeEntry, error)
+	GetBlob(sha string) ([]byte, error)
+	CommitFiles(branch *Branch, commit *Commit, changes []FileChange) (*Branch, error)
+	ListPullRequests(baseBranch string) ([]PullRequest, error)
+	GetPullRequest(number int) (*PullRequest, error)
+	CreatePullRequest(pr *PullRequest) (*PullRequest, error)
+	// UpdatePullRequest changes the title and the description of the PR
+	UpdatePullRequest(number int, title, body string) error
+	ClosePullRequest(number int) error
+	// DismissApprovals withdraws the approvals of the PR, so it has to be reviewed again
+	DismissApprovals(number int, message string) error
+	MergePullRequest(number int, method schedulerv1alpha1.MergeMethod) (string, error)
+	GetCheckState(sha string) (CheckState, error)
+	AddLabels(number int, labels []string) error
+	// RemoveLabel takes the label off the PR
+	RemoveLabel(number int, label string) error
+	GetIssue(number int) (*Issue, error)
+	CreateIssue(title, body string) (*Issue, error)
+	UpdateIssue(number int, title, body string) error
+	CloseIssue(number int) error
+	// MaxPullRequestBodySize is the longest PR description the hosting service accepts in characters
+	MaxPullRequestBodySize() int
+}
+
+// Branch is a git branch pointing to a commit
+type Branch struct {
+	Name string
+	SHA  string
+}
+
+// TreeEntry is a file (blob) in a git tree
+type TreeEntry struct {
+	Path string
+	SHA  string
+}
+
+type FileAction string
+
+const (
+	FileCreate FileAction = "create"
+	FileUpdate FileAction = "update"
+	FileDelete FileAction = "delete"
+)
+
+// FileChange is a change of a single file in a commit
+type FileChange struct {
+	Path    string
+	Content string
+	Action  FileAction
+}
+
+// Commit describes a commit to be created by a GitProvider
+type Commit struct {
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	// Signer signs the commit, the commit is not signed if it's nil
+	Signer Signer
+	// Force creates the commit on top of the branch SHA and moves the branch to it
+	// even if the branch has moved since, dropping its other commits
+	Force bool
+}
+
+type PullRequestState string
+
+const (
+	PullRequestOpen   PullRequestState = "open"
+	PullRequestClosed PullRequestState = "closed"
+	PullRequestMerged PullRequestState = "merged"
+)
+
+// PullRequest is a GitHub pull request, a GitLab merge request or an Azure DevOps pull request
+type PullRequest struct {
+	Number int
+	Title  string
+	Body   string
+	Head   string
+	Base   string
+	URL    string
+	Labels []string
+	State  PullRequestState
+	// the commit at the head branch
+	HeadSHA string
+	// the commit the PR was merged with
+	MergeSHA string
+}
+
+// CheckState is the combined state of the status checks of a commit
+type CheckState string
+
+const (
+	CheckPending CheckState = "pending"
+	CheckSuccess CheckState = "success"
+	CheckFailure CheckState = "failure"
+)
+
+// Issue is a GitHub or GitLab issue or an Azure DevOps work item
+type Issue struct {
+	Number int
+	Title  string
+	Body   string
+	State  string
+}
+
+// NewGitProvider creates a GitProvider for the GitOps repo.
+// If the credentials are nil, it uses the default credentials of the scheduler from the environment variables.
+func NewGitProvider(ctx context.Context, repo *schedulerv1alpha1.GitOpsRepoSpec, credentials *Credentials) (GitProvider, error) {
+	// direct push works with any git remote, so it doesn't need a hosting API
+	if repo.Delivery == schedulerv1alpha1.DirectPushDelivery {
+		if credentials == nil {
+			credentials = getEnvCredentials(repo.Provider)
+		}
+		auth, err := getGoGitAuth(ctx, repo.Repo, credentials)
+		if err != nil {
+			return nil, err
+		}
+		return newGoGitProvider(ctx, repo.Repo, auth)
+	}
+
+	providerType, err := getGitProviderType(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if credentials == nil {
+		credentials = getEnvCredentials(providerType)
+	}
+
+	u, err := url.Parse(repo.Repo)
+	if err != nil {
+		return nil, err
+	}
+	// the repos of the same host accessed with the same credentials share the request budget
+	credentialsKey := credentials.key(string(providerType) + "/" + u.Host)
+
+	switch providerType {
+	case schedulerv1alpha1.GitHubGitProvider:
+		httpClient, err := getGitHubHTTPClient(ctx, repo.Repo, credentials)
+		if err != nil {
+			return nil, err
+		}
+		return newGithubProvider(ctx, repo.Repo, withRateLimit(httpClient, credentialsKey))
+	case schedulerv1alpha1.GitLabGitProvider:
+		return newGitlabProvider(ctx, repo.Repo, withRateLimit(getGitLabHTTPClient(ctx, credentials.Token), credentialsKey))
+	case schedulerv1alpha1.AzureDevOpsGitProvider:
+		return newAzureDevOpsProvider(ctx, repo.Repo, withRateLimit(getAzureDevOpsHTTPClient(credentials.Token), credentialsKey))
+	}
+
+	return nil, fmt.Errorf("unsupported git provider %s", providerType)
+}
+
+// getGitProviderType returns the provider set in the spec or detects it from the repo URL host
+// CheckCommitSigning tells if the scheduler can sign the commits it delivers to the repo. The GitLab and Azure DevOps
+// APIs create the commits on the server, so only their direct push delivery carries the signature of the scheduler.
+func CheckCommitSigning(repo *schedulerv1alpha1.GitOpsRepoSpec) error {
+	if repo.Commit == nil || repo.Commit.SigningKey == nil ||
+		repo.Delivery == schedulerv1alpha1.DirectPushDelivery || repo.Delivery == schedulerv1alpha1.OCIDelivery {
+		return nil
+	}
+
+	providerType, err := getGitProviderType(repo)
+	if err != nil {
+		return err
+	}
+	switch providerType {
+	case schedulerv1alpha1.GitLabGitProvider, schedulerv1alpha1.AzureDevOpsGitProvider:
+		return fmt.Errorf("%w: signed commits in %s with the %s delivery, use the %s delivery to sign the commits",
+			ErrNotSupported, providerType, schedulerv1alpha1.PullRequestDelivery, schedulerv1alpha1.DirectPushDelivery)
+	}
+	return nil
+}
+
+func getGitProviderType(repo *schedulerv1alpha1.GitOpsRepoSpec) (schedulerv1alpha1.GitProviderType, error) {
+	if repo.Provider != "" {
+		return repo.Provider, nil
+	}
+
+	u, err := url.Parse(repo.Repo)
+	if err != nil {
+		return "", err
+	}
+
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "github.com":
+		return schedulerv1alpha1.GitHubGitProvider, nil
+	case strings.Contains(host, "gitlab"):
+		return schedulerv1alpha1.GitLabGitProvider, nil
+	case host == "dev.azure.com" || strings.HasSuffix(host, ".visualstudio.com") || strings.Contains(u.Path, "/_git/"):
+		return schedulerv1alpha1.AzureDevOpsGitProvider, nil
+	}
+
+	// GitHub Enterprise Server is hosted on custom domains
+	return schedulerv1alpha1.GitHubGitProvider, nil
+}
@@ -0,0 +1,400 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakeGitProvider is an in-memory GitProvider used by the tests
+type fakeGitProvider struct {
+	// commit sha -> path -> content
+	commits  map[string]map[string]string
+	branches map[string]string
+	prs      []PullRequest
+	issues   map[int]*Issue
+	nextId   int
+	// commit sha -> state of the status checks
+	checks map[string]CheckState
+	// the changes of the last commit
+	lastChanges []FileChange
+	// the size limit of the PR descriptions, 0 means no limit
+	maxBodySize int
+	// the numbers of the PRs with dismissed approvals
+	dismissed []int
+}
+
+var _ GitProvider = (*fakeGitProvider)(nil)
+
+func newFakeGitProvider(branch string, files map[string]string) *fakeGitProvider {
+	f := &fakeGitProvider{
+		commits:  map[string]map[string]string{},
+		branches: map[string]string{},
+		issues:   map[int]*Issue{},
+		nextId:   1,
+		checks:   map[string]CheckState{},
+	}
+	sha := f.addCommit(files)
+	f.branches[branch] = sha
+	return f
+}
+
+func fakeSHA(content string) string {
+	return gitBlobSHA(content)
+}
+
+func (f *fakeGitProvider) addCommit(files map[string]string) string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	tree := fmt.Sprint(len(f.commits))
+	for _, path := range paths {
+		tree += path + fakeSHA(files[path])
+	}
+	sha := fakeSHA(tree)
+	f.commits[sha] = files
+	return sha
+}
+
+func (f *fakeGitProvider) files(branch string) map[string]string {
+	return f.commits[f.branches[branch]]
+}
+
+func (f *fakeGitProvider) GetBranch(name string) (*Branch, error) {
+	sha, ok := f.branches[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Branch{Name: name, SHA: sha}, nil
+}
+
+func (f *fakeGitProvider) CreateBranch(name string, sha string) (*Branch, error) {
+	f.branches[name] = sha
+	return &Branch{Name: name, SHA: sha}, nil
+}
+
+func (f *fakeGitProvider) DeleteBranch(name string) error {
+	if _, ok := f.branches[name]; !ok {
+		return ErrNotFound
+	}
+	delete(f.branches, name)
+	return nil
+}
+
+func (f *fakeGitProvider) GetTree(sha string) ([]TreeEntry, error) {
+	files, ok := f.commits[sha]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var entries []TreeEntry
+	for path, content := range files {
+		entries = append(entries, TreeEntry{Path: path, SHA: fakeSHA(content)})
+	}
+	return entries, nil
+}
+
+func (f *fakeGitProvider) GetBlob(sha string) ([]byte, error) {
+	for _, files := range f.commits {
+		for _, content := range files {
+			if fakeSHA(content) == sha {
+				return []byte(content), nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (f *fakeGitProvider) CommitFiles(branch *Branch, commit *Commit, changes []FileChange) (*Branch, error) {
+	if f.branches[branch.Name] != branch.SHA && !commit.Force {
+		return nil, fmt.Errorf("branch %s has moved", branch.Name)
+	}
+	files := map[string]string{}
+	for path, content := range f.commits[branch.SHA] {
+		files[path] = content
+	}
+	for _, change := range changes {
+		if change.Action == FileDelete {
+			delete(files, change.Path)
+		} else {
+			files[change.Path] = change.Content
+		}
+	}
+	f.lastChanges = changes
+	sha := f.addCommit(files)
+	f.branches[branch.Name] = sha
+	return &Branch{Name: branch.Name, SHA: sha}, nil
+}
+
+func (f *fakeGitProvider) ListPullRequests(baseBranch string) ([]PullRequest, error) {
+	var prs []PullRequest
+	for _, pr := range f.prs {
+		if pr.Base == baseBranch && pr.State == PullRequestOpen {
+			prs = append(prs, pr)
+		}
+	}
+	return prs, nil
+}
+
+func (f *fakeGitProvider) openPullRequests() []PullRequest {
+	var prs []PullRequest
+	for _, pr := range f.prs {
+		if pr.State == PullRequestOpen {
+			prs = append(prs, pr)
+		}
+	}
+	return prs
+}
+
+func (f *fakeGitProvider) findPullRequest(number int) (*PullRequest, error) {
+	for i := range f.prs {
+		if f.prs[i].Number == number {
+			return &f.prs[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (f *fakeGitProvider) GetPullRequest(number int) (*PullRequest, error) {
+	pr, err := f.findPullRequest(number)
+	if err != nil {
+		return nil, err
+	}
+	result := *pr
+	return &result, nil
+}
+
+func (f *fakeGitProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	for _, existing := range f.openPullRequests() {
+		if existing.Head == pr.Head {
+			return nil, ErrPullRequestExists
+		}
+	}
+	created := *pr
+	created.Number = f.nextId
+	created.State = PullRequestOpen
+	created.HeadSHA = f.branches[pr.Head]
+	f.nextId++
+	f.prs = append(f.prs, created)
+	return &created, nil
+}
+
+func (f *fakeGitProvider) UpdatePullRequest(number int, title, body string) error {
+	pr, err := f.findPullRequest(number)
+	if err != nil {
+		return err
+	}
+	pr.Title = title
+	pr.Body = body
+	pr.HeadSHA = f.branches[pr.Head]
+	return nil
+}
+
+func (f *fakeGitProvider) ClosePullRequest(number int) error {
+	pr, err := f.findPullRequest(number)
+	if err != nil {
+		return err
+	}
+	pr.State = PullRequestClosed
+	return nil
+}
+
+func (f *fakeGitProvider) DismissApprovals(number int, message string) error {
+	if _, err := f.findPullRequest(number); err != nil {
+		return err
+	}
+	f.dismissed = append(f.dismissed, number)
+	return nil
+}
+
+// MergePullRequest fast forwards the base branch to the head of the PR
+func (f *fakeGitProvider) MergePullRequest(number int, method kalypsov1alpha1.MergeMethod) (string, error) {
+	pr, err := f.findPullRequest(number)
+	if err != nil {
+		return "", err
+	}
+	if pr.State != PullRequestOpen {
+		return "", ErrMergeBlocked
+	}
+	pr.State = PullRequestMerged
+	pr.MergeSHA = f.branches[pr.Head]
+	f.branches[pr.Base] = pr.MergeSHA
+	return pr.MergeSHA, nil
+}
+
+func (f *fakeGitProvider) GetCheckState(sha string) (CheckState, error) {
+	if state, ok := f.checks[sha]; ok {
+		return state, nil
+	}
+	return CheckSuccess, nil
+}
+
+func (f *fakeGitProvider) AddLabels(number int, labels []string) error {
+	for i, pr := range f.prs {
+		if pr.Number == number {
+			f.prs[i].Labels = append(f.prs[i].Labels, labels...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (f *fakeGitProvider) RemoveLabel(number int, label string) error {
+	pr, err := f.findPullRequest(number)
+	if err != nil {
+		return err
+	}
+	pr.Labels = slices.DeleteFunc(pr.Labels, func(l string) bool { return l == label })
+	return nil
+}
+
+func (f *fakeGitProvider) GetIssue(number int) (*Issue, error) {
+	issue, ok := f.issues[number]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return issue, nil
+}
+
+func (f *fakeGitProvider) CreateIssue(title, body string) (*Issue, error) {
+	issue := &Issue{Number: f.nextId, Title: title, Body: body, State: "open"}
+	f.nextId++
+	f.issues[issue.Number] = issue
+	return issue, nil
+}
+
+func (f *fakeGitProvider) UpdateIssue(number int, title, body string) error {
+	issue, ok := f.issues[number]
+	if !ok {
+		return ErrNotFound
+	}
+	issue.Title = title
+	issue.Body = body
+	return nil
+}
+
+func (f *fakeGitProvider) CloseIssue(number int) error {
+	issue, ok := f.issues[number]
+	if !ok {
+		return ErrNotFound
+	}
+	issue.State = "closed"
+	return nil
+}
+
+func (f *fakeGitProvider) MaxPullRequestBodySize() int {
+	return f.maxBodySize
+}
+
+// Test getGitProviderType
+func TestGetGitProviderType(t *testing.T) {
+	tests := []struct {
+		repo     string
+		provider kalypsov1alpha1.GitProviderType
+		expected kalypsov1alpha1.GitProviderType
+	}{
+		{"https://github.com/microsoft/kalypso-gitops", "", kalypsov1alpha1.GitHubGitProvider},
+		{"https://github.contoso.com/microsoft/kalypso-gitops", "", kalypsov1alpha1.GitHubGitProvider},
+		{"https://gitlab.com/microsoft/platform/kalypso-gitops", "", kalypsov1alpha1.GitLabGitProvider},
+		{"https://dev.azure.com/microsoft/kalypso/_git/kalypso-gitops", "", kalypsov1alpha1.AzureDevOpsGitProvider},
+		{"https://microsoft.visualstudio.com/kalypso/_git/kalypso-gitops", "", kalypsov1alpha1.AzureDevOpsGitProvider},
+		{"https://git.contoso.com/microsoft/kalypso-gitops", kalypsov1alpha1.GitLabGitProvider, kalypsov1alpha1.GitLabGitProvider},
+	}
+
+	for _, test := range tests {
+		spec := &kalypsov1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: kalypsov1alpha1.ManifestsSpec{Repo: test.repo},
+			Provider:      test.provider,
+		}
+		providerType, err := getGitProviderType(spec)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, providerType, test.repo)
+	}
+}
+
+// Test NewGitProvider
+func TestNewGitProvider(t *testing.T) {
+	provider, err := NewGitProvider(ctx, gitOpsRepo, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &githubProvider{}, provider)
+
+	provider, err = NewGitProvider(ctx, gitOpsRepo, &Credentials{
+		GitHubApp: &GitHubAppCredentials{AppID: 1, InstallationID: 2, PrivateKey: newTestPrivateKey(t)},
+	})
+	assert.NoError(t, err)
+	assert.IsType(t, &githubProvider{}, provider)
+
+	_, err = NewGitProvider(ctx, gitOpsRepo, &Credentials{
+		GitHubApp: &GitHubAppCredentials{AppID: 1, InstallationID: 2, PrivateKey: []byte("not a key")},
+	})
+	assert.Error(t, err)
+
+	_, err = NewGitProvider(ctx, &kalypsov1alpha1.GitOpsRepoSpec{
+		ManifestsSpec: kalypsov1alpha1.ManifestsSpec{Repo: "https://dev.azure.com/microsoft"},
+	}, nil)
+	assert.Error(t, err)
+}
+
+// Test the signed commits are rejected for the hosting services that create the commits on the server
+func TestCheckCommitSigning(t *testing.T) {
+	signed := &kalypsov1alpha1.CommitSpec{SigningKey: &kalypsov1alpha1.SigningKey{}}
+	tests := []struct {
+		repo     string
+		commit   *kalypsov1alpha1.CommitSpec
+		delivery kalypsov1alpha1.DeliveryType
+		wantErr  bool
+	}{
+		{"https://gitlab.com/microsoft/kalypso-gitops", nil, "", false},
+		{"https://gitlab.com/microsoft/kalypso-gitops", &kalypsov1alpha1.CommitSpec{}, "", false},
+		{"https://github.com/microsoft/kalypso-gitops", signed, "", false},
+		{"https://gitlab.com/microsoft/kalypso-gitops", signed, "", true},
+		{"https://gitlab.com/microsoft/kalypso-gitops", signed, kalypsov1alpha1.PullRequestDelivery, true},
+		{"https://dev.azure.com/microsoft/kalypso/_git/kalypso-gitops", signed, "", true},
+		{"https://gitlab.com/microsoft/kalypso-gitops", signed, kalypsov1alpha1.DirectPushDelivery, false},
+		{"https://dev.azure.com/microsoft/kalypso/_git/kalypso-gitops", signed, kalypsov1alpha1.OCIDelivery, false},
+	}
+
+	for _, test := range tests {
+		err := CheckCommitSigning(&kalypsov1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: kalypsov1alpha1.ManifestsSpec{Repo: test.repo},
+			Commit:        test.commit,
+			Delivery:      test.delivery,
+		})
+		if test.wantErr {
+			assert.ErrorIs(t, err, ErrNotSupported, test.repo)
+		} else {
+			assert.NoError(t, err, test.repo)
+		}
+	}
+
+	sshKey, _ := newTestSSHKey(t)
+	signer, err := NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"identity": sshKey}})
+	assert.NoError(t, err)
+	_, err = NewGitRepo(ctx, &kalypsov1alpha1.GitOpsRepoSpec{
+		ManifestsSpec: kalypsov1alpha1.ManifestsSpec{Repo: "https://gitlab.com/microsoft/kalypso-gitops"},
+		Commit:        signed,
+	}, nil, signer)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
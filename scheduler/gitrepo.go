@@ -0,0 +1,663 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	reconcilerName = "reconciler"
+	namespaceName  = "namespace"
+	configName     = "platform-config"
+	// the branches of the PRs created by the scheduler start with the prefix
+	deploymentBranchPrefix = "deployment/"
+)
+
+type GitRepo interface {
+	// CreatePR delivers the content to the GitOps repo. It returns the created PR or nil if the content is pushed directly to the branch.
+	// It returns ErrNoChanges if the content is already in the branch.
+	CreatePR(prBranchName string, content *schedulerv1alpha1.RepoContentType) (*schedulerv1alpha1.PullRequestStatus, error)
+	// GetPR reports the current state of the PR
+	GetPR(prNumber string) (*schedulerv1alpha1.PullRequestStatus, error)
+	UpdateIssue(gitIssueNumber *int, title string, message *string) (*int, error)
+	// MergePR merges the PR according to the auto-merge spec of the repo and reports the result
+	MergePR(prNumber string) (*schedulerv1alpha1.AutoMergeStatus, error)
+	// GetDrift compares the base branch with the content and returns the sorted paths of the files that differ from it
+	GetDrift(content *schedulerv1alpha1.RepoContentType) ([]string, error)
+}
+
+// implements GitRepo interface on top of a GitProvider
+type gitRepo struct {
+	repo     *schedulerv1alpha1.GitOpsRepoSpec
+	provider GitProvider
+	signer   Signer
+	ctx      context.Context
+	logger   logr.Logger
+}
+
+// validate gitRepo implements GitRepo interface
+var _ GitRepo = (*gitRepo)(nil)
+
+var (
+	authorName              string = "Kalypso Scheduler"
+	authorEmail             string = "kalypso.scheduler@email.com"
+	commitMessage           string = "Kalypso Scheduler commit"
+	Promoted_Commit_Id_Path string = ".github/tracking/Promoted_Commit_Id"
+	prometedLabel           string = "promoted"
+	readmeFilename          string = "README.md"
+	readmeContent           string = "This folder contains deployment targets scheduled on the cluster type"
+	dismissApprovalsMessage string = "The manifests have been updated by Kalypso Scheduler"
+)
+
+// new gitRepo function, nil credentials stand for the default credentials of the scheduler.
+// The commits are signed with the signer, if it's not nil.
+func NewGitRepo(ctx context.Context, repo *schedulerv1alpha1.GitOpsRepoSpec, credentials *Credentials, signer Signer) (GitRepo, error) {
+	if signer != nil {
+		if err := CheckCommitSigning(repo); err != nil {
+			return nil, err
+		}
+	}
+
+	provider, err := NewGitProvider(ctx, repo, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	gitRepo := newGitRepoWithProvider(ctx, repo, provider)
+	gitRepo.signer = signer
+	return gitRepo, nil
+}
+
+func newGitRepoWithProvider(ctx context.Context, repo *schedulerv1alpha1.GitOpsRepoSpec, provider GitProvider) *gitRepo {
+	return &gitRepo{
+		repo:     repo,
+		provider: provider,
+		ctx:      ctx,
+		logger:   log.FromContext(ctx),
+	}
+}
+
+// implement CreatePR function
+func (g *gitRepo) CreatePR(prBranchName string, content *schedulerv1alpha1.RepoContentType) (*schedulerv1alpha1.PullRequestStatus, error) {
+	baseBranch, err := g.provider.GetBranch(g.repo.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.repo.Delivery == schedulerv1alpha1.DirectPushDelivery {
+		return nil, g.pushToBranch(baseBranch, content)
+	}
+
+	// the PR branch is rebuilt on top of the base branch, so the changes are the difference with the base one
+	changeSet, err := g.getChanges(baseBranch, content)
+	if err != nil {
+		return nil, err
+	}
+
+	openPR, err := g.cleanPullRequests(g.repo.Branch, prBranchName)
+	if err != nil {
+		return nil, err
+	}
+
+	// no changes means the new tree is the same as the base one
+	if len(changeSet.changes) == 0 {
+		g.logger.Info("No changes to PR", "branch", prBranchName)
+		// the open PR would change the base branch away from the content, so it is no longer valid
+		if openPR != nil {
+			if err := g.closePullRequest(openPR); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrNoChanges
+	}
+
+	prBranch, err := g.getBranch(prBranchName, baseBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	// the open PR that already has the content is left as it is, so its approvals stay valid
+	contentChanged := true
+	if openPR != nil {
+		branchChangeSet, err := g.getChanges(prBranch, content)
+		if err != nil {
+			return nil, err
+		}
+		contentChanged = len(branchChangeSet.changes) > 0
+	}
+
+	if contentChanged {
+		// force the branch to a single commit on top of the base branch, so the open PR gets it instead of being recreated
+		commit := g.getCommit()
+		commit.Force = true
+		_, err = g.provider.CommitFiles(&Branch{Name: prBranchName, SHA: baseBranch.SHA}, commit, changeSet.changes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := g.getPullRequestBody(changeSet, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if openPR != nil {
+		return g.updatePullRequest(openPR, body, changeSet.isPromoted, contentChanged)
+	}
+
+	pr, err := g.createPullRequest(g.repo.Branch, prBranchName, body, changeSet.isPromoted)
+	if err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// implement GetDrift function
+func (g *gitRepo) GetDrift(content *schedulerv1alpha1.RepoContentType) ([]string, error) {
+	baseBranch, err := g.provider.GetBranch(g.repo.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	// the changes that would restore the content are the drifted files
+	changeSet, err := g.getChanges(baseBranch, content)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(changeSet.changes))
+	for i, change := range changeSet.changes {
+		paths[i] = change.Path
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// commits the content straight to the branch without a PR
+func (g *gitRepo) pushToBranch(branch *Branch, content *schedulerv1alpha1.RepoContentType) error {
+	changeSet, err := g.getChanges(branch, content)
+	if err != nil {
+		return err
+	}
+
+	if len(changeSet.changes) == 0 {
+		g.logger.Info("No changes to push", "branch", branch.Name)
+		return ErrNoChanges
+	}
+
+	g.logger.Info("Pushing manifests", "branch", branch.Name)
+	_, err = g.provider.CommitFiles(branch, g.getCommit(), changeSet.changes)
+	return err
+}
+
+func (g *gitRepo) getCommit() *Commit {
+	commit := &Commit{
+		Message:     commitMessage,
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+		Signer:      g.signer,
+	}
+	if g.repo.Commit != nil && g.repo.Commit.Author != nil {
+		commit.AuthorName = g.repo.Commit.Author.Name
+		commit.AuthorEmail = g.repo.Commit.Author.Email
+	}
+	return commit
+}
+
+// gets the branch to commit to
+func (g *gitRepo) getBranch(prBranchName string, baseBranch *Branch) (*Branch, error) {
+	if branch, err := g.provider.GetBranch(prBranchName); err == nil {
+		return branch, nil
+	}
+
+	return g.provider.CreateBranch(prBranchName, baseBranch.SHA)
+}
+
+// convert the content of the string slice into yaml string
+func joinManifests(manifests []string) string {
+	var manifestsYaml string
+	for _, manifest := range manifests {
+		if manifestsYaml != "" {
+			manifestsYaml += "---\n"
+		}
+		manifestsYaml += manifest
+	}
+	return manifestsYaml
+}
+
+// getDeploymentTargetFiles returns the manifest files of a deployment target folder by their names
+func getDeploymentTargetFiles(dt schedulerv1alpha1.AssignmentPackageSpec) map[string]string {
+	files := map[string]string{
+		getManifestsFileName(reconcilerName, dt.ReconcilerManifestsContentType): joinManifests(dt.ReconcilerManifests),
+		getManifestsFileName(namespaceName, dt.NamespaceManifestsContentType):   joinManifests(dt.NamespaceManifests),
+	}
+	if configManifests := joinManifests(dt.ConfigManifests); configManifests != "" {
+		files[getManifestsFileName(configName, dt.ConfigManifestsContentType)] = configManifests
+	}
+	return files
+}
+
+// gitBlobSHA returns the git object id of a blob with the content
+func gitBlobSHA(content string) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "blob %d\x00", len(content))
+	hash.Write([]byte(content))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// changeSet is what has to be committed to a branch to get the content into it
+type changeSet struct {
+	changes    []FileChange
+	isPromoted bool
+	// the blob SHAs of the files in the tree of the branch by path
+	existingFiles map[string]string
+}
+
+// getChanges compares the content with the existing tree of the branch and returns the files to commit.
+// The files that are already in the tree with the same content are skipped, so no changes means the trees are equal.
+func (g *gitRepo) getChanges(branch *Branch, content *schedulerv1alpha1.RepoContentType) (*changeSet, error) {
+	existingTree, err := g.provider.GetTree(branch.SHA)
+	if err != nil {
+		return nil, err
+	}
+
+	existingFiles := make(map[string]string)
+	for _, entry := range existingTree {
+		existingFiles[entry.Path] = entry.SHA
+	}
+
+	layout, err := newRepoLayout(g.repo.Layout)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := layout.getDeploymentTargetFolders(content)
+	if err != nil {
+		return nil, err
+	}
+	readmes := make(map[string]bool)
+	for clusterType := range content.ClusterTypes {
+		readmePath, err := layout.getClusterTypeReadmePath(clusterType)
+		if err != nil {
+			return nil, err
+		}
+		if readmePath != "" {
+			readmes[readmePath] = true
+		}
+	}
+
+	var changes []FileChange
+	//iterate through the existing tree and delete the files of the layout that are not in the content
+	for _, entry := range existingTree {
+		if !layout.isManaged(entry.Path) || readmes[entry.Path] {
+			continue
+		}
+		if folder, ok := layout.getDeploymentTargetFolder(entry.Path); ok && folders[folder.path] != nil {
+			continue
+		}
+		g.logger.Info("Deleting file", "path", entry.Path)
+		changes = append(changes, FileChange{Path: entry.Path, Action: FileDelete})
+	}
+
+	addFile := func(path, fileContent string) {
+		action := FileCreate
+		if existingSHA, ok := existingFiles[path]; ok {
+			// the file is already there with the same content
+			if existingSHA == gitBlobSHA(fileContent) {
+				return
+			}
+			action = FileUpdate
+		}
+		changes = append(changes, FileChange{Path: path, Content: fileContent, Action: action})
+	}
+
+	promotedCommitId, isPromoted, err := g.addPromotedCommitId(existingTree, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if promotedCommitId != nil {
+		addFile(Promoted_Commit_Id_Path, *promotedCommitId)
+	}
+
+	//iterate through the content and add the files
+	for path, folder := range folders {
+		dt := content.ClusterTypes[folder.clusterType].DeploymentTargets[folder.deploymentTarget]
+		for fileName, fileContent := range getDeploymentTargetFiles(dt) {
+			addFile(path+"/"+fileName, fileContent)
+		}
+	}
+
+	for readmePath := range readmes {
+		addFile(readmePath, readmeContent)
+	}
+
+	return &changeSet{changes: changes, isPromoted: isPromoted, existingFiles: existingFiles}, nil
+}
+
+func getManifestsFileName(fileName string, contentType string) string {
+	var fileExtension string
+	if contentType == schedulerv1alpha1.EnvContentType {
+		fileExtension = "sh"
+	} else {
+		fileExtension = "yaml"
+	}
+
+	return fileName + "." + fileExtension
+}
+
+// returns the promoted commit id to write if it differs from the one in the repo
+func (g *gitRepo) addPromotedCommitId(existingEntries []TreeEntry, content *schedulerv1alpha1.RepoContentType) (commitId *string, isPromoted bool, err error) {
+	//get the promoted commit id
+	promotedCommitId := content.BaseRepo.Commit
+	if promotedCommitId == "" {
+		return nil, false, nil
+	}
+
+	// iterate over existingEntries and find the promotedCommitId file
+	for _, entry := range existingEntries {
+		if entry.Path == Promoted_Commit_Id_Path {
+			// if the content is same as the promotedCommitId then return
+			blob, err := g.provider.GetBlob(entry.SHA)
+			if err != nil {
+				return nil, false, err
+			}
+
+			if string(blob) == promotedCommitId {
+				return nil, false, nil
+			}
+			break
+		}
+	}
+
+	return &promotedCommitId, true, nil
+}
+
+// isDeploymentBranch tells if the branch is a PR branch of the scheduler
+func isDeploymentBranch(branchName string) bool {
+	return strings.HasPrefix(branchName, deploymentBranchPrefix)
+}
+
+// DeploymentBranchName returns the PR branch of the scheduler for the base branch.
+// The branch is stable, so the open PR is updated with the new content instead of being replaced.
+func DeploymentBranchName(baseBranchName string) string {
+	return deploymentBranchPrefix + baseBranchName
+}
+
+// closes the PRs of the scheduler to the branch except the open PR from the deployment branch, which is returned.
+// The PRs from other branches are not created by the scheduler, so they are not touched.
+func (g *gitRepo) cleanPullRequests(baseBranchName, prBranchName string) (*PullRequest, error) {
+	prs, err := g.provider.ListPullRequests(baseBranchName)
+	if err != nil {
+		return nil, err
+	}
+
+	var openPR *PullRequest
+	for i, pr := range prs {
+		if !isDeploymentBranch(pr.Head) {
+			continue
+		}
+		if pr.Head == prBranchName && openPR == nil {
+			openPR = &prs[i]
+			continue
+		}
+		// e.g. a PR from a timestamped branch of the previous versions
+		if err := g.closePullRequest(&prs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return openPR, nil
+}
+
+// closes the PR and deletes its branch
+func (g *gitRepo) closePullRequest(pr *PullRequest) error {
+	g.logger.Info("Deleting Branch", "branch", pr.Head)
+	if err := g.provider.DeleteBranch(pr.Head); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	g.logger.Info("Closing PR", "pr", pr.Number)
+	return g.provider.ClosePullRequest(pr.Number)
+}
+
+func getPullRequestTitle(baseBranchName, prBranchName string) string {
+	return fmt.Sprintf("Update manifests in %s from %s", baseBranchName, prBranchName)
+}
+
+func (g *gitRepo) createPullRequest(baseBranchName, prBranchName, body string, isPromoted bool) (*schedulerv1alpha1.PullRequestStatus, error) {
+	newPR := &PullRequest{
+		Title: getPullRequestTitle(baseBranchName, prBranchName),
+		Body:  body,
+		Head:  prBranchName,
+		Base:  baseBranchName,
+	}
+
+	if isPromoted {
+		newPR.Labels = []string{prometedLabel}
+	}
+
+	pr, err := g.provider.CreatePullRequest(newPR)
+	if err != nil {
+		return nil, err
+	}
+
+	return toPullRequestStatus(pr), nil
+}
+
+// updatePullRequest refreshes the description of the open PR after its branch got the new content.
+// The approvals are dismissed if the content has changed and the approval policy requires that.
+func (g *gitRepo) updatePullRequest(pr *PullRequest, body string, isPromoted, contentChanged bool) (*schedulerv1alpha1.PullRequestStatus, error) {
+	g.logger.Info("Updating PR", "pr", pr.Number)
+	pr.Title = getPullRequestTitle(pr.Base, pr.Head)
+	pr.Body = body
+	if err := g.provider.UpdatePullRequest(pr.Number, pr.Title, pr.Body); err != nil {
+		return nil, err
+	}
+
+	if isPromoted && !slices.Contains(pr.Labels, prometedLabel) {
+		if err := g.provider.AddLabels(pr.Number, []string{prometedLabel}); err != nil {
+			return nil, err
+		}
+		pr.Labels = append(pr.Labels, prometedLabel)
+	} else if !isPromoted && slices.Contains(pr.Labels, prometedLabel) {
+		// the new content doesn't carry a new base repo commit anymore
+		if err := g.provider.RemoveLabel(pr.Number, prometedLabel); err != nil {
+			return nil, err
+		}
+		pr.Labels = slices.DeleteFunc(pr.Labels, func(label string) bool { return label == prometedLabel })
+	}
+
+	if contentChanged && g.repo.PullRequest != nil && g.repo.PullRequest.ApprovalPolicy == schedulerv1alpha1.DismissApprovalPolicy {
+		g.logger.Info("Dismissing approvals", "pr", pr.Number)
+		err := g.provider.DismissApprovals(pr.Number, dismissApprovalsMessage)
+		if errors.Is(err, ErrNotSupported) {
+			g.logger.Info("The approvals can't be dismissed", "pr", pr.Number, "reason", err.Error())
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return toPullRequestStatus(pr), nil
+}
+
+func toPullRequestStatus(pr *PullRequest) *schedulerv1alpha1.PullRequestStatus {
+	status := &schedulerv1alpha1.PullRequestStatus{
+		Number: strconv.Itoa(pr.Number),
+		URL:    pr.URL,
+		Branch: pr.Head,
+	}
+
+	switch pr.State {
+	case PullRequestMerged:
+		status.State = schedulerv1alpha1.PullRequestMerged
+		status.MergedSHA = pr.MergeSHA
+	case PullRequestClosed:
+		status.State = schedulerv1alpha1.PullRequestClosed
+	default:
+		status.State = schedulerv1alpha1.PullRequestOpen
+	}
+
+	return status
+}
+
+// implement GetPR function
+func (g *gitRepo) GetPR(prNumber string) (*schedulerv1alpha1.PullRequestStatus, error) {
+	number, err := strconv.Atoi(prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := g.provider.GetPullRequest(number)
+	if err != nil {
+		return nil, err
+	}
+
+	return toPullRequestStatus(pr), nil
+}
+
+// implement MergePR function
+func (g *gitRepo) MergePR(prNumber string) (*schedulerv1alpha1.AutoMergeStatus, error) {
+	status := &schedulerv1alpha1.AutoMergeStatus{PullRequest: prNumber}
+	blocked := func(message string) (*schedulerv1alpha1.AutoMergeStatus, error) {
+		status.State = schedulerv1alpha1.AutoMergeBlocked
+		status.Message = message
+		return status, nil
+	}
+
+	autoMerge := g.repo.AutoMerge
+	if autoMerge == nil || autoMerge.Policy == "" || autoMerge.Policy == schedulerv1alpha1.NeverAutoMerge {
+		return blocked("auto-merge is disabled")
+	}
+
+	number, err := strconv.Atoi(prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := g.provider.GetPullRequest(number)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pr.State {
+	case PullRequestMerged:
+		status.State = schedulerv1alpha1.AutoMergeMerged
+		status.MergedSHA = pr.MergeSHA
+		return status, nil
+	case PullRequestClosed:
+		return blocked("the PR is closed")
+	}
+
+	if autoMerge.Policy == schedulerv1alpha1.UnpromotedAutoMerge {
+		for _, label := range pr.Labels {
+			if label == prometedLabel {
+				return blocked("the PR is labelled " + prometedLabel)
+			}
+		}
+	}
+
+	if autoMerge.WaitForChecks {
+		checkState, err := g.provider.GetCheckState(pr.HeadSHA)
+		if err != nil {
+			return nil, err
+		}
+		switch checkState {
+		case CheckPending:
+			status.State = schedulerv1alpha1.AutoMergePending
+			status.Message = "waiting for the status checks"
+			return status, nil
+		case CheckFailure:
+			return blocked("the status checks failed")
+		}
+	}
+
+	method := autoMerge.Method
+	if method == "" {
+		method = schedulerv1alpha1.MergeMergeMethod
+	}
+
+	g.logger.Info("Merging PR", "pr", number, "method", method)
+	sha, err := g.provider.MergePullRequest(number, method)
+	if errors.Is(err, ErrMergeBlocked) || errors.Is(err, ErrNotSupported) {
+		return blocked(err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status.State = schedulerv1alpha1.AutoMergeMerged
+	status.MergedSHA = sha
+	return status, nil
+}
+
+// implement UpdateIssue function
+func (g *gitRepo) UpdateIssue(gitIssueNumber *int, title string, message *string) (*int, error) {
+	// there is no issue tracker behind a plain git remote
+	if g.repo.Delivery == schedulerv1alpha1.DirectPushDelivery {
+		if message != nil {
+			g.logger.Info("Issues are not supported with direct push delivery", "title", title, "message", *message)
+		}
+		return nil, nil
+	}
+
+	if message == nil {
+		//close issue
+		if gitIssueNumber != nil {
+			//check if issue exists and delete it
+			issue, err := g.provider.GetIssue(*gitIssueNumber)
+			if err != nil || issue == nil {
+				return nil, nil
+			}
+
+			err = g.provider.CloseIssue(*gitIssueNumber)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	}
+
+	//check if issue exists, if not create it
+	if gitIssueNumber == nil || *gitIssueNumber == 0 {
+		issue, err := g.provider.CreateIssue(title, *message)
+		if err != nil {
+			return nil, err
+		}
+		gitIssueNumber = &issue.Number
+	} else {
+		err := g.provider.UpdateIssue(*gitIssueNumber, title, *message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return gitIssueNumber, nil
+
+}
@@ -0,0 +1,475 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ctx        = context.Background()
+	gitOpsRepo = &kalypsov1alpha1.GitOpsRepoSpec{
+		ManifestsSpec: kalypsov1alpha1.ManifestsSpec{
+			Repo:   "https://github.com/microsoft/kalypso-gitops",
+			Path:   ".",
+			Branch: "dev",
+		},
+	}
+	reconcilerManifestsFile = "./testdata/reconciler-manifests.yaml"
+	namespaceManifestsFile  = "./testdata/namespace-manifests.yaml"
+)
+
+// Test NewGitRepo
+func TestNewGitRepo(t *testing.T) {
+
+	gitRepo, err := NewGitRepo(ctx,
+		gitOpsRepo, nil, nil)
+	if err != nil {
+		t.Errorf("error creating git repo: %v", err)
+	}
+	if gitRepo == nil {
+		t.Errorf("git repo is nil")
+	}
+
+}
+
+func getTestRepoContent(t *testing.T) *kalypsov1alpha1.RepoContentType {
+	reconcilerManifests := getManifestsYamlString(t, reconcilerManifestsFile)
+	namespaceManifests := getManifestsYamlString(t, namespaceManifestsFile)
+
+	//Initialize the package
+	assignmentPackageSpec := &kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: reconcilerManifests,
+		NamespaceManifests:  namespaceManifests,
+	}
+
+	repoContentType := kalypsov1alpha1.NewRepoContentType()
+	repoContentType.ClusterTypes["drone"] = *kalypsov1alpha1.NewClusterContentType()
+	repoContentType.ClusterTypes["drone"].DeploymentTargets["hello-world-app-functional-test"] = *assignmentPackageSpec
+	return repoContentType
+}
+
+// Test CreatePR
+func TestCreatePR(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{
+		"README.md":                            "GitOps repo",
+		".github/workflows/check.yaml":         "on: pull_request",
+		"drone/README.md":                      readmeContent,
+		"drone/old-target/reconciler.yaml":     "old",
+		"large/hello-world-app/namespace.yaml": "old",
+	})
+	provider.prs = []PullRequest{{Number: 100, Head: "deployment/old", Base: "dev", State: PullRequestOpen}}
+	provider.branches["deployment/old"] = provider.branches["dev"]
+
+	gitRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+	content := getTestRepoContent(t)
+	content.BaseRepo.Commit = "0123456789"
+
+	pr, err := gitRepo.CreatePR("deployment/new", content)
+	if err != nil {
+		t.Fatalf("can't create PR: %v", err)
+	}
+
+	// the old PR is closed and its branch is deleted
+	prs := provider.openPullRequests()
+	assert.Len(t, prs, 1)
+	assert.Equal(t, "1", pr.Number)
+	assert.Equal(t, "deployment/new", pr.Branch)
+	assert.Equal(t, kalypsov1alpha1.PullRequestOpen, pr.State)
+	assert.Equal(t, "deployment/new", prs[0].Head)
+	assert.Equal(t, []string{prometedLabel}, prs[0].Labels)
+	assert.NotContains(t, provider.branches, "deployment/old")
+	assert.Contains(t, prs[0].Body, "It promotes commit `0123456789`")
+	assert.Contains(t, prs[0].Body, "| hello-world-app-functional-test | added |")
+	assert.Contains(t, prs[0].Body, "| old-target | removed |")
+
+	files := provider.files("deployment/new")
+	assert.Equal(t, "GitOps repo", files["README.md"])
+	assert.Equal(t, "on: pull_request", files[".github/workflows/check.yaml"])
+	assert.Equal(t, "0123456789", files[Promoted_Commit_Id_Path])
+	assert.Equal(t, readmeContent, files["drone/README.md"])
+	assert.NotContains(t, files, "drone/old-target/reconciler.yaml")
+	assert.NotContains(t, files, "large/hello-world-app/namespace.yaml")
+	assert.True(t, strings.HasPrefix(files["drone/hello-world-app-functional-test/reconciler.yaml"], "apiVersion"))
+	assert.NotEmpty(t, files["drone/hello-world-app-functional-test/namespace.yaml"])
+	assert.NotContains(t, files, "drone/hello-world-app-functional-test/platform-config.yaml")
+
+	// existing files are updated, new ones are created
+	for _, change := range provider.lastChanges {
+		switch change.Path {
+		case "drone/README.md":
+			assert.Equal(t, FileUpdate, change.Action)
+		case "drone/hello-world-app-functional-test/reconciler.yaml":
+			assert.Equal(t, FileCreate, change.Action)
+		case "drone/old-target/reconciler.yaml":
+			assert.Equal(t, FileDelete, change.Action)
+		}
+	}
+
+	// once merged, the same commit id is not promoted again
+	assert.NoError(t, provider.ClosePullRequest(1))
+	provider.branches["dev"] = provider.branches["deployment/new"]
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app-functional-test"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+	pr, err = gitRepo.CreatePR("deployment/newer", content)
+	if err != nil {
+		t.Fatalf("can't create PR: %v", err)
+	}
+	assert.Equal(t, "2", pr.Number)
+	assert.Empty(t, provider.openPullRequests()[0].Labels)
+	assert.Contains(t, provider.openPullRequests()[0].Body, "| hello-world-app-functional-test | modified |")
+	assert.NotContains(t, provider.openPullRequests()[0].Body, "It promotes commit")
+	// only the changed files are committed
+	assert.Len(t, provider.lastChanges, 2)
+}
+
+// Test CreatePR when the content is already in the base branch
+func TestCreatePRNoChanges(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{
+		"README.md":       "GitOps repo",
+		"drone/README.md": readmeContent,
+		"drone/hello-world-app-functional-test/reconciler.yaml": "reconciler",
+		"drone/hello-world-app-functional-test/namespace.yaml":  "namespace",
+	})
+	provider.prs = []PullRequest{{Number: 100, Head: "deployment/old", Base: "dev", State: PullRequestOpen}}
+	provider.branches["deployment/old"] = provider.branches["dev"]
+	base := provider.branches["dev"]
+
+	content := kalypsov1alpha1.NewRepoContentType()
+	content.ClusterTypes["drone"] = *kalypsov1alpha1.NewClusterContentType()
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app-functional-test"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+
+	gitRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+	pr, err := gitRepo.CreatePR("deployment/new", content)
+	assert.ErrorIs(t, err, ErrNoChanges)
+	assert.Nil(t, pr)
+
+	// nothing is committed, no branch is created and the outdated PRs are closed
+	assert.Equal(t, base, provider.branches["dev"])
+	assert.NotContains(t, provider.branches, "deployment/new")
+	assert.NotContains(t, provider.branches, "deployment/old")
+	assert.Empty(t, provider.openPullRequests())
+
+	// the same with direct push
+	spec := gitOpsRepo.DeepCopy()
+	spec.Delivery = kalypsov1alpha1.DirectPushDelivery
+	gitRepo = newGitRepoWithProvider(ctx, spec, provider)
+	_, err = gitRepo.CreatePR("deployment/new", content)
+	assert.ErrorIs(t, err, ErrNoChanges)
+	assert.Equal(t, base, provider.branches["dev"])
+}
+
+// Test CreatePR updates the open PR of the scheduler instead of replacing it
+func TestCreatePRUpdatesOpenPR(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{
+		"README.md":       "GitOps repo",
+		"drone/README.md": readmeContent,
+	})
+	// a PR opened by a human
+	provider.branches["feature"] = provider.branches["dev"]
+	provider.prs = []PullRequest{{Number: 100, Head: "feature", Base: "dev", State: PullRequestOpen}}
+	provider.nextId = 101
+
+	spec := gitOpsRepo.DeepCopy()
+	spec.PullRequest = &kalypsov1alpha1.PullRequestSpec{ApprovalPolicy: kalypsov1alpha1.DismissApprovalPolicy}
+	gitRepo := newGitRepoWithProvider(ctx, spec, provider)
+
+	content := kalypsov1alpha1.NewRepoContentType()
+	content.ClusterTypes["drone"] = *kalypsov1alpha1.NewClusterContentType()
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+
+	branchName := DeploymentBranchName("dev")
+	assert.Equal(t, "deployment/dev", branchName)
+	pr, err := gitRepo.CreatePR(branchName, content)
+	assert.NoError(t, err)
+	assert.Equal(t, "101", pr.Number)
+	assert.Empty(t, provider.dismissed)
+
+	// someone merges another PR to the base branch, then the content changes
+	devBranch, err := provider.GetBranch("dev")
+	assert.NoError(t, err)
+	_, err = provider.CommitFiles(devBranch, &Commit{Message: "docs"}, []FileChange{{Path: "docs/notes.md", Content: "notes", Action: FileCreate}})
+	assert.NoError(t, err)
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler v2"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+
+	pr, err = gitRepo.CreatePR(branchName, content)
+	assert.NoError(t, err)
+
+	// the same PR gets the new content on top of the current base branch and its approvals are dismissed
+	assert.Equal(t, "101", pr.Number)
+	assert.Equal(t, kalypsov1alpha1.PullRequestOpen, pr.State)
+	files := provider.files(branchName)
+	assert.Equal(t, "reconciler v2", files["drone/hello-world-app/reconciler.yaml"])
+	assert.Equal(t, "notes", files["docs/notes.md"])
+	assert.Equal(t, []int{101}, provider.dismissed)
+	updated, err := provider.GetPullRequest(101)
+	assert.NoError(t, err)
+	assert.Equal(t, provider.branches[branchName], updated.HeadSHA)
+	assert.Contains(t, updated.Body, "| hello-world-app | added |")
+
+	// the PR of the human is not touched
+	assert.Len(t, provider.openPullRequests(), 2)
+	assert.Contains(t, provider.branches, "feature")
+
+	// the PR already has the content, so nothing is committed and the approvals stay
+	head := provider.branches[branchName]
+	pr, err = gitRepo.CreatePR(branchName, content)
+	assert.NoError(t, err)
+	assert.Equal(t, "101", pr.Number)
+	assert.Equal(t, head, provider.branches[branchName])
+	assert.Equal(t, []int{101}, provider.dismissed)
+
+	// the approvals are preserved by default
+	gitRepo = newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler v3"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+	_, err = gitRepo.CreatePR(branchName, content)
+	assert.NoError(t, err)
+	assert.Equal(t, "reconciler v3", provider.files(branchName)["drone/hello-world-app/reconciler.yaml"])
+	assert.Equal(t, []int{101}, provider.dismissed)
+}
+
+// Test the open PR loses the promoted label when its new content doesn't promote a commit anymore
+func TestCreatePRUpdatesPromotedPR(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{
+		"README.md": "GitOps repo",
+	})
+	gitRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+
+	content := kalypsov1alpha1.NewRepoContentType()
+	content.BaseRepo.Commit = "0123456789"
+	content.ClusterTypes["drone"] = *kalypsov1alpha1.NewClusterContentType()
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler"},
+	}
+
+	branchName := DeploymentBranchName("dev")
+	pr, err := gitRepo.CreatePR(branchName, content)
+	assert.NoError(t, err)
+	promoted, err := provider.GetPullRequest(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{prometedLabel}, promoted.Labels)
+
+	// the commit gets to the base branch with another PR, then the content changes
+	devBranch, err := provider.GetBranch("dev")
+	assert.NoError(t, err)
+	_, err = provider.CommitFiles(devBranch, &Commit{Message: "promote"}, []FileChange{{Path: Promoted_Commit_Id_Path, Content: "0123456789", Action: FileCreate}})
+	assert.NoError(t, err)
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler v2"},
+	}
+
+	updated, err := gitRepo.CreatePR(branchName, content)
+	assert.NoError(t, err)
+	assert.Equal(t, pr.Number, updated.Number)
+	unpromoted, err := provider.GetPullRequest(1)
+	assert.NoError(t, err)
+	assert.Empty(t, unpromoted.Labels)
+	assert.NotContains(t, unpromoted.Body, "It promotes commit")
+}
+
+// Test GetDrift
+func TestGetDrift(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{
+		"README.md":       "GitOps repo",
+		"drone/README.md": readmeContent,
+		"drone/hello-world-app-functional-test/reconciler.yaml": "reconciler",
+		"drone/hello-world-app-functional-test/namespace.yaml":  "namespace",
+	})
+
+	content := kalypsov1alpha1.NewRepoContentType()
+	content.ClusterTypes["drone"] = *kalypsov1alpha1.NewClusterContentType()
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app-functional-test"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"reconciler"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+
+	gitRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+	drifted, err := gitRepo.GetDrift(content)
+	assert.NoError(t, err)
+	assert.Empty(t, drifted)
+
+	// someone edits a manifest, deletes another one and adds a deployment target by hand
+	branch, err := provider.GetBranch("dev")
+	assert.NoError(t, err)
+	_, err = provider.CommitFiles(branch, &Commit{Message: "hand edit"}, []FileChange{
+		{Path: "drone/hello-world-app-functional-test/reconciler.yaml", Content: "edited", Action: FileUpdate},
+		{Path: "drone/hello-world-app-functional-test/namespace.yaml", Action: FileDelete},
+		{Path: "drone/manual/reconciler.yaml", Content: "manual", Action: FileCreate},
+		{Path: "docs/notes.md", Content: "not managed", Action: FileCreate},
+	})
+	assert.NoError(t, err)
+	edited := provider.branches["dev"]
+
+	drifted, err = gitRepo.GetDrift(content)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"drone/hello-world-app-functional-test/namespace.yaml",
+		"drone/hello-world-app-functional-test/reconciler.yaml",
+		"drone/manual/reconciler.yaml",
+	}, drifted)
+
+	// nothing is committed and no PRs are opened
+	assert.Equal(t, edited, provider.branches["dev"])
+	assert.Empty(t, provider.openPullRequests())
+}
+
+// Test MergePR
+func TestMergePR(t *testing.T) {
+	tests := []struct {
+		name      string
+		autoMerge *kalypsov1alpha1.AutoMergeSpec
+		promoted  bool
+		checks    CheckState
+		state     kalypsov1alpha1.AutoMergeState
+		message   string
+	}{
+		{"disabled", nil, false, CheckSuccess, kalypsov1alpha1.AutoMergeBlocked, "auto-merge is disabled"},
+		{"never", &kalypsov1alpha1.AutoMergeSpec{Policy: kalypsov1alpha1.NeverAutoMerge}, false, CheckSuccess, kalypsov1alpha1.AutoMergeBlocked, "auto-merge is disabled"},
+		{"always", &kalypsov1alpha1.AutoMergeSpec{Policy: kalypsov1alpha1.AlwaysAutoMerge}, true, CheckFailure, kalypsov1alpha1.AutoMergeMerged, ""},
+		{"unpromoted", &kalypsov1alpha1.AutoMergeSpec{Policy: kalypsov1alpha1.UnpromotedAutoMerge}, false, CheckSuccess, kalypsov1alpha1.AutoMergeMerged, ""},
+		{"promoted", &kalypsov1alpha1.AutoMergeSpec{Policy: kalypsov1alpha1.UnpromotedAutoMerge}, true, CheckSuccess, kalypsov1alpha1.AutoMergeBlocked, "the PR is labelled promoted"},
+		{"checks pending", &kalypsov1alpha1.AutoMergeSpec{Policy: kalypsov1alpha1.AlwaysAutoMerge, WaitForChecks: true}, false, CheckPending, kalypsov1alpha1.AutoMergePending, "waiting for the status checks"},
+		{"checks failed", &kalypsov1alpha1.AutoMergeSpec{Policy: kalypsov1alpha1.AlwaysAutoMerge, WaitForChecks: true}, false, CheckFailure, kalypsov1alpha1.AutoMergeBlocked, "the status checks failed"},
+		{"checks passed", &kalypsov1alpha1.AutoMergeSpec{Policy: kalypsov1alpha1.AlwaysAutoMerge, WaitForChecks: true}, false, CheckSuccess, kalypsov1alpha1.AutoMergeMerged, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newFakeGitProvider("dev", map[string]string{"README.md": "GitOps repo"})
+			spec := gitOpsRepo.DeepCopy()
+			spec.AutoMerge = test.autoMerge
+			gitRepo := newGitRepoWithProvider(ctx, spec, provider)
+
+			content := getTestRepoContent(t)
+			if test.promoted {
+				content.BaseRepo.Commit = "0123456789"
+			}
+			pr, err := gitRepo.CreatePR("deployment/new", content)
+			assert.NoError(t, err)
+			head := provider.branches["deployment/new"]
+			provider.checks[head] = test.checks
+
+			status, err := gitRepo.MergePR(pr.Number)
+			assert.NoError(t, err)
+			assert.Equal(t, pr.Number, status.PullRequest)
+			assert.Equal(t, test.state, status.State)
+			assert.Equal(t, test.message, status.Message)
+
+			if test.state == kalypsov1alpha1.AutoMergeMerged {
+				assert.Equal(t, head, status.MergedSHA)
+				assert.Equal(t, head, provider.branches["dev"])
+
+				// merged PRs are reported as merged
+				status, err = gitRepo.MergePR(pr.Number)
+				assert.NoError(t, err)
+				assert.Equal(t, kalypsov1alpha1.AutoMergeMerged, status.State)
+			}
+		})
+	}
+}
+
+// Test GetPR
+func TestGetPR(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{"README.md": "GitOps repo"})
+	gitRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+
+	pr, err := gitRepo.CreatePR("deployment/new", getTestRepoContent(t))
+	assert.NoError(t, err)
+
+	status, err := gitRepo.GetPR(pr.Number)
+	assert.NoError(t, err)
+	assert.Equal(t, pr, status)
+	assert.Equal(t, kalypsov1alpha1.PullRequestOpen, status.State)
+
+	sha, err := provider.MergePullRequest(1, kalypsov1alpha1.MergeMergeMethod)
+	assert.NoError(t, err)
+	status, err = gitRepo.GetPR(pr.Number)
+	assert.NoError(t, err)
+	assert.Equal(t, kalypsov1alpha1.PullRequestMerged, status.State)
+	assert.Equal(t, sha, status.MergedSHA)
+
+	provider.prs = append(provider.prs, PullRequest{Number: 2, Head: "deployment/rejected", State: PullRequestClosed})
+	status, err = gitRepo.GetPR("2")
+	assert.NoError(t, err)
+	assert.Equal(t, kalypsov1alpha1.PullRequestClosed, status.State)
+	assert.Equal(t, "deployment/rejected", status.Branch)
+
+	_, err = gitRepo.GetPR("3")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func getManifestsYamlString(t *testing.T, filename string) []string {
+	// Read the file
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Errorf("could not read the file: %v", err)
+	}
+
+	return []string{string(data)}
+}
+
+// test update issue
+func TestUpdateIssue(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{})
+	gitRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+
+	message := "test update issue"
+
+	issueNo, err := gitRepo.UpdateIssue(nil, "unit-test", &message)
+	if err != nil {
+		t.Errorf("can't update issue: %v", err)
+	}
+	assert.Equal(t, "unit-test", provider.issues[*issueNo].Title)
+
+	issueNo, err = gitRepo.UpdateIssue(issueNo, "unit-test2", &message)
+	if err != nil {
+		t.Errorf("can't update issue: %v", err)
+	}
+	assert.Equal(t, "unit-test2", provider.issues[*issueNo].Title)
+	assert.Equal(t, message, provider.issues[*issueNo].Body)
+
+	_, err = gitRepo.UpdateIssue(issueNo, "unit-test2", nil)
+	if err != nil {
+		t.Errorf("can't update issue: %v", err)
+	}
+	assert.Equal(t, "closed", provider.issues[*issueNo].State)
+
+	// closing a missing issue is not an error
+	missing := 42
+	_, err = gitRepo.UpdateIssue(&missing, "unit-test2", nil)
+	assert.NoError(t, err)
+}
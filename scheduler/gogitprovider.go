@@ -0,0 +1,425 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-logr/logr"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	goGitRemoteName = "origin"
+	defaultSSHUser  = "git"
+	// the user name to push with a GitHub App installation token
+	gitHubAppUsername = "x-access-token"
+)
+
+// implements GitProvider interface with plain git operations on an in-memory clone of any git remote.
+// It can only work with branches and commits, PRs and issues are not supported.
+type goGitProvider struct {
+	repoUrl string
+	auth    transport.AuthMethod
+	repo    *git.Repository
+	ctx     context.Context
+	logger  logr.Logger
+}
+
+// validate goGitProvider implements GitProvider interface
+var _ GitProvider = (*goGitProvider)(nil)
+
+// new goGitProvider function
+func newGoGitProvider(ctx context.Context, repoUrl string, auth transport.AuthMethod) (*goGitProvider, error) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: goGitRemoteName,
+		URLs: []string{repoUrl},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &goGitProvider{
+		repoUrl: repoUrl,
+		auth:    auth,
+		repo:    repo,
+		ctx:     ctx,
+		logger:  log.FromContext(ctx),
+	}, nil
+}
+
+// getGoGitAuth returns the auth method for the repo URL from the credentials.
+// Over HTTPS the password falls back to the token, so a PAT or a GitHub App installation token works as well.
+func getGoGitAuth(ctx context.Context, repoUrl string, credentials *Credentials) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(repoUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		identity := credentials.SSHIdentity
+		if len(identity) == 0 {
+			return nil, nil
+		}
+		user := endpoint.User
+		if user == "" {
+			user = defaultSSHUser
+		}
+		auth, err := gitssh.NewPublicKeys(user, identity, "")
+		if err != nil {
+			return nil, err
+		}
+		if knownHosts := credentials.SSHKnownHosts; len(knownHosts) > 0 {
+			auth.HostKeyCallback, err = knownHostsCallback(knownHosts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return auth, nil
+	case "http", "https":
+		if credentials.GitHubApp != nil {
+			apiURL, err := getGitHubAPIURL(repoUrl)
+			if err != nil {
+				return nil, err
+			}
+			appTransport, err := getGitHubAppTransport(credentials.GitHubApp, apiURL)
+			if err != nil {
+				return nil, err
+			}
+			token, err := appTransport.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return &githttp.BasicAuth{Username: gitHubAppUsername, Password: token}, nil
+		}
+
+		password := credentials.Password
+		if password == "" {
+			password = credentials.Token
+		}
+		if password == "" {
+			return nil, nil
+		}
+		username := credentials.Username
+		if username == "" {
+			username = defaultSSHUser
+		}
+		return &githttp.BasicAuth{Username: username, Password: password}, nil
+	}
+
+	return nil, nil
+}
+
+// knownHostsCallback verifies the host key against the content of a known_hosts file
+func knownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	type knownHost struct {
+		hosts []string
+		key   ssh.PublicKey
+	}
+
+	var entries []knownHost
+	rest := knownHosts
+	for {
+		_, hosts, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, knownHost{hosts: hosts, key: key})
+		rest = next
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		host := knownhosts.Normalize(hostname)
+		for _, entry := range entries {
+			for _, h := range entry.hosts {
+				if knownhosts.Normalize(h) == host && bytes.Equal(entry.key.Marshal(), key.Marshal()) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("ssh: host key for %s is not in known hosts", hostname)
+	}, nil
+}
+
+// fetch updates the local branches from the remote
+func (g *goGitProvider) fetch() error {
+	err := g.repo.FetchContext(g.ctx, &git.FetchOptions{
+		RemoteName: goGitRemoteName,
+		RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/heads/*"},
+		Auth:       g.auth,
+		Force:      true,
+		Prune:      true,
+	})
+	if err == git.NoErrAlreadyUpToDate || errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil
+	}
+	return err
+}
+
+func (g *goGitProvider) push(refSpec config.RefSpec) error {
+	err := g.repo.PushContext(g.ctx, &git.PushOptions{
+		RemoteName: goGitRemoteName,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       g.auth,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+func (g *goGitProvider) GetBranch(name string) (*Branch, error) {
+	if err := g.fetch(); err != nil {
+		return nil, err
+	}
+
+	ref, err := g.repo.Reference(plumbing.NewBranchReferenceName(name), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, fmt.Errorf("%w: branch %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Branch{Name: name, SHA: ref.Hash().String()}, nil
+}
+
+func (g *goGitProvider) CreateBranch(name string, sha string) (*Branch, error) {
+	refName := plumbing.NewBranchReferenceName(name)
+	err := g.repo.Storer.SetReference(plumbing.NewHashReference(refName, plumbing.NewHash(sha)))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.push(config.RefSpec(refName + ":" + refName)); err != nil {
+		return nil, err
+	}
+
+	return &Branch{Name: name, SHA: sha}, nil
+}
+
+func (g *goGitProvider) DeleteBranch(name string) error {
+	refName := plumbing.NewBranchReferenceName(name)
+	if err := g.push(config.RefSpec(":" + refName)); err != nil {
+		return err
+	}
+	return g.repo.Storer.RemoveReference(refName)
+}
+
+func (g *goGitProvider) GetTree(sha string) ([]TreeEntry, error) {
+	commit, err := g.repo.CommitObject(plumbing.NewHash(sha))
+	if err == plumbing.ErrObjectNotFound {
+		return nil, fmt.Errorf("%w: commit %s", ErrNotFound, sha)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TreeEntry
+	err = tree.Files().ForEach(func(f *object.File) error {
+		entries = append(entries, TreeEntry{Path: f.Name, SHA: f.Hash.String()})
+		return nil
+	})
+	return entries, err
+}
+
+func (g *goGitProvider) GetBlob(sha string) ([]byte, error) {
+	blob, err := g.repo.BlobObject(plumbing.NewHash(sha))
+	if err == plumbing.ErrObjectNotFound {
+		return nil, fmt.Errorf("%w: blob %s", ErrNotFound, sha)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// CommitFiles checks out the branch, applies the changes, commits them and pushes the branch.
+// The push is not forced unless the commit is, so it fails if the remote branch has moved since it was fetched.
+func (g *goGitProvider) CommitFiles(branch *Branch, commit *Commit, changes []FileChange) (*Branch, error) {
+	refName := plumbing.NewBranchReferenceName(branch.Name)
+	if commit.Force {
+		// reset the branch to the commit the new one is created on top of
+		if err := g.repo.Storer.SetReference(plumbing.NewHashReference(refName, plumbing.NewHash(branch.SHA))); err != nil {
+			return nil, err
+		}
+	} else {
+		ref, err := g.repo.Reference(refName, true)
+		if err != nil {
+			return nil, err
+		}
+		if ref.Hash().String() != branch.SHA {
+			return nil, fmt.Errorf("branch %s has moved to %s", branch.Name, ref.Hash().String())
+		}
+	}
+
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	err = worktree.Checkout(&git.CheckoutOptions{Branch: refName, Force: true})
+	if err != nil {
+		return nil, err
+	}
+
+	fs := worktree.Filesystem
+	for _, change := range changes {
+		if change.Action == FileDelete {
+			if err := fs.Remove(change.Path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		if err := fs.MkdirAll(path.Dir(change.Path), 0755); err != nil {
+			return nil, err
+		}
+		if err := util.WriteFile(fs, change.Path, []byte(change.Content), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	err = worktree.AddWithOptions(&git.AddOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := worktree.Commit(commit.Message, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  commit.AuthorName,
+			Email: commit.AuthorEmail,
+			When:  time.Now(),
+		},
+		AllowEmptyCommits: true,
+		Signer:            commit.Signer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refSpec := config.RefSpec(refName + ":" + refName)
+	if commit.Force {
+		refSpec = "+" + refSpec
+	}
+	if err := g.push(refSpec); err != nil {
+		return nil, err
+	}
+
+	return &Branch{Name: branch.Name, SHA: hash.String()}, nil
+}
+
+func (g *goGitProvider) ListPullRequests(baseBranch string) ([]PullRequest, error) {
+	return nil, ErrNotSupported
+}
+
+func (g *goGitProvider) GetPullRequest(number int) (*PullRequest, error) {
+	return nil, ErrNotSupported
+}
+
+func (g *goGitProvider) MergePullRequest(number int, method schedulerv1alpha1.MergeMethod) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (g *goGitProvider) GetCheckState(sha string) (CheckState, error) {
+	return "", ErrNotSupported
+}
+
+func (g *goGitProvider) CreatePullRequest(pr *PullRequest) (*PullRequest, error) {
+	return nil, ErrNotSupported
+}
+
+func (g *goGitProvider) UpdatePullRequest(number int, title, body string) error {
+	return ErrNotSupported
+}
+
+func (g *goGitProvider) ClosePullRequest(number int) error {
+	return ErrNotSupported
+}
+
+func (g *goGitProvider) DismissApprovals(number int, message string) error {
+	return ErrNotSupported
+}
+
+func (g *goGitProvider) AddLabels(number int, labels []string) error {
+	return ErrNotSupported
+}
+
+func (g *goGitProvider) RemoveLabel(number int, label string) error {
+	return ErrNotSupported
+}
+
+func (g *goGitProvider) GetIssue(number int) (*Issue, error) {
+	return nil, ErrNotSupported
+}
+
+func (g *goGitProvider) CreateIssue(title, body string) (*Issue, error) {
+	return nil, ErrNotSupported
+}
+
+func (g *goGitProvider) UpdateIssue(number int, title, body string) error {
+	return ErrNotSupported
+}
+
+func (g *goGitProvider) CloseIssue(number int) error {
+	return ErrNotSupported
+}
+
+func (g *goGitProvider) MaxPullRequestBodySize() int {
+	return 0
+}
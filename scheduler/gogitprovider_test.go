@@ -0,0 +1,213 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newBareTestRepo creates a bare repo on disk with the files committed to the branch
+func newBareTestRepo(t *testing.T, branch string, files map[string]string) string {
+	dir := t.TempDir()
+	_, err := git.PlainInit(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, _ := seed.Worktree()
+	for path, content := range files {
+		if err := util.WriteFile(worktree.Filesystem, path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _ = worktree.Add(".")
+	_, err = worktree.Commit("initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@email.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = seed.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"file://" + dir}})
+	err = seed.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec("refs/heads/master:refs/heads/" + branch)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "file://" + dir
+}
+
+// readBareTestRepo returns the files of the branch in the bare repo
+func readBareTestRepo(t *testing.T, repoUrl, branch string) map[string]string {
+	repo, err := git.PlainOpen(repoUrl[len("file://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, _ := repo.CommitObject(ref.Hash())
+	tree, _ := commit.Tree()
+
+	files := map[string]string{}
+	_ = tree.Files().ForEach(func(f *object.File) error {
+		files[f.Name], _ = f.Contents()
+		return nil
+	})
+	return files
+}
+
+// Test CreatePR with direct push delivery to a local repo
+func TestDirectPush(t *testing.T) {
+	repoUrl := newBareTestRepo(t, "dev", map[string]string{
+		"README.md":                        "GitOps repo",
+		"drone/README.md":                  readmeContent,
+		"drone/old-target/reconciler.yaml": "old",
+	})
+
+	spec := &kalypsov1alpha1.GitOpsRepoSpec{
+		ManifestsSpec: kalypsov1alpha1.ManifestsSpec{Repo: repoUrl, Branch: "dev", Path: "."},
+		Delivery:      kalypsov1alpha1.DirectPushDelivery,
+	}
+	gitRepo, err := NewGitRepo(ctx, spec, nil, nil)
+	assert.NoError(t, err)
+
+	content := getTestRepoContent(t)
+	content.ClusterTypes["drone"].DeploymentTargets["hello-world-app-functional-test"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests:        []string{"reconciler"},
+		NamespaceManifests:         []string{"namespace"},
+		ConfigManifests:            []string{"KEY=value"},
+		ConfigManifestsContentType: kalypsov1alpha1.EnvContentType,
+	}
+
+	pr, err := gitRepo.CreatePR("deployment/new", content)
+	assert.NoError(t, err)
+	assert.Nil(t, pr)
+
+	files := readBareTestRepo(t, repoUrl, "dev")
+	assert.Equal(t, map[string]string{
+		"README.md":       "GitOps repo",
+		"drone/README.md": readmeContent,
+		"drone/hello-world-app-functional-test/reconciler.yaml":    "reconciler",
+		"drone/hello-world-app-functional-test/namespace.yaml":     "namespace",
+		"drone/hello-world-app-functional-test/platform-config.sh": "KEY=value",
+	}, files)
+
+	// the same content is not pushed again
+	_, err = gitRepo.CreatePR("deployment/new", content)
+	assert.True(t, errors.Is(err, ErrNoChanges))
+
+	// no PR branches are created
+	provider, err := newGoGitProvider(ctx, repoUrl, nil)
+	assert.NoError(t, err)
+	_, err = provider.GetBranch("deployment/new")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// issues are ignored
+	message := "message"
+	issueNo, err := gitRepo.UpdateIssue(nil, "title", &message)
+	assert.NoError(t, err)
+	assert.Nil(t, issueNo)
+}
+
+// Test goGitProvider branch operations
+func TestGoGitProvider(t *testing.T) {
+	repoUrl := newBareTestRepo(t, "main", map[string]string{"README.md": "GitOps repo"})
+
+	provider, err := newGoGitProvider(ctx, repoUrl, nil)
+	assert.NoError(t, err)
+
+	main, err := provider.GetBranch("main")
+	assert.NoError(t, err)
+
+	branch, err := provider.CreateBranch("feature", main.SHA)
+	assert.NoError(t, err)
+
+	tree, err := provider.GetTree(branch.SHA)
+	assert.NoError(t, err)
+	assert.Len(t, tree, 1)
+	blob, err := provider.GetBlob(tree[0].SHA)
+	assert.NoError(t, err)
+	assert.Equal(t, "GitOps repo", string(blob))
+
+	branch, err = provider.CommitFiles(branch, &Commit{Message: "commit", AuthorName: "name", AuthorEmail: "email"}, []FileChange{
+		{Path: "a/b/c.yaml", Content: "c", Action: FileCreate},
+		{Path: "README.md", Action: FileDelete},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a/b/c.yaml": "c"}, readBareTestRepo(t, repoUrl, "feature"))
+
+	// the forced commit replaces the branch history with a commit on top of the given one
+	_, err = provider.CommitFiles(&Branch{Name: "feature", SHA: main.SHA}, &Commit{Message: "commit", Force: true}, []FileChange{
+		{Path: "d.yaml", Content: "d", Action: FileCreate},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"README.md": "GitOps repo", "d.yaml": "d"}, readBareTestRepo(t, repoUrl, "feature"))
+
+	// a stale branch head is rejected
+	_, err = provider.CommitFiles(main, &Commit{Message: "commit"}, nil)
+	assert.NoError(t, err)
+	_, err = provider.CommitFiles(main, &Commit{Message: "commit"}, nil)
+	assert.Error(t, err)
+
+	assert.NoError(t, provider.DeleteBranch("feature"))
+	_, err = provider.GetBranch("feature")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = provider.CreatePullRequest(&PullRequest{})
+	assert.True(t, errors.Is(err, ErrNotSupported))
+}
+
+// Test knownHostsCallback
+func TestKnownHostsCallback(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(privateKey)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+
+	knownHosts := knownhosts.Line([]string{"git.contoso.com"}, signer.PublicKey())
+	callback, err := knownHostsCallback([]byte(knownHosts + "\n"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, callback("git.contoso.com:22", nil, signer.PublicKey()))
+	assert.Error(t, callback("git.contoso.com:22", nil, otherSigner.PublicKey()))
+	assert.Error(t, callback("git.fabrikam.com:22", nil, signer.PublicKey()))
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+)
+
+const (
+	defaultDeploymentTargetPath  = "{{.ClusterType}}/{{.DeploymentTarget}}"
+	defaultClusterTypeReadmePath = "{{.ClusterType}}/README.md"
+)
+
+// layoutVariables are available in the layout templates
+type layoutVariables struct {
+	ClusterType      string
+	DeploymentTarget string
+	Workload         string
+	Workspace        string
+	Environment      string
+}
+
+// the names of the layout variables in the order of the placeholders
+var layoutVariableNames = []string{"ClusterType", "DeploymentTarget", "Workload", "Workspace", "Environment"}
+
+// layoutTemplate is a path template along with a regular expression matching all paths it can render
+type layoutTemplate struct {
+	template *template.Template
+	// the first capture group is the rendered path, the next ones are the variables
+	pattern *regexp.Regexp
+	// the layout variables of the capture groups of the pattern
+	groups []string
+}
+
+// repoLayout places the manifests in the GitOps repo and tells which files of the repo are managed by the scheduler
+type repoLayout struct {
+	deploymentTargetPath *layoutTemplate
+	// nil if there are no READMEs
+	clusterTypeReadmePath *layoutTemplate
+}
+
+// deploymentTargetFolder is a folder of a deployment target found in the repo
+type deploymentTargetFolder struct {
+	clusterType      string
+	deploymentTarget string
+	path             string
+}
+
+func newRepoLayout(spec *schedulerv1alpha1.LayoutSpec) (*repoLayout, error) {
+	deploymentTargetPath, clusterTypeReadmePath := defaultDeploymentTargetPath, defaultClusterTypeReadmePath
+	if spec != nil {
+		deploymentTargetPath, clusterTypeReadmePath = spec.DeploymentTargetPath, spec.ClusterTypeReadmePath
+	}
+
+	layout := &repoLayout{}
+	var err error
+	// the folder contains the files of the deployment target
+	layout.deploymentTargetPath, err = newLayoutTemplate(deploymentTargetPath, "/.+")
+	if err != nil {
+		return nil, fmt.Errorf("invalid deployment target path %q: %w", deploymentTargetPath, err)
+	}
+	if clusterTypeReadmePath != "" {
+		layout.clusterTypeReadmePath, err = newLayoutTemplate(clusterTypeReadmePath, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster type README path %q: %w", clusterTypeReadmePath, err)
+		}
+	}
+	return layout, nil
+}
+
+// newLayoutTemplate parses the template and builds the pattern of its paths.
+// The template is rendered with a placeholder per variable, the placeholders are replaced with the capture groups.
+func newLayoutTemplate(text string, suffix string) (*layoutTemplate, error) {
+	tmpl, err := template.New("layout").Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	placeholders := layoutVariables{}
+	for i, field := range []*string{&placeholders.ClusterType, &placeholders.DeploymentTarget, &placeholders.Workload, &placeholders.Workspace, &placeholders.Environment} {
+		*field = fmt.Sprintf("\x00%d\x00", i)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, placeholders); err != nil {
+		return nil, err
+	}
+
+	layout := &layoutTemplate{template: tmpl}
+	placeholderPattern := regexp.MustCompile("\x00([0-9])\x00")
+	expression := placeholderPattern.ReplaceAllStringFunc(regexp.QuoteMeta(rendered.String()), func(placeholder string) string {
+		index := int(placeholder[1] - '0')
+		layout.groups = append(layout.groups, layoutVariableNames[index])
+		// the names of the objects never start with a dot, so the hidden folders like .github are not matched
+		return "([^/.][^/]*)"
+	})
+	if strings.Contains(expression, "\x00") {
+		return nil, fmt.Errorf("the variables can't be changed by the template functions")
+	}
+
+	layout.pattern, err = regexp.Compile("^(" + expression + ")" + suffix + "$")
+	if err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// render returns the clean relative path of the template
+func (t *layoutTemplate) render(variables layoutVariables) (string, error) {
+	var rendered bytes.Buffer
+	if err := t.template.Execute(&rendered, variables); err != nil {
+		return "", err
+	}
+
+	result := path.Clean(strings.TrimSpace(rendered.String()))
+	if result == "." || strings.HasPrefix(result, "/") || result == ".." || strings.HasPrefix(result, "../") {
+		return "", fmt.Errorf("the layout path %q must be a relative path in the repo", rendered.String())
+	}
+	return result, nil
+}
+
+// getDeploymentTargetPath returns the folder of the deployment target manifests
+func (l *repoLayout) getDeploymentTargetPath(clusterType, deploymentTarget string, metadata schedulerv1alpha1.DeploymentTargetMetadata) (string, error) {
+	return l.deploymentTargetPath.render(layoutVariables{
+		ClusterType:      clusterType,
+		DeploymentTarget: deploymentTarget,
+		Workload:         metadata.Workload,
+		Workspace:        metadata.Workspace,
+		Environment:      metadata.Environment,
+	})
+}
+
+// getClusterTypeReadmePath returns the README path of the cluster type or an empty string if there is no README
+func (l *repoLayout) getClusterTypeReadmePath(clusterType string) (string, error) {
+	if l.clusterTypeReadmePath == nil {
+		return "", nil
+	}
+	return l.clusterTypeReadmePath.render(layoutVariables{ClusterType: clusterType})
+}
+
+// isManaged tells if the file could have been generated by the scheduler, so it's deleted if it's not generated anymore
+func (l *repoLayout) isManaged(filePath string) bool {
+	if l.deploymentTargetPath.pattern.MatchString(filePath) {
+		return true
+	}
+	return l.clusterTypeReadmePath != nil && l.clusterTypeReadmePath.pattern.MatchString(filePath)
+}
+
+// getDeploymentTargetFolder finds out the deployment target of a managed file.
+// The variables that are not in the template are empty.
+func (l *repoLayout) getDeploymentTargetFolder(filePath string) (*deploymentTargetFolder, bool) {
+	match := l.deploymentTargetPath.pattern.FindStringSubmatch(filePath)
+	if match == nil {
+		return nil, false
+	}
+
+	folder := &deploymentTargetFolder{path: match[1]}
+	for i, group := range l.deploymentTargetPath.groups {
+		switch group {
+		case "ClusterType":
+			folder.clusterType = match[i+2]
+		case "DeploymentTarget":
+			folder.deploymentTarget = match[i+2]
+		}
+	}
+	return folder, true
+}
+
+// getDeploymentTargetFolders returns the folders of all deployment targets in the content by their paths
+func (l *repoLayout) getDeploymentTargetFolders(content *schedulerv1alpha1.RepoContentType) (map[string]*deploymentTargetFolder, error) {
+	folders := make(map[string]*deploymentTargetFolder)
+	for clusterType, clusterTypeContent := range content.ClusterTypes {
+		for deploymentTarget := range clusterTypeContent.DeploymentTargets {
+			folderPath, err := l.getDeploymentTargetPath(clusterType, deploymentTarget, clusterTypeContent.Metadata[deploymentTarget])
+			if err != nil {
+				return nil, err
+			}
+			if other, ok := folders[folderPath]; ok {
+				return nil, fmt.Errorf("deployment targets %s/%s and %s/%s have the same folder %s in the layout",
+					other.clusterType, other.deploymentTarget, clusterType, deploymentTarget, folderPath)
+			}
+			folders[folderPath] = &deploymentTargetFolder{clusterType: clusterType, deploymentTarget: deploymentTarget, path: folderPath}
+		}
+	}
+	return folders, nil
+}
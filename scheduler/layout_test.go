@@ -0,0 +1,150 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+var argoLayout = &kalypsov1alpha1.LayoutSpec{
+	DeploymentTargetPath: "clusters/{{.ClusterType}}/apps/{{.Workload}}/{{.DeploymentTarget}}",
+}
+
+// Test the default layout of the repo
+func TestDefaultRepoLayout(t *testing.T) {
+	layout, err := newRepoLayout(nil)
+	assert.NoError(t, err)
+
+	path, err := layout.getDeploymentTargetPath("drone", "functional-test", kalypsov1alpha1.DeploymentTargetMetadata{Workload: "hello-world-app"})
+	assert.NoError(t, err)
+	assert.Equal(t, "drone/functional-test", path)
+
+	path, err = layout.getClusterTypeReadmePath("drone")
+	assert.NoError(t, err)
+	assert.Equal(t, "drone/README.md", path)
+
+	assert.True(t, layout.isManaged("drone/functional-test/reconciler.yaml"))
+	assert.True(t, layout.isManaged("drone/README.md"))
+	assert.False(t, layout.isManaged("README.md"))
+	assert.False(t, layout.isManaged(".github/tracking/Promoted_Commit_Id"))
+
+	folder, ok := layout.getDeploymentTargetFolder("drone/functional-test/reconciler.yaml")
+	assert.True(t, ok)
+	assert.Equal(t, &deploymentTargetFolder{clusterType: "drone", deploymentTarget: "functional-test", path: "drone/functional-test"}, folder)
+	_, ok = layout.getDeploymentTargetFolder("drone/README.md")
+	assert.False(t, ok)
+}
+
+// Test a layout with a template
+func TestRepoLayoutTemplate(t *testing.T) {
+	layout, err := newRepoLayout(&kalypsov1alpha1.LayoutSpec{
+		DeploymentTargetPath:  "{{.Environment}}/clusters/{{.ClusterType | lower}}/{{.Workspace}}-{{.Workload}}/{{.DeploymentTarget}}",
+		ClusterTypeReadmePath: "docs/{{.ClusterType}}.md",
+	})
+	assert.NoError(t, err)
+
+	path, err := layout.getDeploymentTargetPath("Drone", "functional-test", kalypsov1alpha1.DeploymentTargetMetadata{
+		Workload:    "hello-world-app",
+		Workspace:   "kaizen-app-team",
+		Environment: "dev",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "dev/clusters/drone/kaizen-app-team-hello-world-app/functional-test", path)
+
+	path, err = layout.getClusterTypeReadmePath("drone")
+	assert.NoError(t, err)
+	assert.Equal(t, "docs/drone.md", path)
+
+	assert.True(t, layout.isManaged(path))
+	assert.True(t, layout.isManaged("dev/clusters/drone/kaizen-app-team-hello-world-app/functional-test/config/platform-config.sh"))
+	assert.False(t, layout.isManaged("dev/apps/drone/kaizen-app-team-hello-world-app/functional-test/reconciler.yaml"))
+	assert.False(t, layout.isManaged("docs/drone/README.md"))
+
+	// the files in the sub folders belong to the deployment target
+	folder, ok := layout.getDeploymentTargetFolder("dev/clusters/drone/kaizen-app-team-hello-world-app/functional-test/config/platform-config.sh")
+	assert.True(t, ok)
+	assert.Equal(t, &deploymentTargetFolder{
+		clusterType:      "drone",
+		deploymentTarget: "functional-test",
+		path:             "dev/clusters/drone/kaizen-app-team-hello-world-app/functional-test",
+	}, folder)
+
+	// no READMEs
+	layout, err = newRepoLayout(argoLayout)
+	assert.NoError(t, err)
+	path, err = layout.getClusterTypeReadmePath("drone")
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+	assert.False(t, layout.isManaged("drone/README.md"))
+
+	// invalid templates
+	_, err = newRepoLayout(&kalypsov1alpha1.LayoutSpec{DeploymentTargetPath: "{{.ClusterType"})
+	assert.Error(t, err)
+	_, err = newRepoLayout(&kalypsov1alpha1.LayoutSpec{DeploymentTargetPath: "{{.Cluster}}"})
+	assert.Error(t, err)
+	_, err = newRepoLayout(&kalypsov1alpha1.LayoutSpec{DeploymentTargetPath: "{{.ClusterType | trunc 2}}"})
+	assert.Error(t, err)
+
+	layout, err = newRepoLayout(&kalypsov1alpha1.LayoutSpec{DeploymentTargetPath: "../{{.ClusterType}}"})
+	assert.NoError(t, err)
+	_, err = layout.getDeploymentTargetPath("drone", "functional-test", kalypsov1alpha1.DeploymentTargetMetadata{})
+	assert.Error(t, err)
+}
+
+// Test CreatePR with a layout template
+func TestCreatePRWithLayout(t *testing.T) {
+	provider := newFakeGitProvider("dev", map[string]string{
+		"README.md":       "GitOps repo",
+		"drone/README.md": readmeContent,
+		"clusters/drone/apps/old-app/old-target/reconciler.yaml":                         "old",
+		"clusters/drone/apps/hello-world-app/hello-world-app-functional-test/extra.yaml": "kept",
+	})
+
+	repo := *gitOpsRepo
+	repo.Layout = argoLayout
+	gitRepo := newGitRepoWithProvider(ctx, &repo, provider)
+
+	content := getTestRepoContent(t)
+	content.ClusterTypes["drone"].Metadata["hello-world-app-functional-test"] = kalypsov1alpha1.DeploymentTargetMetadata{Workload: "hello-world-app"}
+
+	pr, err := gitRepo.CreatePR("deployment/new", content)
+	assert.NoError(t, err)
+
+	files := provider.files("deployment/new")
+	assert.NotEmpty(t, files["clusters/drone/apps/hello-world-app/hello-world-app-functional-test/reconciler.yaml"])
+	assert.NotEmpty(t, files["clusters/drone/apps/hello-world-app/hello-world-app-functional-test/namespace.yaml"])
+	assert.Equal(t, "kept", files["clusters/drone/apps/hello-world-app/hello-world-app-functional-test/extra.yaml"])
+	assert.NotContains(t, files, "clusters/drone/apps/old-app/old-target/reconciler.yaml")
+	assert.NotContains(t, files, "drone/hello-world-app-functional-test/reconciler.yaml")
+	// the files out of the layout are not touched
+	assert.Equal(t, readmeContent, files["drone/README.md"])
+	assert.Equal(t, "GitOps repo", files["README.md"])
+
+	body := provider.openPullRequests()[0].Body
+	assert.Equal(t, "1", pr.Number)
+	assert.Contains(t, body, "| hello-world-app-functional-test | modified |")
+	assert.Contains(t, body, "| old-target | removed |")
+
+	// the deployment targets can't share a folder
+	repo.Layout = &kalypsov1alpha1.LayoutSpec{DeploymentTargetPath: "clusters/{{.ClusterType}}"}
+	content.ClusterTypes["drone"].DeploymentTargets["another-target"] = kalypsov1alpha1.AssignmentPackageSpec{}
+	_, err = gitRepo.CreatePR("deployment/newer", content)
+	assert.Error(t, err)
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	ociURLPrefix = "oci://"
+	// the media types of the Flux artifacts, so they can be pulled with an OCIRepository
+	ociConfigMediaType  types.MediaType = "application/vnd.cncf.flux.config.v1+json"
+	ociContentMediaType types.MediaType = "application/vnd.cncf.flux.content.v1.tar+gzip"
+	// the tag that always refers to the last published artifact
+	ociLatestTag = "latest"
+
+	ociSourceAnnotation      = "org.opencontainers.image.source"
+	ociRevisionAnnotation    = "org.opencontainers.image.revision"
+	ociContentHashAnnotation = "scheduler.kalypso.io/content-hash"
+	ociClusterTypeAnnotation = "scheduler.kalypso.io/cluster-type"
+)
+
+// OCIRepo publishes the manifests to an OCI repository
+type OCIRepo interface {
+	// Publish pushes an artifact with the manifests of every cluster type, tagged with the content hash
+	Publish(content *schedulerv1alpha1.RepoContentType, contentHash string) ([]schedulerv1alpha1.OCIArtifactStatus, error)
+}
+
+// implements OCIRepo interface with go-containerregistry
+type ociRepo struct {
+	spec     *schedulerv1alpha1.OCIRepositorySpec
+	keychain authn.Keychain
+	ctx      context.Context
+	logger   logr.Logger
+}
+
+// validate ociRepo implements OCIRepo interface
+var _ OCIRepo = (*ociRepo)(nil)
+
+// new ociRepo function, nil credentials stand for the default docker config of the scheduler
+func NewOCIRepo(ctx context.Context, spec *schedulerv1alpha1.OCIRepositorySpec, credentials *RegistryCredentials) (OCIRepo, error) {
+	if !strings.HasPrefix(spec.URL, ociURLPrefix) {
+		return nil, fmt.Errorf("OCI repository URL %s must start with %s", spec.URL, ociURLPrefix)
+	}
+
+	var keychain authn.Keychain = authn.DefaultKeychain
+	if credentials != nil {
+		keychain = credentials
+	}
+
+	return &ociRepo{
+		spec:     spec,
+		keychain: keychain,
+		ctx:      ctx,
+		logger:   log.FromContext(ctx),
+	}, nil
+}
+
+// implement Publish function
+func (o *ociRepo) Publish(content *schedulerv1alpha1.RepoContentType, contentHash string) ([]schedulerv1alpha1.OCIArtifactStatus, error) {
+	artifacts := []schedulerv1alpha1.OCIArtifactStatus{}
+	for _, clusterType := range sortedKeys(content.ClusterTypes) {
+		artifact, err := o.publishClusterType(clusterType, content, contentHash)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, *artifact)
+	}
+	return artifacts, nil
+}
+
+func (o *ociRepo) publishClusterType(clusterType string, content *schedulerv1alpha1.RepoContentType, contentHash string) (*schedulerv1alpha1.OCIArtifactStatus, error) {
+	url := strings.TrimSuffix(o.spec.URL, "/") + "/" + clusterType
+	var nameOptions []name.Option
+	if o.spec.Insecure {
+		nameOptions = append(nameOptions, name.Insecure)
+	}
+	repository, err := name.NewRepository(strings.TrimPrefix(url, ociURLPrefix), nameOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := buildArtifact(clusterType, content, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	o.logger.Info("Pushing OCI artifact", "repository", repository.String(), "tag", contentHash, "digest", digest.String())
+	options := []remote.Option{remote.WithContext(o.ctx), remote.WithAuthFromKeychain(o.keychain)}
+	if err := remote.Write(repository.Tag(contentHash), image, options...); err != nil {
+		return nil, err
+	}
+	if err := remote.Tag(repository.Tag(ociLatestTag), image, options...); err != nil {
+		return nil, err
+	}
+
+	return &schedulerv1alpha1.OCIArtifactStatus{
+		ClusterType: clusterType,
+		URL:         url,
+		Tag:         contentHash,
+		Digest:      digest.String(),
+	}, nil
+}
+
+// buildArtifact builds a Flux artifact with the deployment target folders of the cluster type.
+// The artifact is reproducible, so the same content has the same digest.
+func buildArtifact(clusterType string, content *schedulerv1alpha1.RepoContentType, contentHash string) (v1.Image, error) {
+	files := make(map[string]string)
+	clusterTypeContent := content.ClusterTypes[clusterType]
+	for deploymentTarget, dt := range clusterTypeContent.DeploymentTargets {
+		for fileName, fileContent := range getDeploymentTargetFiles(dt) {
+			files[deploymentTarget+"/"+fileName] = fileContent
+		}
+	}
+
+	layer, err := buildTarball(files)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]string{
+		ociContentHashAnnotation: contentHash,
+		ociClusterTypeAnnotation: clusterType,
+	}
+	if content.BaseRepo.Repo != "" {
+		annotations[ociSourceAnnotation] = content.BaseRepo.Repo
+	}
+	if content.BaseRepo.Commit != "" {
+		// the Flux format of the revision
+		annotations[ociRevisionAnnotation] = fmt.Sprintf("%s@sha1:%s", content.BaseRepo.Branch, content.BaseRepo.Commit)
+	}
+
+	image := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	image = mutate.ConfigMediaType(image, ociConfigMediaType)
+	image = mutate.Annotations(image, annotations).(v1.Image)
+	return mutate.Append(image, mutate.Addendum{Layer: static.NewLayer(layer, ociContentMediaType)})
+}
+
+// buildTarball returns a gzipped tarball of the files in a stable order without timestamps
+func buildTarball(files map[string]string) ([]byte, error) {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, path := range sortedKeys(files) {
+		err := tarWriter.WriteHeader(&tar.Header{
+			Name:     path,
+			Mode:     0644,
+			Size:     int64(len(files[path])),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Unix(0, 0),
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write([]byte(files[path])); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// RegistryCredentials are the credentials of the OCI registries from a docker config
+type RegistryCredentials struct {
+	auths map[string]authn.AuthConfig
+}
+
+// validate RegistryCredentials is a keychain
+var _ authn.Keychain = (*RegistryCredentials)(nil)
+
+// NewRegistryCredentialsFromSecret reads the credentials from a kubernetes.io/dockerconfigjson secret
+func NewRegistryCredentialsFromSecret(secret *corev1.Secret) (*RegistryCredentials, error) {
+	data, ok := secret.Data[corev1.DockerConfigJsonKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s must contain %s", secret.Name, corev1.DockerConfigJsonKey)
+	}
+
+	var config struct {
+		Auths map[string]authn.AuthConfig `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid %s in secret %s: %w", corev1.DockerConfigJsonKey, secret.Name, err)
+	}
+
+	credentials := &RegistryCredentials{auths: make(map[string]authn.AuthConfig)}
+	for host, auth := range config.Auths {
+		// the hosts are sometimes URLs like https://index.docker.io/v1/
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+		host, _, _ = strings.Cut(host, "/")
+		if host == "docker.io" {
+			host = name.DefaultRegistry
+		}
+		credentials.auths[host] = auth
+	}
+	return credentials, nil
+}
+
+// Resolve returns the credentials of the registry or anonymous access
+func (c *RegistryCredentials) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	auth, ok := c.auths[resource.RegistryStr()]
+	if !ok {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(auth), nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newTestRegistry starts an in-process registry that requires the user and the password
+func newTestRegistry(t *testing.T) *httptest.Server {
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// readTestArtifact returns the files of the artifact layer
+func readTestArtifact(t *testing.T, reader io.Reader) map[string]string {
+	gzipReader, err := gzip.NewReader(reader)
+	assert.NoError(t, err)
+	tarReader := tar.NewReader(gzipReader)
+
+	files := map[string]string{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(tarReader)
+		files[header.Name] = string(content)
+	}
+	return files
+}
+
+// Test publishing the content to an OCI registry
+func TestOCIRepoPublish(t *testing.T) {
+	server := newTestRegistry(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	credentials, err := NewRegistryCredentialsFromSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"http://` + host + `/v2/":{"auth":"dXNlcjpwYXNzd29yZA=="}}}`),
+		},
+	})
+	assert.NoError(t, err)
+
+	spec := &kalypsov1alpha1.OCIRepositorySpec{URL: "oci://" + host + "/kalypso-gitops"}
+	ociRepo, err := NewOCIRepo(ctx, spec, credentials)
+	assert.NoError(t, err)
+
+	content := getTestRepoContent(t)
+	content.BaseRepo.Repo = "https://github.com/microsoft/kalypso-control-plane"
+	content.BaseRepo.Branch = "main"
+	content.BaseRepo.Commit = "0123456789"
+	content.ClusterTypes["large"] = *kalypsov1alpha1.NewClusterContentType()
+
+	artifacts, err := ociRepo.Publish(content, "12345")
+	assert.NoError(t, err)
+	assert.Len(t, artifacts, 2)
+	assert.Equal(t, "drone", artifacts[0].ClusterType)
+	assert.Equal(t, "oci://"+host+"/kalypso-gitops/drone", artifacts[0].URL)
+	assert.Equal(t, "12345", artifacts[0].Tag)
+	assert.True(t, strings.HasPrefix(artifacts[0].Digest, "sha256:"))
+	assert.Equal(t, "large", artifacts[1].ClusterType)
+
+	// the artifact is pulled by the tag, the digest and the latest tag
+	for _, reference := range []string{host + "/kalypso-gitops/drone:12345", host + "/kalypso-gitops/drone@" + artifacts[0].Digest, host + "/kalypso-gitops/drone:latest"} {
+		ref, err := name.ParseReference(reference)
+		assert.NoError(t, err)
+		image, err := remote.Image(ref, remote.WithAuthFromKeychain(credentials))
+		assert.NoError(t, err)
+		digest, _ := image.Digest()
+		assert.Equal(t, artifacts[0].Digest, digest.String())
+	}
+
+	ref, _ := name.ParseReference(host + "/kalypso-gitops/drone:12345")
+	image, err := remote.Image(ref, remote.WithAuthFromKeychain(credentials))
+	assert.NoError(t, err)
+
+	manifest, err := image.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, ociConfigMediaType, manifest.Config.MediaType)
+	assert.Equal(t, map[string]string{
+		ociSourceAnnotation:      "https://github.com/microsoft/kalypso-control-plane",
+		ociRevisionAnnotation:    "main@sha1:0123456789",
+		ociContentHashAnnotation: "12345",
+		ociClusterTypeAnnotation: "drone",
+	}, manifest.Annotations)
+
+	layers, err := image.Layers()
+	assert.NoError(t, err)
+	assert.Len(t, layers, 1)
+	mediaType, _ := layers[0].MediaType()
+	assert.Equal(t, ociContentMediaType, mediaType)
+	reader, err := layers[0].Compressed()
+	assert.NoError(t, err)
+	files := readTestArtifact(t, reader)
+	assert.Len(t, files, 2)
+	assert.True(t, strings.HasPrefix(files["hello-world-app-functional-test/reconciler.yaml"], "apiVersion"))
+	assert.NotEmpty(t, files["hello-world-app-functional-test/namespace.yaml"])
+
+	// the same content has the same digest
+	again, err := ociRepo.Publish(content, "12345")
+	assert.NoError(t, err)
+	assert.Equal(t, artifacts, again)
+
+	// no access without the credentials
+	anonymous, err := NewOCIRepo(ctx, spec, &RegistryCredentials{})
+	assert.NoError(t, err)
+	_, err = anonymous.Publish(content, "12345")
+	assert.Error(t, err)
+
+	_, err = NewOCIRepo(ctx, &kalypsov1alpha1.OCIRepositorySpec{URL: host + "/kalypso-gitops"}, nil)
+	assert.Error(t, err)
+}
+
+// Test NewRegistryCredentialsFromSecret
+func TestNewRegistryCredentialsFromSecret(t *testing.T) {
+	credentials, err := NewRegistryCredentialsFromSecret(&corev1.Secret{
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"ghcr.io":{"username":"user","password":"token"},"https://index.docker.io/v1/":{"auth":"dXNlcjpwYXNzd29yZA=="}}}`),
+		},
+	})
+	assert.NoError(t, err)
+
+	registry, _ := name.NewRegistry("ghcr.io")
+	authenticator, err := credentials.Resolve(registry)
+	assert.NoError(t, err)
+	config, err := authenticator.Authorization()
+	assert.NoError(t, err)
+	assert.Equal(t, "user", config.Username)
+	assert.Equal(t, "token", config.Password)
+
+	registry, _ = name.NewRegistry("docker.io")
+	authenticator, _ = credentials.Resolve(registry)
+	config, _ = authenticator.Authorization()
+	assert.Equal(t, "password", config.Password)
+
+	registry, _ = name.NewRegistry("contoso.azurecr.io")
+	authenticator, _ = credentials.Resolve(registry)
+	assert.Equal(t, authn.Anonymous, authenticator)
+
+	_, err = NewRegistryCredentialsFromSecret(&corev1.Secret{})
+	assert.Error(t, err)
+}
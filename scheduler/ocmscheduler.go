@@ -0,0 +1,113 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	PlacementPluginName = "Placement"
+
+	// PlacementLabel is the label of the Open Cluster Management PlacementDecisions with the name of their Placement
+	PlacementLabel = "cluster.open-cluster-management.io/placement"
+)
+
+var (
+	PlacementDecisionGVK     = runtimeschema.GroupVersionKind{Group: "cluster.open-cluster-management.io", Version: "v1beta1", Kind: "PlacementDecision"}
+	placementDecisionListGVK = PlacementDecisionGVK.GroupVersion().WithKind(PlacementDecisionGVK.Kind + "List")
+)
+
+// placementPlugin selects the cluster types with the decisions of the Open Cluster Management Placement
+// the scheduling policy refers to. The OCM cluster names in the decisions are the names of the cluster types.
+type placementPlugin struct {
+	clusterNames map[string]bool
+}
+
+// validate placementPlugin implements SelectPlugin interface
+var _ SelectPlugin = (*placementPlugin)(nil)
+
+// newPlacementPlugin reads the current decisions of the placement the scheduling policy refers to
+func newPlacementPlugin(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	placement := schedulingPolicy.Spec.ClusterTypeSelector.Placement
+	if placement == nil {
+		return nil, nil
+	}
+	if reader == nil {
+		return nil, fmt.Errorf("placement %s can't be read without a client", placement.Name)
+	}
+
+	namespace := GetPlacementNamespace(schedulingPolicy)
+	clusterNames, err := getPlacementDecisions(ctx, reader, namespace, placement.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &placementPlugin{clusterNames: clusterNames}, nil
+}
+
+func (p *placementPlugin) Name() string {
+	return PlacementPluginName
+}
+
+func (p *placementPlugin) SelectDeploymentTarget(ctx context.Context, deploymentTarget *kalypsov1alpha1.DeploymentTarget) bool {
+	return true
+}
+
+// SelectClusterType checks if the cluster type is decided by the placement
+func (p *placementPlugin) SelectClusterType(ctx context.Context, clusterType *kalypsov1alpha1.ClusterType) bool {
+	return p.clusterNames[clusterType.GetName()]
+}
+
+// GetPlacementNamespace returns the namespace of the placement the scheduling policy refers to
+func GetPlacementNamespace(schedulingPolicy *kalypsov1alpha1.SchedulingPolicy) string {
+	placement := schedulingPolicy.Spec.ClusterTypeSelector.Placement
+	if placement == nil || placement.Namespace == "" {
+		return schedulingPolicy.Namespace
+	}
+	return placement.Namespace
+}
+
+// getPlacementDecisions returns the names of the clusters in all decisions of the placement
+func getPlacementDecisions(ctx context.Context, reader client.Reader, namespace, placement string) (map[string]bool, error) {
+	decisions := &unstructured.UnstructuredList{}
+	decisions.SetGroupVersionKind(placementDecisionListGVK)
+	err := reader.List(ctx, decisions, client.InNamespace(namespace), client.MatchingLabels{PlacementLabel: placement})
+	if err != nil {
+		return nil, err
+	}
+
+	clusterNames := map[string]bool{}
+	for _, decision := range decisions.Items {
+		items, _, _ := unstructured.NestedSlice(decision.Object, "status", "decisions")
+		for _, item := range items {
+			if d, ok := item.(map[string]interface{}); ok {
+				if clusterName, ok := d["clusterName"].(string); ok && clusterName != "" {
+					clusterNames[clusterName] = true
+				}
+			}
+		}
+	}
+
+	return clusterNames, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+func newPlacementDecision(namespace, name, placement string, clusterNames ...string) *unstructured.Unstructured {
+	decision := &unstructured.Unstructured{}
+	decision.SetGroupVersionKind(PlacementDecisionGVK)
+	decision.SetNamespace(namespace)
+	decision.SetName(name)
+	decision.SetLabels(map[string]string{PlacementLabel: placement})
+
+	var decisions []interface{}
+	for _, clusterName := range clusterNames {
+		decisions = append(decisions, map[string]interface{}{"clusterName": clusterName, "reason": ""})
+	}
+	_ = unstructured.SetNestedSlice(decision.Object, decisions, "status", "decisions")
+	return decision
+}
+
+func newOCMTestData(namespace string) (*kalypsov1alpha1.SchedulingPolicy, []kalypsov1alpha1.ClusterType, []kalypsov1alpha1.DeploymentTarget) {
+	policy := &kalypsov1alpha1.SchedulingPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: namespace},
+		Spec: kalypsov1alpha1.SchedulingPolicySpec{
+			ClusterTypeSelector: kalypsov1alpha1.ClusterTypeSelectorSpec{
+				LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{"region": "west"}},
+				Placement:     &kalypsov1alpha1.PlacementReference{Name: "edge"},
+			},
+		},
+	}
+
+	clusterTypes := []kalypsov1alpha1.ClusterType{
+		{ObjectMeta: metav1.ObjectMeta{Name: "drone", Labels: map[string]string{"region": "west"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "tank", Labels: map[string]string{"region": "west"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "boat", Labels: map[string]string{"region": "east"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "plane", Labels: map[string]string{"region": "west"}}},
+	}
+
+	deploymentTargets := []kalypsov1alpha1.DeploymentTarget{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "functional-test"},
+			Spec:       kalypsov1alpha1.DeploymentTargetSpec{Environment: namespace},
+		},
+	}
+
+	return policy, clusterTypes, deploymentTargets
+}
+
+func getAssignedClusterTypes(assignments []kalypsov1alpha1.Assignment) []string {
+	var clusterTypes []string
+	for _, assignment := range assignments {
+		clusterTypes = append(clusterTypes, assignment.Spec.ClusterType)
+	}
+	sort.Strings(clusterTypes)
+	return clusterTypes
+}
+
+// Test the OCM scheduler with the placement decisions
+func TestOCMSchedulerSchedule(t *testing.T) {
+	ctx := context.Background()
+	policy, clusterTypes, deploymentTargets := newOCMTestData("dev")
+
+	c := fake.NewClientBuilder().WithObjects(
+		// the decisions of a placement may be split into several objects
+		newPlacementDecision("dev", "edge-decision-1", "edge", "drone", "boat"),
+		newPlacementDecision("dev", "edge-decision-2", "edge", "tank"),
+		// the decisions of other placements and of other namespaces are ignored
+		newPlacementDecision("dev", "core-decision-1", "core", "plane"),
+		newPlacementDecision("stage", "edge-decision-1", "edge", "plane"),
+	).Build()
+
+	s, err := NewFramework(ctx, policy, nil, c)
+	assert.NoError(t, err)
+
+	// boat is decided by the placement but it doesn't match the label selector
+	assignments, _, err := s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"drone", "tank"}, getAssignedClusterTypes(assignments))
+	assert.Equal(t, "policy", assignments[0].Labels[kalypsov1alpha1.AssignmentSchedulingPolicyLabel])
+
+	// the placement in another namespace
+	policy.Spec.ClusterTypeSelector.Placement.Namespace = "stage"
+	assert.Equal(t, "stage", GetPlacementNamespace(policy))
+	s, err = NewFramework(ctx, policy, nil, c)
+	assert.NoError(t, err)
+	assignments, _, err = s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"plane"}, getAssignedClusterTypes(assignments))
+
+	// a placement without decisions selects nothing
+	policy.Spec.ClusterTypeSelector.Placement = &kalypsov1alpha1.PlacementReference{Name: "none"}
+	s, err = NewFramework(ctx, policy, nil, c)
+	assert.NoError(t, err)
+	assignments, _, err = s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, assignments)
+
+	// a policy without a placement selects the cluster types with the label selector only
+	policy.Spec.ClusterTypeSelector.Placement = nil
+	s, err = NewFramework(ctx, policy, nil, c)
+	assert.NoError(t, err)
+	assignments, _, err = s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"drone", "plane", "tank"}, getAssignedClusterTypes(assignments))
+}
+
+// Test the OCM scheduler against an API server with the OCM CRDs
+func TestOCMSchedulerEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, run the tests with make test")
+	}
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("testdata", "ocm")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() {
+		assert.NoError(t, testEnv.Stop())
+	}()
+
+	c, err := client.New(cfg, client.Options{Scheme: runtime.NewScheme()})
+	assert.NoError(t, err)
+	assert.NoError(t, corev1.AddToScheme(c.Scheme()))
+
+	ctx := context.Background()
+	assert.NoError(t, c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev"}}))
+
+	placement := &unstructured.Unstructured{}
+	placement.SetGroupVersionKind(PlacementDecisionGVK.GroupVersion().WithKind("Placement"))
+	placement.SetNamespace("dev")
+	placement.SetName("edge")
+	_ = unstructured.SetNestedField(placement.Object, map[string]interface{}{}, "spec")
+	assert.NoError(t, c.Create(ctx, placement))
+
+	// the placement controller of the hub creates the decisions and sets their status
+	decision := newPlacementDecision("dev", "edge-decision-1", "edge", "drone", "boat")
+	status := decision.Object["status"]
+	assert.NoError(t, c.Create(ctx, decision))
+	decision.Object["status"] = status
+	assert.NoError(t, c.Status().Update(ctx, decision))
+
+	policy, clusterTypes, deploymentTargets := newOCMTestData("dev")
+	s, err := NewFramework(ctx, policy, nil, c)
+	assert.NoError(t, err)
+
+	assignments, _, err := s.Schedule(ctx, clusterTypes, deploymentTargets, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"drone"}, getAssignedClusterTypes(assignments))
+}
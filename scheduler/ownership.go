@@ -0,0 +1,139 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ClusterTypeOwnership tells which GitOps repos of an environment receive the manifests of the cluster types.
+// A repo without a cluster type selector receives all cluster types.
+type ClusterTypeOwnership struct {
+	// the repos selecting the cluster types, sorted by name
+	owners map[string][]string
+	// the repos without a selector, sorted by name
+	catchAll []string
+	// all cluster types of the environment, sorted by name
+	clusterTypes []string
+}
+
+// NewClusterTypeOwnership matches the cluster type selectors of the GitOps repos with the cluster types
+func NewClusterTypeOwnership(gitopsrepos []schedulerv1alpha1.GitOpsRepo, clusterTypes []schedulerv1alpha1.ClusterType) (*ClusterTypeOwnership, error) {
+	repos := make([]schedulerv1alpha1.GitOpsRepo, len(gitopsrepos))
+	copy(repos, gitopsrepos)
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+
+	ownership := &ClusterTypeOwnership{owners: make(map[string][]string)}
+	for _, clusterType := range clusterTypes {
+		ownership.clusterTypes = append(ownership.clusterTypes, clusterType.Name)
+		// the unowned cluster types are in the map as well
+		ownership.owners[clusterType.Name] = nil
+	}
+	sort.Strings(ownership.clusterTypes)
+
+	for _, repo := range repos {
+		if repo.Spec.ClusterTypeSelector == nil {
+			ownership.catchAll = append(ownership.catchAll, repo.Name)
+			for _, clusterType := range ownership.clusterTypes {
+				ownership.owners[clusterType] = append(ownership.owners[clusterType], repo.Name)
+			}
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(repo.Spec.ClusterTypeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster type selector of GitOps repo %s: %w", repo.Name, err)
+		}
+		for _, clusterType := range clusterTypes {
+			if selector.Matches(labels.Set(clusterType.GetLabels())) {
+				ownership.owners[clusterType.Name] = append(ownership.owners[clusterType.Name], repo.Name)
+			}
+		}
+	}
+
+	for clusterType := range ownership.owners {
+		sort.Strings(ownership.owners[clusterType])
+	}
+	return ownership, nil
+}
+
+// getOwners returns the repos of the cluster type.
+// The cluster types without a ClusterType resource, e.g. the ones of the orphan packages, go to the repos without a selector.
+func (o *ClusterTypeOwnership) getOwners(clusterType string) []string {
+	if owners, ok := o.owners[clusterType]; ok {
+		return owners
+	}
+	return o.catchAll
+}
+
+// Owns tells if the repo receives the manifests of the cluster type
+func (o *ClusterTypeOwnership) Owns(gitopsrepo string, clusterType string) bool {
+	for _, owner := range o.getOwners(clusterType) {
+		if owner == gitopsrepo {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOwner returns the repo that reports the issues of the cluster type.
+// If several repos select the cluster type, it's the first one by name.
+func (o *ClusterTypeOwnership) GetOwner(clusterType string) (string, bool) {
+	owners := o.getOwners(clusterType)
+	if len(owners) == 0 {
+		return "", false
+	}
+	return owners[0], true
+}
+
+// GetClusterTypes returns the cluster types of the environment that are delivered to the repo
+func (o *ClusterTypeOwnership) GetClusterTypes(gitopsrepo string) []string {
+	var clusterTypes []string
+	for _, clusterType := range o.clusterTypes {
+		if o.Owns(gitopsrepo, clusterType) {
+			clusterTypes = append(clusterTypes, clusterType)
+		}
+	}
+	return clusterTypes
+}
+
+// GetOverlappingClusterTypes returns the cluster types of the repo that are delivered to other repos as well
+func (o *ClusterTypeOwnership) GetOverlappingClusterTypes(gitopsrepo string) []string {
+	var clusterTypes []string
+	for _, clusterType := range o.clusterTypes {
+		if o.Owns(gitopsrepo, clusterType) && len(o.owners[clusterType]) > 1 {
+			clusterTypes = append(clusterTypes, clusterType)
+		}
+	}
+	return clusterTypes
+}
+
+// GetUnownedClusterTypes returns the cluster types of the environment that are not delivered to any repo
+func (o *ClusterTypeOwnership) GetUnownedClusterTypes() []string {
+	var clusterTypes []string
+	for _, clusterType := range o.clusterTypes {
+		if len(o.owners[clusterType]) == 0 {
+			clusterTypes = append(clusterTypes, clusterType)
+		}
+	}
+	return clusterTypes
+}
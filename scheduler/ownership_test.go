@@ -0,0 +1,104 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestClusterType(name string, labels map[string]string) kalypsov1alpha1.ClusterType {
+	return kalypsov1alpha1.ClusterType{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newTestGitOpsRepo(name string, selector *metav1.LabelSelector) kalypsov1alpha1.GitOpsRepo {
+	return kalypsov1alpha1.GitOpsRepo{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       kalypsov1alpha1.GitOpsRepoSpec{ClusterTypeSelector: selector},
+	}
+}
+
+var testOwnershipClusterTypes = []kalypsov1alpha1.ClusterType{
+	newTestClusterType("drone", map[string]string{"family": "edge"}),
+	newTestClusterType("store", map[string]string{"family": "edge", "region": "west"}),
+	newTestClusterType("large", map[string]string{"family": "cloud"}),
+	newTestClusterType("lab", nil),
+}
+
+// Test a single repo without a selector receives all cluster types
+func TestClusterTypeOwnershipWithoutSelector(t *testing.T) {
+	ownership, err := NewClusterTypeOwnership([]kalypsov1alpha1.GitOpsRepo{newTestGitOpsRepo("dev", nil)}, testOwnershipClusterTypes)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"drone", "lab", "large", "store"}, ownership.GetClusterTypes("dev"))
+	assert.Empty(t, ownership.GetOverlappingClusterTypes("dev"))
+	assert.Empty(t, ownership.GetUnownedClusterTypes())
+
+	// the packages of an unknown cluster type are delivered as well
+	assert.True(t, ownership.Owns("dev", "deleted"))
+	owner, ok := ownership.GetOwner("deleted")
+	assert.True(t, ok)
+	assert.Equal(t, "dev", owner)
+}
+
+// Test the cluster types are routed to the repos by the selectors
+func TestClusterTypeOwnershipWithSelectors(t *testing.T) {
+	repos := []kalypsov1alpha1.GitOpsRepo{
+		newTestGitOpsRepo("retail", &metav1.LabelSelector{MatchLabels: map[string]string{"family": "edge"}}),
+		newTestGitOpsRepo("cloud", &metav1.LabelSelector{MatchLabels: map[string]string{"family": "cloud"}}),
+		newTestGitOpsRepo("west", &metav1.LabelSelector{MatchLabels: map[string]string{"region": "west"}}),
+	}
+	ownership, err := NewClusterTypeOwnership(repos, testOwnershipClusterTypes)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"drone", "store"}, ownership.GetClusterTypes("retail"))
+	assert.Equal(t, []string{"large"}, ownership.GetClusterTypes("cloud"))
+	assert.Equal(t, []string{"store"}, ownership.GetClusterTypes("west"))
+
+	assert.Equal(t, []string{"store"}, ownership.GetOverlappingClusterTypes("retail"))
+	assert.Empty(t, ownership.GetOverlappingClusterTypes("cloud"))
+	assert.Equal(t, []string{"store"}, ownership.GetOverlappingClusterTypes("west"))
+	assert.Equal(t, []string{"lab"}, ownership.GetUnownedClusterTypes())
+
+	// the first repo by name reports the issues of an overlapping cluster type
+	owner, ok := ownership.GetOwner("store")
+	assert.True(t, ok)
+	assert.Equal(t, "retail", owner)
+	owner, ok = ownership.GetOwner("large")
+	assert.True(t, ok)
+	assert.Equal(t, "cloud", owner)
+
+	_, ok = ownership.GetOwner("lab")
+	assert.False(t, ok)
+	_, ok = ownership.GetOwner("deleted")
+	assert.False(t, ok)
+	assert.False(t, ownership.Owns("retail", "large"))
+}
+
+// Test an invalid selector is reported
+func TestClusterTypeOwnershipInvalidSelector(t *testing.T) {
+	repos := []kalypsov1alpha1.GitOpsRepo{
+		newTestGitOpsRepo("retail", &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "family", Operator: "Unknown"},
+		}}),
+	}
+	_, err := NewClusterTypeOwnership(repos, testOwnershipClusterTypes)
+	assert.ErrorContains(t, err, "retail")
+}
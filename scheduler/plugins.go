@@ -0,0 +1,118 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	WorkspacePluginName = "Workspace"
+	LabelsPluginName    = "Labels"
+	DefaultBinderName   = "DefaultBinder"
+)
+
+// workspacePlugin selects the deployment targets of the workspace of the scheduling policy
+type workspacePlugin struct {
+	workspace string
+}
+
+// validate workspacePlugin implements SelectPlugin interface
+var _ SelectPlugin = (*workspacePlugin)(nil)
+
+func newWorkspacePlugin(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	workspace := schedulingPolicy.Spec.DeploymentTargetSelector.Workspace
+	if workspace == "" {
+		return nil, nil
+	}
+	return &workspacePlugin{workspace: workspace}, nil
+}
+
+func (p *workspacePlugin) Name() string {
+	return WorkspacePluginName
+}
+
+func (p *workspacePlugin) SelectDeploymentTarget(ctx context.Context, deploymentTarget *kalypsov1alpha1.DeploymentTarget) bool {
+	return deploymentTarget.GetWorkspace() == p.workspace
+}
+
+func (p *workspacePlugin) SelectClusterType(ctx context.Context, clusterType *kalypsov1alpha1.ClusterType) bool {
+	return true
+}
+
+// labelsPlugin selects the deployment targets and the cluster types that match the label selectors of the scheduling policy
+type labelsPlugin struct {
+	clusterTypesLabelsSelector      labels.Selector
+	deploymentTargetsLabelsSelector labels.Selector
+}
+
+// validate labelsPlugin implements SelectPlugin interface
+var _ SelectPlugin = (*labelsPlugin)(nil)
+
+func newLabelsPlugin(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	clusterTypesLabelsSelector, err := metav1.LabelSelectorAsSelector(&schedulingPolicy.Spec.ClusterTypeSelector.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentTargetsLabelsSelector, err := metav1.LabelSelectorAsSelector(&schedulingPolicy.Spec.DeploymentTargetSelector.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	return &labelsPlugin{
+		clusterTypesLabelsSelector:      clusterTypesLabelsSelector,
+		deploymentTargetsLabelsSelector: deploymentTargetsLabelsSelector,
+	}, nil
+}
+
+func (p *labelsPlugin) Name() string {
+	return LabelsPluginName
+}
+
+func (p *labelsPlugin) SelectDeploymentTarget(ctx context.Context, deploymentTarget *kalypsov1alpha1.DeploymentTarget) bool {
+	return p.deploymentTargetsLabelsSelector.Matches(labels.Set(deploymentTarget.GetLabels()))
+}
+
+func (p *labelsPlugin) SelectClusterType(ctx context.Context, clusterType *kalypsov1alpha1.ClusterType) bool {
+	return p.clusterTypesLabelsSelector.Matches(labels.Set(clusterType.GetLabels()))
+}
+
+// defaultBinder assigns the deployment target to the cluster type with an Assignment labeled with the scheduling policy
+type defaultBinder struct {
+	schedulingPolicy string
+}
+
+// validate defaultBinder implements BindPlugin interface
+var _ BindPlugin = (*defaultBinder)(nil)
+
+func newDefaultBinder(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	return &defaultBinder{schedulingPolicy: schedulingPolicy.GetName()}, nil
+}
+
+func (p *defaultBinder) Name() string {
+	return DefaultBinderName
+}
+
+func (p *defaultBinder) Bind(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) (kalypsov1alpha1.Assignment, error) {
+	return assign(deploymentTarget.GetName(), deploymentTarget.GetWorkload(), clusterType.GetName(), p.schedulingPolicy), nil
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	prDescriptionHeader  = "This PR updates the manifests in GitOps Repo"
+	prDescriptionPartial = "\n_The description is truncated as it exceeds the size limit of the git provider. See the changed files of the PR for the full diff._\n"
+	diffContextLines     = 3
+)
+
+type deploymentTargetChangeKind string
+
+const (
+	deploymentTargetAdded    deploymentTargetChangeKind = "added"
+	deploymentTargetRemoved  deploymentTargetChangeKind = "removed"
+	deploymentTargetModified deploymentTargetChangeKind = "modified"
+)
+
+// the changed files of a deployment target folder in the GitOps repo
+type deploymentTargetChange struct {
+	name   string
+	kind   deploymentTargetChangeKind
+	source schedulerv1alpha1.ContentSource
+	files  []FileChange
+}
+
+// a piece of the PR description, the optional ones are dropped when the description is too long
+type descriptionBlock struct {
+	text     string
+	optional bool
+}
+
+// getPullRequestBody describes the change set of the base branch in markdown. It lists the added, removed and
+// modified deployment targets per cluster type with the collapsed diffs of the manifests.
+func (g *gitRepo) getPullRequestBody(changeSet *changeSet, content *schedulerv1alpha1.RepoContentType) (string, error) {
+	layout, err := newRepoLayout(g.repo.Layout)
+	if err != nil {
+		return "", err
+	}
+	folders, err := layout.getDeploymentTargetFolders(content)
+	if err != nil {
+		return "", err
+	}
+
+	baseFiles := changeSet.existingFiles
+	blocks := []descriptionBlock{{text: prDescriptionHeader + "\n"}}
+
+	// cluster type -> deployment target -> changed files
+	clusterTypes := make(map[string]map[string]*deploymentTargetChange)
+	for _, change := range changeSet.changes {
+		if change.Path == Promoted_Commit_Id_Path {
+			baseRepo := content.BaseRepo
+			blocks = append(blocks, descriptionBlock{
+				text: fmt.Sprintf("\nIt promotes commit `%s` of the base repo %s (branch `%s`).\n", baseRepo.Commit, baseRepo.Repo, baseRepo.Branch),
+			})
+			continue
+		}
+
+		// the READMEs and the other files out of the deployment target folders are not manifests
+		folder, ok := layout.getDeploymentTargetFolder(change.Path)
+		if !ok {
+			continue
+		}
+		// the removed deployment targets are known only by the variables in their path
+		if contentFolder, ok := folders[folder.path]; ok {
+			folder = contentFolder
+		} else if folder.deploymentTarget == "" {
+			folder.deploymentTarget = folder.path
+		}
+		clusterTypeName, deploymentTargetName := folder.clusterType, folder.deploymentTarget
+
+		deploymentTargets, ok := clusterTypes[clusterTypeName]
+		if !ok {
+			deploymentTargets = make(map[string]*deploymentTargetChange)
+			clusterTypes[clusterTypeName] = deploymentTargets
+		}
+
+		deploymentTarget, ok := deploymentTargets[deploymentTargetName]
+		if !ok {
+			clusterTypeContent := content.ClusterTypes[clusterTypeName]
+			deploymentTarget = &deploymentTargetChange{
+				name:   deploymentTargetName,
+				kind:   getDeploymentTargetChangeKind(folder.path, folders, baseFiles),
+				source: clusterTypeContent.Sources[deploymentTargetName],
+			}
+			deploymentTargets[deploymentTargetName] = deploymentTarget
+		}
+		deploymentTarget.files = append(deploymentTarget.files, change)
+	}
+
+	for _, clusterTypeName := range sortedKeys(clusterTypes) {
+		deploymentTargets := clusterTypes[clusterTypeName]
+
+		var summary strings.Builder
+		fmt.Fprintf(&summary, "\n### %s\n\n", clusterTypeName)
+		summary.WriteString("| Deployment target | Change | Scheduling policy | Assignment |\n")
+		summary.WriteString("| --- | --- | --- | --- |\n")
+		for _, deploymentTargetName := range sortedKeys(deploymentTargets) {
+			deploymentTarget := deploymentTargets[deploymentTargetName]
+			fmt.Fprintf(&summary, "| %s | %s | %s | %s |\n", deploymentTarget.name, deploymentTarget.kind,
+				orDash(deploymentTarget.source.SchedulingPolicy), orDash(deploymentTarget.source.Assignment))
+		}
+		blocks = append(blocks, descriptionBlock{text: summary.String()})
+
+		for _, deploymentTargetName := range sortedKeys(deploymentTargets) {
+			files := deploymentTargets[deploymentTargetName].files
+			sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+			for _, file := range files {
+				diff, err := g.getFileDiff(file, baseFiles[file.Path])
+				if err != nil {
+					return "", err
+				}
+				fence := getCodeFence(diff)
+				blocks = append(blocks, descriptionBlock{
+					text:     fmt.Sprintf("\n<details>\n<summary>%s</summary>\n\n%sdiff\n%s%s\n\n</details>\n", file.Path, fence, diff, fence),
+					optional: true,
+				})
+			}
+		}
+	}
+
+	return joinDescriptionBlocks(blocks, g.provider.MaxPullRequestBodySize()), nil
+}
+
+func getDeploymentTargetChangeKind(folderPath string, folders map[string]*deploymentTargetFolder, baseFiles map[string]string) deploymentTargetChangeKind {
+	if _, ok := folders[folderPath]; !ok {
+		return deploymentTargetRemoved
+	}
+
+	prefix := folderPath + "/"
+	for path := range baseFiles {
+		if strings.HasPrefix(path, prefix) {
+			return deploymentTargetModified
+		}
+	}
+	return deploymentTargetAdded
+}
+
+// getCodeFence returns a markdown code fence that is longer than any backtick run in the text, so the text can't close it
+func getCodeFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// getFileDiff returns the unified diff of the file change against the blob in the base branch
+func (g *gitRepo) getFileDiff(change FileChange, baseSHA string) (string, error) {
+	var before string
+	fromFile := "a/" + change.Path
+	toFile := "b/" + change.Path
+
+	if change.Action == FileCreate {
+		fromFile = "/dev/null"
+	} else {
+		blob, err := g.provider.GetBlob(baseSHA)
+		if err != nil {
+			return "", err
+		}
+		before = string(blob)
+	}
+
+	after := change.Content
+	if change.Action == FileDelete {
+		toFile = "/dev/null"
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(before),
+		B:        splitLines(after),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  diffContextLines,
+	})
+}
+
+// joinDescriptionBlocks builds a description that fits into maxSize characters.
+// It keeps all mandatory blocks and as many optional ones as possible, in order.
+// If the mandatory blocks don't fit on their own, they are cut at a line break.
+func joinDescriptionBlocks(blocks []descriptionBlock, maxSize int) string {
+	var full strings.Builder
+	for _, block := range blocks {
+		full.WriteString(block.text)
+	}
+	if maxSize <= 0 || utf8.RuneCountInString(full.String()) <= maxSize {
+		return full.String()
+	}
+
+	budget := maxSize - utf8.RuneCountInString(prDescriptionPartial)
+	for _, block := range blocks {
+		if !block.optional {
+			budget -= utf8.RuneCountInString(block.text)
+		}
+	}
+
+	var description strings.Builder
+	for _, block := range blocks {
+		if block.optional {
+			size := utf8.RuneCountInString(block.text)
+			if size > budget {
+				continue
+			}
+			budget -= size
+		}
+		description.WriteString(block.text)
+	}
+
+	result := description.String()
+	if limit := maxSize - utf8.RuneCountInString(prDescriptionPartial); utf8.RuneCountInString(result) > limit {
+		if limit < 0 {
+			limit = 0
+		}
+		// cut on a rune boundary, so a multi-byte character is not split
+		result = string([]rune(result)[:limit])
+		if i := strings.LastIndex(result, "\n"); i >= 0 {
+			result = result[:i+1]
+		}
+	}
+	return result + prDescriptionPartial
+}
+
+// splitLines splits the content for the diff, an empty file has no lines at all
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return difflib.SplitLines(content)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
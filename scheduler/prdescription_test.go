@@ -0,0 +1,144 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+func getDescriptionTestProvider() *fakeGitProvider {
+	return newFakeGitProvider("dev", map[string]string{
+		"README.md":                             "GitOps repo",
+		"drone/README.md":                       readmeContent,
+		"drone/modified-target/reconciler.yaml": "kind: GitRepository\nname: old\n",
+		"drone/modified-target/namespace.yaml":  "namespace",
+		"drone/removed-target/reconciler.yaml":  "removed",
+	})
+}
+
+func getDescriptionTestContent() *kalypsov1alpha1.RepoContentType {
+	content := kalypsov1alpha1.NewRepoContentType()
+	content.BaseRepo.Repo = "https://github.com/microsoft/kalypso-control-plane"
+	content.BaseRepo.Branch = "main"
+	content.BaseRepo.Commit = "abc123"
+
+	drone := kalypsov1alpha1.NewClusterContentType()
+	drone.DeploymentTargets["modified-target"] = kalypsov1alpha1.AssignmentPackageSpec{
+		ReconcilerManifests: []string{"kind: GitRepository\nname: new\n"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+	drone.DeploymentTargets["added-target"] = kalypsov1alpha1.AssignmentPackageSpec{
+		// the manifest has a markdown code block in it
+		ReconcilerManifests: []string{"description: |\n  ```yaml\n  added\n  ```"},
+		NamespaceManifests:  []string{"namespace"},
+	}
+	drone.Sources["added-target"] = kalypsov1alpha1.ContentSource{SchedulingPolicy: "functional-test-policy", Assignment: "added-assignment"}
+	content.ClusterTypes["drone"] = *drone
+	return content
+}
+
+// Test getPullRequestBody
+func TestGetPullRequestBody(t *testing.T) {
+	provider := getDescriptionTestProvider()
+	gitRepo := newGitRepoWithProvider(ctx, gitOpsRepo, provider)
+	base, _ := provider.GetBranch("dev")
+	changeSet, err := gitRepo.getChanges(base, getDescriptionTestContent())
+	assert.NoError(t, err)
+
+	body, err := gitRepo.getPullRequestBody(changeSet, getDescriptionTestContent())
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(body, prDescriptionHeader))
+	assert.Contains(t, body, "It promotes commit `abc123` of the base repo https://github.com/microsoft/kalypso-control-plane (branch `main`).")
+	assert.Contains(t, body, "### drone")
+	assert.Contains(t, body, "| added-target | added | functional-test-policy | added-assignment |")
+	assert.Contains(t, body, "| modified-target | modified | - | - |")
+	assert.Contains(t, body, "| removed-target | removed | - | - |")
+
+	// only the changed manifests have diffs
+	assert.Contains(t, body, "<summary>drone/modified-target/reconciler.yaml</summary>")
+	assert.NotContains(t, body, "<summary>drone/modified-target/namespace.yaml</summary>")
+	assert.Contains(t, body, "-name: old\n+name: new\n")
+	assert.Contains(t, body, "--- /dev/null\n+++ b/drone/added-target/reconciler.yaml\n")
+	// the code block of the manifest doesn't close the diff
+	assert.Contains(t, body, "````diff\n--- /dev/null\n+++ b/drone/added-target/reconciler.yaml\n")
+	assert.Contains(t, body, "+  ```\n````\n")
+	assert.Contains(t, body, "```diff\n--- a/drone/modified-target/reconciler.yaml\n")
+	assert.Contains(t, body, "--- a/drone/removed-target/reconciler.yaml\n+++ /dev/null\n")
+	assert.NotContains(t, body, prDescriptionPartial)
+
+	// the summary is more important than the diffs
+	provider.maxBodySize = utf8.RuneCountInString(body[:strings.Index(body, "<details>")]) + utf8.RuneCountInString(prDescriptionPartial)
+	body, err = gitRepo.getPullRequestBody(changeSet, getDescriptionTestContent())
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, utf8.RuneCountInString(body), provider.maxBodySize)
+	assert.Contains(t, body, "| removed-target | removed | - | - |")
+	assert.NotContains(t, body, "<details>")
+	assert.True(t, strings.HasSuffix(body, prDescriptionPartial))
+}
+
+// Test getCodeFence
+func TestGetCodeFence(t *testing.T) {
+	assert.Equal(t, "```", getCodeFence("name: new"))
+	assert.Equal(t, "```", getCodeFence("`name` and ``new``"))
+	assert.Equal(t, "````", getCodeFence("```yaml\nname: new\n```"))
+	assert.Equal(t, "``````", getCodeFence("`````"))
+}
+
+// Test joinDescriptionBlocks
+func TestJoinDescriptionBlocks(t *testing.T) {
+	longDiff := strings.Repeat("-", len(prDescriptionPartial)) + "\n"
+	blocks := []descriptionBlock{
+		{text: "header\n"},
+		{text: longDiff, optional: true},
+		{text: "diff\n", optional: true},
+		{text: "summary\n"},
+	}
+	full := "header\n" + longDiff + "diff\nsummary\n"
+
+	assert.Equal(t, full, joinDescriptionBlocks(blocks, 0))
+	assert.Equal(t, full, joinDescriptionBlocks(blocks, len(full)))
+
+	// the optional blocks that don't fit are skipped
+	description := joinDescriptionBlocks(blocks, len("header\ndiff\nsummary\n")+len(prDescriptionPartial))
+	assert.Equal(t, "header\ndiff\nsummary\n"+prDescriptionPartial, description)
+
+	// the mandatory blocks are cut at a line break
+	description = joinDescriptionBlocks(blocks, len("header\nsum")+len(prDescriptionPartial))
+	assert.Equal(t, "header\n"+prDescriptionPartial, description)
+
+	// the size is counted in characters and a multi-byte character is never split
+	accents := strings.Repeat("é", len(prDescriptionPartial))
+	blocks = []descriptionBlock{{text: "日本語\n"}, {text: accents + accents}}
+	description = joinDescriptionBlocks(blocks, len([]rune("日本語\n"+accents+accents)))
+	assert.Equal(t, "日本語\n"+accents+accents, description)
+
+	description = joinDescriptionBlocks(blocks, len([]rune("日本語\néé"))+len(prDescriptionPartial))
+	assert.True(t, utf8.ValidString(description))
+	assert.Equal(t, "日本語\n"+prDescriptionPartial, description)
+
+	// without a line break the cut falls between two characters
+	blocks = []descriptionBlock{{text: accents + accents + accents}}
+	description = joinDescriptionBlocks(blocks, len([]rune(accents))+len(prDescriptionPartial))
+	assert.True(t, utf8.ValidString(description))
+	assert.Equal(t, accents+prDescriptionPartial, description)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	schedulerv1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+)
+
+// PromotionCandidate is the commit of the previous stage that can be promoted to the next one
+type PromotionCandidate struct {
+	Commit string
+	// DeployedSince is the time the commit was deployed in the previous stage, it's zero if it isn't deployed yet
+	DeployedSince time.Time
+	// NotDeployed tells why the commit is not deployed in the previous stage yet, it's empty if it's deployed
+	NotDeployed string
+}
+
+// CheckPromotionGates evaluates the gates of the stage for the candidate.
+// It returns what the promotion is waiting for, or an empty string if the commit can be promoted,
+// along with the time after which the soak time is over.
+func CheckPromotionGates(stage schedulerv1alpha1.PromotionStage, candidate PromotionCandidate, now time.Time) (string, time.Duration) {
+	var waitingFor []string
+	var soakRemaining time.Duration
+
+	if gates := stage.Gates; gates.SoakTime != nil {
+		// the commit doesn't soak in the previous stage until it runs there
+		if candidate.DeployedSince.IsZero() {
+			waitingFor = append(waitingFor, "soak time in the previous stage, the commit is not deployed there yet")
+		} else if soakRemaining = candidate.DeployedSince.Add(gates.SoakTime.Duration).Sub(now); soakRemaining > 0 {
+			waitingFor = append(waitingFor, fmt.Sprintf("soak time in the previous stage, %s left", soakRemaining.Round(time.Second)))
+		} else {
+			soakRemaining = 0
+		}
+	}
+
+	if stage.Gates.PreviousStageDeployed && candidate.NotDeployed != "" {
+		waitingFor = append(waitingFor, candidate.NotDeployed)
+	}
+
+	if stage.Gates.ManualApproval && stage.ApprovedCommit != candidate.Commit {
+		waitingFor = append(waitingFor, fmt.Sprintf("manual approval of commit %s", candidate.Commit))
+	}
+
+	return strings.Join(waitingFor, "; "), soakRemaining
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test CheckPromotionGates
+func TestCheckPromotionGates(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	candidate := PromotionCandidate{Commit: "abc", DeployedSince: now.Add(-30 * time.Minute)}
+
+	// a stage without gates takes the commit right away
+	waitingFor, soakRemaining := CheckPromotionGates(kalypsov1alpha1.PromotionStage{Environment: "stage"}, candidate, now)
+	assert.Empty(t, waitingFor)
+	assert.Zero(t, soakRemaining)
+
+	stage := kalypsov1alpha1.PromotionStage{
+		Environment: "prod",
+		Gates: kalypsov1alpha1.PromotionGates{
+			ManualApproval:        true,
+			SoakTime:              &metav1.Duration{Duration: time.Hour},
+			PreviousStageDeployed: true,
+		},
+		ApprovedCommit: "old",
+	}
+
+	// all gates hold the commit
+	candidate.NotDeployed = "assignment a in environment stage to be ready"
+	waitingFor, soakRemaining = CheckPromotionGates(stage, candidate, now)
+	assert.Equal(t, "soak time in the previous stage, 30m0s left; assignment a in environment stage to be ready; manual approval of commit abc", waitingFor)
+	assert.Equal(t, 30*time.Minute, soakRemaining)
+
+	// the soak time doesn't start until the commit is deployed in the previous stage
+	notDeployedCandidate := PromotionCandidate{Commit: "abc", NotDeployed: candidate.NotDeployed}
+	waitingFor, soakRemaining = CheckPromotionGates(stage, notDeployedCandidate, now.Add(time.Hour))
+	assert.Equal(t, "soak time in the previous stage, the commit is not deployed there yet; assignment a in environment stage to be ready; manual approval of commit abc", waitingFor)
+	assert.Zero(t, soakRemaining)
+
+	// the soak time is over and the previous stage is deployed
+	candidate.NotDeployed = ""
+	waitingFor, soakRemaining = CheckPromotionGates(stage, candidate, now.Add(time.Hour))
+	assert.Equal(t, "manual approval of commit abc", waitingFor)
+	assert.Zero(t, soakRemaining)
+
+	// the commit is approved
+	stage.ApprovedCommit = "abc"
+	waitingFor, _ = CheckPromotionGates(stage, candidate, now.Add(time.Hour))
+	assert.Empty(t, waitingFor)
+
+	// the previous stage is not checked without the gate
+	stage.Gates.PreviousStageDeployed = false
+	candidate.NotDeployed = "assignment a in environment stage to be ready"
+	waitingFor, _ = CheckPromotionGates(stage, candidate, now.Add(time.Hour))
+	assert.Empty(t, waitingFor)
+}
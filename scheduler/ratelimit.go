@@ -0,0 +1,234 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// the requests are retried at most this many times
+	rateLimitMaxRetries = 3
+	// the longest a request waits for the rate limit in the client, longer waits are left to the controller
+	rateLimitMaxWait = 30 * time.Second
+	// the first backoff delay of a retry, it doubles with every attempt
+	retryBaseDelay = time.Second
+	// the request budget before the first response tells the real one, it's the GitHub limit for a token
+	defaultRequestsPerHour = 5000
+	// the number of requests that can be sent at once
+	requestBurst = 50
+)
+
+// RateLimitError is returned when the git hosting service throttles the requests longer than the client can wait
+type RateLimitError struct {
+	// RetryAfter is the time to wait before the next attempt
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %s", ErrRateLimited, e.Message, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+var (
+	// the token buckets are shared by all clients with the same credentials across the reconcilers
+	requestLimiters     = map[string]*rate.Limiter{}
+	requestLimitersLock sync.Mutex
+)
+
+// getRequestLimiter returns the token bucket of the credentials
+func getRequestLimiter(key string) *rate.Limiter {
+	requestLimitersLock.Lock()
+	defer requestLimitersLock.Unlock()
+
+	limiter, ok := requestLimiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(defaultRequestsPerHour/time.Hour.Seconds()), requestBurst)
+		requestLimiters[key] = limiter
+	}
+	return limiter
+}
+
+// rateLimitTransport spends the request budget of the credentials evenly until the rate limit resets,
+// and retries the throttled and failed requests with backoff.
+// It understands the GitHub, GitLab and Azure DevOps rate limit headers.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+	// sleep is replaced in the tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// withRateLimit returns a copy of the http client that shares the request budget with the other clients of the credentials
+func withRateLimit(httpClient *http.Client, credentialsKey string) *http.Client {
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	client := *httpClient
+	client.Transport = &rateLimitTransport{
+		base:    base,
+		limiter: getRequestLimiter(credentialsKey),
+		sleep:   sleepWithContext,
+	}
+	return &client
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		reservation := t.limiter.Reserve()
+		if delay := reservation.Delay(); delay > rateLimitMaxWait {
+			reservation.Cancel()
+			return nil, &RateLimitError{RetryAfter: delay, Message: "the request budget is spent"}
+		} else if err := t.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		r := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		t.updateBudget(resp)
+
+		delay, retry := getRetryDelay(resp, req.Method, attempt)
+		// the request can't be sent again if its body can't be read again
+		canResend := req.Body == nil || req.GetBody != nil
+		if !retry || !canResend || attempt >= rateLimitMaxRetries || delay > rateLimitMaxWait {
+			return resp, nil
+		}
+
+		// drain the body so the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if err := t.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// updateBudget spreads the remaining requests evenly until the rate limit resets
+func (t *rateLimitTransport) updateBudget(resp *http.Response) {
+	remaining, ok := getRateLimitHeader(resp, "RateLimit-Remaining")
+	if !ok {
+		return
+	}
+	reset, ok := getRateLimitHeader(resp, "RateLimit-Reset")
+	if !ok {
+		return
+	}
+
+	untilReset := time.Until(time.Unix(reset, 0)).Seconds()
+	if untilReset < 1 {
+		untilReset = 1
+	}
+	// one request is always allowed right after the reset
+	t.limiter.SetLimit(rate.Limit(math.Max(float64(remaining), 1) / untilReset))
+}
+
+// getRetryDelay returns how long to wait before the request is retried, if it makes sense to retry it
+func getRetryDelay(resp *http.Response, method string, attempt int) (time.Duration, bool) {
+	backoff := retryBaseDelay * time.Duration(1<<attempt)
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusForbidden:
+		if retryAfter, ok := getRateLimitHeader(resp, "Retry-After"); ok {
+			return time.Duration(retryAfter) * time.Second, true
+		}
+		// the primary rate limit is exceeded, the forbidden responses without the rate limit headers are not retried
+		if remaining, ok := getRateLimitHeader(resp, "RateLimit-Remaining"); ok && remaining == 0 {
+			if reset, ok := getRateLimitHeader(resp, "RateLimit-Reset"); ok {
+				return time.Until(time.Unix(reset, 0)) + time.Second, true
+			}
+			return backoff, true
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return backoff, true
+		}
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// the server may have processed the request before it failed,
+		// so sending a POST or PATCH again could e.g. create a second commit or PR
+		if isIdempotent(method) {
+			return backoff, true
+		}
+	}
+
+	return 0, false
+}
+
+// isIdempotent tells if sending the request more than once has the same effect as sending it once
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// getRateLimitHeader reads a numeric header with or without the X- prefix, e.g. X-RateLimit-Remaining on GitHub and RateLimit-Remaining on GitLab
+func getRateLimitHeader(resp *http.Response, name string) (int64, bool) {
+	value := resp.Header.Get(name)
+	if value == "" {
+		value = resp.Header.Get("X-" + name)
+	}
+	if value == "" {
+		return 0, false
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return number, true
+}
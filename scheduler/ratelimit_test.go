@@ -0,0 +1,189 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+// newTestRateLimitClient returns a client with its own token bucket that records the waits instead of sleeping
+func newTestRateLimitClient(server *httptest.Server, sleeps *[]time.Duration) (*http.Client, *rate.Limiter) {
+	limiter := rate.NewLimiter(rate.Inf, requestBurst)
+	return &http.Client{Transport: &rateLimitTransport{
+		base:    server.Client().Transport,
+		limiter: limiter,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			if d > 0 {
+				*sleeps = append(*sleeps, d)
+			}
+			return nil
+		},
+	}}, limiter
+}
+
+// Test the retries of rateLimitTransport
+func TestRateLimitTransportRetries(t *testing.T) {
+	var bodies []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/secondary", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+	unavailable := 0
+	mux.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		unavailable++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	mux.HandleFunc("/exhausted", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// the secondary rate limit is retried after the delay with the same body
+	var sleeps []time.Duration
+	client, _ := newTestRateLimitClient(server, &sleeps)
+	resp, err := client.Post(server.URL+"/secondary", "text/plain", strings.NewReader("body"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"body", "body"}, bodies)
+	assert.Equal(t, []time.Duration{2 * time.Second}, sleeps)
+
+	// the server errors are retried with exponential backoff
+	sleeps = nil
+	resp, err = client.Get(server.URL + "/unavailable")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, rateLimitMaxRetries+1, unavailable)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, sleeps)
+
+	// the server errors of the non-idempotent requests are not retried, the server may have processed them
+	sleeps = nil
+	unavailable = 0
+	resp, err = client.Post(server.URL+"/unavailable", "text/plain", strings.NewReader("body"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 1, unavailable)
+	assert.Empty(t, sleeps)
+
+	req, _ := http.NewRequest(http.MethodPatch, server.URL+"/unavailable", strings.NewReader("body"))
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 2, unavailable)
+	assert.Empty(t, sleeps)
+
+	// the plain forbidden responses are not retried
+	sleeps = nil
+	resp, err = client.Get(server.URL + "/forbidden")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, sleeps)
+
+	// the client doesn't wait for an hour, it's up to the caller
+	resp, err = client.Get(server.URL + "/exhausted")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, sleeps)
+}
+
+// Test the request budget of rateLimitTransport
+func TestRateLimitTransportBudget(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("RateLimit-Remaining", "10")
+		w.Header().Set("RateLimit-Reset", fmt.Sprint(time.Now().Add(100*time.Second).Unix()))
+		fmt.Fprint(w, "ok")
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var sleeps []time.Duration
+	client, limiter := newTestRateLimitClient(server, &sleeps)
+	_, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	// 10 requests in 100 seconds
+	assert.InDelta(t, 0.1, float64(limiter.Limit()), 0.01)
+
+	// once the burst is spent, the requests wait for the budget
+	limiter.SetBurst(0)
+	_, err = client.Get(server.URL)
+	var rateLimitErr *RateLimitError
+	assert.True(t, errors.As(err, &rateLimitErr))
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Greater(t, rateLimitErr.RetryAfter, rateLimitMaxWait)
+
+	// the clients of the same credentials share the bucket
+	assert.Same(t, getRequestLimiter("github/github.com/key"), getRequestLimiter("github/github.com/key"))
+	assert.NotSame(t, getRequestLimiter("github/github.com/key"), getRequestLimiter("github/github.com/other"))
+}
+
+// Test the rate limit errors of the git providers
+func TestGitProviderRateLimitErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/ref/heads/dev", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Hour).Unix()))
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message":"API rate limit exceeded"}`)
+	})
+	mux.HandleFunc("/api/v4/projects/microsoft%2Fkalypso-gitops/repository/branches/dev", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	github, err := newGithubProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+	_, err = github.GetBranch("dev")
+	var rateLimitErr *RateLimitError
+	assert.True(t, errors.As(err, &rateLimitErr))
+	assert.Greater(t, rateLimitErr.RetryAfter, 59*time.Minute)
+
+	gitlab, err := newGitlabProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+	_, err = gitlab.GetBranch("dev")
+	assert.True(t, errors.As(err, &rateLimitErr))
+	assert.Equal(t, time.Minute, rateLimitErr.RetryAfter)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const jsonContentType = "application/json"
+
+// restClient is a minimal JSON REST client used by the git providers that don't have a Go SDK in this project
+type restClient struct {
+	baseURL    string
+	httpClient *http.Client
+	ctx        context.Context
+}
+
+// restError is returned by restClient when the server responds with a non 2xx status code
+type restError struct {
+	StatusCode int
+	Method     string
+	URL        string
+	Message    string
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// do sends a JSON request and decodes the JSON response into result.
+// If result is a *[]byte, the raw response body is returned.
+func (c *restClient) do(method, path string, query url.Values, body interface{}, result interface{}) (*http.Response, error) {
+	return c.doWithContentType(method, path, query, jsonContentType, body, result)
+}
+
+func (c *restClient) doWithContentType(method, path string, query url.Values, contentType string, body interface{}, result interface{}) (*http.Response, error) {
+	requestURL := c.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(c.ctx, method, requestURL, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", jsonContentType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := getRetryDelay(resp, method, 0)
+		return resp, &RateLimitError{RetryAfter: retryAfter, Message: fmt.Sprintf("%s %s: %s", method, requestURL, data)}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, &restError{
+			StatusCode: resp.StatusCode,
+			Method:     method,
+			URL:        requestURL,
+			Message:    string(data),
+		}
+	}
+
+	if result == nil || len(data) == 0 {
+		return resp, nil
+	}
+
+	if raw, ok := result.(*[]byte); ok {
+		*raw = data
+		return resp, nil
+	}
+
+	return resp, json.Unmarshal(data, result)
+}
+
+// basicAuthTransport adds a basic authorization header with an empty user name and a token as a password
+type basicAuthTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.SetBasicAuth("", t.token)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// the keys of the signing key secret, the GPG ones are the same as in the Flux image update automation secrets
+const (
+	gpgKeySecretKey     = "git.asc"
+	sshKeySecretKey     = "identity"
+	passphraseSecretKey = "passphrase"
+)
+
+const (
+	// the namespace of the SSH signatures of git objects
+	sshSignatureNamespace = "git"
+	sshSignatureMagic     = "SSHSIG"
+	sshSignatureVersion   = 1
+	sshSignatureHash      = "sha512"
+	sshSignatureArmorLine = 70
+)
+
+// Signer signs the git objects created by the scheduler.
+// It gets the encoded object without the signature and returns the armored signature,
+// see https://git-scm.com/docs/gitformat-signature
+type Signer interface {
+	Sign(message io.Reader) ([]byte, error)
+}
+
+// NewSignerFromSecret reads a GPG (git.asc) or an SSH (identity) private key from the secret
+func NewSignerFromSecret(secret *corev1.Secret) (Signer, error) {
+	passphrase := secret.Data[passphraseSecretKey]
+
+	if key, ok := secret.Data[gpgKeySecretKey]; ok {
+		signer, err := newGPGSigner(key, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in secret %s: %w", gpgKeySecretKey, secret.Name, err)
+		}
+		return signer, nil
+	}
+
+	if key, ok := secret.Data[sshKeySecretKey]; ok {
+		signer, err := newSSHSigner(key, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in secret %s: %w", sshKeySecretKey, secret.Name, err)
+		}
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("secret %s must contain %s or %s", secret.Name, gpgKeySecretKey, sshKeySecretKey)
+}
+
+// gpgSigner creates detached OpenPGP signatures
+type gpgSigner struct {
+	entity *openpgp.Entity
+}
+
+func newGPGSigner(armoredKey []byte, passphrase []byte) (*gpgSigner, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			if len(passphrase) == 0 {
+				return nil, errors.New("the private key is encrypted and there is no passphrase")
+			}
+			if err := entity.DecryptPrivateKeys(passphrase); err != nil {
+				return nil, err
+			}
+		}
+		return &gpgSigner{entity: entity}, nil
+	}
+
+	return nil, errors.New("no private key found")
+}
+
+func (s *gpgSigner) Sign(message io.Reader) ([]byte, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+// sshSigner creates SSH signatures in the format of ssh-keygen -Y sign,
+// see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func newSSHSigner(key []byte, passphrase []byte) (*sshSigner, error) {
+	var signer ssh.Signer
+	var err error
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sshSigner{signer: signer}, nil
+}
+
+// the blob that is actually signed by the SSH key
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          string
+}
+
+// the SSH signature without the magic preamble
+type sshSignatureBlob struct {
+	Version       uint32
+	PublicKey     string
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     string
+}
+
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	hash := sha512.New()
+	if _, err := io.Copy(hash, message); err != nil {
+		return nil, err
+	}
+
+	signedData := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sshSignatureNamespace,
+		HashAlgorithm: sshSignatureHash,
+		Hash:          string(hash.Sum(nil)),
+	})...)
+
+	var signature *ssh.Signature
+	var err error
+	// the RSA keys must not sign with SHA-1
+	if algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = s.signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignatureBlob{
+		Version:       sshSignatureVersion,
+		PublicKey:     string(s.signer.PublicKey().Marshal()),
+		Namespace:     sshSignatureNamespace,
+		HashAlgorithm: sshSignatureHash,
+		Signature:     string(ssh.Marshal(signature)),
+	})...)
+
+	return armorSSHSignature(blob), nil
+}
+
+func armorSSHSignature(blob []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	var armored bytes.Buffer
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > sshSignatureArmorLine {
+		armored.WriteString(encoded[:sshSignatureArmorLine] + "\n")
+		encoded = encoded[sshSignatureArmorLine:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString("-----END SSH SIGNATURE-----\n")
+	return armored.Bytes()
+}
+
+// signCommit returns the signature of the commit object as git computes it
+func signCommit(signer Signer, commit *object.Commit) (string, error) {
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return "", err
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	signature, err := signer.Sign(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(signature), nil
+}
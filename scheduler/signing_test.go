@@ -0,0 +1,281 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestGPGKey returns the armored private and public keys of a new GPG key
+func newTestGPGKey(t *testing.T, passphrase string) ([]byte, string) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := openpgp.NewEntity("Kalypso Test", "", "kalypso.test@email.com", config)
+	assert.NoError(t, err)
+
+	var public bytes.Buffer
+	writer, _ := armor.Encode(&public, openpgp.PublicKeyType, nil)
+	assert.NoError(t, entity.Serialize(writer))
+	writer.Close()
+
+	if passphrase != "" {
+		assert.NoError(t, entity.EncryptPrivateKeys([]byte(passphrase), config))
+	}
+	var private bytes.Buffer
+	writer, _ = armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	assert.NoError(t, entity.SerializePrivateWithoutSigning(writer, config))
+	writer.Close()
+
+	return private.Bytes(), public.String()
+}
+
+// newTestSSHKey returns the OpenSSH private key and the public key of a new ed25519 key
+func newTestSSHKey(t *testing.T) ([]byte, ssh.PublicKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(private, "")
+	assert.NoError(t, err)
+	sshPublic, err := ssh.NewPublicKey(public)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(block), sshPublic
+}
+
+// verifySSHSignature checks an armored SSH signature of the message like ssh-keygen -Y verify
+func verifySSHSignature(t *testing.T, publicKey ssh.PublicKey, message, armored string) {
+	assert.True(t, strings.HasPrefix(armored, "-----BEGIN SSH SIGNATURE-----\n"))
+	assert.True(t, strings.HasSuffix(armored, "\n-----END SSH SIGNATURE-----\n"))
+	encoded := strings.TrimPrefix(armored, "-----BEGIN SSH SIGNATURE-----\n")
+	encoded = strings.TrimSuffix(encoded, "-----END SSH SIGNATURE-----\n")
+	for _, line := range strings.Split(strings.TrimSpace(encoded), "\n") {
+		assert.LessOrEqual(t, len(line), sshSignatureArmorLine)
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\n", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, sshSignatureMagic, string(blob[:len(sshSignatureMagic)]))
+
+	var signature sshSignatureBlob
+	assert.NoError(t, ssh.Unmarshal(blob[len(sshSignatureMagic):], &signature))
+	assert.Equal(t, uint32(sshSignatureVersion), signature.Version)
+	assert.Equal(t, sshSignatureNamespace, signature.Namespace)
+	assert.Equal(t, publicKey.Marshal(), []byte(signature.PublicKey))
+
+	var sig ssh.Signature
+	assert.NoError(t, ssh.Unmarshal([]byte(signature.Signature), &sig))
+	hash := sha512.Sum512([]byte(message))
+	signedData := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     signature.Namespace,
+		HashAlgorithm: signature.HashAlgorithm,
+		Hash:          string(hash[:]),
+	})...)
+	assert.NoError(t, publicKey.Verify(signedData, &sig))
+}
+
+// Test NewSignerFromSecret
+func TestNewSignerFromSecret(t *testing.T) {
+	message := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"
+
+	// GPG
+	privateKey, publicKey := newTestGPGKey(t, "")
+	signer, err := NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"git.asc": privateKey}})
+	assert.NoError(t, err)
+	signature, err := signer.Sign(strings.NewReader(message))
+	assert.NoError(t, err)
+	keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(publicKey))
+	assert.NoError(t, err)
+	_, err = openpgp.CheckArmoredDetachedSignature(keyRing, strings.NewReader(message), bytes.NewReader(signature), nil)
+	assert.NoError(t, err)
+
+	// encrypted GPG
+	privateKey, publicKey = newTestGPGKey(t, "secret")
+	_, err = NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"git.asc": privateKey}})
+	assert.Error(t, err)
+	signer, err = NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"git.asc": privateKey, "passphrase": []byte("secret")}})
+	assert.NoError(t, err)
+	signature, err = signer.Sign(strings.NewReader(message))
+	assert.NoError(t, err)
+	keyRing, _ = openpgp.ReadArmoredKeyRing(strings.NewReader(publicKey))
+	_, err = openpgp.CheckArmoredDetachedSignature(keyRing, strings.NewReader(message), bytes.NewReader(signature), nil)
+	assert.NoError(t, err)
+
+	// SSH
+	sshKey, sshPublicKey := newTestSSHKey(t)
+	signer, err = NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"identity": sshKey}})
+	assert.NoError(t, err)
+	signature, err = signer.Sign(strings.NewReader(message))
+	assert.NoError(t, err)
+	verifySSHSignature(t, sshPublicKey, message, string(signature))
+
+	// RSA keys sign with SHA-512
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(rsaKey, "")
+	assert.NoError(t, err)
+	signer, err = NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"identity": pem.EncodeToMemory(block)}})
+	assert.NoError(t, err)
+	signature, err = signer.Sign(strings.NewReader(message))
+	assert.NoError(t, err)
+	rsaPublicKey, _ := ssh.NewPublicKey(&rsaKey.PublicKey)
+	verifySSHSignature(t, rsaPublicKey, message, string(signature))
+
+	_, err = NewSignerFromSecret(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "empty"}})
+	assert.Error(t, err)
+	_, err = NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"identity": []byte("not a key")}})
+	assert.Error(t, err)
+}
+
+// Test the signed commits of direct push delivery
+func TestSignedDirectPush(t *testing.T) {
+	gpgKey, gpgPublicKey := newTestGPGKey(t, "")
+	gpgSigner, err := NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"git.asc": gpgKey}})
+	assert.NoError(t, err)
+	sshKey, sshPublicKey := newTestSSHKey(t)
+	sshSigner, err := NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"identity": sshKey}})
+	assert.NoError(t, err)
+
+	for _, signer := range []Signer{gpgSigner, sshSigner} {
+		repoUrl := newBareTestRepo(t, "dev", map[string]string{"README.md": "GitOps repo"})
+		spec := &kalypsov1alpha1.GitOpsRepoSpec{
+			ManifestsSpec: kalypsov1alpha1.ManifestsSpec{Repo: repoUrl, Branch: "dev", Path: "."},
+			Delivery:      kalypsov1alpha1.DirectPushDelivery,
+			Commit: &kalypsov1alpha1.CommitSpec{
+				Author: &kalypsov1alpha1.CommitAuthor{Name: "Platform Bot", Email: "platform.bot@contoso.com"},
+			},
+		}
+		gitRepo, err := NewGitRepo(ctx, spec, nil, signer)
+		assert.NoError(t, err)
+
+		_, err = gitRepo.CreatePR("deployment/new", getTestRepoContent(t))
+		assert.NoError(t, err)
+
+		repo, err := git.PlainOpen(repoUrl[len("file://"):])
+		assert.NoError(t, err)
+		ref, err := repo.Reference(plumbing.NewBranchReferenceName("dev"), true)
+		assert.NoError(t, err)
+		commit, err := repo.CommitObject(ref.Hash())
+		assert.NoError(t, err)
+
+		assert.Equal(t, "Platform Bot", commit.Author.Name)
+		assert.Equal(t, "platform.bot@contoso.com", commit.Author.Email)
+		if signer == gpgSigner {
+			_, err = commit.Verify(gpgPublicKey)
+			assert.NoError(t, err)
+		} else {
+			verifySSHSignature(t, sshPublicKey, encodeTestCommit(t, commit), commit.PGPSignature)
+		}
+	}
+}
+
+// encodeTestCommit returns the commit object that is signed
+func encodeTestCommit(t *testing.T, commit *object.Commit) string {
+	encoded := &plumbing.MemoryObject{}
+	assert.NoError(t, commit.EncodeWithoutSignature(encoded))
+	reader, _ := encoded.Reader()
+	content, _ := io.ReadAll(reader)
+	return string(content)
+}
+
+// Test the signed commits of githubProvider, the signature must match the commit GitHub creates
+func TestGithubProviderSignedCommit(t *testing.T) {
+	var commitRequest struct {
+		Message   string          `json:"message"`
+		Tree      string          `json:"tree"`
+		Parents   []string        `json:"parents"`
+		Author    json.RawMessage `json:"author"`
+		Committer json.RawMessage `json:"committer"`
+		Signature string          `json:"signature"`
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/trees", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sha":"4b825dc642cb6eb9a060e54bf8d69288fbee4904"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/commits", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &commitRequest)
+		fmt.Fprint(w, `{"sha":"new"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/microsoft/kalypso-gitops/git/refs/heads/dev", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ref":"refs/heads/dev","object":{"sha":"new"}}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider, err := newGithubProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+
+	gpgKey, gpgPublicKey := newTestGPGKey(t, "")
+	signer, err := NewSignerFromSecret(&corev1.Secret{Data: map[string][]byte{"git.asc": gpgKey}})
+	assert.NoError(t, err)
+
+	base := &Branch{Name: "dev", SHA: "3f786850e387550fdab836ed7e6dc881de23001b"}
+	_, err = provider.CommitFiles(base, &Commit{Message: "commit", AuthorName: "name", AuthorEmail: "email", Signer: signer},
+		[]FileChange{{Path: "a.yaml", Content: "a", Action: FileCreate}})
+	assert.NoError(t, err)
+
+	// the author and the committer are sent with the signed dates
+	assert.JSONEq(t, string(commitRequest.Author), string(commitRequest.Committer))
+	var author struct {
+		Name  string    `json:"name"`
+		Email string    `json:"email"`
+		Date  time.Time `json:"date"`
+	}
+	assert.NoError(t, json.Unmarshal(commitRequest.Author, &author))
+
+	signature := object.Signature{Name: author.Name, Email: author.Email, When: author.Date}
+	commit := &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      commitRequest.Message,
+		TreeHash:     plumbing.NewHash(commitRequest.Tree),
+		ParentHashes: []plumbing.Hash{plumbing.NewHash(commitRequest.Parents[0])},
+		PGPSignature: commitRequest.Signature,
+	}
+	_, err = commit.Verify(gpgPublicKey)
+	assert.NoError(t, err)
+
+	// the other providers can't sign the commits
+	gitlab, err := newGitlabProvider(ctx, server.URL+"/microsoft/kalypso-gitops", server.Client())
+	assert.NoError(t, err)
+	_, err = gitlab.CommitFiles(base, &Commit{Message: "commit", Signer: signer}, nil)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	CapacityPluginName = "Capacity"
+	SpreadPluginName   = "Spread"
+)
+
+// GetSchedulingStrategy returns the strategy of the scheduling policy and the number of cluster types
+// each deployment target is scheduled on with it
+func GetSchedulingStrategy(schedulingPolicy *kalypsov1alpha1.SchedulingPolicy) (kalypsov1alpha1.SchedulingStrategyType, int) {
+	strategy := schedulingPolicy.Spec.Strategy
+	if strategy == nil || strategy.Type == "" {
+		return kalypsov1alpha1.AllSchedulingStrategy, 0
+	}
+
+	replicas := int(strategy.ClusterTypes)
+	if replicas < 1 {
+		replicas = 1
+	}
+	return strategy.Type, replicas
+}
+
+// capacityPlugin drops the cluster types without enough capacity for the resources the deployment target requests.
+// With the binpack strategy it prefers the most utilized cluster types, with the least-loaded one the least utilized.
+type capacityPlugin struct {
+	strategy kalypsov1alpha1.SchedulingStrategyType
+}
+
+// validate capacityPlugin implements FilterPlugin and ScorePlugin interfaces
+var _ FilterPlugin = (*capacityPlugin)(nil)
+var _ ScorePlugin = (*capacityPlugin)(nil)
+
+func newCapacityPlugin(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	strategy, _ := GetSchedulingStrategy(schedulingPolicy)
+	return &capacityPlugin{strategy: strategy}, nil
+}
+
+func (p *capacityPlugin) Name() string {
+	return CapacityPluginName
+}
+
+// Filter checks if the cluster type has enough capacity for the requested resources.
+// A resource that is missing in a non-empty capacity of the cluster type is not available on it.
+func (p *capacityPlugin) Filter(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) *Status {
+	if len(clusterType.Spec.Capacity) == 0 {
+		return nil
+	}
+
+	requests := deploymentTarget.Spec.Resources
+	allocated := state.GetAllocated(clusterType.Name)
+	for _, name := range getResourceNames(requests) {
+		capacity := clusterType.Spec.Capacity[name]
+		total := allocated[name].DeepCopy()
+		total.Add(requests[name])
+		if total.Cmp(capacity) > 0 {
+			return NewStatus(Unschedulable, fmt.Sprintf("have insufficient %s", name))
+		}
+	}
+	return nil
+}
+
+// Score returns the utilization of the cluster type with the deployment target on it for the binpack strategy,
+// and how much of the cluster type is free for the least-loaded strategy
+func (p *capacityPlugin) Score(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) int64 {
+	var utilization float64
+	switch p.strategy {
+	case kalypsov1alpha1.BinPackSchedulingStrategy:
+		utilization = getUtilization(state, clusterType, deploymentTarget.Spec.Resources)
+	case kalypsov1alpha1.LeastLoadedSchedulingStrategy:
+		utilization = 1 - getUtilization(state, clusterType, nil)
+	default:
+		return 0
+	}
+	return int64(math.Round(math.Max(0, math.Min(1, utilization)) * float64(MaxScore)))
+}
+
+// getUtilization returns the highest ratio of the allocated and the available resources of the cluster type
+func getUtilization(state *State, clusterType *kalypsov1alpha1.ClusterType, requests corev1.ResourceList) float64 {
+	allocated := state.GetAllocated(clusterType.Name)
+	utilization := 0.0
+	for name, capacity := range clusterType.Spec.Capacity {
+		if capacity.IsZero() {
+			continue
+		}
+		total := allocated[name].DeepCopy()
+		total.Add(requests[name])
+		if ratio := total.AsApproximateFloat64() / capacity.AsApproximateFloat64(); ratio > utilization {
+			utilization = ratio
+		}
+	}
+	return utilization
+}
+
+// spreadPlugin prefers the cluster types with the fewest assignments with the spread strategy
+type spreadPlugin struct{}
+
+// validate spreadPlugin implements ScorePlugin and ScoreNormalizer interfaces
+var _ ScorePlugin = (*spreadPlugin)(nil)
+var _ ScoreNormalizer = (*spreadPlugin)(nil)
+
+func newSpreadPlugin(ctx context.Context, schedulingPolicy *kalypsov1alpha1.SchedulingPolicy, reader client.Reader) (Plugin, error) {
+	if strategy, _ := GetSchedulingStrategy(schedulingPolicy); strategy != kalypsov1alpha1.SpreadSchedulingStrategy {
+		return nil, nil
+	}
+	return &spreadPlugin{}, nil
+}
+
+func (p *spreadPlugin) Name() string {
+	return SpreadPluginName
+}
+
+// Score returns the number of assignments of the cluster type, the fewer the better
+func (p *spreadPlugin) Score(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, clusterType *kalypsov1alpha1.ClusterType) int64 {
+	return int64(state.GetAssignments(clusterType.Name))
+}
+
+func (p *spreadPlugin) NormalizeScores(ctx context.Context, state *State, deploymentTarget *kalypsov1alpha1.DeploymentTarget, scores []int64) {
+	NormalizeScores(scores, true)
+}
+
+// getCost returns the cost of the cluster type, a cluster type without cost is free
+func getCost(clusterType kalypsov1alpha1.ClusterType) resource.Quantity {
+	if clusterType.Spec.Cost == nil {
+		return resource.Quantity{}
+	}
+	return *clusterType.Spec.Cost
+}
+
+// getResourceNames returns the sorted names of the resources
+func getResourceNames(resources corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	return names
+}
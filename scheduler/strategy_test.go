@@ -0,0 +1,233 @@
+/*
+Copyright 2023 microsoft.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	kalypsov1alpha1 "github.com/microsoft/kalypso-scheduler/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newStrategyPolicy(strategy kalypsov1alpha1.SchedulingStrategyType, clusterTypes int32) *kalypsov1alpha1.SchedulingPolicy {
+	policy := &kalypsov1alpha1.SchedulingPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "dev"},
+	}
+	if strategy != "" {
+		policy.Spec.Strategy = &kalypsov1alpha1.SchedulingStrategySpec{Type: strategy, ClusterTypes: clusterTypes}
+	}
+	return policy
+}
+
+func newCapacityClusterType(name, cpu, cost string) kalypsov1alpha1.ClusterType {
+	clusterType := kalypsov1alpha1.ClusterType{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if cpu != "" {
+		clusterType.Spec.Capacity = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}
+	}
+	if cost != "" {
+		q := resource.MustParse(cost)
+		clusterType.Spec.Cost = &q
+	}
+	return clusterType
+}
+
+func newRequestingDeploymentTarget(name, cpu string) kalypsov1alpha1.DeploymentTarget {
+	deploymentTarget := kalypsov1alpha1.DeploymentTarget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{kalypsov1alpha1.WorkloadLabel: "app"}},
+	}
+	if cpu != "" {
+		deploymentTarget.Spec.Resources = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}
+	}
+	return deploymentTarget
+}
+
+// getPlacement returns the cluster types of the assignments by deployment target
+func getPlacement(assignments []kalypsov1alpha1.Assignment) map[string][]string {
+	placement := map[string][]string{}
+	for _, assignment := range assignments {
+		placement[assignment.Spec.DeploymentTarget] = append(placement[assignment.Spec.DeploymentTarget], assignment.Spec.ClusterType)
+	}
+	return placement
+}
+
+func scheduleWithStrategy(t *testing.T, policy *kalypsov1alpha1.SchedulingPolicy, clusterTypes []kalypsov1alpha1.ClusterType, deploymentTargets []kalypsov1alpha1.DeploymentTarget, existing []kalypsov1alpha1.Assignment) ([]kalypsov1alpha1.Assignment, []kalypsov1alpha1.UnplacedDeploymentTarget) {
+	s, err := NewScheduler(policy)
+	assert.NoError(t, err)
+	assignments, unplaced, err := s.Schedule(context.Background(), clusterTypes, deploymentTargets, existing)
+	assert.NoError(t, err)
+	return assignments, unplaced
+}
+
+func TestGetSchedulingStrategy(t *testing.T) {
+	strategy, replicas := GetSchedulingStrategy(newStrategyPolicy("", 0))
+	assert.Equal(t, kalypsov1alpha1.AllSchedulingStrategy, strategy)
+	assert.Equal(t, 0, replicas)
+
+	strategy, replicas = GetSchedulingStrategy(newStrategyPolicy(kalypsov1alpha1.SpreadSchedulingStrategy, 0))
+	assert.Equal(t, kalypsov1alpha1.SpreadSchedulingStrategy, strategy)
+	assert.Equal(t, 1, replicas)
+
+	_, replicas = GetSchedulingStrategy(newStrategyPolicy(kalypsov1alpha1.BinPackSchedulingStrategy, 3))
+	assert.Equal(t, 3, replicas)
+}
+
+func TestScheduleAllStrategy(t *testing.T) {
+	clusterTypes := []kalypsov1alpha1.ClusterType{
+		newCapacityClusterType("large", "4", ""),
+		newCapacityClusterType("small", "1", ""),
+		newCapacityClusterType("unlimited", "", ""),
+	}
+	deploymentTargets := []kalypsov1alpha1.DeploymentTarget{
+		newRequestingDeploymentTarget("a", "1"),
+		newRequestingDeploymentTarget("b", "1"),
+	}
+
+	assignments, unplaced := scheduleWithStrategy(t, newStrategyPolicy("", 0), clusterTypes, deploymentTargets, nil)
+	assert.Equal(t, map[string][]string{
+		"a": {"large", "small", "unlimited"},
+		"b": {"large", "unlimited"},
+	}, getPlacement(assignments))
+	assert.Equal(t, []kalypsov1alpha1.UnplacedDeploymentTarget{
+		{Name: "b", Workload: "app", Message: "scheduled on 2 of 3 cluster types: 1 cluster types have insufficient cpu"},
+	}, unplaced)
+
+	// the deployment targets without requests fit everywhere
+	assignments, unplaced = scheduleWithStrategy(t, newStrategyPolicy("", 0), clusterTypes,
+		[]kalypsov1alpha1.DeploymentTarget{newRequestingDeploymentTarget("c", "")}, nil)
+	assert.Len(t, assignments, 3)
+	assert.Empty(t, unplaced)
+
+	// nothing to schedule on
+	assignments, unplaced = scheduleWithStrategy(t, newStrategyPolicy("", 0), nil, deploymentTargets, nil)
+	assert.Empty(t, assignments)
+	assert.Len(t, unplaced, 2)
+	assert.Equal(t, "no cluster types match the scheduling policy", unplaced[0].Message)
+}
+
+func TestScheduleSpreadStrategy(t *testing.T) {
+	clusterTypes := []kalypsov1alpha1.ClusterType{
+		newCapacityClusterType("east", "", ""),
+		newCapacityClusterType("north", "", "2"),
+		newCapacityClusterType("south", "", ""),
+		newCapacityClusterType("west", "", "1"),
+	}
+	deploymentTargets := []kalypsov1alpha1.DeploymentTarget{
+		newRequestingDeploymentTarget("a", ""),
+		newRequestingDeploymentTarget("b", ""),
+		newRequestingDeploymentTarget("c", ""),
+	}
+
+	// the targets are spread over the cluster types, the free ones go first
+	assignments, unplaced := scheduleWithStrategy(t, newStrategyPolicy(kalypsov1alpha1.SpreadSchedulingStrategy, 2), clusterTypes, deploymentTargets, nil)
+	assert.Equal(t, map[string][]string{
+		"a": {"east", "south"},
+		"b": {"west", "north"},
+		"c": {"east", "south"},
+	}, getPlacement(assignments))
+	assert.Empty(t, unplaced)
+
+	// the assignments of other policies count
+	existing := []kalypsov1alpha1.Assignment{
+		assign("x", "other", "east", "other-policy"),
+		assign("y", "other", "south", "other-policy"),
+	}
+	assignments, _ = scheduleWithStrategy(t, newStrategyPolicy(kalypsov1alpha1.SpreadSchedulingStrategy, 1), clusterTypes, deploymentTargets[:1], existing)
+	assert.Equal(t, map[string][]string{"a": {"west"}}, getPlacement(assignments))
+
+	// the current assignments of the policy are kept
+	existing = append(existing, assign("a", "app", "south", "policy"))
+	assignments, _ = scheduleWithStrategy(t, newStrategyPolicy(kalypsov1alpha1.SpreadSchedulingStrategy, 1), clusterTypes, deploymentTargets[:1], existing)
+	assert.Equal(t, map[string][]string{"a": {"south"}}, getPlacement(assignments))
+
+	// more cluster types are required than selected
+	_, unplaced = scheduleWithStrategy(t, newStrategyPolicy(kalypsov1alpha1.SpreadSchedulingStrategy, 5), clusterTypes, deploymentTargets[:1], nil)
+	assert.Equal(t, []kalypsov1alpha1.UnplacedDeploymentTarget{
+		{Name: "a", Workload: "app", Message: "scheduled on 4 of 5 cluster types: only 4 cluster types match the scheduling policy"},
+	}, unplaced)
+}
+
+func TestScheduleBinPackStrategy(t *testing.T) {
+	clusterTypes := []kalypsov1alpha1.ClusterType{
+		newCapacityClusterType("large", "4", ""),
+		newCapacityClusterType("small", "2", ""),
+	}
+	deploymentTargets := []kalypsov1alpha1.DeploymentTarget{
+		newRequestingDeploymentTarget("a", "1"),
+		newRequestingDeploymentTarget("b", "1"),
+		newRequestingDeploymentTarget("c", "1"),
+		newRequestingDeploymentTarget("d", "2"),
+		newRequestingDeploymentTarget("e", "2"),
+	}
+
+	// the small cluster type fills up first
+	assignments, unplaced := scheduleWithStrategy(t, newStrategyPolicy(kalypsov1alpha1.BinPackSchedulingStrategy, 1), clusterTypes, deploymentTargets, nil)
+	assert.Equal(t, map[string][]string{
+		"a": {"small"},
+		"b": {"small"},
+		"c": {"large"},
+		"d": {"large"},
+	}, getPlacement(assignments))
+	assert.Equal(t, []kalypsov1alpha1.UnplacedDeploymentTarget{
+		{Name: "e", Workload: "app", Message: "scheduled on 0 of 1 cluster types: 2 cluster types have insufficient cpu"},
+	}, unplaced)
+
+	// a resource that is not in the capacity is not available
+	gpu := newRequestingDeploymentTarget("gpu", "")
+	gpu.Spec.Resources = corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}
+	assignments, unplaced = scheduleWithStrategy(t, newStrategyPolicy(kalypsov1alpha1.BinPackSchedulingStrategy, 1), clusterTypes,
+		[]kalypsov1alpha1.DeploymentTarget{gpu}, nil)
+	assert.Empty(t, assignments)
+	assert.Equal(t, "scheduled on 0 of 1 cluster types: 2 cluster types have insufficient nvidia.com/gpu", unplaced[0].Message)
+}
+
+func TestScheduleLeastLoadedStrategy(t *testing.T) {
+	clusterTypes := []kalypsov1alpha1.ClusterType{
+		newCapacityClusterType("cheap", "4", "1"),
+		newCapacityClusterType("expensive", "4", "10"),
+	}
+	deploymentTargets := []kalypsov1alpha1.DeploymentTarget{
+		newRequestingDeploymentTarget("a", "2"),
+		newRequestingDeploymentTarget("b", "1"),
+		newRequestingDeploymentTarget("c", "1"),
+	}
+
+	// equally loaded cluster types are chosen by their cost
+	assignments, unplaced := scheduleWithStrategy(t, newStrategyPolicy(kalypsov1alpha1.LeastLoadedSchedulingStrategy, 1), clusterTypes, deploymentTargets, nil)
+	assert.Equal(t, map[string][]string{
+		"a": {"cheap"},
+		"b": {"expensive"},
+		"c": {"expensive"},
+	}, getPlacement(assignments))
+	assert.Empty(t, unplaced)
+
+	// the deployment targets of other policies load the cluster types
+	otherTarget := newRequestingDeploymentTarget("x", "3")
+	otherTarget.Labels[kalypsov1alpha1.WorkloadLabel] = "other"
+	existing := []kalypsov1alpha1.Assignment{assign("x", "other", "expensive", "other-policy")}
+	policy := newStrategyPolicy(kalypsov1alpha1.LeastLoadedSchedulingStrategy, 1)
+	policy.Spec.DeploymentTargetSelector.LabelSelector.MatchLabels = map[string]string{kalypsov1alpha1.WorkloadLabel: "app"}
+	assignments, _ = scheduleWithStrategy(t, policy, clusterTypes,
+		append([]kalypsov1alpha1.DeploymentTarget{otherTarget}, deploymentTargets[1:]...), existing)
+	assert.Equal(t, map[string][]string{
+		"b": {"cheap"},
+		"c": {"cheap"},
+	}, getPlacement(assignments))
+}
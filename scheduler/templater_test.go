@@ -72,7 +72,10 @@ func TestProcessTemplate(t *testing.T) {
 		unstructuredProcessedTemplates = append(unstructuredProcessedTemplates, unstructured.Unstructured{Object: unstructuredObject})
 	}
 
-	t.Error(processedTemplates)
+	if len(unstructuredProcessedTemplates) != 2 {
+		t.Errorf("expected a GitRepository and a Kustomization, got %v", processedTemplates)
+		return
+	}
 
 	assert.Equal(t, "GitRepository", unstructuredProcessedTemplates[0].GetKind())
 	assert.Equal(t, "test-deployment-target-kustomize", unstructuredProcessedTemplates[0].Object["metadata"].(map[string]interface{})["name"])